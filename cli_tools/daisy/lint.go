@@ -0,0 +1,103 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy"
+)
+
+// lintFinding is a finding of a workflow file.
+type lintFinding struct {
+	File string `json:"file"`
+	daisy.LintFinding
+}
+
+// runLint runs the lint subcommand with args and returns its exit status: 0
+// if there are no findings, 1 if there are and 2 for usage errors.
+func runLint(args []string, stdout, stderr io.Writer) int {
+	rules := daisy.LintRules()
+	known := map[string]bool{}
+	for _, r := range rules {
+		known[r] = true
+	}
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: daisy lint [-format text|json] [-disable rule,...] workflow...")
+		fs.PrintDefaults()
+	}
+	format := fs.String("format", "text", "output format, text or json")
+	disable := fs.String("disable", "", "comma separated list of rules not to check, of: "+strings.Join(rules, ", "))
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(stderr, "unknown format %q, must be text or json\n", *format)
+		return 2
+	}
+	disabled := map[string]bool{}
+	if *disable != "" {
+		for _, r := range strings.Split(*disable, ",") {
+			r = strings.TrimSpace(r)
+			if !known[r] {
+				fmt.Fprintf(stderr, "unknown rule %q, must be one of: %s\n", r, strings.Join(rules, ", "))
+				return 2
+			}
+			disabled[r] = true
+		}
+	}
+
+	findings := []lintFinding{}
+	for _, path := range fs.Args() {
+		w, err := daisy.NewFromFile(path)
+		if err != nil {
+			findings = append(findings, lintFinding{path, daisy.LintFinding{Rule: daisy.LintParse, Message: err.Error()}})
+			continue
+		}
+		for _, f := range w.Lint() {
+			if !disabled[f.Rule] {
+				findings = append(findings, lintFinding{path, f})
+			}
+		}
+	}
+
+	if *format == "json" {
+		e := json.NewEncoder(stdout)
+		e.SetIndent("", "  ")
+		e.Encode(findings)
+	} else {
+		for _, f := range findings {
+			if f.Field == "" {
+				fmt.Fprintf(stdout, "%s: %s [%s]\n", f.File, f.Message, f.Rule)
+			} else {
+				fmt.Fprintf(stdout, "%s: %s: %s [%s]\n", f.File, f.Field, f.Message, f.Rule)
+			}
+		}
+	}
+	if len(findings) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -19,34 +19,73 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"cloud.google.com/go/compute/metadata"
-	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/compute-image-tools/daisy"
 	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/storage"
 	"google.golang.org/api/option"
 )
 
 var (
-	oauth     = flag.String("oauth", "", "path to oauth json file, overrides what is set in workflow")
-	project   = flag.String("project", "", "project to run in, overrides what is set in workflow")
-	gcsPath   = flag.String("gcs_path", "", "GCS bucket to use, overrides what is set in workflow")
-	zone      = flag.String("zone", "", "zone to run in, overrides what is set in workflow")
-	variables = flag.String("variables", "", "comma separated list of variables, in the form 'key=value'")
-	print     = flag.Bool("print", false, "print out the parsed workflow for debugging")
-	validate  = flag.Bool("validate", false, "validate the workflow and exit")
-	ce        = flag.String("compute_endpoint_override", "", "API endpoint to override default")
-	se        = flag.String("storage_endpoint_override", "", "API endpoint to override default")
+	oauth      = flag.String("oauth", "", "path to oauth json file, overrides what is set in workflow")
+	project    = flag.String("project", "", "project to run in, overrides what is set in workflow")
+	gcsPath    = flag.String("gcs_path", "", "GCS bucket to use, overrides what is set in workflow")
+	zone       = flag.String("zone", "", "zone to run in, overrides what is set in workflow")
+	variables  = flag.String("variables", "", "comma separated list of variables, in the form 'key=value'")
+	print      = flag.Bool("print", false, "print out the parsed workflow for debugging")
+	validate   = flag.Bool("validate", false, "validate the workflow and exit")
+	ce         = flag.String("compute_endpoint_override", "", "API endpoint to override default")
+	se         = flag.String("storage_endpoint_override", "", "API endpoint to override default")
+	resume     = flag.String("resume", "", "path to the checkpoint of a previous run to resume, local or GCS")
+	fake       = flag.Bool("fake", false, "run against an in-memory fake of the Compute API")
+	fakeScript = flag.String("fake_script", "", "path to a JSON script for the -fake Compute API, adding existing resources and driving instances")
+	localGCS   = flag.String("local_gcs_dir", "", "local directory to use in place of GCS, gs://bucket/object maps to DIR/bucket/object")
+	eventsJSON = flag.String("events_json", "", "path to a file to write workflow events to, as JSON lines")
+	schema     = flag.Bool("schema", false, "print the JSON Schema of workflow files and exit")
+	convert    = flag.Bool("convert", false, "convert the workflows between JSON and YAML, writing foo.wf.yaml for foo.wf.json and foo.wf.json for foo.wf.yaml, and exit")
+	describe   = flag.Bool("describe", false, "print the vars of the workflows, with their types, defaults and descriptions, and exit")
+	allowEnv   = flag.Bool("allow_env", false, "allow workflows to reference environment variables as ${env.NAME}")
+	graph      = flag.String("graph", "", "print the populated DAG of the workflows in the given format, dot or mermaid, and exit")
+	keep       = flag.Bool("keep_on_failure", false, "keep the disks, images and instances of workflows that fail, to debug them, instead of deleting them")
+	keepResume = flag.Bool("keep_for_resume", false, "keep the disks, images and instances created by the completed steps of workflows that fail or are canceled, to -resume them; runs given -resume keep them too")
+	cleanupRun = flag.String("cleanup", "", "delete the resources kept by the failed run with this scratch path, as printed by the run, and exit")
+	sweep      = flag.Bool("sweep", false, "find the disks, images and instances of -project that workflow runs leaked, delete them with -dry_run=false, and exit")
+	olderThan  = flag.Duration("older_than", 24*time.Hour, "with -sweep, only consider resources created longer ago than this")
+	dryRun     = flag.Bool("dry_run", true, "with -sweep, only report the resources that would be deleted")
+	skipQuota  = flag.Bool("skip_quota_check", false, "don't check that region quotas leave room for the resources of the workflows, with -validate and -graph too")
+	cacheDir   = flag.String("cache_dir", "", "directory to cache the gs:// and https:// workflow files of IncludeWorkflow and SubWorkflow steps in, for later runs to use when their server can't be reached; defaults to daisy in the user's cache directory")
+	varFiles   stringsFlag
 )
 
+func init() {
+	flag.Var(&varFiles, "var_file", "path to a JSON, YAML or .env file of variables, can be given more than once; later files override earlier ones, -variables and -var: flags override files")
+}
+
+// stringsFlag is a flag that can be given more than once.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
 const (
-	flgDefValue   = "flag generated for workflow variable"
-	varFlagPrefix = "var:"
+	flgDefValue         = "flag generated for workflow variable"
+	varFlagPrefix       = "var:"
+	secretVarFlagPrefix = "secret_var:"
 )
 
 func populateVars(input string) map[string]string {
@@ -70,7 +109,33 @@ func populateVars(input string) map[string]string {
 	return varMap
 }
 
-func parseWorkflow(ctx context.Context, path string, varMap map[string]string, project, zone, gcsPath, oauth, cEndpoint, sEndpoint string) (*daisy.Workflow, error) {
+// readVarFiles reads the vars of files, values of later files override the
+// values of earlier ones.
+func readVarFiles(files []string) (map[string]string, error) {
+	varMap := map[string]string{}
+	for _, f := range files {
+		vs, err := daisy.ReadVarFile(f)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range vs {
+			varMap[k] = v
+		}
+	}
+	return varMap, nil
+}
+
+func populateSecretVars() map[string]string {
+	varMap := map[string]string{}
+	flag.Visit(func(flg *flag.Flag) {
+		if strings.HasPrefix(flg.Name, secretVarFlagPrefix) {
+			varMap[strings.TrimPrefix(flg.Name, secretVarFlagPrefix)] = flg.Value.String()
+		}
+	})
+	return varMap
+}
+
+func parseWorkflow(ctx context.Context, path string, varMap, secretVarMap map[string]string, project, zone, gcsPath, oauth, cEndpoint, sEndpoint string) (*daisy.Workflow, error) {
 	w, err := daisy.NewFromFile(path)
 	if err != nil {
 		return nil, err
@@ -78,6 +143,9 @@ func parseWorkflow(ctx context.Context, path string, varMap map[string]string, p
 	for k, v := range varMap {
 		w.AddVar(k, v)
 	}
+	for k, v := range secretVarMap {
+		w.AddSecretVar(k, v)
+	}
 
 	if project != "" {
 		w.Project = project
@@ -119,6 +187,110 @@ func parseWorkflow(ctx context.Context, path string, varMap map[string]string, p
 	return w, nil
 }
 
+func fakeComputeClient(w *daisy.Workflow, script string) (compute.Client, error) {
+	c := compute.NewFakeClient()
+	c.Permissive = true
+	c.AddProject(w.Project, w.Zone)
+	if script != "" {
+		f, err := os.Open(script)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := c.LoadScript(f); err != nil {
+			return nil, fmt.Errorf("error loading fake script %q: %v", script, err)
+		}
+	}
+	return c, nil
+}
+
+// computeClient returns the Compute client of -oauth and
+// -compute_endpoint_override, for commands run without a workflow.
+func computeClient(ctx context.Context) (compute.Client, error) {
+	opts := []option.ClientOption{option.WithCredentialsFile(*oauth)}
+	if *ce != "" {
+		opts = append(opts, option.WithEndpoint(*ce))
+	}
+	return compute.NewClient(ctx, opts...)
+}
+
+// cleanup deletes the resources kept by the run with scratch path
+// scratchPath.
+func cleanup(ctx context.Context, scratchPath string) error {
+	cc, err := computeClient(ctx)
+	if err != nil {
+		return err
+	}
+	var sc storage.Client
+	if *localGCS != "" {
+		sc, err = storage.NewLocalClient(*localGCS)
+	} else {
+		opts := []option.ClientOption{option.WithCredentialsFile(*oauth)}
+		if *se != "" {
+			opts = append(opts, option.WithEndpoint(*se))
+		}
+		sc, err = storage.NewClient(ctx, opts...)
+	}
+	if err != nil {
+		return err
+	}
+
+	deleted, err := daisy.CleanupRun(ctx, cc, sc, scratchPath)
+	for _, r := range deleted {
+		fmt.Printf("[Daisy] Deleted %s %s\n", r.Type, r.Link)
+	}
+	return err
+}
+
+// sweepLeaked reports the resources of project leaked by workflow runs, and
+// deletes them unless dry is set.
+func sweepLeaked(ctx context.Context, project string, olderThan time.Duration, dry bool) error {
+	cc, err := computeClient(ctx)
+	if err != nil {
+		return err
+	}
+	leaked, err := daisy.FindLeaked(cc, project, olderThan)
+	if err != nil {
+		return err
+	}
+	if len(leaked) == 0 {
+		fmt.Printf("[Daisy] No resources created more than %s ago leaked in project %q\n", olderThan, project)
+		return nil
+	}
+	fmt.Printf("[Daisy] Resources created more than %s ago leaked in project %q:\n", olderThan, project)
+	for _, r := range leaked {
+		fmt.Printf("  %s %s (workflow %q, run %s, user %q, created %s)\n", r.Type, r.Link, r.Workflow, r.RunID, r.User, r.Created.Format(time.RFC3339))
+	}
+	if dry {
+		fmt.Println("[Daisy] Run with -dry_run=false to delete them.")
+		return nil
+	}
+	deleted, err := daisy.DeleteLeaked(cc, leaked)
+	for _, r := range deleted {
+		fmt.Printf("[Daisy] Deleted %s %s\n", r.Type, r.Link)
+	}
+	return err
+}
+
+// cleanupCommand returns the command deleting the resources kept by w.
+func cleanupCommand(w *daisy.Workflow) string {
+	cmd := fmt.Sprintf("daisy -cleanup %s", w.ScratchPath())
+	if w.OAuthPath != "" {
+		cmd += " -oauth " + w.OAuthPath
+	}
+	return cmd
+}
+
+// convertPath returns the path to convert the workflow at path to.
+func convertPath(path string) string {
+	ext := filepath.Ext(path)
+	switch strings.ToLower(ext) {
+	case ".yaml", ".yml":
+		return strings.TrimSuffix(path, ext) + ".json"
+	}
+	return strings.TrimSuffix(path, ext) + ".yaml"
+}
+
 func addFlags(args []string) {
 	for _, arg := range args {
 		if len(arg) <= 1 || arg[0] != '-' {
@@ -130,7 +302,7 @@ func addFlags(args []string) {
 			name = name[1:]
 		}
 
-		if !strings.HasPrefix(name, varFlagPrefix) {
+		if !strings.HasPrefix(name, varFlagPrefix) && !strings.HasPrefix(name, secretVarFlagPrefix) {
 			continue
 		}
 
@@ -145,25 +317,127 @@ func addFlags(args []string) {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "lint" {
+		os.Exit(runLint(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	addFlags(os.Args[1:])
 	flag.Parse()
 
+	if *schema {
+		s, err := daisy.Schema()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(s))
+		return
+	}
+
+	if *cleanupRun != "" {
+		if err := cleanup(context.Background(), *cleanupRun); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *sweep {
+		if *project == "" {
+			log.Fatal("-sweep needs the -project to sweep.")
+		}
+		if err := sweepLeaked(context.Background(), *project, *olderThan, *dryRun); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if len(flag.Args()) == 0 {
 		log.Fatal("Not enough args, first arg needs to be the path to a workflow.")
 	}
+	if *resume != "" && len(flag.Args()) > 1 {
+		log.Fatal("Only one workflow can be resumed at a time.")
+	}
 	ctx := context.Background()
 
+	if *convert {
+		for _, path := range flag.Args() {
+			dst := convertPath(path)
+			if err := daisy.ConvertWorkflowFile(path, dst); err != nil {
+				log.Fatalf("error converting workflow %q: %v", path, err)
+			}
+			fmt.Printf("[Daisy] Converted %q to %q\n", path, dst)
+		}
+		return
+	}
+
+	if *describe {
+		for _, path := range flag.Args() {
+			w, err := daisy.NewFromFile(path)
+			if err != nil {
+				log.Fatalf("error parsing workflow %q: %v", path, err)
+			}
+			fmt.Printf("[Daisy] Workflow %q (%s)\n", w.Name, path)
+			if err := w.DescribeVars(os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
+
 	var ws []*daisy.Workflow
-	varMap := populateVars(*variables)
+	varMap, err := readVarFiles(varFiles)
+	if err != nil {
+		log.Fatalf("error reading var file: %v", err)
+	}
+	for k, v := range populateVars(*variables) {
+		varMap[k] = v
+	}
+	secretVarMap := populateSecretVars()
+
+	var observer daisy.Observer
+	if *eventsJSON != "" {
+		f, err := os.Create(*eventsJSON)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		observer = daisy.NewJSONObserver(f)
+	}
 
 	for _, path := range flag.Args() {
-		w, err := parseWorkflow(ctx, path, varMap, *project, *zone, *gcsPath, *oauth, *ce, *se)
+		w, err := parseWorkflow(ctx, path, varMap, secretVarMap, *project, *zone, *gcsPath, *oauth, *ce, *se)
 		if err != nil {
 			log.Fatalf("error parsing workflow %q: %v", path, err)
 		}
+		if *localGCS != "" {
+			if w.StorageClient, err = storage.NewLocalClient(*localGCS); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *fake {
+			if w.ComputeClient, err = fakeComputeClient(w, *fakeScript); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if observer != nil {
+			w.AddObserver(observer)
+		}
+		w.AllowEnv = *allowEnv
+		w.KeepOnFailure = *keep
+		w.KeepForResume = *keepResume
+		w.CacheDir = *cacheDir
+		w.SkipQuotaCheck = *skipQuota
 		ws = append(ws, w)
 	}
 
+	// The graph alone goes to stdout, so it can be piped to other tools.
+	var msgs io.Writer = os.Stdout
+	if *graph != "" {
+		msgs = os.Stderr
+	}
+	for _, w := range ws {
+		w.LogOutput = msgs
+	}
+
 	errors := make(chan error, len(ws))
 	var wg sync.WaitGroup
 	for _, w := range ws {
@@ -172,19 +446,25 @@ func main() {
 		go func(w *daisy.Workflow) {
 			select {
 			case <-c:
-				fmt.Printf("\nCtrl-C caught, sending cancel signal to %q...\n", w.Name)
+				fmt.Fprintf(msgs, "\nCtrl-C caught, sending cancel signal to %q...\n", w.Name)
 				close(w.Cancel)
 				errors <- fmt.Errorf("workflow %q was canceled", w.Name)
 			case <-w.Cancel:
 			}
 		}(w)
 		if *print {
-			fmt.Printf("[Daisy] Printing workflow %q\n", w.Name)
+			fmt.Fprintf(msgs, "[Daisy] Printing workflow %q\n", w.Name)
 			w.Print(ctx)
 			continue
 		}
+		if *graph != "" {
+			if err := w.Graph(ctx, os.Stdout, *graph); err != nil {
+				errors <- fmt.Errorf("%s: %v", w.Name, err)
+			}
+			continue
+		}
 		if *validate {
-			fmt.Printf("[Daisy] Validating workflow %q\n", w.Name)
+			fmt.Fprintf(msgs, "[Daisy] Validating workflow %q\n", w.Name)
 			if err := w.Validate(ctx); err != nil {
 				fmt.Fprintln(os.Stderr, "[Daisy] Error validating workflow:", err)
 			}
@@ -193,12 +473,22 @@ func main() {
 		wg.Add(1)
 		go func(w *daisy.Workflow) {
 			defer wg.Done()
-			fmt.Printf("[Daisy] Running workflow %q\n", w.Name)
-			if err := w.Run(ctx); err != nil {
+			var err error
+			if *resume != "" {
+				fmt.Fprintf(msgs, "[Daisy] Resuming workflow %q from %q\n", w.Name, *resume)
+				err = w.Resume(ctx, *resume)
+			} else {
+				fmt.Fprintf(msgs, "[Daisy] Running workflow %q\n", w.Name)
+				err = w.Run(ctx)
+			}
+			if err != nil {
+				if len(w.RetainedResources()) > 0 {
+					fmt.Fprintf(msgs, "[Daisy] Workflow %q kept its resources, to delete them run:\n  %s\n", w.Name, cleanupCommand(w))
+				}
 				errors <- fmt.Errorf("%s: %v", w.Name, err)
 				return
 			}
-			fmt.Printf("[Daisy] Workflow %q finished\n", w.Name)
+			fmt.Fprintf(msgs, "[Daisy] Workflow %q finished\n", w.Name)
 		}(w)
 	}
 	wg.Wait()
@@ -217,7 +507,7 @@ func main() {
 			}
 		}
 	default:
-		if !*print && !*validate {
+		if !*print && !*validate && *graph == "" {
 			fmt.Println("[Daisy] All workflows completed successfully.")
 		}
 	}
@@ -15,12 +15,19 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"testing"
+
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy"
 )
 
 func TestPopulateVars(t *testing.T) {
@@ -46,6 +53,53 @@ func TestPopulateVars(t *testing.T) {
 	}
 }
 
+func TestPopulateSecretVars(t *testing.T) {
+	flag.String("secret_var:key", "", "")
+	flag.CommandLine.Parse([]string{"-secret_var:key", "value"})
+
+	want := map[string]string{"key": "value"}
+	if got := populateSecretVars(); !reflect.DeepEqual(want, got) {
+		t.Errorf("populateSecretVars() = %q, want %q", got, want)
+	}
+	if _, ok := populateVars("")["key"]; ok {
+		t.Error("secret var should not be in populateVars()")
+	}
+}
+
+func TestReadVarFiles(t *testing.T) {
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	files := map[string]string{
+		"a.json": `{"key1": "json", "key2": "json", "size": 10}`,
+		"b.yaml": "key2: yaml\nkey3: yaml\n",
+		"c.env":  "# comment\nexport key3=env\nkey4=\"a,b\"\n",
+	}
+	var paths []string
+	for _, f := range []string{"a.json", "b.yaml", "c.env"} {
+		p := filepath.Join(td, f)
+		if err := ioutil.WriteFile(p, []byte(files[f]), 0600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+
+	got, err := readVarFiles(paths)
+	if err != nil {
+		t.Fatalf("error reading var files: %v", err)
+	}
+	want := map[string]string{"key1": "json", "key2": "yaml", "key3": "env", "key4": "a,b", "size": "10"}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("readVarFiles() = %q, want %q", got, want)
+	}
+
+	if _, err := readVarFiles([]string{filepath.Join(td, "dne.json")}); err == nil {
+		t.Error("missing file: expected error, got nil")
+	}
+}
+
 func TestAddFlags(t *testing.T) {
 	firstFlag := "var:first_var"
 	secondFlag := "var:second_var"
@@ -80,7 +134,7 @@ func TestParseWorkflows(t *testing.T) {
 	zone := "zone"
 	gcsPath := "gcspath"
 	oauth := "oauthpath"
-	w, err := parseWorkflow(context.Background(), path, varMap, project, zone, gcsPath, oauth, "", "")
+	w, err := parseWorkflow(context.Background(), path, varMap, nil, project, zone, gcsPath, oauth, "", "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -109,11 +163,78 @@ func TestParseWorkflows(t *testing.T) {
 		want = "dialing: cannot read credentials file: open oauthpath: The system cannot find the file specified."
 	}
 
-	if _, err := parseWorkflow(context.Background(), path, varMap, project, zone, gcsPath, oauth, "noplace", ""); err.Error() != want {
+	if _, err := parseWorkflow(context.Background(), path, varMap, nil, project, zone, gcsPath, oauth, "noplace", ""); err.Error() != want {
 		t.Errorf("did not get expected error, got: %q, want: %q", err.Error(), want)
 	}
 
-	if _, err := parseWorkflow(context.Background(), path, varMap, project, zone, gcsPath, oauth, "", "noplace"); err.Error() != want {
+	if _, err := parseWorkflow(context.Background(), path, varMap, nil, project, zone, gcsPath, oauth, "", "noplace"); err.Error() != want {
 		t.Errorf("did not get expected error, got: %q, want: %q", err.Error(), want)
 	}
 }
+
+func TestConvertPath(t *testing.T) {
+	tests := []struct{ path, want string }{
+		{"foo.wf.json", "foo.wf.yaml"},
+		{"dir/foo.wf.yaml", "dir/foo.wf.json"},
+		{"foo.wf.YML", "foo.wf.json"},
+	}
+	for _, tt := range tests {
+		if got := convertPath(tt.path); got != tt.want {
+			t.Errorf("convertPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRunLint(t *testing.T) {
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	clean := filepath.Join(td, "clean.wf.json")
+	unused := filepath.Join(td, "unused.wf.json")
+	bad := filepath.Join(td, "bad.wf.json")
+	for p, data := range map[string]string{
+		clean:  `{"Name": "clean", "Vars": {"v": "x"}, "Steps": {"s": {"Timeout": "${v}"}}}`,
+		unused: `{"Name": "unused", "Vars": {"v": "x", "required": {"Required": true}}}`,
+		bad:    `{"Name": }`,
+	} {
+		if err := ioutil.WriteFile(p, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		desc    string
+		args    []string
+		status  int
+		wantOut string
+	}{
+		{"no findings case", []string{clean}, 0, ""},
+		{"text case", []string{unused}, 1, fmt.Sprintf("%[1]s: Vars.required: required var \"required\" has no Description [required-var-description]\n%[1]s: Vars.required: var \"required\" is not used [unused-var]\n%[1]s: Vars.v: var \"v\" is not used [unused-var]\n", unused)},
+		{"disable case", []string{"-disable", "unused-var", unused}, 1, fmt.Sprintf("%s: Vars.required: required var \"required\" has no Description [required-var-description]\n", unused)},
+		{"json case", []string{"-format", "json", "-disable", "unused-var,required-var-description", unused, clean}, 0, "[]\n"},
+		{"bad rule case", []string{"-disable", "foo", clean}, 2, ""},
+		{"bad format case", []string{"-format", "xml", clean}, 2, ""},
+		{"no workflow case", nil, 2, ""},
+	}
+	for _, tt := range tests {
+		var out, errOut bytes.Buffer
+		if status := runLint(tt.args, &out, &errOut); status != tt.status {
+			t.Errorf("%s: exit status %d, want %d, stderr: %s", tt.desc, status, tt.status, errOut.String())
+		}
+		if out.String() != tt.wantOut {
+			t.Errorf("%s: output %q, want %q", tt.desc, out.String(), tt.wantOut)
+		}
+	}
+
+	var out bytes.Buffer
+	runLint([]string{"-format", "json", "-disable", "unused-var", bad, unused}, &out, ioutil.Discard)
+	var got []lintFinding
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, out.String())
+	}
+	if len(got) != 2 || got[0].File != bad || got[0].Rule != daisy.LintParse || got[1].File != unused || got[1].Field != "Vars.required" {
+		t.Errorf("unexpected findings: %+v", got)
+	}
+}
@@ -0,0 +1,485 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/storage"
+	"google.golang.org/api/googleapi"
+)
+
+const checkpointFile = "checkpoint.json"
+
+// checkpoint is the persisted state of a workflow run. The checkpoint of the
+// top level workflow is written to its scratch path as steps complete, it
+// contains the checkpoints of IncludeWorkflow and SubWorkflow steps.
+type checkpoint struct {
+	ID   string
+	Time time.Time
+	// Vars are only recorded for the top level workflow.
+	Vars map[string]string `json:",omitempty"`
+	// Steps that completed successfully.
+	Steps []string `json:",omitempty"`
+	// Outputs of the steps that completed, by step name.
+	Outputs map[string]map[string]string `json:",omitempty"`
+	// Resource registries, only recorded for workflows that own them.
+	Disks     map[string]*resourceCheckpoint `json:",omitempty"`
+	Images    map[string]*resourceCheckpoint `json:",omitempty"`
+	Instances map[string]*resourceCheckpoint `json:",omitempty"`
+	// Checkpoints of IncludeWorkflow and SubWorkflow steps, by step name.
+	Workflows map[string]*checkpoint `json:",omitempty"`
+}
+
+type resourceCheckpoint struct {
+	Real, Link string
+	Deleted    bool
+}
+
+func (c *checkpoint) resources(typeName string) map[string]*resourceCheckpoint {
+	if c == nil {
+		return nil
+	}
+	switch typeName {
+	case "disk":
+		return c.Disks
+	case "image":
+		return c.Images
+	case "instance":
+		return c.Instances
+	}
+	return nil
+}
+
+func readCheckpoint(ctx context.Context, client storage.Client, p string) (*checkpoint, dErr) {
+	var r io.ReadCloser
+	if bkt, obj, err := splitGCSPath(p); err == nil {
+		var err error
+		if r, err = client.NewReader(ctx, bkt, obj); err != nil {
+			return nil, typedErr(apiError, err)
+		}
+	} else {
+		var err error
+		if r, err = os.Open(p); err != nil {
+			return nil, typedErr(fileIOError, err)
+		}
+	}
+	defer r.Close()
+
+	var c checkpoint
+	if err := json.NewDecoder(r).Decode(&c); err != nil {
+		return nil, errf("error parsing checkpoint %q: %v", p, err)
+	}
+	if c.ID == "" {
+		return nil, errf("checkpoint %q has no run ID", p)
+	}
+	return &c, nil
+}
+
+// ownsRegistries returns false for included workflows, which share the
+// resource registries of their parent.
+func (w *Workflow) ownsRegistries() bool {
+	if w.parent == nil {
+		return true
+	}
+	disksMu.Lock()
+	defer disksMu.Unlock()
+	return disks[w] != disks[w.parent]
+}
+
+// childWorkflows returns the workflows of w's IncludeWorkflow and SubWorkflow
+// steps, by step name.
+func (w *Workflow) childWorkflows() map[string]*Workflow {
+	children := map[string]*Workflow{}
+	for name, s := range w.Steps {
+		if s == nil || s.skipped {
+			continue
+		}
+		if s.IncludeWorkflow != nil && s.IncludeWorkflow.Workflow != nil {
+			children[name] = s.IncludeWorkflow.Workflow
+		}
+		if s.SubWorkflow != nil && s.SubWorkflow.Workflow != nil {
+			children[name] = s.SubWorkflow.Workflow
+		}
+	}
+	return children
+}
+
+// allWorkflows returns w and all workflows nested in it.
+func (w *Workflow) allWorkflows() []*Workflow {
+	ws := []*Workflow{w}
+	for _, child := range w.childWorkflows() {
+		ws = append(ws, child.allWorkflows()...)
+	}
+	return ws
+}
+
+// registries returns the resource registries of w. The registries of
+// workflows are added as subworkflows populate, take the locks to read them.
+func (w *Workflow) registries() []*baseResourceRegistry {
+	var rs []*baseResourceRegistry
+	disksMu.Lock()
+	dr := disks[w]
+	disksMu.Unlock()
+	if dr != nil {
+		rs = append(rs, &dr.baseResourceRegistry)
+	}
+	imagesMu.Lock()
+	imr := images[w]
+	imagesMu.Unlock()
+	if imr != nil {
+		rs = append(rs, &imr.baseResourceRegistry)
+	}
+	instancesMu.Lock()
+	inr := instances[w]
+	instancesMu.Unlock()
+	if inr != nil {
+		rs = append(rs, &inr.baseResourceRegistry)
+	}
+	return rs
+}
+
+func (w *Workflow) stepDone(name string) bool {
+	w.stepsDoneMx.Lock()
+	defer w.stepsDoneMx.Unlock()
+	return w.stepsDone[name]
+}
+
+func (w *Workflow) setStepDone(name string, done bool) {
+	w.stepsDoneMx.Lock()
+	defer w.stepsDoneMx.Unlock()
+	if w.stepsDone == nil {
+		w.stepsDone = map[string]bool{}
+	}
+	if done {
+		w.stepsDone[name] = true
+	} else {
+		delete(w.stepsDone, name)
+	}
+}
+
+func (r *baseResourceRegistry) snapshot() map[string]*resourceCheckpoint {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if len(r.m) == 0 {
+		return nil
+	}
+	m := map[string]*resourceCheckpoint{}
+	for name, res := range r.m {
+		res.mx.Lock()
+		m[name] = &resourceCheckpoint{Real: res.real, Link: res.link, Deleted: res.deleted}
+		res.mx.Unlock()
+	}
+	return m
+}
+
+// checkpointed reports whether name was registered by the run being resumed.
+func (r *baseResourceRegistry) checkpointed(name string) bool {
+	if r.w == nil {
+		return false
+	}
+	_, ok := r.w.checkpoint.resources(r.typeName)[name]
+	return ok
+}
+
+func (w *Workflow) snapshot() *checkpoint {
+	c := &checkpoint{ID: w.id, Time: w.started}
+	w.stepsDoneMx.Lock()
+	for name := range w.stepsDone {
+		c.Steps = append(c.Steps, name)
+	}
+	w.stepsDoneMx.Unlock()
+	sort.Strings(c.Steps)
+
+	w.outputsMx.Lock()
+	for _, name := range c.Steps {
+		if len(w.outputs[name]) == 0 {
+			continue
+		}
+		if c.Outputs == nil {
+			c.Outputs = map[string]map[string]string{}
+		}
+		c.Outputs[name] = map[string]string{}
+		for k, v := range w.outputs[name] {
+			c.Outputs[name][k] = v
+		}
+	}
+	w.outputsMx.Unlock()
+
+	if w.ownsRegistries() {
+		for _, r := range w.registries() {
+			switch r.typeName {
+			case "disk":
+				c.Disks = r.snapshot()
+			case "image":
+				c.Images = r.snapshot()
+			case "instance":
+				c.Instances = r.snapshot()
+			}
+		}
+	}
+
+	for name, child := range w.childWorkflows() {
+		if c.Workflows == nil {
+			c.Workflows = map[string]*checkpoint{}
+		}
+		c.Workflows[name] = child.snapshot()
+	}
+	return c
+}
+
+func (w *Workflow) checkpointPath() string {
+	return fmt.Sprintf("gs://%s", path.Join(w.bucket, w.scratchPath, checkpointFile))
+}
+
+// writeCheckpoint persists the state of the top level workflow run to its
+// scratch path.
+func (w *Workflow) writeCheckpoint(ctx context.Context) dErr {
+	root := w.rootWorkflow()
+	root.checkpointMx.Lock()
+	defer root.checkpointMx.Unlock()
+
+	c := root.snapshot()
+	c.Vars = map[string]string{}
+	for k, v := range root.Vars {
+		// Secret vars are passed again to resume the run.
+		if !v.Secret {
+			c.Vars[k] = v.Value
+		}
+	}
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return newErr(err)
+	}
+
+	root.checkpointed = false
+	wc := root.StorageClient.NewWriter(ctx, root.bucket, path.Join(root.scratchPath, checkpointFile), "application/json")
+	if _, err := wc.Write(b); err != nil {
+		return typedErr(apiError, err)
+	}
+	if err := wc.Close(); err != nil {
+		return typedErr(apiError, err)
+	}
+	root.checkpointed = true
+	return nil
+}
+
+// keepsForResume reports whether the top level workflow of w keeps the
+// resources of its completed steps if it fails or is canceled: with
+// KeepForResume set, or when it resumes a run.
+func (w *Workflow) keepsForResume() bool {
+	root := w.rootWorkflow()
+	return root.KeepForResume || root.resumeFrom != ""
+}
+
+// keepForResume stops the cleanup of the resources created by the completed
+// steps of the failed or canceled run w, resuming the run from its checkpoint
+// adopts them again. Without a checkpoint of the run they are deleted. It
+// reports whether the resources are kept.
+func (w *Workflow) keepForResume() bool {
+	w.checkpointMx.Lock()
+	defer w.checkpointMx.Unlock()
+	if !w.checkpointed {
+		return false
+	}
+	w.keepCompleted = true
+	w.logger.Printf("Keeping the resources created by completed steps, resume the run with -resume %s", w.checkpointPath())
+	return true
+}
+
+// keptForResume reports whether res was created by a completed step of a
+// run that keeps them for resume.
+func (w *Workflow) keptForResume(res *resource) bool {
+	root := w.rootWorkflow()
+	root.checkpointMx.Lock()
+	keep := root.keepCompleted
+	root.checkpointMx.Unlock()
+	return keep && createdByCompletedStep(res)
+}
+
+// createdByCompletedStep reports whether res was created by a step that
+// completed.
+func createdByCompletedStep(res *resource) bool {
+	return res.creator != nil && res.creator.w.stepDone(res.creator.name)
+}
+
+// completeStep marks s as completed and persists the checkpoint.
+func (w *Workflow) completeStep(ctx context.Context, s *Step) {
+	w.setStepDone(s.name, true)
+	if err := w.writeCheckpoint(ctx); err != nil {
+		w.logger.Printf("Error writing checkpoint after step %q: %v", s.name, err)
+	}
+}
+
+func resourceLive(client compute.Client, typeName, link string) (bool, dErr) {
+	var err error
+	switch typeName {
+	case "disk":
+		m := namedSubexp(diskURLRgx, link)
+		_, err = client.GetDisk(m["project"], m["zone"], m["disk"])
+	case "image":
+		m := namedSubexp(imageURLRgx, link)
+		_, err = client.GetImage(m["project"], m["image"])
+	case "instance":
+		m := namedSubexp(instanceURLRgx, link)
+		_, err = client.GetInstance(m["project"], m["zone"], m["instance"])
+	default:
+		return false, errf("unknown resource type: %q", typeName)
+	}
+	if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, typedErr(apiError, err)
+	}
+	return true, nil
+}
+
+// resume applies the checkpoint being resumed from to the populated and
+// validated workflow:
+//   - steps completed by the previous run are marked as completed.
+//   - resources keep the names and links recorded by the previous run.
+//   - a completed step is run again if a resource it created no longer exists,
+//     as are all steps depending on it.
+//   - leftover resources of steps that will run again are deleted.
+func (w *Workflow) resume() dErr {
+	ws := w.allWorkflows()
+	for _, wf := range ws {
+		if wf.checkpoint == nil {
+			continue
+		}
+		for _, name := range wf.checkpoint.Steps {
+			if _, ok := wf.Steps[name]; ok {
+				wf.setStepDone(name, true)
+				for k, v := range wf.checkpoint.Outputs[name] {
+					wf.setOutput(name, k, v)
+				}
+			}
+		}
+	}
+
+	type registered struct {
+		typeName string
+		r        *baseResourceRegistry
+		res      *resource
+	}
+	var rs []registered
+	for _, wf := range ws {
+		if !wf.ownsRegistries() {
+			continue
+		}
+		for _, r := range wf.registries() {
+			saved := wf.checkpoint.resources(r.typeName)
+			r.mx.Lock()
+			for name, res := range r.m {
+				rc, ok := saved[name]
+				if !ok {
+					continue
+				}
+				res.real = rc.Real
+				res.link = rc.Link
+				// Resources without a deleter were deleted by a SubWorkflow cleanup.
+				res.deleted = rc.Deleted && (res.deleter == nil || res.deleter.w.stepDone(res.deleter.name))
+				rs = append(rs, registered{r.typeName, r, res})
+			}
+			r.mx.Unlock()
+		}
+	}
+
+	// Find completed steps whose resources are gone.
+	invalid := map[*Step]bool{}
+	for _, rr := range rs {
+		c := rr.res.creator
+		if c == nil || rr.res.deleted || !c.w.stepDone(c.name) || invalid[c] {
+			continue
+		}
+		live, err := resourceLive(w.ComputeClient, rr.typeName, rr.res.link)
+		if err != nil {
+			return err
+		}
+		if !live {
+			w.logger.Printf("Resuming: %s %q created by step %q no longer exists, step will run again.", rr.typeName, rr.res.real, c.name)
+			invalid[c] = true
+		}
+	}
+
+	// Invalidate steps that depend on invalid steps, and the IncludeWorkflow
+	// and SubWorkflow steps containing invalid steps.
+	var steps []*Step
+	for _, wf := range ws {
+		for _, s := range wf.Steps {
+			steps = append(steps, s)
+		}
+	}
+	for changed := len(invalid) > 0; changed; {
+		changed = false
+		for _, s := range steps {
+			if invalid[s] || !s.w.stepDone(s.name) {
+				continue
+			}
+			for i := range invalid {
+				if s.nestedDepends(i) || stepContains(s, i) {
+					invalid[s] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	for s := range invalid {
+		s.w.setStepDone(s.name, false)
+	}
+
+	// Delete leftovers from steps that will run (again).
+	for _, rr := range rs {
+		c := rr.res.creator
+		if c == nil || rr.res.deleted || c.w.stepDone(c.name) {
+			continue
+		}
+		live, err := resourceLive(w.ComputeClient, rr.typeName, rr.res.link)
+		if err != nil {
+			return err
+		}
+		if !live {
+			continue
+		}
+		w.logger.Printf("Resuming: deleting %s %q left over by step %q.", rr.typeName, rr.res.real, c.name)
+		if err := rr.r.deleteFn(rr.res); err != nil && err.Type() != resourceDNEError {
+			return err
+		}
+	}
+	return nil
+}
+
+// stepContains reports whether other is nested in the IncludeWorkflow or
+// SubWorkflow of s.
+func stepContains(s, other *Step) bool {
+	chain := other.getChain()
+	for i := 0; i < len(chain)-1; i++ {
+		if chain[i] == s {
+			return true
+		}
+	}
+	return false
+}
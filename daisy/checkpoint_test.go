@@ -0,0 +1,406 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/storage"
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+func TestCheckpointSnapshot(t *testing.T) {
+	w := testWorkflow()
+	w.started = time.Date(2017, 10, 18, 0, 0, 0, 0, time.UTC)
+	w.Steps = map[string]*Step{"s1": {w: w}, "s2": {w: w}}
+	w.setStepDone("s1", true)
+	w.setOutput("s1", "o", "v1")
+	w.setOutput("s2", "o", "v2")
+	disks[w].m = map[string]*resource{"d1": {real: "d1-real", link: "d1-link", deleted: true}}
+
+	got := w.snapshot()
+	want := &checkpoint{
+		ID:      w.id,
+		Time:    w.started,
+		Steps:   []string{"s1"},
+		Outputs: map[string]map[string]string{"s1": {"o": "v1"}},
+		Disks:   map[string]*resourceCheckpoint{"d1": {Real: "d1-real", Link: "d1-link", Deleted: true}},
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("checkpoint does not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestReadCheckpoint(t *testing.T) {
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	want := &checkpoint{
+		ID:    "abcdef",
+		Time:  time.Date(2017, 10, 18, 0, 0, 0, 0, time.UTC),
+		Vars:  map[string]string{"foo": "bar"},
+		Steps: []string{"s1"},
+		Workflows: map[string]*checkpoint{
+			"sub": {ID: "ghijkl", Steps: []string{"s2"}},
+		},
+	}
+	b, _ := json.Marshal(want)
+	good := filepath.Join(td, "good.json")
+	noID := filepath.Join(td, "noid.json")
+	bad := filepath.Join(td, "bad.json")
+	ioutil.WriteFile(good, b, 0600)
+	ioutil.WriteFile(noID, []byte(`{"Steps":["s1"]}`), 0600)
+	ioutil.WriteFile(bad, []byte(`{"ID":`), 0600)
+
+	got, err := readCheckpoint(context.Background(), nil, good)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("checkpoint does not match expectation: (-got +want)\n%s", diff)
+	}
+
+	for _, p := range []string{noID, bad, filepath.Join(td, "dne.json")} {
+		if _, err := readCheckpoint(context.Background(), nil, p); err == nil {
+			t.Errorf("%s: expected error", p)
+		}
+	}
+}
+
+func TestRunSkipsCompletedSteps(t *testing.T) {
+	w := testWorkflow()
+	var ran []string
+	var mx sync.Mutex
+	run := func(ctx context.Context, s *Step) dErr {
+		mx.Lock()
+		defer mx.Unlock()
+		ran = append(ran, s.name)
+		return nil
+	}
+	w.Steps = map[string]*Step{
+		"s1": {name: "s1", w: w, timeout: time.Minute, testType: &mockStep{runImpl: run}},
+		"s2": {name: "s2", w: w, timeout: time.Minute, testType: &mockStep{runImpl: run}},
+	}
+	w.Dependencies = map[string][]string{"s2": {"s1"}}
+	w.setStepDone("s1", true)
+
+	if err := w.run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(ran, []string{"s2"}); diff != "" {
+		t.Errorf("ran steps do not match expectation: (-got +want)\n%s", diff)
+	}
+	if !w.stepDone("s2") {
+		t.Error("s2 should be marked as completed")
+	}
+}
+
+func TestResume(t *testing.T) {
+	link := "projects/p/zones/z/disks/d"
+	notFound := &googleapi.Error{Code: http.StatusNotFound}
+
+	tests := []struct {
+		desc       string
+		done       []string
+		diskExists bool
+		wantDone   []string
+		wantDelete bool
+	}{
+		{"disk exists", []string{"create", "use", "other"}, true, []string{"create", "other", "use"}, false},
+		{"disk gone", []string{"create", "use", "other"}, false, []string{"other"}, false},
+		{"leftover disk", []string{"other"}, true, []string{"other"}, true},
+		{"no leftover disk", []string{"other"}, false, []string{"other"}, false},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		create := &Step{name: "create", w: w}
+		use := &Step{name: "use", w: w}
+		other := &Step{name: "other", w: w}
+		w.Steps = map[string]*Step{"create": create, "use": use, "other": other}
+		w.Dependencies = map[string][]string{"use": {"create"}}
+		disks[w].m = map[string]*resource{"d": {real: "d", link: link, creator: create, users: []*Step{use}}}
+		w.checkpoint = &checkpoint{
+			ID:    w.id,
+			Steps: tt.done,
+			Disks: map[string]*resourceCheckpoint{"d": {Real: "d", Link: link}},
+		}
+
+		deleted := false
+		c := w.ComputeClient.(*daisyCompute.TestClient)
+		c.GetDiskFn = func(_, _, _ string) (*compute.Disk, error) {
+			if tt.diskExists {
+				return &compute.Disk{}, nil
+			}
+			return nil, notFound
+		}
+		c.DeleteDiskFn = func(_, _, _ string) error {
+			deleted = true
+			return nil
+		}
+
+		if err := w.resume(); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		if diff := pretty.Compare(w.snapshot().Steps, tt.wantDone); diff != "" {
+			t.Errorf("%s: completed steps do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+		if deleted != tt.wantDelete {
+			t.Errorf("%s: leftover disk deleted: %t, want %t", tt.desc, deleted, tt.wantDelete)
+		}
+	}
+}
+
+func TestFailThenResume(t *testing.T) {
+	wf := `{
+  "Name": "resume",
+  "Steps": {
+    "create": {"CreateDisks": [{"Name": "disk", "SizeGb": "10"}]},
+    "build": {"TestRecord": {"Message": "build", "Fail": %t}}
+  },
+  "Dependencies": {"build": ["create"]}
+}`
+	td, err := ioutil.TempDir("", "daisy-resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	sc, err := storage.NewLocalClient(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(td, "test-bucket"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	recordedMx.Lock()
+	recorded = nil
+	recordedMx.Unlock()
+
+	w := fakeTestWorkflow(t, fmt.Sprintf(wf, true), nil)
+	defer os.RemoveAll(w.workflowDir)
+	w.StorageClient = sc
+	w.KeepForResume = true
+	if err := w.Run(context.Background()); err == nil {
+		t.Fatal("first run should have failed")
+	}
+	c := w.ComputeClient.(*daisyCompute.FakeClient)
+	disk := w.genName("disk")
+	if _, err := c.GetDisk("fake-project", "fake-zone", disk); err != nil {
+		t.Fatalf("failed run deleted the disk of a completed step: %v", err)
+	}
+
+	// The fixed workflow resumes the run with the disk the failed run kept.
+	rw := fakeTestWorkflow(t, fmt.Sprintf(wf, false), nil)
+	defer os.RemoveAll(rw.workflowDir)
+	rw.StorageClient = sc
+	rw.ComputeClient = c
+	o := &recordingObserver{}
+	rw.AddObserver(o)
+	if err := rw.Resume(context.Background(), w.checkpointPath()); err != nil {
+		t.Fatalf("error resuming run: %v", err)
+	}
+	if rw.ID() != w.ID() {
+		t.Errorf("resumed run ID %q, want %q", rw.ID(), w.ID())
+	}
+	if got := o.summary()["create"]; len(got) != 1 || got[0] != StepSkipped {
+		t.Errorf("got create step events %v, want it skipped", got)
+	}
+	if !rw.stepDone("create") || !rw.stepDone("build") {
+		t.Errorf("got completed steps %v, want create and build", rw.snapshot().Steps)
+	}
+	if diff := pretty.Compare(recorded, []string{"build", "build"}); diff != "" {
+		t.Errorf("recorded steps do not match expectation: (-got +want)\n%s", diff)
+	}
+	if _, err := c.GetDisk("fake-project", "fake-zone", disk); err == nil {
+		t.Errorf("disk %q should have been deleted by the resumed run", disk)
+	}
+}
+
+// cancelObserver closes the Cancel channel of w when step starts.
+type cancelObserver struct {
+	w    *Workflow
+	step string
+}
+
+func (o *cancelObserver) OnEvent(e *Event) {
+	if e.Type == StepStarted && e.Step == o.step {
+		close(o.w.Cancel)
+	}
+}
+
+func TestCancelThenResume(t *testing.T) {
+	wf := `{
+  "Name": "resume",
+  "Steps": {
+    "create": {"CreateDisks": [{"Name": "disk", "SizeGb": "10"}]},
+    "build": {"TestRecord": {"Message": "build"}},
+    "delete": {"DeleteResources": {"Disks": ["disk"]}}
+  },
+  "Dependencies": {"build": ["create"], "delete": ["build"]}
+}`
+	td, err := ioutil.TempDir("", "daisy-resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	sc, err := storage.NewLocalClient(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(td, "test-bucket"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	w := fakeTestWorkflow(t, wf, nil)
+	defer os.RemoveAll(w.workflowDir)
+	w.StorageClient = sc
+	w.KeepForResume = true
+	w.AddObserver(&cancelObserver{w, "build"})
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatalf("error running canceled workflow: %v", err)
+	}
+	c := w.ComputeClient.(*daisyCompute.FakeClient)
+	disk := w.genName("disk")
+	if _, err := c.GetDisk("fake-project", "fake-zone", disk); err != nil {
+		t.Fatalf("canceled run deleted the disk of a completed step: %v", err)
+	}
+
+	rw := fakeTestWorkflow(t, wf, nil)
+	defer os.RemoveAll(rw.workflowDir)
+	rw.StorageClient = sc
+	rw.ComputeClient = c
+	o := &recordingObserver{}
+	rw.AddObserver(o)
+	if err := rw.Resume(context.Background(), w.checkpointPath()); err != nil {
+		t.Fatalf("error resuming run: %v", err)
+	}
+	if got := o.summary()["create"]; len(got) != 1 || got[0] != StepSkipped {
+		t.Errorf("got create step events %v, want it skipped", got)
+	}
+	if _, err := c.GetDisk("fake-project", "fake-zone", disk); err == nil {
+		t.Errorf("disk %q should have been deleted by the resumed run", disk)
+	}
+}
+
+func TestFailWithoutKeepForResume(t *testing.T) {
+	td, err := ioutil.TempDir("", "daisy-resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	sc, err := storage.NewLocalClient(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(td, "test-bucket"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	w := fakeTestWorkflow(t, `{
+  "Name": "resume",
+  "Steps": {
+    "create": {"CreateDisks": [{"Name": "disk", "SizeGb": "10"}]},
+    "sub": {"SubWorkflow": {"Path": "./sub.wf.json"}},
+    "build": {"TestRecord": {"Message": "build", "Fail": true}}
+  },
+  "Dependencies": {"build": ["create", "sub"]}
+}`, map[string]string{"sub.wf.json": `{
+  "Steps": {"create": {"CreateDisks": [{"Name": "sub-disk", "SizeGb": "10", "RealName": "sub-disk"}]}}
+}`})
+	defer os.RemoveAll(w.workflowDir)
+	w.StorageClient = sc
+	if err := w.Run(context.Background()); err == nil {
+		t.Fatal("run should have failed")
+	}
+	if !w.stepDone("create") {
+		t.Fatal("run did not checkpoint its completed step")
+	}
+	c := w.ComputeClient.(*daisyCompute.FakeClient)
+	for _, disk := range []string{w.genName("disk"), "sub-disk"} {
+		if _, err := c.GetDisk("fake-project", "fake-zone", disk); err == nil {
+			t.Errorf("run without KeepForResume kept disk %q", disk)
+		}
+	}
+}
+
+// noCheckpointClient is a storage client failing to write checkpoints.
+type noCheckpointClient struct {
+	storage.Client
+}
+
+type errWriter struct{}
+
+func (errWriter) Write(b []byte) (int, error) { return 0, errors.New("write error") }
+
+func (errWriter) Close() error { return errors.New("write error") }
+
+func (c noCheckpointClient) NewWriter(ctx context.Context, bucket, object, contentType string) io.WriteCloser {
+	if path.Base(object) == checkpointFile {
+		return errWriter{}
+	}
+	return c.Client.NewWriter(ctx, bucket, object, contentType)
+}
+
+func TestFailWithoutCheckpoint(t *testing.T) {
+	td, err := ioutil.TempDir("", "daisy-resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	sc, err := storage.NewLocalClient(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(td, "test-bucket"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	w := fakeTestWorkflow(t, `{
+  "Name": "resume",
+  "Steps": {
+    "create": {"CreateDisks": [{"Name": "disk", "SizeGb": "10"}]},
+    "build": {"TestRecord": {"Message": "build", "Fail": true}}
+  },
+  "Dependencies": {"build": ["create"]}
+}`, nil)
+	defer os.RemoveAll(w.workflowDir)
+	w.StorageClient = noCheckpointClient{sc}
+	if err := w.Run(context.Background()); err == nil {
+		t.Fatal("run should have failed")
+	}
+	c := w.ComputeClient.(*daisyCompute.FakeClient)
+	if _, err := c.GetDisk("fake-project", "fake-zone", w.genName("disk")); err == nil {
+		t.Error("run that cannot be resumed kept its disk")
+	}
+}
@@ -20,7 +20,6 @@ import (
 	"os/user"
 	"reflect"
 	"regexp"
-	"strings"
 	"time"
 )
 
@@ -106,9 +105,14 @@ func strOr(s string, ss ...string) string {
 	return ""
 }
 
+// replacer replaces the substrings of strings, like a strings.Replacer.
+type replacer interface {
+	Replace(s string) string
+}
+
 // substitute runs replacer on string elements within a complex data structure
 // (except those contained in private data structure fields).
-func substitute(v reflect.Value, replacer *strings.Replacer) {
+func substitute(v reflect.Value, replacer replacer) {
 	traverseData(v, func(val reflect.Value) dErr {
 		switch val.Interface().(type) {
 		case string:
@@ -138,6 +142,10 @@ func traverseData(v reflect.Value, f func(reflect.Value) dErr) dErr {
 		if v.IsNil() {
 			return nil
 		}
+		if v.Type() == stepTypeTyp && v.Elem().Kind() == reflect.Ptr {
+			// A registered step type, the value it points to can be set.
+			return traverseData(v.Elem().Elem(), f)
+		}
 		// I'm a pointer, dereference me.
 		return traverseData(v.Elem(), f)
 	}
@@ -37,11 +37,15 @@ type Client interface {
 	DeleteDisk(project, zone, name string) error
 	DeleteImage(project, name string) error
 	DeleteInstance(project, zone, name string) error
+	SetDiskLabels(project, zone, name string, r *compute.ZoneSetLabelsRequest) error
+	SetImageLabels(project, name string, r *compute.GlobalSetLabelsRequest) error
+	SetInstanceLabels(project, zone, name string, r *compute.InstancesSetLabelsRequest) error
 	GetMachineType(project, zone, machineType string) (*compute.MachineType, error)
 	ListMachineTypes(project, zone string) ([]*compute.MachineType, error)
 	GetProject(project string) (*compute.Project, error)
 	GetSerialPortOutput(project, zone, name string, port, start int64) (*compute.SerialPortOutput, error)
 	GetZone(project, zone string) (*compute.Zone, error)
+	GetRegion(project, region string) (*compute.Region, error)
 	ListZones(project string) ([]*compute.Zone, error)
 	GetInstance(project, zone, name string) (*compute.Instance, error)
 	ListInstances(project, zone string) ([]*compute.Instance, error)
@@ -128,6 +132,29 @@ func NewClient(ctx context.Context, opts ...option.ClientOption) (Client, error)
 	return c, nil
 }
 
+// OperationError is returned when a GCE operation finishes with errors.
+type OperationError struct {
+	Operation *compute.Operation
+}
+
+func (e *OperationError) Error() string {
+	var operrs string
+	for _, operr := range e.Operation.Error.Errors {
+		operrs = operrs + fmt.Sprintf("\n  Code: %s, Message: %s", operr.Code, operr.Message)
+	}
+	return fmt.Sprintf("operation failed %+v: %s", e.Operation, operrs)
+}
+
+// HasCode reports whether one of the errors of the operation has code.
+func (e *OperationError) HasCode(code string) bool {
+	for _, operr := range e.Operation.Error.Errors {
+		if operr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *client) operationsWait(project, zone, name string) error {
 	for {
 		var err error
@@ -149,11 +176,7 @@ func (c *client) operationsWait(project, zone, name string) error {
 			continue
 		case "DONE":
 			if op.Error != nil {
-				var operrs string
-				for _, operr := range op.Error.Errors {
-					operrs = operrs + fmt.Sprintf("\n  Code: %s, Message: %s", operr.Code, operr.Message)
-				}
-				return fmt.Errorf("operation failed %+v: %s", op, operrs)
+				return &OperationError{Operation: op}
 			}
 		default:
 			return fmt.Errorf("unknown operation status %q: %+v", op.Status, op)
@@ -267,6 +290,36 @@ func (c *client) DeleteInstance(project, zone, name string) error {
 	return c.i.operationsWait(project, zone, op.Name)
 }
 
+// SetDiskLabels sets the labels of a GCE persistent disk.
+func (c *client) SetDiskLabels(project, zone, name string, r *compute.ZoneSetLabelsRequest) error {
+	op, err := c.Retry(c.raw.Disks.SetLabels(project, zone, name, r).Do)
+	if err != nil {
+		return err
+	}
+
+	return c.i.operationsWait(project, zone, op.Name)
+}
+
+// SetImageLabels sets the labels of a GCE image.
+func (c *client) SetImageLabels(project, name string, r *compute.GlobalSetLabelsRequest) error {
+	op, err := c.Retry(c.raw.Images.SetLabels(project, name, r).Do)
+	if err != nil {
+		return err
+	}
+
+	return c.i.operationsWait(project, "", op.Name)
+}
+
+// SetInstanceLabels sets the labels of a GCE instance.
+func (c *client) SetInstanceLabels(project, zone, name string, r *compute.InstancesSetLabelsRequest) error {
+	op, err := c.Retry(c.raw.Instances.SetLabels(project, zone, name, r).Do)
+	if err != nil {
+		return err
+	}
+
+	return c.i.operationsWait(project, zone, op.Name)
+}
+
 // GetMachineType gets a GCE MachineType.
 func (c *client) GetMachineType(project, zone, machineType string) (*compute.MachineType, error) {
 	mt, err := c.raw.MachineTypes.Get(project, zone, machineType).Do()
@@ -323,6 +376,15 @@ func (c *client) GetZone(project, zone string) (*compute.Zone, error) {
 	return z, err
 }
 
+// GetRegion gets a GCE Region, with its quotas.
+func (c *client) GetRegion(project, region string) (*compute.Region, error) {
+	r, err := c.raw.Regions.Get(project, region).Do()
+	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		return c.raw.Regions.Get(project, region).Do()
+	}
+	return r, err
+}
+
 // ListZones gets a list GCE Zones.
 func (c *client) ListZones(project string) ([]*compute.Zone, error) {
 	var zs []*compute.Zone
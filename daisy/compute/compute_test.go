@@ -293,3 +293,24 @@ func TestDeleteInstance(t *testing.T) {
 		t.Fatalf("error running DeleteInstance: %v", err)
 	}
 }
+
+func TestSetDiskLabels(t *testing.T) {
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.String() == fmt.Sprintf("/%s/zones/%s/disks/%s/setLabels?alt=json", testProject, testZone, testDisk) {
+			fmt.Fprint(w, `{}`)
+		} else if r.Method == "GET" && r.URL.String() == fmt.Sprintf("/%s/zones/%s/operations/?alt=json", testProject, testZone) {
+			fmt.Fprint(w, `{"Status":"DONE"}`)
+		} else {
+			w.WriteHeader(500)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	if err := c.SetDiskLabels(testProject, testZone, testDisk, &compute.ZoneSetLabelsRequest{Labels: map[string]string{"a": "b"}}); err != nil {
+		t.Fatalf("error running SetDiskLabels: %v", err)
+	}
+}
@@ -0,0 +1,1054 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package compute
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+const fakeBaseURL = "https://www.googleapis.com/compute/v1/"
+
+// Instance statuses the FakeClient moves instances through. Every call that
+// observes an instance moves it to the next status.
+var (
+	fakeStartStatuses = []string{"PROVISIONING", "STAGING", "RUNNING"}
+	fakeStopStatuses  = []string{"STOPPING", "TERMINATED"}
+)
+
+// InstanceScript drives the guest of instances created on a FakeClient.
+type InstanceScript struct {
+	// Regexp matched against the names of created instances.
+	Instance string
+	// Serial port the output is written to, defaults to 1.
+	Port int64 `json:",omitempty"`
+	// Output written to the serial port once the instance is running, one
+	// entry for each time the serial port output is read.
+	Output []string `json:",omitempty"`
+	// Stop the instance once all Output is written.
+	Stop bool `json:",omitempty"`
+
+	re *regexp.Regexp
+}
+
+// FakeScript is the JSON representation of resources to add to a FakeClient
+// and of the scripts driving its instances.
+type FakeScript struct {
+	// Projects and their zones.
+	Projects map[string][]string `json:",omitempty"`
+	// Images by partial URL: projects/PROJECT/global/images/IMAGE.
+	Images    []string          `json:",omitempty"`
+	Instances []*InstanceScript `json:",omitempty"`
+}
+
+type fakeInstance struct {
+	*compute.Instance
+	script   *InstanceScript
+	output   map[int64]string
+	statusIt int
+	written  int
+}
+
+type fakeProject struct {
+	zones        map[string]bool
+	machineTypes map[string]map[string]bool
+	licenses     map[string]bool
+	networks     map[string]*compute.Network
+	images       map[string]*compute.Image
+	disks        map[string]map[string]*compute.Disk
+	instances    map[string]map[string]*fakeInstance
+	// Quotas by region and metric.
+	quotas map[string]map[string]*compute.Quota
+	// Zones out of resources for new instances.
+	exhausted map[string]bool
+	// Zones failing disk creations, to the names of the failing disks, all
+	// disks if nil.
+	diskFails map[string]map[string]bool
+}
+
+// FakeClient is a stateful, in-memory Client. It keeps track of the disks,
+// images and instances created through it, and of the serial port output of
+// its instances, which is driven by InstanceScripts. Resources created without
+// a CreationTimestamp get the current time. Resources are copied as they are
+// stored and returned, changing them doesn't change the FakeClient's state.
+type FakeClient struct {
+	// Permissive treats projects, machine types, licenses and image families
+	// that were not added to the FakeClient as existing.
+	Permissive bool
+
+	mu       sync.Mutex
+	projects map[string]*fakeProject
+	scripts  []*InstanceScript
+	// Number of internal IPs handed out to instances.
+	ips int
+}
+
+// NewFakeClient returns an empty FakeClient.
+func NewFakeClient() *FakeClient {
+	return &FakeClient{projects: map[string]*fakeProject{}}
+}
+
+func notFound(format string, a ...interface{}) error {
+	return &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf(format, a...)}
+}
+
+func alreadyExists(format string, a ...interface{}) error {
+	return &googleapi.Error{Code: http.StatusConflict, Message: fmt.Sprintf(format, a...)}
+}
+
+func badRequest(format string, a ...interface{}) error {
+	return &googleapi.Error{Code: http.StatusBadRequest, Message: fmt.Sprintf(format, a...)}
+}
+
+// deepCopy copies the resource src to dst, so that the resources the
+// FakeClient stores and returns share no memory with its callers.
+func deepCopy(src, dst interface{}) {
+	data, err := json.Marshal(src)
+	if err == nil {
+		err = json.Unmarshal(data, dst)
+	}
+	if err != nil {
+		panic(fmt.Sprintf("error copying %T: %v", src, err))
+	}
+}
+
+// project returns the state of project, creating it if create is set.
+// Callers must hold c.mu.
+func (c *FakeClient) project(project string, create bool) (*fakeProject, error) {
+	if p, ok := c.projects[project]; ok {
+		return p, nil
+	}
+	if !create && !c.Permissive {
+		return nil, notFound("project %q not found", project)
+	}
+	p := &fakeProject{
+		zones:        map[string]bool{},
+		machineTypes: map[string]map[string]bool{},
+		licenses:     map[string]bool{},
+		networks:     map[string]*compute.Network{},
+		images:       map[string]*compute.Image{},
+		disks:        map[string]map[string]*compute.Disk{},
+		instances:    map[string]map[string]*fakeInstance{},
+		quotas:       map[string]map[string]*compute.Quota{},
+		exhausted:    map[string]bool{},
+		diskFails:    map[string]map[string]bool{},
+	}
+	p.networks["default"] = &compute.Network{Name: "default", SelfLink: fakeBaseURL + fmt.Sprintf("projects/%s/global/networks/default", project)}
+	c.projects[project] = p
+	return p, nil
+}
+
+func (c *FakeClient) zone(project, zone string) (*fakeProject, error) {
+	p, err := c.project(project, false)
+	if err != nil {
+		return nil, err
+	}
+	if !p.zones[zone] {
+		return nil, notFound("zone %q not found in project %q", zone, project)
+	}
+	return p, nil
+}
+
+// AddProject adds project and its zones to the FakeClient. Projects have a
+// "default" network.
+func (c *FakeClient) AddProject(project string, zones ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, _ := c.project(project, true)
+	for _, z := range zones {
+		p.zones[z] = true
+	}
+}
+
+// AddMachineType adds a machine type to a project zone.
+func (c *FakeClient) AddMachineType(project, zone, machineType string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, _ := c.project(project, true)
+	if p.machineTypes[zone] == nil {
+		p.machineTypes[zone] = map[string]bool{}
+	}
+	p.machineTypes[zone][machineType] = true
+}
+
+// SetQuota sets the limit and usage of a region quota of a project, such as
+// "CPUS". Regions have no quotas otherwise.
+func (c *FakeClient) SetQuota(project, region, metric string, limit, usage float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, _ := c.project(project, true)
+	if p.quotas[region] == nil {
+		p.quotas[region] = map[string]*compute.Quota{}
+	}
+	p.quotas[region][metric] = &compute.Quota{Metric: metric, Limit: limit, Usage: usage}
+}
+
+// ExhaustZone makes instance creations in a project zone fail as they do
+// when the zone is out of resources.
+func (c *FakeClient) ExhaustZone(project, zone string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, _ := c.project(project, true)
+	p.exhausted[zone] = true
+}
+
+// FailDiskCreations makes disk creations in a project zone fail, of the
+// disks with names if set, of all disks otherwise.
+func (c *FakeClient) FailDiskCreations(project, zone string, names ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, _ := c.project(project, true)
+	var fails map[string]bool
+	if len(names) > 0 {
+		fails = map[string]bool{}
+		for _, n := range names {
+			fails[n] = true
+		}
+	}
+	p.diskFails[zone] = fails
+}
+
+// AddLicense adds a license to a project.
+func (c *FakeClient) AddLicense(project, license string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, _ := c.project(project, true)
+	p.licenses[license] = true
+}
+
+// AddNetwork adds a network to a project.
+func (c *FakeClient) AddNetwork(project, network string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, _ := c.project(project, true)
+	p.networks[network] = &compute.Network{Name: network, SelfLink: fakeBaseURL + fmt.Sprintf("projects/%s/global/networks/%s", project, network)}
+}
+
+// AddImage adds an existing image to a project.
+func (c *FakeClient) AddImage(project string, i *compute.Image) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, _ := c.project(project, true)
+	i.Status = "READY"
+	i.SelfLink = fakeBaseURL + fmt.Sprintf("projects/%s/global/images/%s", project, i.Name)
+	if i.CreationTimestamp == "" {
+		i.CreationTimestamp = time.Now().Format(time.RFC3339)
+	}
+	stored := &compute.Image{}
+	deepCopy(i, stored)
+	p.images[i.Name] = stored
+}
+
+// AddInstanceScript adds a script for the guests of instances created after
+// this call. The first script matching an instance name is used.
+func (c *FakeClient) AddInstanceScript(s *InstanceScript) error {
+	re, err := regexp.Compile(s.Instance)
+	if err != nil {
+		return err
+	}
+	s.re = re
+	if s.Port == 0 {
+		s.Port = 1
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scripts = append(c.scripts, s)
+	return nil
+}
+
+// LoadScript adds the resources and instance scripts of the JSON FakeScript
+// read from r.
+func (c *FakeClient) LoadScript(r io.Reader) error {
+	var fs FakeScript
+	if err := json.NewDecoder(r).Decode(&fs); err != nil {
+		return err
+	}
+	for p, zones := range fs.Projects {
+		c.AddProject(p, zones...)
+	}
+	for _, u := range fs.Images {
+		parts := strings.Split(u, "/")
+		if len(parts) != 5 || parts[0] != "projects" || parts[2] != "global" || parts[3] != "images" {
+			return fmt.Errorf("bad image URL %q, want projects/PROJECT/global/images/IMAGE", u)
+		}
+		c.AddImage(parts[1], &compute.Image{Name: parts[4]})
+	}
+	for _, s := range fs.Instances {
+		if err := c.AddInstanceScript(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteSerialPortOutput appends output to the serial port of an instance.
+func (c *FakeClient) WriteSerialPortOutput(project, zone, name string, port int64, output string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i, err := c.instance(project, zone, name)
+	if err != nil {
+		return err
+	}
+	i.output[port] += output
+	return nil
+}
+
+// StopInstance stops an instance, as the guest shutting down would.
+func (c *FakeClient) StopInstance(project, zone, name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i, err := c.instance(project, zone, name)
+	if err != nil {
+		return err
+	}
+	i.Status = "TERMINATED"
+	return nil
+}
+
+// splitRef splits a full or partial resource URL into its project and name.
+func splitRef(ref, project string) (string, string) {
+	ref = strings.TrimPrefix(ref, fakeBaseURL)
+	parts := strings.Split(ref, "/")
+	if len(parts) > 1 && parts[0] == "projects" {
+		project = parts[1]
+	}
+	return project, parts[len(parts)-1]
+}
+
+// zoneOf returns the zone of a full or partial zonal resource URL.
+func zoneOf(ref, zone string) string {
+	parts := strings.Split(ref, "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if parts[i] == "zones" {
+			return parts[i+1]
+		}
+	}
+	return zone
+}
+
+func (c *FakeClient) disk(project, zone, name string) (*compute.Disk, error) {
+	p, err := c.zone(project, zone)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := p.disks[zone][name]
+	if !ok {
+		return nil, notFound("disk %q not found", name)
+	}
+	return d, nil
+}
+
+func (c *FakeClient) instance(project, zone, name string) (*fakeInstance, error) {
+	p, err := c.zone(project, zone)
+	if err != nil {
+		return nil, err
+	}
+	i, ok := p.instances[zone][name]
+	if !ok {
+		return nil, notFound("instance %q not found", name)
+	}
+	return i, nil
+}
+
+// sourceImage resolves an image reference, either by name or by family.
+func (c *FakeClient) sourceImage(ref, project string) (*compute.Image, error) {
+	ref = strings.TrimPrefix(ref, fakeBaseURL)
+	if i := strings.Index(ref, "/family/"); i != -1 {
+		p, _ := splitRef(ref[:i], project)
+		return c.imageFromFamily(p, ref[i+len("/family/"):])
+	}
+	p, name := splitRef(ref, project)
+	fp, err := c.project(p, false)
+	if err != nil {
+		return nil, err
+	}
+	img, ok := fp.images[name]
+	if !ok {
+		return nil, notFound("image %q not found in project %q", name, p)
+	}
+	return img, nil
+}
+
+func (c *FakeClient) imageFromFamily(project, family string) (*compute.Image, error) {
+	p, err := c.project(project, false)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name, img := range p.images {
+		if img.Family == family && img.Deprecated == nil {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		if c.Permissive {
+			return &compute.Image{Name: family, Family: family, Status: "READY"}, nil
+		}
+		return nil, notFound("image family %q not found in project %q", family, project)
+	}
+	sort.Strings(names)
+	return p.images[names[len(names)-1]], nil
+}
+
+func (c *FakeClient) createDisk(project, zone string, d *compute.Disk) error {
+	p, err := c.zone(project, zone)
+	if err != nil {
+		return err
+	}
+	if _, ok := p.disks[zone][d.Name]; ok {
+		return alreadyExists("disk %q already exists", d.Name)
+	}
+	if fails, ok := p.diskFails[zone]; ok && (fails == nil || fails[d.Name]) {
+		return fmt.Errorf("operation failed: can't create disk %q in zone %q", d.Name, zone)
+	}
+	if d.SourceImage != "" {
+		img, err := c.sourceImage(d.SourceImage, project)
+		if err != nil {
+			return err
+		}
+		if d.SizeGb == 0 {
+			d.SizeGb = img.DiskSizeGb
+		}
+	}
+	if d.SizeGb == 0 {
+		d.SizeGb = 10
+	}
+	d.Zone = fakeBaseURL + fmt.Sprintf("projects/%s/zones/%s", project, zone)
+	d.SelfLink = fakeBaseURL + fmt.Sprintf("projects/%s/zones/%s/disks/%s", project, zone, d.Name)
+	d.Status = "READY"
+	if d.CreationTimestamp == "" {
+		d.CreationTimestamp = time.Now().Format(time.RFC3339)
+	}
+	if p.disks[zone] == nil {
+		p.disks[zone] = map[string]*compute.Disk{}
+	}
+	stored := &compute.Disk{}
+	deepCopy(d, stored)
+	p.disks[zone][d.Name] = stored
+	return nil
+}
+
+// CreateDisk creates a disk, SourceImage has to exist.
+func (c *FakeClient) CreateDisk(project, zone string, d *compute.Disk) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.createDisk(project, zone, d)
+}
+
+// CreateImage creates an image, SourceDisk has to exist.
+func (c *FakeClient) CreateImage(project string, i *compute.Image) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, err := c.project(project, false)
+	if err != nil {
+		return err
+	}
+	if _, ok := p.images[i.Name]; ok {
+		return alreadyExists("image %q already exists", i.Name)
+	}
+	if i.SourceDisk != "" {
+		dp, name := splitRef(i.SourceDisk, project)
+		d, err := c.disk(dp, zoneOf(i.SourceDisk, ""), name)
+		if err != nil {
+			return err
+		}
+		i.DiskSizeGb = d.SizeGb
+	} else if i.RawDisk == nil || i.RawDisk.Source == "" {
+		return badRequest("image %q has no source", i.Name)
+	}
+	for _, l := range i.Licenses {
+		lp, name := splitRef(l, project)
+		if _, err := c.license(lp, name); err != nil {
+			return err
+		}
+	}
+	i.Status = "READY"
+	i.SelfLink = fakeBaseURL + fmt.Sprintf("projects/%s/global/images/%s", project, i.Name)
+	stored := &compute.Image{}
+	deepCopy(i, stored)
+	p.images[i.Name] = stored
+	return nil
+}
+
+// CreateInstance creates an instance. Attached disks have to exist, disks
+// with InitializeParams are created.
+func (c *FakeClient) CreateInstance(project, zone string, i *compute.Instance) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, err := c.zone(project, zone)
+	if err != nil {
+		return err
+	}
+	if _, ok := p.instances[zone][i.Name]; ok {
+		return alreadyExists("instance %q already exists", i.Name)
+	}
+	if p.exhausted[zone] {
+		return &OperationError{Operation: &compute.Operation{
+			Name:   "insert-" + i.Name,
+			Status: "DONE",
+			Error: &compute.OperationError{Errors: []*compute.OperationErrorErrors{{
+				Code:    "ZONE_RESOURCE_POOL_EXHAUSTED",
+				Message: fmt.Sprintf("The zone 'projects/%s/zones/%s' does not have enough resources available to fulfill the request.", project, zone),
+			}}},
+		}}
+	}
+	selfLink := fakeBaseURL + fmt.Sprintf("projects/%s/zones/%s/instances/%s", project, zone, i.Name)
+
+	// Disks are attached once they all can be, the disks created for the
+	// instance are deleted if one can't.
+	sources := make([]string, len(i.Disks))
+	var created []string
+	rollback := func(err error) error {
+		for _, name := range created {
+			delete(p.disks[zone], name)
+		}
+		return err
+	}
+	for j, ad := range i.Disks {
+		sources[j] = ad.Source
+		if ad.InitializeParams == nil {
+			continue
+		}
+		ip := ad.InitializeParams
+		name := ip.DiskName
+		if name == "" {
+			name = i.Name
+		}
+		d := &compute.Disk{Name: name, SourceImage: ip.SourceImage, SizeGb: ip.DiskSizeGb, Type: ip.DiskType, Labels: ip.Labels}
+		if err := c.createDisk(project, zone, d); err != nil {
+			return rollback(err)
+		}
+		created = append(created, name)
+		sources[j] = d.SelfLink
+	}
+	var attached []*compute.Disk
+	for j, ad := range i.Disks {
+		dp, name := splitRef(sources[j], project)
+		d, err := c.disk(dp, zoneOf(sources[j], zone), name)
+		if err != nil {
+			return rollback(err)
+		}
+		if len(d.Users) > 0 && ad.Mode != "READ_ONLY" {
+			return rollback(badRequest("disk %q is already being used by %q", d.Name, d.Users[0]))
+		}
+		attached = append(attached, d)
+	}
+	for j, d := range attached {
+		d.Users = append(d.Users, selfLink)
+		i.Disks[j].Source = sources[j]
+	}
+
+	fi := &fakeInstance{Instance: &compute.Instance{}, output: map[int64]string{}}
+	for _, s := range c.scripts {
+		if s.re.MatchString(i.Name) {
+			fi.script = s
+			break
+		}
+	}
+	for _, ni := range i.NetworkInterfaces {
+		c.ips++
+		ni.NetworkIP = fmt.Sprintf("10.128.%d.%d", c.ips/256, c.ips%256)
+		for _, ac := range ni.AccessConfigs {
+			ac.NatIP = fmt.Sprintf("203.0.113.%d", c.ips%256)
+		}
+	}
+	i.Zone = fakeBaseURL + fmt.Sprintf("projects/%s/zones/%s", project, zone)
+	i.SelfLink = selfLink
+	i.Status = fakeStartStatuses[0]
+	if i.CreationTimestamp == "" {
+		i.CreationTimestamp = time.Now().Format(time.RFC3339)
+	}
+	deepCopy(i, fi.Instance)
+	if p.instances[zone] == nil {
+		p.instances[zone] = map[string]*fakeInstance{}
+	}
+	p.instances[zone][i.Name] = fi
+	return nil
+}
+
+// DeleteDisk deletes a disk, the disk can't be attached to an instance.
+func (c *FakeClient) DeleteDisk(project, zone, name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, err := c.disk(project, zone, name)
+	if err != nil {
+		return err
+	}
+	if len(d.Users) > 0 {
+		return badRequest("disk %q is already being used by %q", name, d.Users[0])
+	}
+	delete(c.projects[project].disks[zone], name)
+	return nil
+}
+
+// DeleteImage deletes an image.
+func (c *FakeClient) DeleteImage(project, name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, err := c.project(project, false)
+	if err != nil {
+		return err
+	}
+	if _, ok := p.images[name]; !ok {
+		return notFound("image %q not found", name)
+	}
+	delete(p.images, name)
+	return nil
+}
+
+// DeleteInstance deletes an instance, and its attached disks set to
+// AutoDelete.
+func (c *FakeClient) DeleteInstance(project, zone, name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i, err := c.instance(project, zone, name)
+	if err != nil {
+		return err
+	}
+	for _, ad := range i.Disks {
+		dp, dName := splitRef(ad.Source, project)
+		d, err := c.disk(dp, zoneOf(ad.Source, zone), dName)
+		if err != nil {
+			continue
+		}
+		var users []string
+		for _, u := range d.Users {
+			if u != i.SelfLink {
+				users = append(users, u)
+			}
+		}
+		d.Users = users
+		if ad.AutoDelete && len(users) == 0 {
+			delete(c.projects[dp].disks[zoneOf(ad.Source, zone)], dName)
+		}
+	}
+	delete(c.projects[project].instances[zone], name)
+	return nil
+}
+
+// SetDiskLabels replaces the labels of a disk.
+func (c *FakeClient) SetDiskLabels(project, zone, name string, r *compute.ZoneSetLabelsRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, err := c.disk(project, zone, name)
+	if err != nil {
+		return err
+	}
+	d.Labels = r.Labels
+	return nil
+}
+
+// SetImageLabels replaces the labels of an image.
+func (c *FakeClient) SetImageLabels(project, name string, r *compute.GlobalSetLabelsRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, err := c.project(project, false)
+	if err != nil {
+		return err
+	}
+	img, ok := p.images[name]
+	if !ok {
+		return notFound("image %q not found", name)
+	}
+	img.Labels = r.Labels
+	return nil
+}
+
+// SetInstanceLabels replaces the labels of an instance.
+func (c *FakeClient) SetInstanceLabels(project, zone, name string, r *compute.InstancesSetLabelsRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i, err := c.instance(project, zone, name)
+	if err != nil {
+		return err
+	}
+	i.Labels = r.Labels
+	return nil
+}
+
+// GetMachineType gets a machine type.
+func (c *FakeClient) GetMachineType(project, zone, machineType string) (*compute.MachineType, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, err := c.zone(project, zone)
+	if err != nil {
+		return nil, err
+	}
+	if !p.machineTypes[zone][machineType] && !c.Permissive {
+		return nil, notFound("machine type %q not found", machineType)
+	}
+	return &compute.MachineType{Name: machineType, Zone: zone, GuestCpus: guestCpus(machineType)}, nil
+}
+
+// guestCpus returns the number of CPUs of machine type name: the number it
+// ends with as in "n1-standard-4", the number after "custom" as in
+// "custom-2-4096", or 1.
+func guestCpus(name string) int64 {
+	parts := strings.Split(name, "-")
+	n := parts[len(parts)-1]
+	for i, p := range parts[:len(parts)-1] {
+		if p == "custom" {
+			n = parts[i+1]
+		}
+	}
+	cpus, err := strconv.ParseInt(n, 10, 64)
+	if err != nil || cpus < 1 {
+		return 1
+	}
+	return cpus
+}
+
+// ListMachineTypes lists the machine types added to a project zone.
+func (c *FakeClient) ListMachineTypes(project, zone string) ([]*compute.MachineType, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, err := c.zone(project, zone)
+	if err != nil {
+		return nil, err
+	}
+	var mts []*compute.MachineType
+	for _, name := range sortedKeys(p.machineTypes[zone]) {
+		mts = append(mts, &compute.MachineType{Name: name, Zone: zone})
+	}
+	return mts, nil
+}
+
+// GetProject gets a project.
+func (c *FakeClient) GetProject(project string) (*compute.Project, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.project(project, false); err != nil {
+		return nil, err
+	}
+	return &compute.Project{Name: project}, nil
+}
+
+// tick moves an instance to its next status. Running instances are stopped
+// once their script is done.
+func (i *fakeInstance) tick() {
+	switch i.Status {
+	case "PROVISIONING", "STAGING":
+		i.statusIt++
+		i.Status = fakeStartStatuses[i.statusIt]
+	case "RUNNING":
+		if i.script != nil && i.script.Stop && i.written >= len(i.script.Output) {
+			i.Status = fakeStopStatuses[0]
+		}
+	case "STOPPING":
+		i.Status = fakeStopStatuses[1]
+	}
+}
+
+// GetSerialPortOutput gets the serial port output of an instance. Reading the
+// port of a running instance's script writes the next entry of its Output.
+func (c *FakeClient) GetSerialPortOutput(project, zone, name string, port, start int64) (*compute.SerialPortOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i, err := c.instance(project, zone, name)
+	if err != nil {
+		return nil, err
+	}
+	i.tick()
+	if i.Status == "TERMINATED" {
+		return nil, badRequest("instance %q is not running", name)
+	}
+	if s := i.script; i.Status == "RUNNING" && s != nil && s.Port == port && i.written < len(s.Output) {
+		i.output[port] += s.Output[i.written]
+		i.written++
+	}
+	out := i.output[port]
+	if start > int64(len(out)) {
+		start = int64(len(out))
+	}
+	return &compute.SerialPortOutput{Contents: out[start:], Start: start, Next: int64(len(out))}, nil
+}
+
+// GetZone gets a zone.
+func (c *FakeClient) GetZone(project, zone string) (*compute.Zone, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.zone(project, zone); err != nil {
+		return nil, err
+	}
+	return &compute.Zone{Name: zone}, nil
+}
+
+// GetRegion gets a region of a project with one of its zones, and the quotas
+// set for it.
+func (c *FakeClient) GetRegion(project, region string) (*compute.Region, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, err := c.project(project, false)
+	if err != nil {
+		return nil, err
+	}
+	r := &compute.Region{Name: region, SelfLink: fakeBaseURL + fmt.Sprintf("projects/%s/regions/%s", project, region)}
+	for _, z := range sortedKeys(p.zones) {
+		if strings.HasPrefix(z, region+"-") {
+			r.Zones = append(r.Zones, fakeBaseURL+fmt.Sprintf("projects/%s/zones/%s", project, z))
+		}
+	}
+	if r.Zones == nil && !c.Permissive {
+		return nil, notFound("region %q not found in project %q", region, project)
+	}
+	for _, q := range p.quotas[region] {
+		r.Quotas = append(r.Quotas, q)
+	}
+	sort.Slice(r.Quotas, func(i, j int) bool { return r.Quotas[i].Metric < r.Quotas[j].Metric })
+	return r, nil
+}
+
+// ListZones lists the zones added to a project.
+func (c *FakeClient) ListZones(project string) ([]*compute.Zone, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, err := c.project(project, false)
+	if err != nil {
+		return nil, err
+	}
+	var zs []*compute.Zone
+	for _, z := range sortedKeys(p.zones) {
+		zs = append(zs, &compute.Zone{Name: z})
+	}
+	return zs, nil
+}
+
+// GetInstance gets an instance.
+func (c *FakeClient) GetInstance(project, zone, name string) (*compute.Instance, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i, err := c.instance(project, zone, name)
+	if err != nil {
+		return nil, err
+	}
+	i.tick()
+	ci := &compute.Instance{}
+	deepCopy(i.Instance, ci)
+	return ci, nil
+}
+
+// ListInstances lists the instances in a project zone.
+func (c *FakeClient) ListInstances(project, zone string) ([]*compute.Instance, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, err := c.zone(project, zone)
+	if err != nil {
+		return nil, err
+	}
+	var is []*compute.Instance
+	for _, name := range sortedInstanceKeys(p.instances[zone]) {
+		ci := &compute.Instance{}
+		deepCopy(p.instances[zone][name].Instance, ci)
+		is = append(is, ci)
+	}
+	return is, nil
+}
+
+// GetDisk gets a disk.
+func (c *FakeClient) GetDisk(project, zone, name string) (*compute.Disk, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, err := c.disk(project, zone, name)
+	if err != nil {
+		return nil, err
+	}
+	cd := &compute.Disk{}
+	deepCopy(d, cd)
+	return cd, nil
+}
+
+// ListDisks lists the disks in a project zone.
+func (c *FakeClient) ListDisks(project, zone string) ([]*compute.Disk, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, err := c.zone(project, zone)
+	if err != nil {
+		return nil, err
+	}
+	var ds []*compute.Disk
+	for _, name := range sortedDiskKeys(p.disks[zone]) {
+		cd := &compute.Disk{}
+		deepCopy(p.disks[zone][name], cd)
+		ds = append(ds, cd)
+	}
+	return ds, nil
+}
+
+// GetImage gets an image.
+func (c *FakeClient) GetImage(project, name string) (*compute.Image, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, err := c.project(project, false)
+	if err != nil {
+		return nil, err
+	}
+	img, ok := p.images[name]
+	if !ok {
+		return nil, notFound("image %q not found", name)
+	}
+	ci := &compute.Image{}
+	deepCopy(img, ci)
+	return ci, nil
+}
+
+// GetImageFromFamily gets the latest image of a family, by name.
+func (c *FakeClient) GetImageFromFamily(project, family string) (*compute.Image, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	img, err := c.imageFromFamily(project, family)
+	if err != nil {
+		return nil, err
+	}
+	ci := &compute.Image{}
+	deepCopy(img, ci)
+	return ci, nil
+}
+
+// ListImages lists the images in a project.
+func (c *FakeClient) ListImages(project string) ([]*compute.Image, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, err := c.project(project, false)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range p.images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var is []*compute.Image
+	for _, name := range names {
+		ci := &compute.Image{}
+		deepCopy(p.images[name], ci)
+		is = append(is, ci)
+	}
+	return is, nil
+}
+
+func (c *FakeClient) license(project, name string) (*compute.License, error) {
+	p, err := c.project(project, false)
+	if err != nil {
+		return nil, err
+	}
+	if !p.licenses[name] && !c.Permissive {
+		return nil, notFound("license %q not found", name)
+	}
+	return &compute.License{Name: name}, nil
+}
+
+// GetLicense gets a license.
+func (c *FakeClient) GetLicense(project, name string) (*compute.License, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.license(project, name)
+}
+
+// GetNetwork gets a network.
+func (c *FakeClient) GetNetwork(project, name string) (*compute.Network, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, err := c.project(project, false)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := p.networks[name]
+	if !ok {
+		return nil, notFound("network %q not found", name)
+	}
+	return n, nil
+}
+
+// ListNetworks lists the networks in a project.
+func (c *FakeClient) ListNetworks(project string) ([]*compute.Network, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, err := c.project(project, false)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range p.networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var ns []*compute.Network
+	for _, name := range names {
+		ns = append(ns, p.networks[name])
+	}
+	return ns, nil
+}
+
+// InstanceStatus returns the status of an instance.
+func (c *FakeClient) InstanceStatus(project, zone, name string) (string, error) {
+	i, err := c.GetInstance(project, zone, name)
+	if err != nil {
+		return "", err
+	}
+	return i.Status, nil
+}
+
+// InstanceStopped checks if an instance is in a 'TERMINATED' or 'STOPPED'
+// state.
+func (c *FakeClient) InstanceStopped(project, zone, name string) (bool, error) {
+	status, err := c.InstanceStatus(project, zone, name)
+	if err != nil {
+		return false, err
+	}
+	return status == "TERMINATED" || status == "STOPPED", nil
+}
+
+// Retry calls f once, there is nothing to retry in memory.
+func (c *FakeClient) Retry(f func(opts ...googleapi.CallOption) (*compute.Operation, error), opts ...googleapi.CallOption) (*compute.Operation, error) {
+	return f(opts...)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+func sortedDiskKeys(m map[string]*compute.Disk) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+func sortedInstanceKeys(m map[string]*fakeInstance) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
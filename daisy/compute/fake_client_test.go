@@ -0,0 +1,251 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package compute
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+var _ Client = &FakeClient{}
+
+func errCode(err error) int {
+	if apiErr, ok := err.(*googleapi.Error); ok {
+		return apiErr.Code
+	}
+	return 0
+}
+
+func TestFakeClientResources(t *testing.T) {
+	c := NewFakeClient()
+	c.AddProject("p", "z")
+	c.AddImage("src", &compute.Image{Name: "i1", Family: "f"})
+
+	if err := c.CreateDisk("p", "z", &compute.Disk{Name: "d", SourceImage: "projects/src/global/images/family/f"}); err != nil {
+		t.Fatalf("error creating disk: %v", err)
+	}
+	if err := c.CreateDisk("p", "z", &compute.Disk{Name: "d"}); errCode(err) != http.StatusConflict {
+		t.Errorf("duplicate disk: got error %v, want 409", err)
+	}
+	if err := c.CreateDisk("p", "z", &compute.Disk{Name: "d2", SourceImage: "projects/src/global/images/dne"}); errCode(err) != http.StatusNotFound {
+		t.Errorf("disk from missing image: got error %v, want 404", err)
+	}
+	if err := c.CreateDisk("p", "dne", &compute.Disk{Name: "d2"}); errCode(err) != http.StatusNotFound {
+		t.Errorf("disk in missing zone: got error %v, want 404", err)
+	}
+
+	i := &compute.Instance{
+		Name: "i",
+		Disks: []*compute.AttachedDisk{
+			{Source: "projects/p/zones/z/disks/d"},
+			{AutoDelete: true, InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: "scratch"}},
+		},
+	}
+	if err := c.CreateInstance("p", "z", i); err != nil {
+		t.Fatalf("error creating instance: %v", err)
+	}
+	if err := c.DeleteDisk("p", "z", "d"); errCode(err) != http.StatusBadRequest {
+		t.Errorf("deleting attached disk: got error %v, want 400", err)
+	}
+	if err := c.CreateImage("p", &compute.Image{Name: "img", SourceDisk: "zones/z/disks/d"}); err != nil {
+		t.Errorf("error creating image: %v", err)
+	}
+	if _, err := c.GetImage("p", "img"); err != nil {
+		t.Errorf("error getting image: %v", err)
+	}
+
+	if err := c.DeleteInstance("p", "z", "i"); err != nil {
+		t.Fatalf("error deleting instance: %v", err)
+	}
+	if _, err := c.GetDisk("p", "z", "scratch"); errCode(err) != http.StatusNotFound {
+		t.Errorf("AutoDelete disk: got error %v, want 404", err)
+	}
+	if err := c.DeleteDisk("p", "z", "d"); err != nil {
+		t.Errorf("error deleting detached disk: %v", err)
+	}
+	ds, _ := c.ListDisks("p", "z")
+	if len(ds) != 0 {
+		t.Errorf("want no disks, got %d", len(ds))
+	}
+}
+
+func TestFakeClientCopies(t *testing.T) {
+	c := NewFakeClient()
+	c.AddProject("p", "z")
+	d := &compute.Disk{Name: "d", Labels: map[string]string{"k": "v"}}
+	if err := c.CreateDisk("p", "z", d); err != nil {
+		t.Fatalf("error creating disk: %v", err)
+	}
+	d.Labels["k"] = "changed"
+	got, err := c.GetDisk("p", "z", "d")
+	if err != nil {
+		t.Fatalf("error getting disk: %v", err)
+	}
+	got.Users = append(got.Users, "user")
+	got.SizeGb = 1
+	if got, _ := c.GetDisk("p", "z", "d"); len(got.Users) != 0 || got.SizeGb != 10 || got.Labels["k"] != "v" {
+		t.Errorf("disk changed through the disks passed to and returned by the client: %+v", got)
+	}
+
+	i := &compute.Instance{Name: "i", Disks: []*compute.AttachedDisk{{Source: "zones/z/disks/d"}}}
+	if err := c.CreateInstance("p", "z", i); err != nil {
+		t.Fatalf("error creating instance: %v", err)
+	}
+	i.Disks = nil
+	gotI, err := c.GetInstance("p", "z", "i")
+	if err != nil {
+		t.Fatalf("error getting instance: %v", err)
+	}
+	gotI.Disks[0].Source = "changed"
+	if gotI, _ := c.GetInstance("p", "z", "i"); len(gotI.Disks) != 1 || gotI.Disks[0].Source != "zones/z/disks/d" {
+		t.Errorf("instance changed through the instances passed to and returned by the client: %+v", gotI)
+	}
+}
+
+func TestFakeClientCreateInstanceRollback(t *testing.T) {
+	c := NewFakeClient()
+	c.AddProject("p", "z")
+	if err := c.CreateDisk("p", "z", &compute.Disk{Name: "attached"}); err != nil {
+		t.Fatalf("error creating disk: %v", err)
+	}
+	if err := c.CreateInstance("p", "z", &compute.Instance{Name: "user", Disks: []*compute.AttachedDisk{{Source: "zones/z/disks/attached"}}}); err != nil {
+		t.Fatalf("error creating instance: %v", err)
+	}
+
+	tests := []struct {
+		desc string
+		disk *compute.AttachedDisk
+		code int
+	}{
+		{"missing disk", &compute.AttachedDisk{Source: "zones/z/disks/dne"}, http.StatusNotFound},
+		{"disk in use", &compute.AttachedDisk{Source: "zones/z/disks/attached"}, http.StatusBadRequest},
+		{"duplicate disk name", &compute.AttachedDisk{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: "boot"}}, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		i := &compute.Instance{
+			Name:  "i",
+			Disks: []*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: "boot"}}, tt.disk},
+		}
+		if err := c.CreateInstance("p", "z", i); errCode(err) != tt.code {
+			t.Errorf("%s: got error %v, want %d", tt.desc, err, tt.code)
+		}
+		if _, err := c.GetDisk("p", "z", "boot"); errCode(err) != http.StatusNotFound {
+			t.Errorf("%s: disk created for the instance not deleted, got error %v", tt.desc, err)
+		}
+		if d, _ := c.GetDisk("p", "z", "attached"); len(d.Users) != 1 {
+			t.Errorf("%s: users of attached disk: got %v, want only the first instance", tt.desc, d.Users)
+		}
+	}
+}
+
+func TestFakeClientPermissive(t *testing.T) {
+	c := NewFakeClient()
+	if _, err := c.GetProject("p"); errCode(err) != http.StatusNotFound {
+		t.Errorf("missing project: got error %v, want 404", err)
+	}
+	if _, err := c.GetImageFromFamily("p", "f"); errCode(err) != http.StatusNotFound {
+		t.Errorf("missing family: got error %v, want 404", err)
+	}
+
+	c.Permissive = true
+	if _, err := c.GetProject("p"); err != nil {
+		t.Errorf("error getting project: %v", err)
+	}
+	if _, err := c.GetImageFromFamily("p", "f"); err != nil {
+		t.Errorf("error getting image family: %v", err)
+	}
+	if _, err := c.GetZone("p", "z"); errCode(err) != http.StatusNotFound {
+		t.Errorf("missing zone: got error %v, want 404", err)
+	}
+}
+
+func TestFakeClientRegion(t *testing.T) {
+	c := NewFakeClient()
+	c.AddProject("p", "us-central1-a", "us-central1-b")
+	c.SetQuota("p", "us-central1", "INSTANCES", 10, 2)
+	c.SetQuota("p", "us-central1", "CPUS", 24, 4)
+
+	r, err := c.GetRegion("p", "us-central1")
+	if err != nil {
+		t.Fatalf("error getting region: %v", err)
+	}
+	if len(r.Zones) != 2 {
+		t.Errorf("got zones %v, want the 2 zones of the region", r.Zones)
+	}
+	want := []*compute.Quota{{Metric: "CPUS", Limit: 24, Usage: 4}, {Metric: "INSTANCES", Limit: 10, Usage: 2}}
+	if diff := pretty.Compare(r.Quotas, want); diff != "" {
+		t.Errorf("quotas do not match expectation: (-got +want)\n%s", diff)
+	}
+	if _, err := c.GetRegion("p", "europe-west1"); errCode(err) != http.StatusNotFound {
+		t.Errorf("missing region: got error %v, want 404", err)
+	}
+
+	c.Permissive = true
+	for name, want := range map[string]int64{"n1-standard-4": 4, "f1-micro": 1, "custom-2-4096": 2} {
+		if mt, err := c.GetMachineType("p", "us-central1-a", name); err != nil || mt.GuestCpus != want {
+			t.Errorf("machine type %q: got %v, %v, want %d CPUs", name, mt, err, want)
+		}
+	}
+}
+
+func TestFakeClientExhaustZone(t *testing.T) {
+	c := NewFakeClient()
+	c.AddProject("p", "z1", "z2")
+	c.ExhaustZone("p", "z1")
+	if err := c.CreateInstance("p", "z1", &compute.Instance{Name: "i"}); err == nil || !strings.Contains(err.Error(), "ZONE_RESOURCE_POOL_EXHAUSTED") {
+		t.Errorf("exhausted zone: got error %v, want ZONE_RESOURCE_POOL_EXHAUSTED", err)
+	}
+	if err := c.CreateInstance("p", "z2", &compute.Instance{Name: "i"}); err != nil {
+		t.Errorf("error creating instance: %v", err)
+	}
+}
+
+func TestFakeClientInstanceScript(t *testing.T) {
+	c := NewFakeClient()
+	c.AddProject("p", "z")
+	c.CreateDisk("p", "z", &compute.Disk{Name: "d"})
+	if err := c.LoadScript(strings.NewReader(`{"Instances": [{"Instance": "^inst", "Output": ["booting\n", "DaisySuccess\n"], "Stop": true}]}`)); err != nil {
+		t.Fatalf("error loading script: %v", err)
+	}
+	if err := c.CreateInstance("p", "z", &compute.Instance{Name: "inst-1", Disks: []*compute.AttachedDisk{{Source: "zones/z/disks/d"}}}); err != nil {
+		t.Fatalf("error creating instance: %v", err)
+	}
+
+	if s, _ := c.InstanceStatus("p", "z", "inst-1"); s != "STAGING" {
+		t.Errorf("instance status: got %q, want STAGING", s)
+	}
+
+	var out string
+	var start int64
+	for n := 0; n < 10; n++ {
+		sp, err := c.GetSerialPortOutput("p", "z", "inst-1", 1, start)
+		if err != nil {
+			break
+		}
+		out += sp.Contents
+		start = sp.Next
+	}
+	if want := "booting\nDaisySuccess\n"; out != want {
+		t.Errorf("serial output: got %q, want %q", out, want)
+	}
+	if stopped, err := c.InstanceStopped("p", "z", "inst-1"); err != nil || !stopped {
+		t.Errorf("instance should be stopped, error: %v", err)
+	}
+}
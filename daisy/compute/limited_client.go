@@ -0,0 +1,85 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package compute
+
+import (
+	compute "google.golang.org/api/compute/v1"
+)
+
+// limitedClient is a Client that limits the number of mutating operations in
+// flight: creations, deletions and label changes. Read-only calls are passed
+// through.
+type limitedClient struct {
+	Client
+	sem chan struct{}
+}
+
+// NewLimitedClient returns a Client that runs at most n create, delete and
+// set labels operations of c at the same time. Callers over the limit block until an
+// operation finishes.
+func NewLimitedClient(c Client, n int) Client {
+	return &limitedClient{Client: c, sem: make(chan struct{}, n)}
+}
+
+func (c *limitedClient) limit(f func() error) error {
+	c.sem <- struct{}{}
+	defer func() { <-c.sem }()
+	return f()
+}
+
+// CreateDisk creates a GCE persistent disk.
+func (c *limitedClient) CreateDisk(project, zone string, d *compute.Disk) error {
+	return c.limit(func() error { return c.Client.CreateDisk(project, zone, d) })
+}
+
+// CreateImage creates a GCE image.
+func (c *limitedClient) CreateImage(project string, i *compute.Image) error {
+	return c.limit(func() error { return c.Client.CreateImage(project, i) })
+}
+
+// CreateInstance creates a GCE instance.
+func (c *limitedClient) CreateInstance(project, zone string, i *compute.Instance) error {
+	return c.limit(func() error { return c.Client.CreateInstance(project, zone, i) })
+}
+
+// DeleteDisk deletes a GCE persistent disk.
+func (c *limitedClient) DeleteDisk(project, zone, name string) error {
+	return c.limit(func() error { return c.Client.DeleteDisk(project, zone, name) })
+}
+
+// DeleteImage deletes a GCE image.
+func (c *limitedClient) DeleteImage(project, name string) error {
+	return c.limit(func() error { return c.Client.DeleteImage(project, name) })
+}
+
+// DeleteInstance deletes a GCE instance.
+func (c *limitedClient) DeleteInstance(project, zone, name string) error {
+	return c.limit(func() error { return c.Client.DeleteInstance(project, zone, name) })
+}
+
+// SetDiskLabels sets the labels of a GCE persistent disk.
+func (c *limitedClient) SetDiskLabels(project, zone, name string, r *compute.ZoneSetLabelsRequest) error {
+	return c.limit(func() error { return c.Client.SetDiskLabels(project, zone, name, r) })
+}
+
+// SetImageLabels sets the labels of a GCE image.
+func (c *limitedClient) SetImageLabels(project, name string, r *compute.GlobalSetLabelsRequest) error {
+	return c.limit(func() error { return c.Client.SetImageLabels(project, name, r) })
+}
+
+// SetInstanceLabels sets the labels of a GCE instance.
+func (c *limitedClient) SetInstanceLabels(project, zone, name string, r *compute.InstancesSetLabelsRequest) error {
+	return c.limit(func() error { return c.Client.SetInstanceLabels(project, zone, name, r) })
+}
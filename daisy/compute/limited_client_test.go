@@ -0,0 +1,94 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package compute
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// concurrencyClient records the highest number of concurrent CreateDisk and
+// SetDiskLabels calls, and of concurrent GetDisk calls.
+type concurrencyClient struct {
+	Client
+	mx                  sync.Mutex
+	inFlight, maxCreate int
+	reads, maxReads     int
+}
+
+func (c *concurrencyClient) track(n, max *int) func() {
+	c.mx.Lock()
+	*n++
+	if *n > *max {
+		*max = *n
+	}
+	c.mx.Unlock()
+	return func() {
+		c.mx.Lock()
+		*n--
+		c.mx.Unlock()
+	}
+}
+
+func (c *concurrencyClient) CreateDisk(project, zone string, d *compute.Disk) error {
+	defer c.track(&c.inFlight, &c.maxCreate)()
+	time.Sleep(5 * time.Millisecond)
+	return nil
+}
+
+func (c *concurrencyClient) SetDiskLabels(project, zone, name string, r *compute.ZoneSetLabelsRequest) error {
+	defer c.track(&c.inFlight, &c.maxCreate)()
+	time.Sleep(5 * time.Millisecond)
+	return nil
+}
+
+func (c *concurrencyClient) GetDisk(project, zone, name string) (*compute.Disk, error) {
+	defer c.track(&c.reads, &c.maxReads)()
+	time.Sleep(5 * time.Millisecond)
+	return nil, nil
+}
+
+func TestLimitedClient(t *testing.T) {
+	cc := &concurrencyClient{}
+	c := NewLimitedClient(cc, 2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			c.CreateDisk("p", "z", &compute.Disk{})
+		}()
+		go func() {
+			defer wg.Done()
+			c.SetDiskLabels("p", "z", "d", &compute.ZoneSetLabelsRequest{})
+		}()
+		go func() {
+			defer wg.Done()
+			c.GetDisk("p", "z", "d")
+		}()
+	}
+	wg.Wait()
+
+	if cc.maxCreate > 2 {
+		t.Errorf("want at most 2 concurrent creates and label changes, got %d", cc.maxCreate)
+	}
+	if cc.maxReads <= 2 {
+		t.Errorf("reads should not be limited, got at most %d concurrent reads", cc.maxReads)
+	}
+}
@@ -52,11 +52,15 @@ type TestClient struct {
 	DeleteDiskFn          func(project, zone, name string) error
 	DeleteImageFn         func(project, name string) error
 	DeleteInstanceFn      func(project, zone, name string) error
+	SetDiskLabelsFn       func(project, zone, name string, r *compute.ZoneSetLabelsRequest) error
+	SetImageLabelsFn      func(project, name string, r *compute.GlobalSetLabelsRequest) error
+	SetInstanceLabelsFn   func(project, zone, name string, r *compute.InstancesSetLabelsRequest) error
 	GetMachineTypeFn      func(project, zone, machineType string) (*compute.MachineType, error)
 	ListMachineTypesFn    func(project, zone string) ([]*compute.MachineType, error)
 	GetProjectFn          func(project string) (*compute.Project, error)
 	GetSerialPortOutputFn func(project, zone, name string, port, start int64) (*compute.SerialPortOutput, error)
 	GetZoneFn             func(project, zone string) (*compute.Zone, error)
+	GetRegionFn           func(project, region string) (*compute.Region, error)
 	ListZonesFn           func(project string) ([]*compute.Zone, error)
 	GetInstanceFn         func(project, zone, name string) (*compute.Instance, error)
 	ListInstancesFn       func(project, zone string) ([]*compute.Instance, error)
@@ -131,6 +135,30 @@ func (c *TestClient) DeleteInstance(project, zone, name string) error {
 	return c.client.DeleteInstance(project, zone, name)
 }
 
+// SetDiskLabels uses the override method SetDiskLabelsFn or the real implementation.
+func (c *TestClient) SetDiskLabels(project, zone, name string, r *compute.ZoneSetLabelsRequest) error {
+	if c.SetDiskLabelsFn != nil {
+		return c.SetDiskLabelsFn(project, zone, name, r)
+	}
+	return c.client.SetDiskLabels(project, zone, name, r)
+}
+
+// SetImageLabels uses the override method SetImageLabelsFn or the real implementation.
+func (c *TestClient) SetImageLabels(project, name string, r *compute.GlobalSetLabelsRequest) error {
+	if c.SetImageLabelsFn != nil {
+		return c.SetImageLabelsFn(project, name, r)
+	}
+	return c.client.SetImageLabels(project, name, r)
+}
+
+// SetInstanceLabels uses the override method SetInstanceLabelsFn or the real implementation.
+func (c *TestClient) SetInstanceLabels(project, zone, name string, r *compute.InstancesSetLabelsRequest) error {
+	if c.SetInstanceLabelsFn != nil {
+		return c.SetInstanceLabelsFn(project, zone, name, r)
+	}
+	return c.client.SetInstanceLabels(project, zone, name, r)
+}
+
 // GetProject uses the override method GetProjectFn or the real implementation.
 func (c *TestClient) GetProject(project string) (*compute.Project, error) {
 	if c.GetProjectFn != nil {
@@ -163,6 +191,14 @@ func (c *TestClient) GetZone(project, zone string) (*compute.Zone, error) {
 	return c.client.GetZone(project, zone)
 }
 
+// GetRegion uses the override method GetRegionFn or the real implementation.
+func (c *TestClient) GetRegion(project, region string) (*compute.Region, error) {
+	if c.GetRegionFn != nil {
+		return c.GetRegionFn(project, region)
+	}
+	return c.client.GetRegion(project, region)
+}
+
 // ListZones uses the override method ListZonesFn or the real implementation.
 func (c *TestClient) ListZones(project string) ([]*compute.Zone, error) {
 	if c.ListZonesFn != nil {
@@ -45,11 +45,15 @@ func TestTestClient(t *testing.T) {
 		{"delete disk", func() { c.DeleteDisk("a", "b", "c") }},
 		{"delete image", func() { c.DeleteImage("a", "b") }},
 		{"delete instance", func() { c.DeleteInstance("a", "b", "c") }},
+		{"set disk labels", func() { c.SetDiskLabels("a", "b", "c", &compute.ZoneSetLabelsRequest{}) }},
+		{"set image labels", func() { c.SetImageLabels("a", "b", &compute.GlobalSetLabelsRequest{}) }},
+		{"set instance labels", func() { c.SetInstanceLabels("a", "b", "c", &compute.InstancesSetLabelsRequest{}) }},
 		{"get serial port", func() { c.GetSerialPortOutput("a", "b", "c", 1, 2) }},
 		{"get project", func() { c.GetProject("a") }},
 		{"get machine type", func() { c.GetMachineType("a", "b", "c") }},
 		{"list machine types", func() { c.ListMachineTypes("a", "b") }},
 		{"get zone", func() { c.GetZone("a", "b") }},
+		{"get region", func() { c.GetRegion("a", "b") }},
 		{"list zones", func() { c.ListZones("a") }},
 		{"get instance", func() { c.GetInstance("a", "b", "c") }},
 		{"list instances", func() { c.ListInstances("a", "b") }},
@@ -93,12 +97,16 @@ func TestTestClient(t *testing.T) {
 	c.DeleteDiskFn = func(_, _, _ string) error { fakeCalled = true; return nil }
 	c.DeleteImageFn = func(_, _ string) error { fakeCalled = true; return nil }
 	c.DeleteInstanceFn = func(_, _, _ string) error { fakeCalled = true; return nil }
+	c.SetDiskLabelsFn = func(_, _, _ string, _ *compute.ZoneSetLabelsRequest) error { fakeCalled = true; return nil }
+	c.SetImageLabelsFn = func(_, _ string, _ *compute.GlobalSetLabelsRequest) error { fakeCalled = true; return nil }
+	c.SetInstanceLabelsFn = func(_, _, _ string, _ *compute.InstancesSetLabelsRequest) error { fakeCalled = true; return nil }
 	c.GetSerialPortOutputFn = func(_, _, _ string, _, _ int64) (*compute.SerialPortOutput, error) {
 		fakeCalled = true
 		return nil, nil
 	}
 	c.GetProjectFn = func(_ string) (*compute.Project, error) { fakeCalled = true; return nil, nil }
 	c.GetZoneFn = func(_, _ string) (*compute.Zone, error) { fakeCalled = true; return nil, nil }
+	c.GetRegionFn = func(_, _ string) (*compute.Region, error) { fakeCalled = true; return nil, nil }
 	c.ListZonesFn = func(_ string) ([]*compute.Zone, error) { fakeCalled = true; return nil, nil }
 	c.GetInstanceFn = func(_, _, _ string) (*compute.Instance, error) { fakeCalled = true; return nil, nil }
 	c.ListInstancesFn = func(_, _ string) ([]*compute.Instance, error) { fakeCalled = true; return nil, nil }
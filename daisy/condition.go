@@ -0,0 +1,332 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Step If conditions are parsed before var substitution: an expression, like
+// ${var}, is part of the operand it is in, so its value never changes the
+// structure of the condition. The grammar is:
+//   expr    = and { "||" and }
+//   and     = unary { "&&" unary }
+//   unary   = "!" unary | primary
+//   primary = "(" expr ")" | "empty(" [operand] ")" | operand [("==" | "!=") operand]
+//   operand = quoted string | bare word
+// A lone operand is a boolean test: it must be "true" or "false" (as parsed
+// by strconv.ParseBool), or empty, which is false.
+
+type condToken struct {
+	op  string // One of the operators, or "" for operands.
+	val string
+}
+
+// exprEnd returns the position after the ${...} expression at expr[i], or i
+// if there is none.
+func exprEnd(expr string, i int) int {
+	if strings.HasPrefix(expr[i:], "${") {
+		if _, end, ok := parseExpr(expr, i); ok {
+			return end
+		}
+	}
+	return i
+}
+
+func tokenizeCondition(expr string) ([]condToken, dErr) {
+	var toks []condToken
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case strings.HasPrefix(expr[i:], "=="), strings.HasPrefix(expr[i:], "!="),
+			strings.HasPrefix(expr[i:], "&&"), strings.HasPrefix(expr[i:], "||"):
+			toks = append(toks, condToken{op: expr[i : i+2]})
+			i += 2
+		case strings.HasPrefix(expr[i:], "empty("):
+			toks = append(toks, condToken{op: "empty("})
+			i += len("empty(")
+		case c == '!' || c == '(' || c == ')':
+			toks = append(toks, condToken{op: string(c)})
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(expr) && expr[j] != c {
+				if e := exprEnd(expr, j); e > j {
+					j = e
+				} else {
+					j++
+				}
+			}
+			if j == len(expr) {
+				return nil, errf("unterminated string in condition %q", expr)
+			}
+			toks = append(toks, condToken{val: expr[i+1 : j]})
+			i = j + 1
+		default:
+			j := i
+			for j < len(expr) && !unicode.IsSpace(rune(expr[j])) && !strings.ContainsRune(`!=&|()"'`, rune(expr[j])) {
+				if e := exprEnd(expr, j); e > j {
+					j = e
+				} else {
+					j++
+				}
+			}
+			if j == i {
+				return nil, errf("unexpected %q in condition %q", c, expr)
+			}
+			toks = append(toks, condToken{val: expr[i:j]})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+type condParser struct {
+	expr string
+	toks []condToken
+	pos  int
+}
+
+func (p *condParser) peek() *condToken {
+	if p.pos < len(p.toks) {
+		return &p.toks[p.pos]
+	}
+	return nil
+}
+
+func (p *condParser) accept(op string) bool {
+	if t := p.peek(); t != nil && t.op == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *condParser) operand() (string, dErr) {
+	t := p.peek()
+	if t == nil || t.op != "" {
+		return "", errf("expected a value at position %d in condition %q", p.pos+1, p.expr)
+	}
+	p.pos++
+	return t.val, nil
+}
+
+func (p *condParser) parseExpr() (bool, dErr) {
+	result, err := p.parseAnd()
+	if err != nil {
+		return false, err
+	}
+	for p.accept("||") {
+		b, err := p.parseAnd()
+		if err != nil {
+			return false, err
+		}
+		result = result || b
+	}
+	return result, nil
+}
+
+func (p *condParser) parseAnd() (bool, dErr) {
+	result, err := p.parseUnary()
+	if err != nil {
+		return false, err
+	}
+	for p.accept("&&") {
+		b, err := p.parseUnary()
+		if err != nil {
+			return false, err
+		}
+		result = result && b
+	}
+	return result, nil
+}
+
+func (p *condParser) parseUnary() (bool, dErr) {
+	if p.accept("!") {
+		b, err := p.parseUnary()
+		return !b, err
+	}
+	return p.parsePrimary()
+}
+
+func (p *condParser) parsePrimary() (bool, dErr) {
+	if p.accept("(") {
+		b, err := p.parseExpr()
+		if err != nil {
+			return false, err
+		}
+		if !p.accept(")") {
+			return false, errf("missing ')' in condition %q", p.expr)
+		}
+		return b, nil
+	}
+	if p.accept("empty(") {
+		if p.accept(")") {
+			return true, nil
+		}
+		v, err := p.operand()
+		if err != nil {
+			return false, err
+		}
+		if !p.accept(")") {
+			return false, errf("missing ')' in condition %q", p.expr)
+		}
+		return v == "", nil
+	}
+
+	left, err := p.operand()
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case p.accept("=="):
+		right, err := p.operand()
+		return left == right, err
+	case p.accept("!="):
+		right, err := p.operand()
+		return left != right, err
+	}
+	if left == "" {
+		return false, nil
+	}
+	b, pErr := strconv.ParseBool(left)
+	if pErr != nil {
+		return false, errf("%q is not a boolean in condition %q", left, p.expr)
+	}
+	return b, nil
+}
+
+// evalCondition evaluates a step If condition.
+func evalCondition(expr string) (bool, dErr) {
+	toks, err := tokenizeCondition(expr)
+	if err != nil {
+		return false, err
+	}
+	return evalTokens(expr, toks)
+}
+
+// evalTokens evaluates the tokens of condition expr.
+func evalTokens(expr string, toks []condToken) (bool, dErr) {
+	if len(toks) == 0 {
+		return false, errf("empty condition")
+	}
+	p := &condParser{expr: expr, toks: toks}
+	b, err := p.parseExpr()
+	if err != nil {
+		return false, err
+	}
+	if p.pos != len(p.toks) {
+		return false, errf("unexpected trailing input in condition %q", expr)
+	}
+	return b, nil
+}
+
+// substituteIf substitutes the expressions in the operands of the step's If
+// condition. The condition is parsed the first time, before any of its
+// expressions is substituted.
+func (s *Step) substituteIf(r replacer) dErr {
+	if s.If == "" {
+		return nil
+	}
+	if s.cond == nil {
+		toks, err := tokenizeCondition(s.If)
+		if err != nil {
+			return err
+		}
+		s.cond = toks
+	}
+	// ForEach expansions share the tokens, so they are copied.
+	cond := make([]condToken, len(s.cond))
+	for i, t := range s.cond {
+		if t.op == "" {
+			t.val = r.Replace(t.val)
+		}
+		cond[i] = t
+	}
+	s.cond = cond
+	return nil
+}
+
+// evalIf evaluates the step's If condition.
+func (s *Step) evalIf() (bool, dErr) {
+	if s.cond == nil {
+		return evalCondition(s.If)
+	}
+	return evalTokens(s.If, s.cond)
+}
+
+// substituteIfs calls substituteIf on steps, before the expressions in If
+// fields are substituted like the other strings.
+func substituteIfs(steps map[string]*Step, in *interpolator) {
+	for name, s := range steps {
+		if err := s.substituteIf(in); err != nil {
+			in.errorf("error parsing If of step %q: %v", name, err)
+		}
+	}
+}
+
+// runtimeIf reports whether the If condition of s is evaluated when s runs
+// rather than when it is populated: conditions referencing step outputs, and
+// conditions of handler steps, which may reference ${FAILED_STEP} and
+// ${ERROR}, only set once Steps are done.
+func (s *Step) runtimeIf() bool {
+	return s.If != "" && (hasOutputRef(s.If) || s.inHandler())
+}
+
+// checkRuntimeIf evaluates the If condition of s if it is only evaluated when
+// s runs, marking s skipped if it is false. It runs before s starts, so a
+// skipped step is never reported as started.
+func (s *Step) checkRuntimeIf() dErr {
+	if s.skipped || !s.runtimeIf() {
+		return nil
+	}
+	if err := s.resolveOutputs(); err != nil {
+		return err
+	}
+	run, err := s.evalIf()
+	if err != nil {
+		return errf("error evaluating If: %v", err)
+	}
+	if !run {
+		s.skipped = true
+	}
+	return nil
+}
+
+// conditionalCreator returns the step of the chain of creator, the step
+// creating a resource, that may not run when u, a step referencing the
+// resource, runs: a step whose If references outputs, so is only evaluated
+// when it runs, or a skipped step. Steps of u's own chain are not returned,
+// u is skipped along with them.
+func conditionalCreator(creator, u *Step) *Step {
+	uChain := u.getChain()
+Chain:
+	for _, st := range creator.getChain() {
+		if !st.skipped && !st.runtimeIf() {
+			continue
+		}
+		for _, us := range uChain {
+			if us == st {
+				continue Chain
+			}
+		}
+		return st
+	}
+	return nil
+}
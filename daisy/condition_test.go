@@ -0,0 +1,159 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestEvalCondition(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"true", true},
+		{"false", false},
+		{"True", true},
+		{"0", false},
+		{`""`, false},
+		{"!false", true},
+		{"foo == foo", true},
+		{"foo == bar", false},
+		{"foo != bar", true},
+		{`"licensed rhel" == 'licensed rhel'`, true},
+		{`"" == ""`, true},
+		{"empty()", true},
+		{`empty("")`, true},
+		{"empty(foo)", false},
+		{"!empty(foo)", true},
+		{"true && false", false},
+		{"true || false", true},
+		{"false || true && false", false},
+		{"(false || true) && true", true},
+		{"!(a == a) || b==b", true},
+	}
+	for _, tt := range tests {
+		got, err := evalCondition(tt.expr)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.expr, err)
+		} else if got != tt.want {
+			t.Errorf("%q: got %t, want %t", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"", "foo", "a ==", "== a", "(true", "empty(a", `"a == a`, "true false", "a = b", "true &&"} {
+		if _, err := evalCondition(expr); err == nil {
+			t.Errorf("%q: expected error", expr)
+		}
+	}
+}
+
+func TestTokenizeConditionExprs(t *testing.T) {
+	got, err := tokenizeCondition(`${a:-x y} == "${trimprefix(b, "x")}" && !empty(${c})`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []condToken{{val: "${a:-x y}"}, {op: "=="}, {val: `${trimprefix(b, "x")}`}, {op: "&&"}, {op: "!"}, {op: "empty("}, {val: "${c}"}, {op: ")"}}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("tokens do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestIfVarValues(t *testing.T) {
+	w := fakeTestWorkflow(t, `{
+  "Name": "if-vars",
+  "Vars": {"v": "true || b", "q": "x' == 'x"},
+  "Steps": {
+    "or": {"If": "${v} == b", "TestRecord": {"Message": "or"}},
+    "quote": {"If": "'${q}' != 'x'", "TestRecord": {"Message": "quote"}},
+    "value": {"If": "${v} == 'true || b'", "TestRecord": {"Message": "value"}}
+  }
+}`, nil)
+	defer os.RemoveAll(w.workflowDir)
+	recorded = nil
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatalf("error running workflow: %v", err)
+	}
+	sort.Strings(recorded)
+	if diff := pretty.Compare(recorded, []string{"quote", "value"}); diff != "" {
+		t.Errorf("recorded steps do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestRuntimeIfResources(t *testing.T) {
+	const record = `"record": {"TestRecord": {"Message": "record"}}`
+	tests := []struct {
+		desc, steps, deps, included, wantErr string
+	}{
+		{
+			"use",
+			`"create": {"If": "${steps.record.out} == x", "CreateDisks": [{"Name": "d", "SizeGb": "10"}]},
+    "image": {"CreateImages": [{"Name": "i", "SourceDisk": "d"}]}`,
+			`"create": ["record"], "image": ["create"]`,
+			"",
+			`cannot reference disk "d": step "create" creating it may be skipped`,
+		},
+		{
+			"delete",
+			`"create": {"If": "${steps.record.out} == x", "CreateDisks": [{"Name": "d", "SizeGb": "10"}]},
+    "delete": {"DeleteResources": {"Disks": ["d"]}}`,
+			`"create": ["record"], "delete": ["create"]`,
+			"",
+			`cannot reference disk "d": step "create" creating it may be skipped`,
+		},
+		{
+			"included",
+			`"include": {"If": "${steps.record.out} == x", "IncludeWorkflow": {"Path": "./included.wf.json"}}`,
+			`"include": ["record"]`,
+			`{"Steps": {
+    "create": {"CreateDisks": [{"Name": "d", "SizeGb": "10"}]},
+    "delete": {"DeleteResources": {"Disks": ["d"]}}
+  },
+  "Dependencies": {"delete": ["create"]}}`,
+			"",
+		},
+		{
+			"no output",
+			`"create": {"If": "x == x", "CreateDisks": [{"Name": "d", "SizeGb": "10"}]},
+    "delete": {"DeleteResources": {"Disks": ["d"]}}`,
+			`"create": ["record"], "delete": ["create"]`,
+			"",
+			"",
+		},
+	}
+	for _, tt := range tests {
+		w := fakeTestWorkflow(t, `{
+  "Name": "runtime-if",
+  "Steps": {
+    `+record+`,
+    `+tt.steps+`
+  },
+  "Dependencies": {`+tt.deps+`}
+}`, map[string]string{"included.wf.json": tt.included})
+		defer os.RemoveAll(w.workflowDir)
+		err := w.Validate(context.Background())
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		} else if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: got error %v, want error containing %q", tt.desc, err, tt.wantErr)
+		}
+	}
+}
@@ -27,6 +27,9 @@ const (
 
 	apiError    = "APIError"
 	apiError404 = "APIError404"
+
+	timeoutError      = "Timeout"
+	failureMatchError = "FailureMatch"
 )
 
 // dErr is a Daisy internal error type.
@@ -0,0 +1,125 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// EventType is the type of an Event.
+type EventType string
+
+// Event types.
+const (
+	WorkflowStarted   EventType = "WorkflowStarted"
+	WorkflowFinished  EventType = "WorkflowFinished"
+	StepStarted       EventType = "StepStarted"
+	StepFinished      EventType = "StepFinished"
+	StepSkipped       EventType = "StepSkipped"
+	StepFailed        EventType = "StepFailed"
+	ResourceCreated   EventType = "ResourceCreated"
+	ResourceDeleted   EventType = "ResourceDeleted"
+	SerialOutputMatch EventType = "SerialOutputMatch"
+)
+
+// Event is something that happened during a workflow run.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// Workflow the event happened in. The workflows of IncludeWorkflow and
+	// SubWorkflow steps are named after their parents, "parent/child".
+	Workflow string
+	Step     string `json:",omitempty"`
+	// Duration of the workflow or step, for WorkflowFinished, StepFinished
+	// and StepFailed events.
+	Duration time.Duration `json:",omitempty"`
+	// Resource type and name for ResourceCreated and ResourceDeleted
+	// events, the instance for SerialOutputMatch events.
+	ResourceType string `json:",omitempty"`
+	Resource     string `json:",omitempty"`
+	// Kind of match (SuccessMatch, FailureMatch or StatusMatch) and the
+	// matching serial output for SerialOutputMatch events.
+	MatchType string `json:",omitempty"`
+	Match     string `json:",omitempty"`
+	// Reason a step was skipped.
+	Reason string `json:",omitempty"`
+	// Error of a failed workflow or step.
+	Error string `json:",omitempty"`
+}
+
+// Observer is notified of the events of a workflow run. OnEvent is called
+// from the goroutines running the workflow, it must be safe for concurrent
+// use and should return quickly.
+type Observer interface {
+	OnEvent(*Event)
+}
+
+// AddObserver adds an observer of the workflow's events, including the
+// events of its IncludeWorkflow and SubWorkflow steps. Observers must be
+// added before the workflow runs.
+func (w *Workflow) AddObserver(o Observer) {
+	w.observers = append(w.observers, o)
+}
+
+// workflowPath returns the name of w prefixed with the names of its parents.
+func (w *Workflow) workflowPath() string {
+	if w.parent == nil {
+		return w.Name
+	}
+	return w.parent.workflowPath() + "/" + w.Name
+}
+
+// emit sends e to the observers of the top level workflow.
+func (w *Workflow) emit(e *Event) {
+	if w == nil {
+		return
+	}
+	root := w.rootWorkflow()
+	if len(root.observers) == 0 {
+		return
+	}
+	e.Time = time.Now()
+	e.Workflow = w.workflowPath()
+	e.Error = w.redact(e.Error)
+	e.Match = w.redact(e.Match)
+	for _, o := range root.observers {
+		o.OnEvent(e)
+	}
+}
+
+func (w *Workflow) emitResource(t EventType, typeName, name string) {
+	w.emit(&Event{Type: t, ResourceType: typeName, Resource: name})
+}
+
+// JSONObserver writes events as JSON, one event per line.
+type JSONObserver struct {
+	mx  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONObserver returns a JSONObserver writing to wr.
+func NewJSONObserver(wr io.Writer) *JSONObserver {
+	return &JSONObserver{enc: json.NewEncoder(wr)}
+}
+
+// OnEvent writes e.
+func (o *JSONObserver) OnEvent(e *Event) {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+	o.enc.Encode(e)
+}
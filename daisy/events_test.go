@@ -0,0 +1,207 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+)
+
+type recordingObserver struct {
+	mx     sync.Mutex
+	events []*Event
+}
+
+func (o *recordingObserver) OnEvent(e *Event) {
+	o.mx.Lock()
+	defer o.mx.Unlock()
+	o.events = append(o.events, e)
+}
+
+// summary returns the event types by step, or by resource for resource
+// events, in order.
+func (o *recordingObserver) summary() map[string][]EventType {
+	got := map[string][]EventType{}
+	for _, e := range o.events {
+		key := e.Step
+		switch e.Type {
+		case ResourceCreated, ResourceDeleted:
+			key = e.ResourceType
+		case WorkflowStarted, WorkflowFinished:
+			key = e.Workflow
+		}
+		got[key] = append(got[key], e.Type)
+	}
+	return got
+}
+
+func TestEvents(t *testing.T) {
+	wf := `{
+  "Name": "events",
+  "Steps": {
+    "create-instance": {"CreateInstances": [{"Name": "inst", "Disks": [{"InitializeParams": {"SourceImage": "projects/fake-src/global/images/fake-image"}}]}]},
+    "wait": {"WaitForInstancesSignal": [{"Name": "inst", "Interval": "1ms", "SerialOutput": {"Port": 1, "SuccessMatch": "DaisySuccess"}}]},
+    "skipped": {"If": "false", "CreateDisks": [{"Name": "disk", "SizeGb": "10"}]},
+    "delete-instance": {"DeleteResources": {"Instances": ["inst"]}}
+  },
+  "Dependencies": {
+    "wait": ["create-instance"],
+    "delete-instance": ["wait"]
+  }
+}`
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	tf := filepath.Join(td, "events.wf.json")
+	if err := ioutil.WriteFile(tf, []byte(wf), 0600); err != nil {
+		t.Fatalf("error writing workflow: %v", err)
+	}
+
+	w, err := NewFromFile(tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := daisyCompute.NewFakeClient()
+	c.Permissive = true
+	c.AddProject("fake-project", "fake-zone")
+	c.AddImage("fake-src", &compute.Image{Name: "fake-image"})
+	c.AddInstanceScript(&daisyCompute.InstanceScript{Instance: "^inst-", Output: []string{"DaisySuccess\n"}})
+	w.Project = "fake-project"
+	w.Zone = "fake-zone"
+	w.GCSPath = testGCSPath
+	w.ComputeClient = c
+	w.StorageClient, _ = newTestGCSClient()
+	w.logger = log.New(ioutil.Discard, "", 0)
+	o := &recordingObserver{}
+	w.AddObserver(o)
+
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatalf("error running workflow: %v", err)
+	}
+
+	want := map[string][]EventType{
+		"events":          {WorkflowStarted, WorkflowFinished},
+		"create-instance": {StepStarted, StepFinished},
+		"wait":            {StepStarted, SerialOutputMatch, StepFinished},
+		"skipped":         {StepSkipped},
+		"delete-instance": {StepStarted, StepFinished},
+		"instance":        {ResourceCreated, ResourceDeleted},
+		"disk":            {ResourceCreated, ResourceDeleted},
+	}
+	if diff := pretty.Compare(o.summary(), want); diff != "" {
+		t.Errorf("events do not match expectation: (-got +want)\n%s", diff)
+	}
+	for _, e := range o.events {
+		if e.Type == SerialOutputMatch && (e.MatchType != "SuccessMatch" || e.Match != "DaisySuccess" || !strings.HasPrefix(e.Resource, "inst-")) {
+			t.Errorf("unexpected SerialOutputMatch event: %+v", e)
+		}
+	}
+}
+
+func TestEventsFailedStep(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+	s.timeout = time.Minute
+	s.testType = &mockStep{runImpl: func(ctx context.Context, s *Step) dErr {
+		return errf("failure")
+	}}
+	o := &recordingObserver{}
+	w.AddObserver(o)
+	if err := w.run(context.Background()); err == nil {
+		t.Fatal("should have returned an error")
+	}
+	if len(o.events) != 2 || o.events[1].Type != StepFailed || o.events[1].Error != s.wrapRunError(errf("failure")).Error() {
+		t.Errorf("unexpected events: %v", o.events)
+	}
+}
+
+func TestEventsRuntimeIfSkipped(t *testing.T) {
+	w := testWorkflow()
+	for _, name := range []string{"s1", "s2", "s3"} {
+		s, _ := w.NewStep(name)
+		s.timeout = time.Minute
+		s.testType = &mockStep{runImpl: func(ctx context.Context, s *Step) dErr {
+			s.w.setOutput(s.name, "ok", "false")
+			return nil
+		}}
+	}
+	w.Steps["s2"].If = "${steps.s1.ok}"
+	w.AddDependency("s2", "s1")
+	w.AddDependency("s3", "s2")
+	o := &recordingObserver{}
+	w.AddObserver(o)
+	if err := w.run(context.Background()); err != nil {
+		t.Fatalf("error running workflow: %v", err)
+	}
+
+	var got []string
+	for _, e := range o.events {
+		got = append(got, fmt.Sprintf("%s %s", e.Type, e.Step))
+	}
+	want := []string{"StepStarted s1", "StepFinished s1", "StepSkipped s2", "StepStarted s3", "StepFinished s3"}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("events do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestEventWorkflowPath(t *testing.T) {
+	w := testWorkflow()
+	o := &recordingObserver{}
+	w.AddObserver(o)
+	sw := w.NewSubWorkflow()
+	sw.Name = "sub"
+	iw := sw.NewIncludedWorkflow()
+	iw.Name = "inc"
+
+	iw.emit(&Event{Type: StepStarted})
+	if len(o.events) != 1 || o.events[0].Workflow != testWf+"/sub/inc" {
+		t.Errorf("unexpected events: %v", o.events)
+	}
+}
+
+func TestJSONObserver(t *testing.T) {
+	var buf bytes.Buffer
+	o := NewJSONObserver(&buf)
+	o.OnEvent(&Event{Type: StepStarted, Step: "s1"})
+	o.OnEvent(&Event{Type: StepFinished, Step: "s1"})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("want 2 lines, got %q", buf.String())
+	}
+	var e Event
+	if err := json.Unmarshal([]byte(lines[1]), &e); err != nil {
+		t.Fatalf("error unmarshalling event: %v", err)
+	}
+	if e.Type != StepFinished || e.Step != "s1" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+}
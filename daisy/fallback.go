@@ -0,0 +1,206 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"strings"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// stockoutCodes are the error codes of instance creations failing because the
+// zone is out of the requested resources.
+var stockoutCodes = []string{"ZONE_RESOURCE_POOL_EXHAUSTED", "ZONE_RESOURCE_POOL_EXHAUSTED_WITH_DETAILS"}
+
+func isStockout(err error) bool {
+	opErr, ok := err.(*daisyCompute.OperationError)
+	if !ok {
+		return false
+	}
+	for _, code := range stockoutCodes {
+		if opErr.HasCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// inZone returns url, a link to a zonal resource, with its zone changed from
+// from to to.
+func inZone(url, from, to string) string {
+	return strings.Replace(url, "zones/"+from+"/", "zones/"+to+"/", 1)
+}
+
+// getByLink returns the resource with link, or nil.
+func (r *baseResourceRegistry) getByLink(link string) *resource {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	for _, res := range r.m {
+		if res.link == link {
+			return res
+		}
+	}
+	return nil
+}
+
+func (res *resource) setLink(link string) {
+	res.mx.Lock()
+	res.link = link
+	res.mx.Unlock()
+}
+
+// movableDisk returns the CreateDisk that created the disk attached as
+// source, if the disk can be created again in another zone: no step used it
+// before step s.
+func (c *CreateInstance) movableDisk(s *Step, source string) (*CreateDisk, *resource, dErr) {
+	r := disks[s.w].getByLink(source)
+	if r == nil || r.creator == nil || r.creator.CreateDisks == nil {
+		return nil, nil, errf("disk %q was not created by a CreateDisks step of the workflow", source)
+	}
+	for _, u := range r.users {
+		if u != s && s.nestedDepends(u) {
+			return nil, nil, errf("disk %q was used by step %q", source, u.name)
+		}
+	}
+	for _, cd := range *r.creator.CreateDisks {
+		if cd.Project == c.Project && cd.Zone == c.Zone && cd.Name == r.real {
+			return cd, r, nil
+		}
+	}
+	return nil, nil, errf("disk %q was not created by a CreateDisks step of the workflow", source)
+}
+
+// diskInZone returns the request creating d, a disk created in zone from,
+// again in zone to: the fields of the CreateDisks request, without the ones
+// the API set when it created d.
+func diskInZone(d *compute.Disk, from, to string) *compute.Disk {
+	return &compute.Disk{
+		Name:            d.Name,
+		Description:     d.Description,
+		SizeGb:          d.SizeGb,
+		SourceImage:     d.SourceImage,
+		SourceSnapshot:  d.SourceSnapshot,
+		Type:            inZone(d.Type, from, to),
+		Labels:          d.Labels,
+		GuestOsFeatures: d.GuestOsFeatures,
+	}
+}
+
+// moveToZone moves c, not created yet, to zone: the disks the workflow
+// created for it are created again in zone, then the links of c and its
+// disks in the registries are updated and the disks in the old zone are
+// deleted. If a disk can't be created, the disks already created in zone are
+// deleted and c is left in its zone.
+func (c *CreateInstance) moveToZone(s *Step, zone string) dErr {
+	w := s.w
+	from := c.Zone
+	if zoneRegion(from) != zoneRegion(zone) {
+		for _, ni := range c.NetworkInterfaces {
+			if ni.Subnetwork != "" {
+				return errf("can't move instance with subnetwork %q out of region %q", ni.Subnetwork, zoneRegion(from))
+			}
+		}
+	}
+
+	// Check all disks first, so that none is moved if one can't be.
+	type move struct {
+		cd *CreateDisk
+		r  *resource
+		d  *compute.Disk
+	}
+	moves := map[int]*move{}
+	for i, ad := range c.Disks {
+		if ad.InitializeParams != nil {
+			continue
+		}
+		cd, r, err := c.movableDisk(s, ad.Source)
+		if err != nil {
+			return err
+		}
+		moves[i] = &move{cd: cd, r: r}
+	}
+
+	var created []*move
+	for i := range c.Disks {
+		m, ok := moves[i]
+		if !ok {
+			continue
+		}
+		w.logger.Printf("CreateInstances: moving disk %q of instance %q to zone %q.", m.cd.Name, c.Name, zone)
+		m.d = diskInZone(&m.cd.Disk, from, zone)
+		if err := w.ComputeClient.CreateDisk(m.cd.Project, zone, m.d); err != nil {
+			for _, cm := range created {
+				if dErr := w.ComputeClient.DeleteDisk(cm.cd.Project, zone, cm.cd.Name); dErr != nil {
+					w.logger.Printf("CreateInstances: error deleting disk %q moved to zone %q: %v", cm.cd.Name, zone, dErr)
+				}
+			}
+			return errf("error creating disk %q in zone %q: %v", m.cd.Name, zone, err)
+		}
+		created = append(created, m)
+	}
+
+	// All disks exist in zone, the registries now point to them.
+	for i, ad := range c.Disks {
+		if p := ad.InitializeParams; p != nil {
+			p.DiskType = inZone(p.DiskType, from, zone)
+			if r, ok := disks[w].get(p.DiskName); ok {
+				r.setLink(inZone(r.link, from, zone))
+			}
+			continue
+		}
+		m := moves[i]
+		m.cd.Disk = *m.d
+		m.cd.Zone = zone
+		link := fmt.Sprintf("projects/%s/zones/%s/disks/%s", m.cd.Project, zone, m.cd.Name)
+		if m.cd.SourceImage != "" {
+			w.recordDiskImage(link, m.cd.SourceImage)
+		}
+		m.r.setLink(link)
+		ad.Source = link
+	}
+	c.MachineType = inZone(c.MachineType, from, zone)
+	if r, ok := instances[w].get(c.daisyName); ok {
+		r.setLink(inZone(r.link, from, zone))
+	}
+	c.Zone = zone
+
+	// The old disks are no longer in the registry, those that can't be
+	// deleted are left to -sweep.
+	for _, m := range created {
+		if err := w.ComputeClient.DeleteDisk(m.cd.Project, from, m.cd.Name); err != nil {
+			w.logger.Printf("CreateInstances: error deleting disk %q in zone %q, left to -sweep: %v", m.cd.Name, from, err)
+		}
+	}
+	return nil
+}
+
+// create creates c, in its FallbackZones in turn while its zone is out of
+// resources.
+func (c *CreateInstance) create(s *Step) error {
+	err := s.w.ComputeClient.CreateInstance(c.Project, c.Zone, &c.Instance)
+	for _, zone := range c.FallbackZones {
+		if !isStockout(err) {
+			break
+		}
+		s.w.logger.Printf("CreateInstances: zone %q is out of resources for instance %q, trying zone %q.", c.Zone, c.Name, zone)
+		if mErr := c.moveToZone(s, zone); mErr != nil {
+			return fmt.Errorf("%v; can't try zone %q: %v", err, zone, mErr)
+		}
+		err = s.w.ComputeClient.CreateInstance(c.Project, c.Zone, &c.Instance)
+	}
+	return err
+}
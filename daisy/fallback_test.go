@@ -0,0 +1,177 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestFallbackZones(t *testing.T) {
+	tests := []struct {
+		desc, steps, deps string
+		wantErr           string
+	}{
+		{
+			"disks moved",
+			`"disk": {"CreateDisks": [{"Name": "d", "SizeGb": "10", "NoCleanup": true}]},
+    "inst": {"CreateInstances": [{"Name": "i", "Disks": [{"Source": "d"}, {"AutoDelete": true, "InitializeParams": {"DiskName": "i-data", "SourceImage": "projects/other/global/images/family/base"}}], "FallbackZones": ["fallback-region-a", "fallback-region-b"], "NoCleanup": true}]},
+    "image": {"CreateImages": [{"Name": "img", "SourceDisk": "d", "NoCleanup": true}]}`,
+			`"inst": ["disk"], "image": ["inst"]`,
+			"",
+		},
+		{
+			"disk used before",
+			`"disk": {"CreateDisks": [{"Name": "d", "SizeGb": "10"}]},
+    "image": {"CreateImages": [{"Name": "img", "SourceDisk": "d"}]},
+    "inst": {"CreateInstances": [{"Name": "i", "Disks": [{"Source": "d"}], "FallbackZones": ["fallback-region-b"]}]}`,
+			`"image": ["disk"], "inst": ["image"]`,
+			`was used by step "image"`,
+		},
+	}
+	for _, tt := range tests {
+		w := fakeTestWorkflow(t, `{"Name": "fallback", "Steps": {`+tt.steps+`}, "Dependencies": {`+tt.deps+`}}`, nil)
+		defer os.RemoveAll(w.workflowDir)
+		w.Zone = "fallback-region-z"
+		c := w.ComputeClient.(*daisyCompute.FakeClient)
+		c.AddProject("fake-project", "fallback-region-z", "fallback-region-a", "fallback-region-b")
+		c.ExhaustZone("fake-project", "fallback-region-z")
+		c.ExhaustZone("fake-project", "fallback-region-a")
+
+		err := w.Run(context.Background())
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got error %v, want error containing %q", tt.desc, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		for _, d := range []string{w.genName("d"), "i-data"} {
+			if _, err := c.GetDisk("fake-project", "fallback-region-b", d); err != nil {
+				t.Errorf("%s: disk %q not in fallback zone: %v", tt.desc, d, err)
+			}
+		}
+		if _, err := c.GetDisk("fake-project", "fallback-region-z", w.genName("d")); err == nil {
+			t.Errorf("%s: disk left in exhausted zone", tt.desc)
+		}
+		if _, err := c.GetInstance("fake-project", "fallback-region-b", w.genName("i")); err != nil {
+			t.Errorf("%s: instance not in fallback zone: %v", tt.desc, err)
+		}
+		img, err := c.GetImage("fake-project", w.genName("img"))
+		if err != nil {
+			t.Fatalf("%s: error getting image: %v", tt.desc, err)
+		}
+		if !strings.Contains(img.SourceDisk, "zones/fallback-region-b/disks/d-") {
+			t.Errorf("%s: image created from %q, want the moved disk", tt.desc, img.SourceDisk)
+		}
+	}
+}
+
+func TestFallbackZonesDiskCreationFails(t *testing.T) {
+	for _, failing := range []string{"", "fallback-e"} {
+		w := fakeTestWorkflow(t, `{
+  "Name": "fallback",
+  "Steps": {
+    "disk": {"CreateDisks": [{"Name": "d", "RealName": "fallback-d", "SizeGb": "10", "NoCleanup": true}, {"Name": "e", "RealName": "fallback-e", "SizeGb": "10", "NoCleanup": true}]},
+    "inst": {"CreateInstances": [{"Name": "i", "Disks": [{"Source": "d"}, {"Source": "e"}], "FallbackZones": ["fallback-region-b"]}]}
+  },
+  "Dependencies": {"inst": ["disk"]}
+}`, nil)
+		defer os.RemoveAll(w.workflowDir)
+		w.Zone = "fallback-region-z"
+		c := w.ComputeClient.(*daisyCompute.FakeClient)
+		c.AddProject("fake-project", "fallback-region-z", "fallback-region-b")
+		c.ExhaustZone("fake-project", "fallback-region-z")
+		if failing == "" {
+			c.FailDiskCreations("fake-project", "fallback-region-b")
+		} else {
+			c.FailDiskCreations("fake-project", "fallback-region-b", failing)
+		}
+
+		if err := w.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "error creating disk") {
+			t.Errorf("failing %q: got error %v, want error creating disk", failing, err)
+		}
+		for _, d := range []string{"fallback-d", "fallback-e"} {
+			if _, err := c.GetDisk("fake-project", "fallback-region-z", d); err != nil {
+				t.Errorf("failing %q: disk %q not kept in its zone: %v", failing, d, err)
+			}
+			if _, err := c.GetDisk("fake-project", "fallback-region-b", d); err == nil {
+				t.Errorf("failing %q: disk %q left in the fallback zone", failing, d)
+			}
+		}
+	}
+}
+
+func TestIsStockout(t *testing.T) {
+	for _, code := range []string{"ZONE_RESOURCE_POOL_EXHAUSTED", "ZONE_RESOURCE_POOL_EXHAUSTED_WITH_DETAILS"} {
+		opErr := &daisyCompute.OperationError{Operation: &compute.Operation{Error: &compute.OperationError{
+			Errors: []*compute.OperationErrorErrors{{Code: "QUOTA_EXCEEDED"}, {Code: code}},
+		}}}
+		if !isStockout(opErr) {
+			t.Errorf("%v: should be a stockout", opErr)
+		}
+		opErr.Operation.Error.Errors = opErr.Operation.Error.Errors[:1]
+		if isStockout(opErr) {
+			t.Errorf("%v: should not be a stockout", opErr)
+		}
+		if err := errors.New("instance description: " + code); isStockout(err) {
+			t.Errorf("%v: should not be a stockout", err)
+		}
+	}
+}
+
+func TestDiskInZone(t *testing.T) {
+	d := &compute.Disk{
+		Name:                "d",
+		Description:         "desc",
+		SizeGb:              10,
+		SourceImage:         "projects/p/global/images/i",
+		Type:                "projects/p/zones/z1/diskTypes/pd-ssd",
+		Labels:              map[string]string{"l": "v"},
+		GuestOsFeatures:     []*compute.GuestOsFeature{{Type: "VIRTIO_SCSI_MULTIQUEUE"}},
+		Id:                  1,
+		Kind:                "compute#disk",
+		LabelFingerprint:    "abc",
+		SourceImageId:       "2",
+		LastAttachTimestamp: "2017-10-18T10:00:00Z",
+		ResourcePolicies:    []string{"projects/p/regions/r1/resourcePolicies/rp"},
+		SelfLink:            "projects/p/zones/z1/disks/d",
+		Zone:                "projects/p/zones/z1",
+		Status:              "READY",
+		Users:               []string{"projects/p/zones/z1/instances/i"},
+	}
+	want := &compute.Disk{
+		Name:            "d",
+		Description:     "desc",
+		SizeGb:          10,
+		SourceImage:     "projects/p/global/images/i",
+		Type:            "projects/p/zones/z2/diskTypes/pd-ssd",
+		Labels:          map[string]string{"l": "v"},
+		GuestOsFeatures: []*compute.GuestOsFeature{{Type: "VIRTIO_SCSI_MULTIQUEUE"}},
+	}
+	if diff := pretty.Compare(diskInZone(d, "z1", "z2"), want); diff != "" {
+		t.Errorf("disk request does not match expectation: (-got +want)\n%s", diff)
+	}
+}
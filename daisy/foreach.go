@@ -0,0 +1,195 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ForEach expands an IncludeWorkflow or SubWorkflow step into one step per
+// set of vars. The expansions run in parallel, steps depending on the step
+// wait for all of them.
+type ForEach struct {
+	// Var sets, one expansion each.
+	Vars []map[string]string `json:",omitempty"`
+	// Values of vars, one expansion for each combination of values. Combined
+	// with Vars, each var set is expanded with each combination.
+	Matrix map[string][]string `json:",omitempty"`
+}
+
+// varSets returns the var set of each expansion, in order.
+func (f *ForEach) varSets() ([]map[string]string, dErr) {
+	if len(f.Vars) == 0 && len(f.Matrix) == 0 {
+		return nil, errf("ForEach must set Vars or Matrix")
+	}
+	sets := f.Vars
+	if len(sets) == 0 {
+		sets = []map[string]string{{}}
+	}
+
+	var keys []string
+	for k := range f.Matrix {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if len(f.Matrix[k]) == 0 {
+			return nil, errf("ForEach Matrix var %q has no values", k)
+		}
+		var next []map[string]string
+		for _, set := range sets {
+			for _, v := range f.Matrix[k] {
+				ns := map[string]string{}
+				for sk, sv := range set {
+					ns[sk] = sv
+				}
+				ns[k] = v
+				next = append(next, ns)
+			}
+		}
+		sets = next
+	}
+	return sets, nil
+}
+
+func (s *Step) forEach() *ForEach {
+	switch {
+	case s.IncludeWorkflow != nil:
+		return s.IncludeWorkflow.ForEach
+	case s.SubWorkflow != nil:
+		return s.SubWorkflow.ForEach
+	}
+	return nil
+}
+
+// expand returns a copy of s, named name, whose workflow gets vars on top of
+// the step's own vars.
+func (s *Step) expand(name string, vars map[string]string) (*Step, dErr) {
+	merge := func(base map[string]string) map[string]string {
+		m := map[string]string{}
+		for k, v := range base {
+			m[k] = v
+		}
+		for k, v := range vars {
+			m[k] = v
+		}
+		return m
+	}
+
+	es := *s
+	es.name = name
+	if s.Retry != nil {
+		r := *s.Retry
+		es.Retry = &r
+	}
+	// Each expansion reads its own copy of the workflow from Path.
+	switch {
+	case s.IncludeWorkflow != nil:
+		if s.IncludeWorkflow.Path == "" {
+			return nil, errf("ForEach requires the IncludeWorkflow Path to be set")
+		}
+		es.IncludeWorkflow = &IncludeWorkflow{Path: s.IncludeWorkflow.Path, SHA256: s.IncludeWorkflow.SHA256, Vars: merge(s.IncludeWorkflow.Vars)}
+	case s.SubWorkflow != nil:
+		if s.SubWorkflow.Path == "" {
+			return nil, errf("ForEach requires the SubWorkflow Path to be set")
+		}
+		es.SubWorkflow = &SubWorkflow{Path: s.SubWorkflow.Path, SHA256: s.SubWorkflow.SHA256, Vars: merge(s.SubWorkflow.Vars)}
+	}
+	return &es, nil
+}
+
+// expandForEach replaces each ForEach step with its expansions, named
+// "<step>-1", "<step>-2", etc. Dependencies on the step become dependencies
+// on all the expansions.
+func (w *Workflow) expandForEach() dErr {
+	var names []string
+	for name, s := range w.Steps {
+		if s.forEach() != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) > 0 && w.Dependencies == nil {
+		w.Dependencies = map[string][]string{}
+	}
+
+	for _, name := range names {
+		s := w.Steps[name]
+		sets, err := s.forEach().varSets()
+		if err != nil {
+			return errf("cannot expand step %q: %v", name, err)
+		}
+
+		var expanded []string
+		for i, vars := range sets {
+			en := fmt.Sprintf("%s-%d", name, i+1)
+			if _, ok := w.Steps[en]; ok {
+				return errf("cannot expand step %q: step %q already exists", name, en)
+			}
+			es, err := s.expand(en, vars)
+			if err != nil {
+				return errf("cannot expand step %q: %v", name, err)
+			}
+			es.forEachOf = name
+			w.Steps[en] = es
+			if deps, ok := w.Dependencies[name]; ok {
+				w.Dependencies[en] = append([]string(nil), deps...)
+			}
+			expanded = append(expanded, en)
+		}
+		delete(w.Steps, name)
+		delete(w.Dependencies, name)
+
+		for dependent, deps := range w.Dependencies {
+			var newDeps []string
+			for _, d := range deps {
+				if d == name {
+					newDeps = append(newDeps, expanded...)
+				} else {
+					newDeps = append(newDeps, d)
+				}
+			}
+			w.Dependencies[dependent] = newDeps
+		}
+	}
+	return nil
+}
+
+// includedExpansion returns the ForEach expansion of an IncludeWorkflow step
+// that s is part of, or nil.
+func includedExpansion(s *Step) *Step {
+	for _, st := range s.getChain() {
+		if st.forEachOf != "" && st.IncludeWorkflow != nil {
+			return st
+		}
+	}
+	return nil
+}
+
+// forEachCollision returns an error if the steps a and b, creating resources
+// of the same name, are in different expansions of the same ForEach
+// IncludeWorkflow step. The expansions share the parent's resources, so
+// their resource names must differ.
+func forEachCollision(typeName, name string, a, b *Step) dErr {
+	ea, eb := includedExpansion(a), includedExpansion(b)
+	if ea == nil || eb == nil || ea == eb || ea.w != eb.w || ea.forEachOf != eb.forEachOf {
+		return nil
+	}
+	if eb.name < ea.name {
+		ea, eb = eb, ea
+	}
+	return errf("cannot create %s %q in both %q and %q, expansions of ForEach step %q: included workflows share the parent's resources, resource names must differ between the sets of vars", typeName, name, ea.name, eb.name, ea.forEachOf)
+}
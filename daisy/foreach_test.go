@@ -0,0 +1,192 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestForEachVarSets(t *testing.T) {
+	tests := []struct {
+		desc      string
+		f         *ForEach
+		want      []map[string]string
+		shouldErr bool
+	}{
+		{
+			"list case",
+			&ForEach{Vars: []map[string]string{{"a": "1"}, {"a": "2"}}},
+			[]map[string]string{{"a": "1"}, {"a": "2"}},
+			false,
+		},
+		{
+			"matrix case",
+			&ForEach{Matrix: map[string][]string{"b": {"x", "y"}, "a": {"1", "2"}}},
+			[]map[string]string{{"a": "1", "b": "x"}, {"a": "1", "b": "y"}, {"a": "2", "b": "x"}, {"a": "2", "b": "y"}},
+			false,
+		},
+		{
+			"list and matrix case",
+			&ForEach{Vars: []map[string]string{{"a": "1"}, {"a": "2"}}, Matrix: map[string][]string{"b": {"x", "y"}}},
+			[]map[string]string{{"a": "1", "b": "x"}, {"a": "1", "b": "y"}, {"a": "2", "b": "x"}, {"a": "2", "b": "y"}},
+			false,
+		},
+		{"empty case", &ForEach{}, nil, true},
+		{"empty matrix var case", &ForEach{Matrix: map[string][]string{"a": {}}}, nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.f.varSets()
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("%s: var sets do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestExpandForEach(t *testing.T) {
+	w := testWorkflow()
+	w.Steps = map[string]*Step{
+		"setup": {testType: &mockStep{}},
+		"build": {
+			Timeout: "1h",
+			Retry:   &Retry{Attempts: 2},
+			SubWorkflow: &SubWorkflow{
+				Path:    "./test_sub.wf.json",
+				Vars:    map[string]string{"key": "default", "other": "value"},
+				ForEach: &ForEach{Vars: []map[string]string{{"key": "a"}, {"key": "b"}}},
+			},
+		},
+		"publish": {testType: &mockStep{}},
+	}
+	w.Dependencies = map[string][]string{
+		"build":   {"setup"},
+		"publish": {"setup", "build"},
+	}
+
+	if err := w.expandForEach(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantDeps := map[string][]string{
+		"build-1": {"setup"},
+		"build-2": {"setup"},
+		"publish": {"setup", "build-1", "build-2"},
+	}
+	if diff := pretty.Compare(w.Dependencies, wantDeps); diff != "" {
+		t.Errorf("dependencies do not match expectation: (-got +want)\n%s", diff)
+	}
+	if _, ok := w.Steps["build"]; ok || len(w.Steps) != 4 {
+		t.Errorf("unexpected steps: %v", w.Steps)
+	}
+	for name, key := range map[string]string{"build-1": "a", "build-2": "b"} {
+		s := w.Steps[name]
+		if s == nil {
+			t.Errorf("missing step %q", name)
+			continue
+		}
+		want := &SubWorkflow{Path: "./test_sub.wf.json", Vars: map[string]string{"key": key, "other": "value"}}
+		if diff := pretty.Compare(s.SubWorkflow, want); diff != "" {
+			t.Errorf("step %q: SubWorkflow does not match expectation: (-got +want)\n%s", name, diff)
+		}
+		if s.Timeout != "1h" || s.Retry == nil {
+			t.Errorf("step %q: Timeout and Retry not copied from the expanded step", name)
+		}
+	}
+	if w.Steps["build-1"].Retry == w.Steps["build-2"].Retry {
+		t.Error("expansions should not share a Retry")
+	}
+
+	// Expansion names must not collide with existing steps.
+	w.Steps["x"] = &Step{IncludeWorkflow: &IncludeWorkflow{Path: "./test.wf.json", ForEach: &ForEach{Vars: []map[string]string{{}}}}}
+	w.Steps["x-1"] = &Step{testType: &mockStep{}}
+	if err := w.expandForEach(); err == nil {
+		t.Error("should have returned an error")
+	}
+}
+
+func TestPopulateForEach(t *testing.T) {
+	ctx := context.Background()
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	sub := `{"Steps": {"create": {"CreateDisks": [{"Name": "d", "SizeGb": "10", "Description": "${key}"}]}}}`
+	if err := ioutil.WriteFile(filepath.Join(td, "sub.wf.json"), []byte(sub), 0600); err != nil {
+		t.Fatalf("error writing subworkflow: %v", err)
+	}
+
+	w := testWorkflow()
+	w.workflowDir = td
+	w.Steps = map[string]*Step{
+		"build": {
+			SubWorkflow: &SubWorkflow{
+				Path:    "./sub.wf.json",
+				ForEach: &ForEach{Matrix: map[string][]string{"key": {"a", "b"}}},
+			},
+		},
+	}
+
+	if err := w.populate(ctx); err != nil {
+		t.Fatalf("error populating workflow: %v", err)
+	}
+	seen := map[string]bool{}
+	for name, want := range map[string]string{"build-1": "a", "build-2": "b"} {
+		cd := (*w.Steps[name].SubWorkflow.Workflow.Steps["create"].CreateDisks)[0]
+		if seen[cd.Name] {
+			t.Errorf("disk name %q is not unique to the expansion", cd.Name)
+		}
+		seen[cd.Name] = true
+		if cd.Description != want {
+			t.Errorf("step %q: got var value %q, want %q", name, cd.Description, want)
+		}
+	}
+}
+
+func TestForEachIncludeWorkflowResources(t *testing.T) {
+	tests := []struct {
+		desc, disk, wantErr string
+	}{
+		{"same name", "d", `cannot create disk "d" in both "build-1" and "build-2", expansions of ForEach step "build"`},
+		{"names from vars", "d-${key}", ""},
+	}
+	for _, tt := range tests {
+		w := fakeTestWorkflow(t, `{
+  "Name": "foreach",
+  "Steps": {
+    "build": {"IncludeWorkflow": {"Path": "./included.wf.json", "ForEach": {"Matrix": {"key": ["a", "b"]}}}}
+  }
+}`, map[string]string{"included.wf.json": `{"Steps": {"create": {"CreateDisks": [{"Name": "` + tt.disk + `", "SizeGb": "10"}]}}}`})
+		defer os.RemoveAll(w.workflowDir)
+		err := w.Validate(context.Background())
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		} else if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: got error %v, want error containing %q", tt.desc, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Graph formats, see Workflow.Graph.
+const (
+	GraphDot     = "dot"
+	GraphMermaid = "mermaid"
+)
+
+// graph is a workflow DAG. Steps of IncludeWorkflow and SubWorkflow steps are
+// in clusters.
+type graph struct {
+	root  *graphCluster
+	edges []graphEdge
+}
+
+type graphCluster struct {
+	id, label string
+	nodes     []graphNode
+	clusters  []*graphCluster
+}
+
+type graphNode struct {
+	id, label string
+	skipped   bool
+}
+
+// graphEdge is a dependency of to on from, or if label is set, a resource
+// from creates and to uses or deletes.
+type graphEdge struct {
+	from, to string
+	label    string
+}
+
+// Graph populates and validates the workflow, then writes its DAG to out in
+// format, GraphDot (Graphviz) or GraphMermaid. The steps of IncludeWorkflow
+// and SubWorkflow steps are drawn in clusters. Dependencies are solid edges,
+// resources are dashed edges from the step creating them to the steps using
+// and deleting them.
+func (w *Workflow) Graph(ctx context.Context, out io.Writer, format string) error {
+	if format != GraphDot && format != GraphMermaid {
+		return fmt.Errorf("unknown graph format %q, must be %s or %s", format, GraphDot, GraphMermaid)
+	}
+	w.gcsLogging = false
+	if err := w.Validate(ctx); err != nil {
+		return err
+	}
+
+	g := &graph{root: &graphCluster{label: w.Name}}
+	ids := map[*Step]string{}
+	clusters := map[string]bool{}
+	g.addWorkflow(w, g.root, ids, clusters)
+	names, groups := w.stepGroups()
+	for i, sg := range groups {
+		c := &graphCluster{id: "cluster_" + names[i], label: names[i]}
+		g.root.clusters = append(g.root.clusters, c)
+		g.addSteps(w, sg.Steps, sg.Dependencies, c, ids, clusters)
+	}
+	g.addResources(w, ids)
+
+	if format == GraphDot {
+		g.writeDot(out, clusters)
+	} else {
+		g.writeMermaid(out)
+	}
+	return nil
+}
+
+// stepTypeName returns the name of the type of s, as in workflow files.
+func stepTypeName(s *Step) string {
+	for name := range s.Custom {
+		return name
+	}
+	v := reflect.ValueOf(s).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath == "" && f.Name != "Retry" && f.Type.Kind() == reflect.Ptr && !v.Field(i).IsNil() {
+			return f.Name
+		}
+	}
+	return ""
+}
+
+func (g *graph) addWorkflow(w *Workflow, c *graphCluster, ids map[*Step]string, clusters map[string]bool) {
+	g.addSteps(w, w.Steps, w.Dependencies, c, ids, clusters)
+}
+
+// addSteps adds steps of w, and the edges of their dependencies deps, to c.
+func (g *graph) addSteps(w *Workflow, steps map[string]*Step, deps map[string][]string, c *graphCluster, ids map[*Step]string, clusters map[string]bool) {
+	var names []string
+	for name := range steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	children := w.childWorkflows()
+	for _, name := range names {
+		s := steps[name]
+		label := fmt.Sprintf("%s\n%s", name, stepTypeName(s))
+		id := fmt.Sprintf("s%d", len(ids))
+		ids[s] = id
+		if child, ok := children[name]; ok {
+			cc := &graphCluster{id: "cluster_" + id, label: label}
+			ids[s] = cc.id
+			clusters[cc.id] = true
+			c.clusters = append(c.clusters, cc)
+			g.addWorkflow(child, cc, ids, clusters)
+			continue
+		}
+		if s.skipped {
+			label += " (skipped)"
+		}
+		c.nodes = append(c.nodes, graphNode{id: id, label: label, skipped: s.skipped})
+	}
+
+	for _, name := range names {
+		sDeps := append([]string{}, deps[name]...)
+		sort.Strings(sDeps)
+		for _, dep := range sDeps {
+			if d, ok := steps[dep]; ok {
+				g.edges = append(g.edges, graphEdge{from: ids[d], to: ids[steps[name]]})
+			}
+		}
+	}
+}
+
+// addResources adds an edge from the step creating each resource to each
+// step using or deleting it.
+func (g *graph) addResources(w *Workflow, ids map[*Step]string) {
+	seen := map[graphEdge]bool{}
+	add := func(from, to *Step, label string) {
+		e := graphEdge{from: ids[from], to: ids[to], label: label}
+		if e.from != "" && e.to != "" && !seen[e] {
+			seen[e] = true
+			g.edges = append(g.edges, e)
+		}
+	}
+	for _, cw := range w.allWorkflows() {
+		if !cw.ownsRegistries() {
+			continue
+		}
+		for _, r := range cw.registries() {
+			var names []string
+			for name := range r.m {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				res := r.m[name]
+				if res.creator == nil {
+					continue
+				}
+				for _, u := range res.users {
+					add(res.creator, u, fmt.Sprintf("%s %s: used", r.typeName, name))
+				}
+				if res.deleter != nil {
+					add(res.creator, res.deleter, fmt.Sprintf("%s %s: deleted", r.typeName, name))
+				}
+			}
+		}
+	}
+}
+
+func (g *graph) writeDot(out io.Writer, clusters map[string]bool) {
+	fmt.Fprintf(out, "digraph %q {\n", g.root.label)
+	fmt.Fprintln(out, "  compound=true;")
+	fmt.Fprintln(out, "  node [shape=box];")
+	var writeCluster func(c *graphCluster, indent string)
+	writeCluster = func(c *graphCluster, indent string) {
+		for _, n := range c.nodes {
+			style := ""
+			if n.skipped {
+				style = ", style=dashed"
+			}
+			fmt.Fprintf(out, "%s%s [label=%q%s];\n", indent, n.id, n.label, style)
+		}
+		for _, cc := range c.clusters {
+			fmt.Fprintf(out, "%ssubgraph %s {\n", indent, cc.id)
+			fmt.Fprintf(out, "%s  label=%q;\n", indent, cc.label)
+			// Edges to and from clusters are drawn to this node.
+			fmt.Fprintf(out, "%s  %s_anchor [shape=point, style=invis];\n", indent, cc.id)
+			writeCluster(cc, indent+"  ")
+			fmt.Fprintf(out, "%s}\n", indent)
+		}
+	}
+	writeCluster(g.root, "  ")
+
+	for _, e := range g.edges {
+		from, to := e.from, e.to
+		var attrs []string
+		if clusters[from] {
+			attrs = append(attrs, "ltail="+from)
+			from += "_anchor"
+		}
+		if clusters[to] {
+			attrs = append(attrs, "lhead="+to)
+			to += "_anchor"
+		}
+		if e.label != "" {
+			attrs = append(attrs, fmt.Sprintf("label=%q", e.label), "style=dashed")
+		}
+		if len(attrs) > 0 {
+			fmt.Fprintf(out, "  %s -> %s [%s];\n", from, to, strings.Join(attrs, ", "))
+		} else {
+			fmt.Fprintf(out, "  %s -> %s;\n", from, to)
+		}
+	}
+	fmt.Fprintln(out, "}")
+}
+
+// mermaidLabel quotes s as a Mermaid label.
+func mermaidLabel(s string) string {
+	s = strings.Replace(s, `"`, "#quot;", -1)
+	return `"` + strings.Replace(s, "\n", "<br/>", -1) + `"`
+}
+
+func (g *graph) writeMermaid(out io.Writer) {
+	fmt.Fprintln(out, "flowchart TD")
+	var writeCluster func(c *graphCluster, indent string)
+	writeCluster = func(c *graphCluster, indent string) {
+		for _, n := range c.nodes {
+			fmt.Fprintf(out, "%s%s[%s]\n", indent, n.id, mermaidLabel(n.label))
+		}
+		for _, cc := range c.clusters {
+			fmt.Fprintf(out, "%ssubgraph %s[%s]\n", indent, cc.id, mermaidLabel(cc.label))
+			writeCluster(cc, indent+"  ")
+			fmt.Fprintf(out, "%send\n", indent)
+		}
+	}
+	writeCluster(g.root, "  ")
+
+	for _, e := range g.edges {
+		if e.label != "" {
+			fmt.Fprintf(out, "  %s -.->|%s| %s\n", e.from, mermaidLabel(e.label), e.to)
+		} else {
+			fmt.Fprintf(out, "  %s --> %s\n", e.from, e.to)
+		}
+	}
+	for _, n := range g.skipped() {
+		fmt.Fprintf(out, "  style %s stroke-dasharray: 5 5\n", n)
+	}
+}
+
+// skipped returns the ids of the skipped steps.
+func (g *graph) skipped() []string {
+	var ids []string
+	var walk func(c *graphCluster)
+	walk = func(c *graphCluster) {
+		for _, n := range c.nodes {
+			if n.skipped {
+				ids = append(ids, n.id)
+			}
+		}
+		for _, cc := range c.clusters {
+			walk(cc)
+		}
+	}
+	walk(g.root)
+	return ids
+}
@@ -0,0 +1,131 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+)
+
+func TestGraph(t *testing.T) {
+	tf := writeTestWorkflow(t, "graph.wf.json", `{
+  "Name": "graph",
+  "Steps": {
+    "create-disk": {"CreateDisks": [{"Name": "disk", "SizeGb": "10"}]},
+    "include": {"IncludeWorkflow": {"Path": "./child.wf.json"}},
+    "skipped": {"If": "false", "CreateDisks": [{"Name": "other", "SizeGb": "10"}]}
+  },
+  "Dependencies": {"include": ["create-disk"], "skipped": ["include"]},
+  "Finally": {
+    "Steps": {"delete-image": {"DeleteResources": {"Images": ["image"]}}}
+  }
+}`)
+	defer os.RemoveAll(filepath.Dir(tf))
+	child := `{
+  "Name": "child",
+  "Steps": {
+    "create-image": {"CreateImages": [{"Name": "image", "SourceDisk": "disk"}]},
+    "delete-disk": {"DeleteResources": {"Disks": ["disk"]}}
+  },
+  "Dependencies": {"delete-disk": ["create-image"]}
+}`
+	if err := ioutil.WriteFile(filepath.Join(filepath.Dir(tf), "child.wf.json"), []byte(child), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	graph := func(format string) (string, error) {
+		w, err := NewFromFile(tf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := daisyCompute.NewFakeClient()
+		c.Permissive = true
+		c.AddProject("fake-project", "fake-zone")
+		w.Project = "fake-project"
+		w.Zone = "fake-zone"
+		w.GCSPath = testGCSPath
+		w.ComputeClient = c
+		w.StorageClient, _ = newTestGCSClient()
+		w.logger = log.New(ioutil.Discard, "", 0)
+		var buf bytes.Buffer
+		err = w.Graph(context.Background(), &buf, format)
+		return buf.String(), err
+	}
+
+	tests := []struct{ format, want string }{
+		{GraphDot, `digraph "graph" {
+  compound=true;
+  node [shape=box];
+  s0 [label="create-disk\nCreateDisks"];
+  s4 [label="skipped\nCreateDisks (skipped)", style=dashed];
+  subgraph cluster_s1 {
+    label="include\nIncludeWorkflow";
+    cluster_s1_anchor [shape=point, style=invis];
+    s2 [label="create-image\nCreateImages"];
+    s3 [label="delete-disk\nDeleteResources"];
+  }
+  subgraph cluster_Finally {
+    label="Finally";
+    cluster_Finally_anchor [shape=point, style=invis];
+    s5 [label="delete-image\nDeleteResources"];
+  }
+  s2 -> s3;
+  s0 -> cluster_s1_anchor [lhead=cluster_s1];
+  cluster_s1_anchor -> s4 [ltail=cluster_s1];
+  s0 -> s2 [label="disk disk: used", style=dashed];
+  s0 -> s3 [label="disk disk: deleted", style=dashed];
+  s2 -> s5 [label="image image: deleted", style=dashed];
+}
+`},
+		{GraphMermaid, `flowchart TD
+  s0["create-disk<br/>CreateDisks"]
+  s4["skipped<br/>CreateDisks (skipped)"]
+  subgraph cluster_s1["include<br/>IncludeWorkflow"]
+    s2["create-image<br/>CreateImages"]
+    s3["delete-disk<br/>DeleteResources"]
+  end
+  subgraph cluster_Finally["Finally"]
+    s5["delete-image<br/>DeleteResources"]
+  end
+  s2 --> s3
+  s0 --> cluster_s1
+  cluster_s1 --> s4
+  s0 -.->|"disk disk: used"| s2
+  s0 -.->|"disk disk: deleted"| s3
+  s2 -.->|"image image: deleted"| s5
+  style s4 stroke-dasharray: 5 5
+`},
+	}
+	for _, tt := range tests {
+		got, err := graph(tt.format)
+		if err != nil {
+			t.Fatalf("%s: error graphing workflow: %v", tt.format, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: graph does not match expectation, got:\n%s\nwant:\n%s", tt.format, got, tt.want)
+		}
+	}
+
+	if _, err := graph("svg"); err == nil || err.Error() != `unknown graph format "svg", must be dot or mermaid` {
+		t.Errorf("unexpected error for a bad format: %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"reflect"
+	"strings"
+)
+
+// StepGroup is a set of steps run after the workflow's Steps, see
+// Workflow.OnFailure and Workflow.Finally. The steps are in the namespace of
+// the workflow: they can use the resources and outputs of its Steps.
+type StepGroup struct {
+	Steps map[string]*Step
+	// Map of steps to their dependencies, within the group.
+	Dependencies map[string][]string `json:",omitempty"`
+}
+
+// Autovars set when handler steps run, to the name of the step that failed,
+// as parent.child for steps of included workflows, and to its error. Both
+// are empty if no step failed.
+const (
+	failedStepAutovar = "FAILED_STEP"
+	errorAutovar      = "ERROR"
+)
+
+var handlerAutovars = []string{failedStepAutovar, errorAutovar}
+
+// stepGroups returns the handler step groups of w, by name, in the order
+// they run.
+func (w *Workflow) stepGroups() ([]string, []*StepGroup) {
+	var names []string
+	var groups []*StepGroup
+	if w.OnFailure != nil {
+		names = append(names, "OnFailure")
+		groups = append(groups, w.OnFailure)
+	}
+	if w.Finally != nil {
+		names = append(names, "Finally")
+		groups = append(groups, w.Finally)
+	}
+	return names, groups
+}
+
+// groupOrder returns the position of step group g in the run order of w,
+// 0 for Steps.
+func (w *Workflow) groupOrder(g *StepGroup) int {
+	switch {
+	case g == nil:
+		return 0
+	case g == w.OnFailure:
+		return 1
+	}
+	return 2
+}
+
+// inHandler reports whether s is a handler step, or a step of a workflow
+// included by one.
+func (s *Step) inHandler() bool {
+	c := s.getChain()
+	return len(c) > 0 && c[0].group != nil
+}
+
+// hasHandlers reports whether the top level workflow of w has OnFailure or
+// Finally steps.
+func (w *Workflow) hasHandlers() bool {
+	root := w.rootWorkflow()
+	return root.OnFailure != nil || root.Finally != nil
+}
+
+func (w *Workflow) populateHandlers(ctx context.Context) dErr {
+	names, groups := w.stepGroups()
+	if len(groups) > 0 && w.parent != nil {
+		return errf("OnFailure and Finally steps are only run for the top level workflow")
+	}
+	for i, g := range groups {
+		for name, s := range g.Steps {
+			if _, ok := w.Steps[name]; ok {
+				return errf("%s step %q has the same name as a step of Steps", names[i], name)
+			}
+			if g == w.Finally && w.OnFailure != nil && w.OnFailure.Steps[name] != nil {
+				return errf("Finally step %q has the same name as a step of OnFailure", name)
+			}
+			if s.forEach() != nil {
+				return errf("%s step %q: ForEach is only supported in Steps", names[i], name)
+			}
+			s.name = name
+			s.w = w
+			s.group = g
+			if err := w.populateStep(ctx, s); err != nil {
+				return errf("error populating %s step %q: %v", names[i], name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (w *Workflow) validateHandlers(ctx context.Context) dErr {
+	names, groups := w.stepGroups()
+	for i, g := range groups {
+		if err := w.validateSteps(ctx, g.Steps, g.Dependencies); err != nil {
+			return errf("error validating %s: %v", names[i], err)
+		}
+	}
+	return nil
+}
+
+// recordFailure records s as the step that failed the workflow, unless a
+// step already did.
+func (w *Workflow) recordFailure(s *Step, err dErr) {
+	root := w.rootWorkflow()
+	root.failureMx.Lock()
+	defer root.failureMx.Unlock()
+	if root.failedStep != "" {
+		return
+	}
+	var names []string
+	for _, st := range s.getChain() {
+		names = append(names, st.name)
+	}
+	root.failedStep = strings.Join(names, ".")
+	root.failure = w.redact(err.Error())
+}
+
+// substituteHandlerIfs calls substituteIf on handler steps, and on the steps
+// of the workflows they include or run.
+func substituteHandlerIfs(steps map[string]*Step, in *interpolator) {
+	substituteIfs(steps, in)
+	for _, s := range steps {
+		if s.IncludeWorkflow != nil && s.IncludeWorkflow.Workflow != nil {
+			substituteHandlerIfs(s.IncludeWorkflow.Workflow.Steps, in)
+		}
+		if s.SubWorkflow != nil && s.SubWorkflow.Workflow != nil {
+			substituteHandlerIfs(s.SubWorkflow.Workflow.Steps, in)
+		}
+	}
+}
+
+// runHandlers runs the OnFailure steps if err, the error running Steps, is
+// set, then the Finally steps. Errors of handler steps are added after err.
+func (w *Workflow) runHandlers(ctx context.Context, err dErr) dErr {
+	names, groups := w.stepGroups()
+	if len(groups) == 0 {
+		return err
+	}
+	select {
+	case <-w.Cancel:
+		w.logger.Print("Workflow canceled, not running OnFailure and Finally steps")
+		return err
+	default:
+	}
+
+	w.failureMx.Lock()
+	autovars := map[string]string{failedStepAutovar: w.failedStep, errorAutovar: w.failure}
+	w.failureMx.Unlock()
+	for i, g := range groups {
+		if g == w.OnFailure && err == nil {
+			continue
+		}
+		w.logger.Printf("Running %s steps", names[i])
+		in := w.newInterpolator(autovars)
+		substituteHandlerIfs(g.Steps, in)
+		substitute(reflect.ValueOf(g).Elem(), in)
+		hErr := in.errs
+		if hErr == nil {
+			hErr = w.traverseSteps(g.Steps, g.Dependencies, func(s *Step) dErr { return w.runStepWithEvents(ctx, s) })
+		}
+		if hErr != nil {
+			w.logger.Printf("Error running %s steps: %v", names[i], hErr)
+			err = addErrs(err, errf("%s: %v", names[i], hErr))
+		}
+	}
+	return err
+}
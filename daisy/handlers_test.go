@@ -0,0 +1,277 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+// testRecord is a step type recording its Message when it runs, failing if
+// Fail is set. With Sleep, it records its Message after sleeping or once it
+// is canceled.
+type testRecord struct {
+	Message string
+	Fail    bool   `json:",omitempty"`
+	Sleep   string `json:",omitempty"`
+}
+
+var (
+	recordedMx sync.Mutex
+	recorded   []string
+)
+
+func (r *testRecord) Populate(ctx context.Context, s *Step) error { return nil }
+
+func (r *testRecord) Validate(ctx context.Context, s *Step) error { return nil }
+
+func (r *testRecord) Run(ctx context.Context, s *Step) error {
+	if r.Sleep != "" {
+		d, err := time.ParseDuration(r.Sleep)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+		}
+	}
+	recordedMx.Lock()
+	defer recordedMx.Unlock()
+	recorded = append(recorded, r.Message)
+	if r.Fail {
+		return errors.New("failed " + r.Message)
+	}
+	return nil
+}
+
+func init() {
+	RegisterStepType("TestRecord", func() StepType { return &testRecord{} })
+}
+
+func TestHandlers(t *testing.T) {
+	included := `{
+  "Steps": {
+    "build": {"TestRecord": {"Message": "build", "Fail": true}}
+  }
+}`
+	tests := []struct {
+		desc, steps string
+		want        []string
+		wantErr     string
+	}{
+		{
+			"success",
+			`"build": {"TestRecord": {"Message": "build"}}`,
+			[]string{"build", "finally: "},
+			"",
+		},
+		{
+			"failure",
+			`"build": {"TestRecord": {"Message": "build", "Fail": true}}`,
+			[]string{"build", `build: step "build" run error: failed build`, "finally: build"},
+			`step "build" run error: failed build`,
+		},
+		{
+			"included step failure",
+			`"inc": {"IncludeWorkflow": {"Path": "./included.wf.json"}}`,
+			[]string{"build", `inc.build: step "build" run error: failed build`, "finally: inc.build"},
+			`step "inc" run error: step "build" run error: failed build`,
+		},
+	}
+	for _, tt := range tests {
+		recorded = nil
+		w := fakeTestWorkflow(t, `{
+  "Name": "handlers",
+  "Steps": {`+tt.steps+`},
+  "OnFailure": {
+    "Steps": {
+      "report": {"TestRecord": {"Message": "${FAILED_STEP}: ${ERROR}"}}
+    }
+  },
+  "Finally": {
+    "Steps": {
+      "finally": {"TestRecord": {"Message": "finally: ${FAILED_STEP}"}}
+    }
+  }
+}`, map[string]string{"included.wf.json": included})
+		defer os.RemoveAll(w.workflowDir)
+
+		err := w.Run(context.Background())
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		} else if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+			t.Errorf("%s: got error %v, want %q", tt.desc, err, tt.wantErr)
+		}
+		if diff := pretty.Compare(recorded, tt.want); diff != "" {
+			t.Errorf("%s: steps run do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestHandlersIf(t *testing.T) {
+	included := `{
+  "Steps": {
+    "cleanup": {"If": "'${FAILED_STEP}' == build", "TestRecord": {"Message": "included cleanup"}}
+  }
+}`
+	tests := []struct {
+		desc string
+		fail bool
+		want []string
+	}{
+		{"success", false, []string{"build", "always"}},
+		{"failure", true, []string{"build", "cleanup", "error", "included cleanup", "always"}},
+	}
+	for _, tt := range tests {
+		recorded = nil
+		fail := "false"
+		if tt.fail {
+			fail = "true"
+		}
+		w := fakeTestWorkflow(t, `{
+  "Name": "handlers",
+  "Steps": {
+    "build": {"TestRecord": {"Message": "build", "Fail": `+fail+`}}
+  },
+  "Finally": {
+    "Steps": {
+      "cleanup": {"If": "${FAILED_STEP} == build", "TestRecord": {"Message": "cleanup"}},
+      "error": {"If": "!empty('${ERROR}')", "TestRecord": {"Message": "error"}},
+      "included": {"IncludeWorkflow": {"Path": "./included.wf.json"}},
+      "always": {"If": "empty('${FAILED_STEP}') || ${FAILED_STEP} == build", "TestRecord": {"Message": "always"}}
+    },
+    "Dependencies": {"error": ["cleanup"], "included": ["error"], "always": ["included"]}
+  }
+}`, map[string]string{"included.wf.json": included})
+		defer os.RemoveAll(w.workflowDir)
+
+		if err := w.Run(context.Background()); (err != nil) != tt.fail {
+			t.Errorf("%s: unexpected error result: %v", tt.desc, err)
+		}
+		if diff := pretty.Compare(recorded, tt.want); diff != "" {
+			t.Errorf("%s: steps run do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestHandlersFailure(t *testing.T) {
+	recorded = nil
+	w := fakeTestWorkflow(t, `{
+  "Name": "handlers",
+  "Steps": {
+    "build": {"TestRecord": {"Message": "build", "Fail": true}}
+  },
+  "OnFailure": {
+    "Steps": {
+      "report": {"TestRecord": {"Message": "report", "Fail": true}}
+    }
+  },
+  "Finally": {
+    "Steps": {
+      "first": {"TestRecord": {"Message": "first"}},
+      "second": {"TestRecord": {"Message": "second"}}
+    },
+    "Dependencies": {"second": ["first"]}
+  }
+}`, nil)
+	defer os.RemoveAll(w.workflowDir)
+
+	want := "Multiple errors:\n" +
+		"* step \"build\" run error: failed build\n" +
+		"* OnFailure: step \"report\" run error: failed report"
+	if err := w.Run(context.Background()); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+	if diff := pretty.Compare(recorded, []string{"build", "report", "first", "second"}); diff != "" {
+		t.Errorf("steps run do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestHandlersWaitForRunningSteps(t *testing.T) {
+	recorded = nil
+	w := fakeTestWorkflow(t, `{
+  "Name": "handlers",
+  "Steps": {
+    "build": {"TestRecord": {"Message": "build", "Fail": true, "Sleep": "100ms"}},
+    "slow": {"TestRecord": {"Message": "slow", "Sleep": "1m"}},
+    "next": {"TestRecord": {"Message": "next"}}
+  },
+  "Dependencies": {"next": ["build"]},
+  "Finally": {
+    "Steps": {
+      "finally": {"TestRecord": {"Message": "finally"}}
+    }
+  }
+}`, nil)
+	defer os.RemoveAll(w.workflowDir)
+
+	// slow is canceled when build fails, Finally runs once it stopped.
+	if err := w.Run(context.Background()); err == nil {
+		t.Error("expected error")
+	}
+	if diff := pretty.Compare(recorded, []string{"build", "slow", "finally"}); diff != "" {
+		t.Errorf("steps run do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestHandlersErrors(t *testing.T) {
+	tests := []struct{ desc, wf, files, want string }{
+		{
+			"same name as a step",
+			`"Steps": {"a": {"TestRecord": {}}}, "Finally": {"Steps": {"a": {"TestRecord": {}}}}`,
+			"",
+			`error populating workflow: Finally step "a" has the same name as a step of Steps`,
+		},
+		{
+			"same name as an OnFailure step",
+			`"Steps": {"a": {"TestRecord": {}}}, "OnFailure": {"Steps": {"b": {"TestRecord": {}}}}, "Finally": {"Steps": {"b": {"TestRecord": {}}}}`,
+			"",
+			`error populating workflow: Finally step "b" has the same name as a step of OnFailure`,
+		},
+		{
+			"dependency on a step of Steps",
+			`"Steps": {"a": {"TestRecord": {}}}, "Finally": {"Steps": {"b": {"TestRecord": {}}}, "Dependencies": {"b": ["a"]}}`,
+			"",
+			`error validating Finally: Dependencies reference non existent step "a": "b":["a"]`,
+		},
+		{
+			"handler autovar in Steps",
+			`"Steps": {"a": {"TestRecord": {"Message": "${ERROR}"}}}`,
+			"",
+			`Unresolved var "${ERROR}" found in "${ERROR}" at Steps.a.TestRecord.Message: ERROR is only set in OnFailure and Finally steps`,
+		},
+		{
+			"handlers in an included workflow",
+			`"Steps": {"inc": {"IncludeWorkflow": {"Path": "./included.wf.json"}}}`,
+			`{"Steps": {"a": {"TestRecord": {}}}, "Finally": {"Steps": {"b": {"TestRecord": {}}}}}`,
+			`OnFailure and Finally steps are only run for the top level workflow`,
+		},
+	}
+	for _, tt := range tests {
+		w := fakeTestWorkflow(t, `{"Name": "handlers", `+tt.wf+`}`, map[string]string{"included.wf.json": tt.files})
+		defer os.RemoveAll(w.workflowDir)
+		if err := w.Validate(context.Background()); err == nil || !strings.HasSuffix(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want error ending with %q", tt.desc, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,475 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Expressions in workflow strings are written ${...} and are one of:
+//   - a reference to a var or autovar: ${name}
+//   - a reference with a default, used if the name is unset or empty:
+//     ${name:-default}. The default can contain expressions.
+//   - a function call: ${fn(arg, ...)}. Arguments are names, "quoted"
+//     strings or function calls.
+
+// piece is a part of a template, literal text or an expression.
+type piece struct {
+	text string
+	expr exprNode
+}
+
+// template is a parsed string.
+type template []piece
+
+type exprNode interface {
+	// src returns the expression as written, to leave unresolved
+	// expressions as they are.
+	src() string
+}
+
+type refNode struct {
+	name   string
+	hasDef bool
+	def    template
+	source string
+}
+
+type callNode struct {
+	fn     string
+	args   []exprNode
+	source string
+}
+
+type litNode struct {
+	value, source string
+}
+
+func (n *refNode) src() string  { return n.source }
+func (n *callNode) src() string { return n.source }
+func (n *litNode) src() string  { return n.source }
+
+func isNameChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+func skipSpaces(s string, i int) int {
+	for i < len(s) && s[i] == ' ' {
+		i++
+	}
+	return i
+}
+
+// parseTemplate parses s from i. If inExpr is set, it stops at the '}'
+// closing an expression, and returns the position of the '}'.
+func parseTemplate(s string, i int, inExpr bool) (template, int) {
+	var t template
+	var text strings.Builder
+	for i < len(s) {
+		if inExpr && s[i] == '}' {
+			break
+		}
+		if strings.HasPrefix(s[i:], "${") {
+			if e, end, ok := parseExpr(s, i); ok {
+				if text.Len() > 0 {
+					t = append(t, piece{text: text.String()})
+					text.Reset()
+				}
+				t = append(t, piece{expr: e})
+				i = end
+				continue
+			}
+		}
+		text.WriteByte(s[i])
+		i++
+	}
+	if text.Len() > 0 {
+		t = append(t, piece{text: text.String()})
+	}
+	return t, i
+}
+
+// parseExpr parses the expression starting at s[i], "${". It returns the
+// position after the closing '}'.
+func parseExpr(s string, i int) (exprNode, int, bool) {
+	start := i
+	i = skipSpaces(s, i+2)
+	j := i
+	for j < len(s) && isNameChar(s[j]) {
+		j++
+	}
+	name := s[i:j]
+	if name == "" {
+		return nil, 0, false
+	}
+	i = skipSpaces(s, j)
+
+	var e exprNode
+	switch {
+	case strings.HasPrefix(s[i:], "("):
+		c, end, ok := parseCall(s, name, i)
+		if !ok {
+			return nil, 0, false
+		}
+		e, i = c, skipSpaces(s, end)
+	case strings.HasPrefix(s[i:], ":-"):
+		def, end := parseTemplate(s, i+2, true)
+		e, i = &refNode{name: name, hasDef: true, def: def}, end
+	default:
+		e = &refNode{name: name}
+	}
+	if i >= len(s) || s[i] != '}' {
+		return nil, 0, false
+	}
+	switch e := e.(type) {
+	case *refNode:
+		e.source = s[start : i+1]
+	case *callNode:
+		e.source = s[start : i+1]
+	}
+	return e, i + 1, true
+}
+
+// parseCall parses the arguments of a call to fn, starting at the '(' at
+// s[i]. It returns the position after the closing ')'.
+func parseCall(s, fn string, i int) (*callNode, int, bool) {
+	c := &callNode{fn: fn}
+	start := i
+	i = skipSpaces(s, i+1)
+	if strings.HasPrefix(s[i:], ")") {
+		c.source = fn + s[start:i+1]
+		return c, i + 1, true
+	}
+	for {
+		i = skipSpaces(s, i)
+		if i >= len(s) {
+			return nil, 0, false
+		}
+		if s[i] == '"' {
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, 0, false
+			}
+			v, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return nil, 0, false
+			}
+			c.args = append(c.args, &litNode{value: v, source: s[i : j+1]})
+			i = j + 1
+		} else {
+			j := i
+			for j < len(s) && isNameChar(s[j]) {
+				j++
+			}
+			if j == i {
+				return nil, 0, false
+			}
+			name := s[i:j]
+			if k := skipSpaces(s, j); strings.HasPrefix(s[k:], "(") {
+				arg, end, ok := parseCall(s, name, k)
+				if !ok {
+					return nil, 0, false
+				}
+				c.args = append(c.args, arg)
+				i = end
+			} else {
+				c.args = append(c.args, &refNode{name: name, source: name})
+				i = j
+			}
+		}
+		i = skipSpaces(s, i)
+		if i >= len(s) {
+			return nil, 0, false
+		}
+		switch s[i] {
+		case ',':
+			i++
+		case ')':
+			c.source = fn + s[start:i+1]
+			return c, i + 1, true
+		default:
+			return nil, 0, false
+		}
+	}
+}
+
+// interpFuncs are the functions expressions can call, by name, with their
+// number of arguments.
+var interpFuncs = map[string]struct {
+	args int
+	f    func(args []string) string
+}{
+	"lower":      {1, func(a []string) string { return strings.ToLower(a[0]) }},
+	"upper":      {1, func(a []string) string { return strings.ToUpper(a[0]) }},
+	"trimprefix": {2, func(a []string) string { return strings.TrimPrefix(a[0], a[1]) }},
+	// join joins the values of a list, as in list vars, with a separator.
+	"join": {2, func(a []string) string { return strings.Join(listValues(a[0]), a[1]) }},
+	// sha is a short hash, the first 8 hex digits of the SHA-256 of a value.
+	"sha": {1, func(a []string) string {
+		h := sha256.Sum256([]byte(a[0]))
+		return hex.EncodeToString(h[:])[:8]
+	}},
+}
+
+// lateAutovars are the autovars set from workflow fields, after the first
+// round of substitution.
+var lateAutovars = []string{"NAME", "ZONE", "PROJECT", "GCSPATH", "SCRATCHPATH", "SOURCESPATH", "LOGSPATH", "OUTSPATH"}
+
+// interpolator resolves the expressions in workflow strings. Expressions
+// that cannot be resolved yet are left as they are; validateVarsSubbed
+// reports those left after populate.
+type interpolator struct {
+	autovars map[string]string
+	vars     map[string]string
+	env      bool
+	// Names resolved later, like autovars set after the first round of
+	// substitution.
+	deferred map[string]bool
+
+	resolved map[string]string
+	stack    []string
+	errs     dErr
+	errMsgs  map[string]bool
+
+	// Names of the secret vars, and of the vars whose values are derived
+	// from them. Function results derived from them are passed to
+	// addSecret.
+	secret    map[string]bool
+	addSecret func(string)
+}
+
+// newInterpolator returns an interpolator of the workflow's vars and of
+// autovars. References to deferred names are left as they are.
+func (w *Workflow) newInterpolator(autovars map[string]string, deferred ...string) *interpolator {
+	root := w.rootWorkflow()
+	in := &interpolator{
+		autovars:  autovars,
+		vars:      map[string]string{},
+		env:       root.AllowEnv,
+		deferred:  map[string]bool{},
+		resolved:  map[string]string{},
+		errMsgs:   map[string]bool{},
+		secret:    map[string]bool{},
+		addSecret: w.addSecret,
+	}
+	for k, v := range w.Vars {
+		in.vars[k] = v.Value
+		if v.Secret {
+			in.secret[k] = true
+		}
+	}
+	for _, d := range deferred {
+		in.deferred[d] = true
+	}
+	return in
+}
+
+func (in *interpolator) errorf(format string, a ...interface{}) {
+	e := errf(format, a...)
+	if !in.errMsgs[e.Error()] {
+		in.errMsgs[e.Error()] = true
+		in.errs = addErrs(in.errs, e)
+	}
+}
+
+// Replace returns s with its expressions resolved.
+func (in *interpolator) Replace(s string) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+	t, _ := parseTemplate(s, 0, false)
+	v, _ := in.template(t)
+	return v
+}
+
+// template returns the value of t, and whether it is derived from a secret.
+func (in *interpolator) template(t template) (string, bool) {
+	var b strings.Builder
+	var secret bool
+	for _, p := range t {
+		if p.expr == nil {
+			b.WriteString(p.text)
+		} else if v, ok, s := in.expr(p.expr); ok {
+			b.WriteString(v)
+			secret = secret || s
+		} else {
+			b.WriteString(p.expr.src())
+		}
+	}
+	return b.String(), secret
+}
+
+// expr returns the value of e, whether it is resolved and whether it is
+// derived from a secret.
+func (in *interpolator) expr(e exprNode) (string, bool, bool) {
+	switch e := e.(type) {
+	case *litNode:
+		return e.value, true, false
+	case *refNode:
+		if in.isDeferred(e.name) {
+			return "", false, false
+		}
+		v, ok := in.lookup(e.name)
+		if e.hasDef && (!ok || v == "") {
+			v, secret := in.template(e.def)
+			return v, true, secret
+		}
+		if !ok && strings.HasPrefix(e.name, "env.") && in.env {
+			in.errorf("environment variable %q is not set", strings.TrimPrefix(e.name, "env."))
+		}
+		return v, ok, in.secret[e.name]
+	case *callNode:
+		f, ok := interpFuncs[e.fn]
+		if !ok {
+			in.errorf("unknown function %q", e.fn)
+			return "", false, false
+		}
+		if len(e.args) != f.args {
+			in.errorf("function %q takes %d arguments, got %d", e.fn, f.args, len(e.args))
+			return "", false, false
+		}
+		var args []string
+		var secret bool
+		for _, a := range e.args {
+			v, ok, s := in.expr(a)
+			if !ok {
+				return "", false, false
+			}
+			args = append(args, v)
+			secret = secret || s
+		}
+		v := f.f(args)
+		// Values derived from secrets, like their hashes, are masked too.
+		if secret && in.addSecret != nil {
+			in.addSecret(v)
+		}
+		return v, true, secret
+	}
+	return "", false, false
+}
+
+func (in *interpolator) isDeferred(name string) bool {
+	// Step outputs are resolved when the step runs.
+	if strings.HasPrefix(name, "steps.") || (strings.HasPrefix(name, "env.") && !in.env) {
+		return true
+	}
+	return in.deferred[name]
+}
+
+// lookup returns the value of name, resolving the expressions in the values
+// of vars.
+func (in *interpolator) lookup(name string) (string, bool) {
+	if v, ok := in.autovars[name]; ok {
+		return v, true
+	}
+	if strings.HasPrefix(name, "env.") {
+		return os.LookupEnv(strings.TrimPrefix(name, "env."))
+	}
+	raw, ok := in.vars[name]
+	if !ok {
+		return "", false
+	}
+	if v, ok := in.resolved[name]; ok {
+		return v, true
+	}
+	for i, n := range in.stack {
+		if n == name {
+			in.errorf("var cycle: %s -> %s", strings.Join(in.stack[i:], " -> "), name)
+			return "", false
+		}
+	}
+	in.stack = append(in.stack, name)
+	t, _ := parseTemplate(raw, 0, false)
+	v, secret := in.template(t)
+	in.stack = in.stack[:len(in.stack)-1]
+	in.resolved[name] = v
+	if secret {
+		in.secret[name] = true
+	}
+	return v, true
+}
+
+// resolveVars sets the values of the workflow's vars to their resolved
+// values.
+func (w *Workflow) resolveVars(in *interpolator) dErr {
+	var names []string
+	for k := range w.Vars {
+		names = append(names, k)
+	}
+	// Resolving in order keeps errors deterministic.
+	sort.Strings(names)
+	for _, k := range names {
+		v := w.Vars[k]
+		v.Value, _ = in.lookup(k)
+		w.Vars[k] = v
+	}
+	return in.errs
+}
+
+// interpolate resolves the expressions in the strings of the workflow.
+func (w *Workflow) interpolate(in *interpolator) dErr {
+	substituteIfs(w.Steps, in)
+	for _, g := range []*StepGroup{w.OnFailure, w.Finally} {
+		if g != nil {
+			substituteIfs(g.Steps, in)
+		}
+	}
+	substitute(reflect.ValueOf(w).Elem(), in)
+	return in.errs
+}
+
+// unresolvedReason returns why expression e is unresolved, or "" if e is
+// resolved later, when a step runs. handler is whether e is in a handler
+// step, where handler autovars are resolved.
+func unresolvedReason(e exprNode, handler bool) string {
+	switch e := e.(type) {
+	case *refNode:
+		switch {
+		case strings.HasPrefix(e.name, "steps."):
+			return ""
+		case strings.HasPrefix(e.name, "env."):
+			return "references to environment variables are not allowed"
+		case strIn(e.name, handlerAutovars):
+			if handler {
+				return ""
+			}
+			return fmt.Sprintf("%s is only set in OnFailure and Finally steps", e.name)
+		}
+		return fmt.Sprintf("var %q is not defined", e.name)
+	case *callNode:
+		if _, ok := interpFuncs[e.fn]; !ok {
+			return fmt.Sprintf("unknown function %q", e.fn)
+		}
+		for _, a := range e.args {
+			if r := unresolvedReason(a, handler); r != "" {
+				return r
+			}
+		}
+	}
+	return ""
+}
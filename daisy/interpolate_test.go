@@ -0,0 +1,138 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"testing"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestInterpolatorReplace(t *testing.T) {
+	w := New()
+	w.Vars = map[string]wVar{
+		"a":      {Value: "foo"},
+		"b":      {Value: "${a}-bar"},
+		"c":      {Value: "${b}-baz"},
+		"empty":  {},
+		"list":   {Value: "x, y,z"},
+		"prefix": {Value: "debian-"},
+		"image":  {Value: "debian-9"},
+	}
+	in := w.newInterpolator(map[string]string{"ID": "abcde"}, "NAME")
+
+	tests := []struct{ s, want string }{
+		{"plain", "plain"},
+		{"${a}", "foo"},
+		{"${ a }", "foo"},
+		{"${a}-${ID}", "foo-abcde"},
+		{"${c}", "foo-bar-baz"},
+		{"${dne}", "${dne}"},
+		{"${dne:-default}", "default"},
+		{"${empty:-default}", "default"},
+		{"${a:-default}", "foo"},
+		{"${dne:-${a}-${ID}}", "foo-abcde"},
+		{"${dne:-}", ""},
+		{"${NAME}", "${NAME}"},
+		{"${NAME:-default}", "${NAME:-default}"},
+		{"${steps.s.out}", "${steps.s.out}"},
+		{"${env.HOME}", "${env.HOME}"},
+		{"${upper(a)}", "FOO"},
+		{"${lower(\"FoO\")}", "foo"},
+		{"${trimprefix(image, prefix)}", "9"},
+		{"${trimprefix(image, \"debian-\")}", "9"},
+		{"${join(list, \"-\")}", "x-y-z"},
+		{"${upper(trimprefix(b, \"foo-\"))}", "BAR"},
+		{"${sha(a)}", "2c26b46b"},
+		{"${upper(NAME)}", "${upper(NAME)}"},
+		{"${upper(dne)}", "${upper(dne)}"},
+		{"$a ${} ${a b} ${a", "$a ${} ${a b} ${a"},
+	}
+	for _, tt := range tests {
+		if got := in.Replace(tt.s); got != tt.want {
+			t.Errorf("Replace(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+	if in.errs != nil {
+		t.Errorf("unexpected error: %v", in.errs)
+	}
+}
+
+func TestInterpolatorErrors(t *testing.T) {
+	tests := []struct {
+		desc string
+		vars map[string]wVar
+		s    string
+		want string
+	}{
+		{"cycle case", map[string]wVar{"a": {Value: "${b}"}, "b": {Value: "x${c}"}, "c": {Value: "${a}"}}, "${a}", "var cycle: a -> b -> c -> a"},
+		{"self reference case", map[string]wVar{"a": {Value: "${a}"}}, "${a}", "var cycle: a -> a"},
+		{"unknown function case", nil, "${foo(\"a\")}", `unknown function "foo"`},
+		{"argument count case", nil, "${upper(\"a\", \"b\")}", `function "upper" takes 1 arguments, got 2`},
+	}
+	for _, tt := range tests {
+		w := New()
+		w.Vars = tt.vars
+		in := w.newInterpolator(nil)
+		in.Replace(tt.s)
+		if in.errs == nil || in.errs.Error() != tt.want {
+			t.Errorf("%s: got error %v, want %q", tt.desc, in.errs, tt.want)
+		}
+	}
+}
+
+func TestPopulateInterpolation(t *testing.T) {
+	w := testWorkflow()
+	w.Vars = map[string]wVar{
+		// Resolved values are checked against their types.
+		"size":  {Value: "${base_size}", Type: "int"},
+		"disk":  {Value: "${lower(prefix)}-${NAME}"},
+		"image": {Value: "${image_override:-projects/p/global/images/i}", Type: "image-url"},
+	}
+	w.AddVar("base_size", "10")
+	w.AddVar("prefix", "DISK")
+	s, _ := w.NewStep("s")
+	s.CreateDisks = &CreateDisks{{Disk: compute.Disk{Name: "${disk}", SourceImage: "${image}"}, SizeGb: "${size}"}}
+
+	if err := w.populate(context.Background()); err != nil {
+		t.Fatalf("error populating workflow: %v", err)
+	}
+	cd := (*s.CreateDisks)[0]
+	if cd.daisyName != "disk-"+testWf || cd.SourceImage != "projects/p/global/images/i" || cd.SizeGb != "10" {
+		t.Errorf("unexpected disk: Name %q, SourceImage %q, SizeGb %q", cd.daisyName, cd.SourceImage, cd.SizeGb)
+	}
+
+	w = testWorkflow()
+	w.Vars = map[string]wVar{"a": {Value: "${b}"}, "b": {Value: "${a}"}}
+	if err := w.populate(context.Background()); err == nil || err.Error() != "var cycle: a -> b -> a" {
+		t.Errorf("cycle: got error %v", err)
+	}
+}
+
+func TestValidateVarsSubbedPaths(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+	s.CreateDisks = &CreateDisks{{Disk: compute.Disk{Name: "${dne}", Description: "${steps.other.out} ${env.HOME} ${foo(dne)} ${a b}"}}}
+
+	want := "Multiple errors:\n" +
+		`* Unresolved var "${env.HOME}" found in "${steps.other.out} ${env.HOME} ${foo(dne)} ${a b}" at Steps.s.CreateDisks.0.Description: references to environment variables are not allowed` + "\n" +
+		`* Unresolved var "${foo(dne)}" found in "${steps.other.out} ${env.HOME} ${foo(dne)} ${a b}" at Steps.s.CreateDisks.0.Description: unknown function "foo"` + "\n" +
+		`* Unresolved var "${a b}" found in "${steps.other.out} ${env.HOME} ${foo(dne)} ${a b}" at Steps.s.CreateDisks.0.Description: bad expression` + "\n" +
+		`* Unresolved var "${dne}" found in "${dne}" at Steps.s.CreateDisks.0.Name: var "dne" is not defined`
+	if err := w.validateVarsSubbed(); err == nil || err.Error() != want {
+		t.Errorf("got error:\n%v\nwant:\n%s", err, want)
+	}
+}
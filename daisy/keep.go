@@ -0,0 +1,226 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+
+	gcs "cloud.google.com/go/storage"
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/storage"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// retainedFile is the record of the resources kept by a failed run, in its
+// scratch path.
+const retainedFile = "retained.json"
+
+// RetainedResource is a resource that a failed run of a workflow with
+// KeepOnFailure set did not delete.
+type RetainedResource struct {
+	// Type is disk, image or instance.
+	Type string
+	// Name is the name of the resource in the workflow.
+	Name string
+	Link string
+}
+
+// RetainedResources returns the resources w kept when it failed, see
+// Workflow.KeepOnFailure.
+func (w *Workflow) RetainedResources() []RetainedResource {
+	return w.retained
+}
+
+// keepingResources reports whether the top level workflow of w failed and
+// keeps its resources.
+func (w *Workflow) keepingResources() bool {
+	return w.rootWorkflow().keepResources
+}
+
+// keepsOnFailure reports whether the top level workflow of w keeps its
+// resources if it fails.
+func (w *Workflow) keepsOnFailure() bool {
+	return w.rootWorkflow().KeepOnFailure
+}
+
+// retainResources stops the cleanup of the resources of w and its nested
+// workflows, labels them as kept so that FindLeaked skips them, then records
+// them to the scratch path for CleanupRun.
+func (w *Workflow) retainResources(ctx context.Context) dErr {
+	w.keepResources = true
+	return w.recordRetained(ctx, "Keeping the resources of the failed workflow:", func(*resource) bool { return true })
+}
+
+// recordRetained labels the resources of w and its nested workflows that
+// keep selects as kept, and records them to the scratch path for CleanupRun.
+// The resources are logged after msg.
+func (w *Workflow) recordRetained(ctx context.Context, msg string, keep func(*resource) bool) dErr {
+	for _, cw := range w.allWorkflows() {
+		if !cw.ownsRegistries() {
+			continue
+		}
+		for _, r := range cw.registries() {
+			r.mx.Lock()
+			for name, res := range r.m {
+				res.mx.Lock()
+				if !res.noCleanup && !res.deleted && keep(res) {
+					w.retained = append(w.retained, RetainedResource{Type: r.typeName, Name: name, Link: res.link})
+				}
+				res.mx.Unlock()
+			}
+			r.mx.Unlock()
+		}
+	}
+	sort.Slice(w.retained, func(i, j int) bool { return w.retained[i].Link < w.retained[j].Link })
+	if len(w.retained) == 0 {
+		return nil
+	}
+
+	w.logger.Print(msg)
+	var errs dErr
+	for _, r := range w.retained {
+		w.logger.Printf("  %s %q: %s", r.Type, r.Name, r.Link)
+		if err := labelKept(w.ComputeClient, r.Type, r.Link); err != nil {
+			errs = addErrs(errs, errf("error labeling %s %s as kept: %v", r.Type, r.Link, err))
+		}
+	}
+	b, err := json.MarshalIndent(w.retained, "", "  ")
+	if err != nil {
+		return addErrs(errs, newErr(err))
+	}
+	wc := w.StorageClient.NewWriter(ctx, w.bucket, path.Join(w.scratchPath, retainedFile), "application/json")
+	if _, err := wc.Write(b); err != nil {
+		return addErrs(errs, typedErr(apiError, err))
+	}
+	if err := wc.Close(); err != nil {
+		return addErrs(errs, typedErr(apiError, err))
+	}
+	return errs
+}
+
+// keptLabels returns labels with the cleanup label set to cleanupKeep.
+func keptLabels(labels map[string]string) map[string]string {
+	kept := map[string]string{cleanupLabel: cleanupKeep}
+	for k, v := range labels {
+		if k != cleanupLabel {
+			kept[k] = v
+		}
+	}
+	return kept
+}
+
+// labelKept sets the cleanup label of the resource of type typeName at link
+// to cleanupKeep.
+func labelKept(client daisyCompute.Client, typeName, link string) dErr {
+	var err error
+	switch typeName {
+	case "disk":
+		m := namedSubexp(diskURLRgx, link)
+		var d *compute.Disk
+		if d, err = client.GetDisk(m["project"], m["zone"], m["disk"]); err == nil {
+			err = client.SetDiskLabels(m["project"], m["zone"], m["disk"], &compute.ZoneSetLabelsRequest{Labels: keptLabels(d.Labels), LabelFingerprint: d.LabelFingerprint})
+		}
+	case "image":
+		m := namedSubexp(imageURLRgx, link)
+		var i *compute.Image
+		if i, err = client.GetImage(m["project"], m["image"]); err == nil {
+			err = client.SetImageLabels(m["project"], m["image"], &compute.GlobalSetLabelsRequest{Labels: keptLabels(i.Labels), LabelFingerprint: i.LabelFingerprint})
+		}
+	case "instance":
+		m := namedSubexp(instanceURLRgx, link)
+		var i *compute.Instance
+		if i, err = client.GetInstance(m["project"], m["zone"], m["instance"]); err == nil {
+			err = client.SetInstanceLabels(m["project"], m["zone"], m["instance"], &compute.InstancesSetLabelsRequest{Labels: keptLabels(i.Labels), LabelFingerprint: i.LabelFingerprint})
+		}
+	default:
+		return errf("unknown resource type: %q", typeName)
+	}
+	return typedErr(apiError, err)
+}
+
+// deletionOrder orders resource types for deletion: instances first, their
+// disks can't be deleted while attached.
+var deletionOrder = map[string]int{"instance": 0, "image": 1, "disk": 2}
+
+// deleteResource deletes the resource of type typeName at link, returning a
+// resourceDNEError if it doesn't exist.
+func deleteResource(client daisyCompute.Client, typeName, link string) dErr {
+	var err error
+	switch typeName {
+	case "disk":
+		m := namedSubexp(diskURLRgx, link)
+		err = client.DeleteDisk(m["project"], m["zone"], m["disk"])
+	case "image":
+		m := namedSubexp(imageURLRgx, link)
+		err = client.DeleteImage(m["project"], m["image"])
+	case "instance":
+		m := namedSubexp(instanceURLRgx, link)
+		err = client.DeleteInstance(m["project"], m["zone"], m["instance"])
+	default:
+		return errf("unknown resource type: %q", typeName)
+	}
+	if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
+		return typedErr(resourceDNEError, err)
+	}
+	return typedErr(apiError, err)
+}
+
+// CleanupRun deletes the resources kept by a failed run, recorded in the
+// run's scratch path scratchPath, see Workflow.ScratchPath. Resources that no
+// longer exist are skipped. It returns the resources it deleted.
+func CleanupRun(ctx context.Context, cc daisyCompute.Client, sc storage.Client, scratchPath string) ([]RetainedResource, error) {
+	bkt, p, err := splitGCSPath(scratchPath)
+	if err != nil {
+		return nil, err
+	}
+	obj := path.Join(p, retainedFile)
+	r, rErr := sc.NewReader(ctx, bkt, obj)
+	if gErr, ok := rErr.(*googleapi.Error); rErr == gcs.ErrObjectNotExist || ok && gErr.Code == http.StatusNotFound {
+		return nil, errf("no resources kept by the run in %s", scratchPath)
+	} else if rErr != nil {
+		return nil, typedErr(apiError, rErr)
+	}
+	defer r.Close()
+	var retained []RetainedResource
+	if err := json.NewDecoder(r).Decode(&retained); err != nil {
+		return nil, errf("error parsing %s: %v", obj, err)
+	}
+
+	sort.SliceStable(retained, func(i, j int) bool { return deletionOrder[retained[i].Type] < deletionOrder[retained[j].Type] })
+	var deleted []RetainedResource
+	var errs dErr
+	for _, res := range retained {
+		if err := deleteResource(cc, res.Type, res.Link); err != nil {
+			if err.Type() != resourceDNEError {
+				errs = addErrs(errs, errf("error deleting %s %s: %v", res.Type, res.Link, err))
+			}
+			continue
+		}
+		deleted = append(deleted, res)
+	}
+	if errs != nil {
+		return deleted, errs
+	}
+	if err := sc.DeleteObject(ctx, bkt, obj); err != nil {
+		return deleted, fmt.Errorf("error deleting %s: %v", obj, err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,101 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/storage"
+)
+
+func TestKeepOnFailure(t *testing.T) {
+	tf := writeTestWorkflow(t, "keep.wf.json", `{
+  "Name": "keep",
+  "Steps": {
+    "create": {"CreateDisks": [{"Name": "disk", "SizeGb": "10"}, {"Name": "kept", "SizeGb": "10", "NoCleanup": true}]},
+    "fail": {"TestRecord": {"Message": "fail", "Fail": true}}
+  },
+  "Dependencies": {"fail": ["create"]}
+}`)
+	td := filepath.Dir(tf)
+	defer os.RemoveAll(td)
+
+	for _, keep := range []bool{false, true} {
+		w, err := NewFromFile(tf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := daisyCompute.NewFakeClient()
+		c.Permissive = true
+		c.AddProject("fake-project", "fake-zone")
+		sc, err := storage.NewLocalClient(filepath.Join(td, "gcs"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(td, "gcs", "test-bucket"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		w.Project = "fake-project"
+		w.Zone = "fake-zone"
+		w.GCSPath = testGCSPath + "/keep"
+		w.ComputeClient = c
+		w.StorageClient = sc
+		w.logger = log.New(ioutil.Discard, "", 0)
+		w.KeepOnFailure = keep
+		w.KeepForResume = !keep
+
+		if err := w.Run(context.Background()); err == nil {
+			t.Fatalf("keep %t: workflow should have failed", keep)
+		}
+		disk := "disk-keep-" + w.ID()
+		// With KeepForResume, the disk of the completed step is kept to resume
+		// the run.
+		if _, err := c.GetDisk("fake-project", "fake-zone", disk); err != nil {
+			t.Fatalf("keep %t: disk %q should have been kept: %v", keep, disk, err)
+		}
+		want := []RetainedResource{{"disk", "disk", "projects/fake-project/zones/fake-zone/disks/" + disk}}
+		if r := w.RetainedResources(); len(r) != 1 || r[0] != want[0] {
+			t.Errorf("got retained resources %v, want %v", r, want)
+		}
+		// Kept resources are not leaked.
+		if leaked, err := FindLeaked(c, "fake-project", 0); err != nil || len(leaked) != 0 {
+			t.Errorf("got leaked resources %v, error %v, want none", leaked, err)
+		}
+
+		deleted, err := CleanupRun(context.Background(), c, sc, w.ScratchPath())
+		if err != nil {
+			t.Fatalf("error cleaning up run: %v", err)
+		}
+		if len(deleted) != 1 || deleted[0] != want[0] {
+			t.Errorf("got deleted resources %v, want %v", deleted, want)
+		}
+		if _, err := c.GetDisk("fake-project", "fake-zone", disk); err == nil {
+			t.Errorf("disk %q should have been deleted", disk)
+		}
+		if _, err := c.GetDisk("fake-project", "fake-zone", "kept-keep-"+w.ID()); err != nil {
+			t.Errorf("disk with NoCleanup should not have been deleted: %v", err)
+		}
+		if _, err := CleanupRun(context.Background(), c, sc, w.ScratchPath()); err == nil || !strings.HasPrefix(err.Error(), "no resources kept by the run") {
+			t.Errorf("unexpected error cleaning up a cleaned up run: %v", err)
+		}
+	}
+}
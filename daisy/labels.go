@@ -0,0 +1,63 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Labels set on the disks, images and instances daisy creates, to find the
+// resources of runs that did not clean up, see FindLeaked.
+const (
+	workflowLabel = "daisy-workflow"
+	runIDLabel    = "daisy-run-id"
+	userLabel     = "daisy-user"
+	// cleanupLabel is cleanupDelete for resources deleted when the workflow
+	// ends, cleanupKeep for resources with NoCleanup and the resources kept
+	// by failed runs, see Workflow.KeepOnFailure.
+	cleanupLabel  = "daisy-cleanup"
+	cleanupDelete = "delete"
+	cleanupKeep   = "keep"
+)
+
+var labelValueRgx = regexp.MustCompile(`[^a-z0-9_-]`)
+
+// labelValue returns s as a valid GCE label value: lower case letters,
+// digits, underscores and dashes, at most 63 characters.
+func labelValue(s string) string {
+	s = labelValueRgx.ReplaceAllString(strings.ToLower(s), "_")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return s
+}
+
+// resourceLabels returns labels with the labels of the resources created by
+// the run of w added. Daisy's labels replace labels of the same keys.
+func (w *Workflow) resourceLabels(labels map[string]string, noCleanup bool) map[string]string {
+	root := w.rootWorkflow()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[workflowLabel] = labelValue(root.Name)
+	labels[runIDLabel] = labelValue(root.id)
+	labels[userLabel] = labelValue(root.username)
+	labels[cleanupLabel] = cleanupDelete
+	if noCleanup {
+		labels[cleanupLabel] = cleanupKeep
+	}
+	return labels
+}
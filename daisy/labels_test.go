@@ -0,0 +1,60 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestResourceLabels(t *testing.T) {
+	w := testWorkflow()
+	w.Name = "My.Workflow"
+	w.username = "someone@example.com"
+	child := testWorkflow()
+	child.parent = w
+
+	tests := []struct {
+		desc      string
+		labels    map[string]string
+		noCleanup bool
+		want      map[string]string
+	}{
+		{
+			"no labels case",
+			nil,
+			false,
+			map[string]string{workflowLabel: "my_workflow", runIDLabel: "abcdef", userLabel: "someone_example_com", cleanupLabel: cleanupDelete},
+		},
+		{
+			"user labels case",
+			map[string]string{"foo": "bar", cleanupLabel: "never"},
+			true,
+			map[string]string{"foo": "bar", workflowLabel: "my_workflow", runIDLabel: "abcdef", userLabel: "someone_example_com", cleanupLabel: cleanupKeep},
+		},
+	}
+	for _, tt := range tests {
+		got := child.resourceLabels(tt.labels, tt.noCleanup)
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("%s: labels do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+
+	if got := labelValue(strings.Repeat("a", 70)); len(got) != 63 {
+		t.Errorf("labelValue should truncate to 63 characters, got %d", len(got))
+	}
+}
@@ -0,0 +1,348 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Lint rules, LintFinding.Rule.
+const (
+	lintUnusedVar         = "unused-var"
+	lintUnusedSource      = "unused-source"
+	lintUnusedResource    = "unused-resource"
+	lintVarDescription    = "required-var-description"
+	lintSignalInterval    = "signal-interval"
+	lintRealNameNoCleanup = "real-name-cleanup"
+	// LintParse is the rule of workflow files, and of the files of their
+	// IncludeWorkflow and SubWorkflow steps, that can't be read. It can't be
+	// disabled.
+	LintParse = "parse"
+)
+
+// LintRules returns the names of the rules Lint checks.
+func LintRules() []string {
+	return []string{lintUnusedVar, lintUnusedSource, lintUnusedResource, lintVarDescription, lintSignalInterval, lintRealNameNoCleanup}
+}
+
+// LintFinding is a problem Lint found in a workflow.
+type LintFinding struct {
+	// Rule is the name of the rule that found the problem.
+	Rule string `json:"rule"`
+	// Field is the path of the workflow field the problem is in, such as
+	// Vars.foo or Steps.bar.CreateDisks.0.
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Lint checks the workflow for problems that don't stop it from running,
+// following docs/daisy-styleguide.md. Lint works on a workflow as it was
+// read, before populate, so it needs no credentials. The local files of
+// IncludeWorkflow and SubWorkflow steps are read and linted too, their
+// findings are in Steps.<step>.IncludeWorkflow.Workflow or
+// Steps.<step>.SubWorkflow.Workflow. Findings are ordered by Field.
+func (w *Workflow) Lint() []LintFinding {
+	fs := w.lint(false, map[string]bool{})
+	sort.Slice(fs, func(i, j int) bool {
+		if fs[i].Field != fs[j].Field {
+			return fs[i].Field < fs[j].Field
+		}
+		return fs[i].Rule < fs[j].Rule
+	})
+	return fs
+}
+
+// readLocalSource returns the content of the local file of source k, or ""
+// if it can't be read.
+func (w *Workflow) readLocalSource(k string) string {
+	p, ok := w.Sources[k]
+	if !ok || p == "" || strings.Contains(p, "${") || isRemote(p) {
+		return ""
+	}
+	p, err := joinPath(w.workflowDir, p)
+	if err != nil {
+		return ""
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// sourcesDirRefs are the references of scripts to the sources directory:
+// the instance metadata key daisy sets to its path, and its autovars.
+var sourcesDirRefs = []string{"daisy-sources-path", "${SOURCESPATH}", "${SCRATCHPATH}/sources"}
+
+// sourcesDirUsed reports whether one of scripts reads from the sources
+// directory, so it may use any source.
+func sourcesDirUsed(scripts []string) bool {
+	for _, s := range scripts {
+		for _, r := range sourcesDirRefs {
+			if strings.Contains(s, r) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sourceNamed reports whether one of scripts names source k.
+func sourceNamed(k string, scripts []string) bool {
+	k = strings.TrimSuffix(k, "/")
+	for _, s := range scripts {
+		if strings.Contains(s, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// lint returns the findings of w. shared is whether the resources of w may
+// be used by steps of the workflow including it. seen are the files of the
+// nested workflows already linted.
+func (w *Workflow) lint(shared bool, seen map[string]bool) []LintFinding {
+	var fs []LintFinding
+	add := func(rule, field, format string, a ...interface{}) {
+		fs = append(fs, LintFinding{Rule: rule, Field: field, Message: fmt.Sprintf(format, a...)})
+	}
+
+	refs := map[string]bool{}
+	// Scripts, in metadata or StartupScript sources, use the sources they
+	// name.
+	var strs, scripts []string
+	walkStrings(reflect.ValueOf(w).Elem(), nil, func(path []string, s string) {
+		if inNestedWorkflow(path) {
+			return
+		}
+		if path[0] != "Sources" {
+			strs = append(strs, s)
+		}
+		if strIn("Metadata", path) {
+			scripts = append(scripts, s)
+		}
+		t, _ := parseTemplate(s, 0, false)
+		templateRefs(t, func(name string) {
+			// A var referencing itself doesn't use it.
+			if len(path) < 2 || path[0] != "Vars" || path[1] != name {
+				refs[name] = true
+			}
+		})
+	})
+
+	for k, v := range w.Vars {
+		if !refs[k] {
+			add(lintUnusedVar, "Vars."+k, "var %q is not used", k)
+		}
+		if v.Required && v.Description == "" {
+			add(lintVarDescription, "Vars."+k, "required var %q has no Description", k)
+		}
+	}
+
+	for _, s := range w.Steps {
+		if s.CreateInstances == nil {
+			continue
+		}
+		for _, ci := range *s.CreateInstances {
+			if script := w.readLocalSource(ci.StartupScript); script != "" {
+				scripts = append(scripts, script)
+			}
+		}
+	}
+	// Scripts reading from the sources directory may use any source.
+	if !sourcesDirUsed(scripts) {
+		for k := range w.Sources {
+			if !sourceUsed(k, strs) && !sourceNamed(k, scripts) {
+				add(lintUnusedSource, "Sources."+k, "source %q is not used", k)
+			}
+		}
+	}
+
+	// Handler steps run after all of Steps, they may use any resource.
+	handlers := w.OnFailure != nil || w.Finally != nil
+	dependents := map[string]bool{}
+	for _, deps := range w.Dependencies {
+		for _, d := range deps {
+			dependents[d] = true
+		}
+	}
+	for name, s := range w.Steps {
+		field := "Steps." + name
+		for _, r := range createdResources(s) {
+			rField := fmt.Sprintf("%s.%s.%d", field, r.stepType, r.i)
+			if r.realName && !r.noCleanup {
+				add(lintRealNameNoCleanup, rField, "%s %q has a RealName but is deleted when the workflow ends, set NoCleanup to keep it", r.kind, r.name)
+			}
+			if !dependents[name] && !handlers && !shared && !r.noCleanup {
+				add(lintUnusedResource, rField, "step %q has no dependents, %s %q is deleted when the workflow ends without being used", name, r.kind, r.name)
+			}
+		}
+
+		if s.WaitForInstancesSignal == nil {
+			continue
+		}
+		timeout, err := time.ParseDuration(s.Timeout)
+		if s.Timeout == "" {
+			timeout, err = time.ParseDuration(defaultTimeout)
+		}
+		if err != nil {
+			continue
+		}
+		for i, is := range *s.WaitForInstancesSignal {
+			if is == nil {
+				continue
+			}
+			interval, err := time.ParseDuration(is.Interval)
+			if err == nil && interval >= timeout {
+				add(lintSignalInterval, fmt.Sprintf("%s.WaitForInstancesSignal.%d", field, i), "Interval %s of instance %q is not shorter than the step Timeout %s, the signal is checked at most once", interval, is.Name, timeout)
+			}
+		}
+	}
+
+	// Files included by several steps are linted once, for the first step.
+	var names []string
+	for name := range w.Steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := w.Steps[name]
+		var stepType, file string
+		switch {
+		case s.IncludeWorkflow != nil:
+			stepType, file = "IncludeWorkflow", s.IncludeWorkflow.Path
+		case s.SubWorkflow != nil:
+			stepType, file = "SubWorkflow", s.SubWorkflow.Path
+		default:
+			continue
+		}
+		// Files named by vars or on GCS or https are not read.
+		if file == "" || strings.Contains(file, "${") || isRemote(file) {
+			continue
+		}
+		file, err := joinPath(w.workflowDir, file)
+		if err != nil {
+			continue
+		}
+		if seen[file] {
+			continue
+		}
+		seen[file] = true
+		field := fmt.Sprintf("Steps.%s.%s", name, stepType)
+		cw := New()
+		if err := readWorkflow(file, cw); err != nil {
+			add(LintParse, field+".Path", "%v", err)
+			continue
+		}
+		// The resources of included workflows are the including workflow's.
+		cShared := stepType == "IncludeWorkflow" && (shared || handlers || dependents[name])
+		for _, f := range cw.lint(cShared, seen) {
+			f.Field = field + ".Workflow." + f.Field
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
+
+// inNestedWorkflow reports whether path is in the Workflow of an
+// IncludeWorkflow or SubWorkflow step, which have their own vars.
+func inNestedWorkflow(path []string) bool {
+	for i := 1; i < len(path); i++ {
+		if path[i] == "Workflow" && (path[i-1] == "IncludeWorkflow" || path[i-1] == "SubWorkflow") {
+			return true
+		}
+	}
+	return false
+}
+
+// templateRefs runs f on the names t references, in defaults and function
+// arguments too.
+func templateRefs(t template, f func(string)) {
+	for _, p := range t {
+		if p.expr != nil {
+			exprRefs(p.expr, f)
+		}
+	}
+}
+
+func exprRefs(e exprNode, f func(string)) {
+	switch e := e.(type) {
+	case *refNode:
+		f(e.name)
+		templateRefs(e.def, f)
+	case *callNode:
+		for _, a := range e.args {
+			exprRefs(a, f)
+		}
+	}
+}
+
+// sourceUsed reports whether one of strs references source k, by name, as
+// StartupScript does, or by a path under ${SOURCESPATH}, k or a directory of
+// k.
+func sourceUsed(k string, strs []string) bool {
+	k = strings.TrimSuffix(k, "/")
+	for _, s := range strs {
+		if s == k {
+			return true
+		}
+		for _, p := range []string{"${SOURCESPATH}", "${SCRATCHPATH}/sources"} {
+			for r, i := s, strings.Index(s, p); i != -1; i = strings.Index(r, p) {
+				r = r[i+len(p):]
+				j := 0
+				for j < len(r) && (r[j] == '/' || isNameChar(r[j])) {
+					j++
+				}
+				ref := strings.Trim(r[:j], "/")
+				if ref == "" || ref == k || strings.HasPrefix(k, ref+"/") || strings.HasPrefix(ref, k+"/") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+type lintResource struct {
+	kind, stepType, name string
+	i                    int
+	realName, noCleanup  bool
+}
+
+// createdResources returns the resources step s creates.
+func createdResources(s *Step) []lintResource {
+	var rs []lintResource
+	if s.CreateDisks != nil {
+		for i, cd := range *s.CreateDisks {
+			rs = append(rs, lintResource{"disk", "CreateDisks", cd.Name, i, cd.RealName != "" || cd.ExactName, cd.NoCleanup})
+		}
+	}
+	if s.CreateImages != nil {
+		for i, ci := range *s.CreateImages {
+			rs = append(rs, lintResource{"image", "CreateImages", ci.Name, i, ci.RealName != "" || ci.ExactName, ci.NoCleanup})
+		}
+	}
+	if s.CreateInstances != nil {
+		for i, ci := range *s.CreateInstances {
+			rs = append(rs, lintResource{"instance", "CreateInstances", ci.Name, i, ci.RealName != "" || ci.ExactName, ci.NoCleanup})
+		}
+	}
+	return rs
+}
@@ -0,0 +1,185 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestLint(t *testing.T) {
+	wf := `{
+  "Name": "lint",
+  "Vars": {
+    "used": "${nested:-x}",
+    "nested": "",
+    "unused": "${unused}",
+    "required": {"Required": true},
+    "described": {"Required": true, "Description": "Used in a function call."},
+    "source_file": "./file"
+  },
+  "Sources": {
+    "file": "${source_file}",
+    "dir/file": "./dir/file",
+    "unused": "./unused"
+  },
+  "Steps": {
+    "create-disks": {
+      "CreateDisks": [
+        {"Name": "disk-${used}", "SourceImage": "${SOURCESPATH}/dir", "Description": "${upper(described)} ${required}"},
+        {"Name": "disk-real", "RealName": "real"}
+      ]
+    },
+    "create-images": {
+      "CreateImages": [
+        {"Name": "image-kept", "SourceDisk": "disk-real", "NoCleanup": true},
+        {"Name": "image-dropped", "RawDisk": {"Source": "file"}}
+      ]
+    },
+    "wait": {
+      "Timeout": "1m",
+      "WaitForInstancesSignal": [
+        {"Name": "a", "Interval": "30s", "Stopped": true},
+        {"Name": "b", "Interval": "2m", "Stopped": true}
+      ]
+    }
+  },
+  "Dependencies": {
+    "create-images": ["create-disks"]
+  }
+}`
+	w, err := NewFromFile(writeTestWorkflow(t, "lint.wf.json", wf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []LintFinding{
+		{lintUnusedSource, "Sources.unused", `source "unused" is not used`},
+		{lintRealNameNoCleanup, "Steps.create-disks.CreateDisks.1", `disk "disk-real" has a RealName but is deleted when the workflow ends, set NoCleanup to keep it`},
+		{lintUnusedResource, "Steps.create-images.CreateImages.1", `step "create-images" has no dependents, image "image-dropped" is deleted when the workflow ends without being used`},
+		{lintSignalInterval, "Steps.wait.WaitForInstancesSignal.1", `Interval 2m0s of instance "b" is not shorter than the step Timeout 1m0s, the signal is checked at most once`},
+		{lintVarDescription, "Vars.required", `required var "required" has no Description`},
+		{lintUnusedVar, "Vars.unused", `var "unused" is not used`},
+	}
+	if diff := pretty.Compare(w.Lint(), want); diff != "" {
+		t.Errorf("findings do not match expectation: (-got +want)\n%s", diff)
+	}
+
+	// Instance scripts use the sources they name.
+	if err := ioutil.WriteFile(filepath.Join(w.workflowDir, "startup.sh"), []byte("cp dir/file /\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	w.Sources["startup.sh"] = "./startup.sh"
+	w.Sources["metadata"] = "./metadata"
+	w.Steps["create-disks"].CreateInstances = &CreateInstances{
+		{Instance: compute.Instance{Name: "a"}, StartupScript: "startup.sh", Metadata: map[string]string{"script": "cat metadata"}},
+	}
+	var got []string
+	for _, f := range w.Lint() {
+		if f.Rule == lintUnusedSource {
+			got = append(got, f.Field)
+		}
+	}
+	if diff := pretty.Compare(got, []string{"Sources.unused"}); diff != "" {
+		t.Errorf("unused sources do not match expectation: (-got +want)\n%s", diff)
+	}
+
+	// Scripts reading from the sources directory may use any source.
+	for _, script := range []string{
+		"gsutil cp -r $(curl -H Metadata-Flavor:Google http://metadata.google.internal/computeMetadata/v1/instance/attributes/daisy-sources-path)/* .\n",
+		"gsutil cp -r ${SOURCESPATH}/* .\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(w.workflowDir, "startup.sh"), []byte(script), 0600); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range w.Lint() {
+			if f.Rule == lintUnusedSource {
+				t.Errorf("startup script %q: unexpected finding %v", script, f)
+			}
+		}
+	}
+}
+
+func TestLintNestedWorkflows(t *testing.T) {
+	tf := writeTestWorkflow(t, "lint.wf.json", `{
+  "Name": "lint",
+  "Steps": {
+    "include": {"IncludeWorkflow": {"Path": "./include.wf.json"}},
+    "include-again": {"IncludeWorkflow": {"Path": "include.wf.json"}},
+    "sub": {"SubWorkflow": {"Path": "./sub.wf.json"}},
+    "remote": {"SubWorkflow": {"Path": "gs://bucket/remote.wf.json"}},
+    "missing": {"SubWorkflow": {"Path": "./missing.wf.json"}},
+    "use": {"CreateImages": [{"Name": "image", "SourceDisk": "disk", "NoCleanup": true}]}
+  },
+  "Dependencies": {
+    "use": ["include"]
+  }
+}`)
+	defer os.RemoveAll(filepath.Dir(tf))
+	files := map[string]string{
+		"include.wf.json": `{"Vars": {"unused": ""}, "Steps": {"disk": {"CreateDisks": [{"Name": "disk", "SizeGb": "10"}]}}}`,
+		"sub.wf.json":     `{"Steps": {"disk": {"CreateDisks": [{"Name": "disk", "SizeGb": "10"}]}}}`,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(filepath.Dir(tf), name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := NewFromFile(tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, f := range w.Lint() {
+		got = append(got, f.Rule+" "+f.Field)
+	}
+	want := []string{
+		lintUnusedVar + " Steps.include.IncludeWorkflow.Workflow.Vars.unused",
+		LintParse + " Steps.missing.SubWorkflow.Path",
+		lintUnusedResource + " Steps.sub.SubWorkflow.Workflow.Steps.disk.CreateDisks.0",
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("findings do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestSourceUsed(t *testing.T) {
+	tests := []struct {
+		k, s string
+		want bool
+	}{
+		{"file", "file", true},
+		{"file", "other", false},
+		{"file", "${SOURCESPATH}/file", true},
+		{"file", "gs://${SOURCESPATH}/file.sh", false},
+		{"file", "${SOURCESPATH}", true},
+		{"file", "cp ${SOURCESPATH}/ .", true},
+		{"dir/file", "${SOURCESPATH}/dir", true},
+		{"dir/file", "${SOURCESPATH}/dir2", false},
+		{"dir", "${SCRATCHPATH}/sources/dir/file", true},
+		{"dir/", "x ${SOURCESPATH}/other ${SOURCESPATH}/dir/file", true},
+	}
+	for _, tt := range tests {
+		if got := sourceUsed(tt.k, []string{tt.s}); got != tt.want {
+			t.Errorf("sourceUsed(%q, %q) = %t, want %t", tt.k, tt.s, got, tt.want)
+		}
+	}
+}
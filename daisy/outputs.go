@@ -0,0 +1,223 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Steps publish outputs as they run, other steps reference them as
+// ${steps.<step>.<output>}. References are resolved just before the
+// referencing step runs, so the referencing step must depend on the step
+// publishing the output.
+var outputRefRgx = regexp.MustCompile(`\$\{steps\.([^.}]+)\.([^}]+)\}`)
+
+// outputFields are the fields output references can be used in, at any
+// depth. Other fields are validated before the workflow runs, which the
+// values of the references would not be.
+var outputFields = []string{"If", "Description", "Metadata"}
+
+// runtimeResourceFields are the fields naming the resource a step uses that
+// output references can be used in, by path from the step. Steps check them
+// when they run, once the references are resolved.
+var runtimeResourceFields = []string{
+	"CreateDisks.SourceImage",
+	"CreateImages.SourceDisk",
+	"CreateInstances.Disks.InitializeParams.SourceImage",
+}
+
+// hasOutputRef reports whether v references step outputs.
+func hasOutputRef(v string) bool {
+	return outputRefRgx.MatchString(v)
+}
+
+func (w *Workflow) setOutput(step, name, value string) {
+	w.outputsMx.Lock()
+	defer w.outputsMx.Unlock()
+	if w.outputs == nil {
+		w.outputs = map[string]map[string]string{}
+	}
+	if w.outputs[step] == nil {
+		w.outputs[step] = map[string]string{}
+	}
+	w.outputs[step][name] = value
+}
+
+func (w *Workflow) output(step, name string) (string, bool) {
+	w.outputsMx.Lock()
+	defer w.outputsMx.Unlock()
+	v, ok := w.outputs[step][name]
+	return v, ok
+}
+
+// setOutput publishes an output of s.
+func (s *Step) setOutput(name, value string) {
+	s.w.setOutput(s.name, name, value)
+}
+
+// findStep looks up a step by name in w, then in the workflows w is nested in.
+func (w *Workflow) findStep(name string) *Step {
+	for ; w != nil; w = w.parent {
+		if s, ok := w.Steps[name]; ok {
+			return s
+		}
+	}
+	return nil
+}
+
+// traverseFields runs f on the values of the step's fields. The steps of
+// IncludeWorkflow and SubWorkflow workflows resolve their own references,
+// so the workflows are not traversed.
+func (s *Step) traverseFields(f func(reflect.Value) dErr) dErr {
+	v := reflect.ValueOf(s).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		switch v.Type().Field(i).Name {
+		case "IncludeWorkflow", "SubWorkflow":
+			continue
+		}
+		if err := traverseData(v.Field(i), f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outputRefs returns the steps whose outputs s references, by name.
+func (s *Step) outputRefs() map[string][]string {
+	refs := map[string][]string{}
+	s.traverseFields(func(v reflect.Value) dErr {
+		if v.Kind() != reflect.String {
+			return nil
+		}
+		for _, m := range outputRefRgx.FindAllStringSubmatch(v.String(), -1) {
+			if !strIn(m[2], refs[m[1]]) {
+				refs[m[1]] = append(refs[m[1]], m[2])
+			}
+		}
+		return nil
+	})
+	return refs
+}
+
+// checkOutputFields returns an error if v, the value of the field at path,
+// has output references outside of outputFields and runtimeResourceFields.
+// allowed is whether a field v is in is one of outputFields.
+func checkOutputFields(path string, v reflect.Value, allowed bool) dErr {
+	name := path[strings.LastIndex(path, ".")+1:]
+	allowed = allowed || strIn(name, outputFields)
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if !v.IsNil() {
+			return checkOutputFields(path, v.Elem(), allowed)
+		}
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := checkOutputFields(path, v.Index(i), allowed); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			if err := checkOutputFields(path, k, allowed); err != nil {
+				return err
+			}
+			if err := checkOutputFields(path, v.MapIndex(k), allowed); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			// Fields of embedded structs, like the compute.Disk of a
+			// CreateDisk, are fields of the embedding struct.
+			fPath := path
+			if !f.Anonymous {
+				fPath += "." + f.Name
+			}
+			if err := checkOutputFields(fPath, v.Field(i), allowed); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		if m := outputRefRgx.FindString(v.String()); m != "" && !allowed && !strIn(path, runtimeResourceFields) {
+			return errf("cannot use output reference %s in field %s, outputs can only be used in the %s fields and in %s", m, name, strings.Join(outputFields, ", "), strings.Join(runtimeResourceFields, ", "))
+		}
+	}
+	return nil
+}
+
+func (s *Step) validateOutputRefs() dErr {
+	v := reflect.ValueOf(s).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		switch f.Name {
+		case "IncludeWorkflow", "SubWorkflow":
+			continue
+		}
+		if f.PkgPath == "" {
+			if err := checkOutputFields(f.Name, v.Field(i), false); err != nil {
+				return err
+			}
+		}
+	}
+	for name := range s.outputRefs() {
+		ref := s.w.findStep(name)
+		if ref == nil {
+			return errf("cannot reference outputs of step %q, step does not exist", name)
+		}
+		if !s.nestedDepends(ref) {
+			return errf("referencing outputs of step %q MUST transitively depend on step %q", name, name)
+		}
+	}
+	return nil
+}
+
+// resolveOutputs substitutes the output references of s with their values.
+func (s *Step) resolveOutputs() dErr {
+	var replacements []string
+	for name, outputs := range s.outputRefs() {
+		ref := s.w.findStep(name)
+		if ref == nil {
+			return errf("cannot resolve outputs of step %q, step does not exist", name)
+		}
+		for _, o := range outputs {
+			// The outputs of a step skipped by its If condition are empty.
+			v, ok := ref.w.output(name, o)
+			if !ok && !ref.skipped {
+				return errf("step %q did not publish output %q", name, o)
+			}
+			replacements = append(replacements, fmt.Sprintf("${steps.%s.%s}", name, o), v)
+		}
+	}
+	if len(replacements) == 0 {
+		return nil
+	}
+	r := strings.NewReplacer(replacements...)
+	if err := s.substituteIf(r); err != nil {
+		return err
+	}
+	return s.traverseFields(func(v reflect.Value) dErr {
+		if v.Kind() == reflect.String {
+			v.SetString(r.Replace(v.String()))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,280 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestValidateOutputRefs(t *testing.T) {
+	w := testWorkflow()
+	w.NewStep("s1")
+	s2, _ := w.NewStep("s2")
+	s3, _ := w.NewStep("s3")
+	w.AddDependency("s2", "s1")
+
+	tests := []struct {
+		desc      string
+		s         *Step
+		ref       string
+		shouldErr bool
+	}{
+		{"dependency case", s2, "${steps.s1.out}", false},
+		{"no dependency case", s3, "${steps.s1.out}", true},
+		{"missing step case", s2, "${steps.dne.out}", true},
+	}
+
+	for _, tt := range tests {
+		tt.s.CreateDisks = &CreateDisks{{Disk: compute.Disk{Description: "value: " + tt.ref}}}
+		err := tt.s.validateOutputRefs()
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+
+	s2.CreateDisks = nil
+	ref := "${steps.s1.out}"
+	fieldTests := []struct {
+		desc      string
+		ci        *CreateInstance
+		shouldErr bool
+	}{
+		{"metadata case", &CreateInstance{Metadata: map[string]string{"ip": "${steps.s1.out}"}}, false},
+		{"instance metadata case", &CreateInstance{Instance: compute.Instance{Metadata: &compute.Metadata{Items: []*compute.MetadataItems{{Key: "ip", Value: &ref}}}}}, false},
+		{"name case", &CreateInstance{Instance: compute.Instance{Name: "${steps.s1.out}"}}, true},
+		{"disk source case", &CreateInstance{Instance: compute.Instance{Disks: []*compute.AttachedDisk{{Source: "${steps.s1.out}"}}}}, true},
+		{"source image case", &CreateInstance{Instance: compute.Instance{Disks: []*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{SourceImage: "${steps.s1.out}"}}}}}, false},
+		{"disk name case", &CreateInstance{Instance: compute.Instance{Disks: []*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: "${steps.s1.out}"}}}}}, true},
+	}
+	for _, tt := range fieldTests {
+		s2.CreateInstances = &CreateInstances{tt.ci}
+		err := s2.validateOutputRefs()
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestResolveOutputs(t *testing.T) {
+	w := testWorkflow()
+	w.NewStep("s1")
+	s2, _ := w.NewStep("s2")
+	w.AddDependency("s2", "s1")
+	s2.CreateDisks = &CreateDisks{{Disk: compute.Disk{Description: "${steps.s1.a}-${steps.s1.b.c}"}}}
+
+	if err := s2.resolveOutputs(); err == nil {
+		t.Error("unpublished output: should have returned an error")
+	}
+	// The outputs of a skipped step are empty.
+	(*s2.CreateDisks)[0].Description = "${steps.s1.a}"
+	w.Steps["s1"].skipped = true
+	if err := s2.resolveOutputs(); err != nil {
+		t.Fatalf("unexpected error resolving outputs of a skipped step: %v", err)
+	}
+	if got := (*s2.CreateDisks)[0].Description; got != "" {
+		t.Errorf("got %q, want an empty output", got)
+	}
+	w.Steps["s1"].skipped = false
+	(*s2.CreateDisks)[0].Description = "${steps.s1.a}-${steps.s1.b.c}"
+
+	w.setOutput("s1", "a", "foo")
+	w.setOutput("s1", "b.c", "bar")
+	if err := s2.resolveOutputs(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := (*s2.CreateDisks)[0].Description; got != "foo-bar" {
+		t.Errorf("got %q, want %q", got, "foo-bar")
+	}
+}
+
+func TestResolveOutputsIncludedWorkflow(t *testing.T) {
+	w := testWorkflow()
+	w.NewStep("s1")
+	w.setOutput("s1", "a", "foo")
+	iw := w.NewIncludedWorkflow()
+	s2, _ := iw.NewStep("s2")
+	s2.CreateDisks = &CreateDisks{{Disk: compute.Disk{Description: "${steps.s1.a}"}}}
+
+	if err := s2.resolveOutputs(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := (*s2.CreateDisks)[0].Description; got != "foo" {
+		t.Errorf("got %q, want %q", got, "foo")
+	}
+}
+
+func TestStepRunOutputIf(t *testing.T) {
+	w := testWorkflow()
+	w.NewStep("s1")
+	s2, _ := w.NewStep("s2")
+	w.AddDependency("s2", "s1")
+	var ran bool
+	s2.testType = &mockStep{runImpl: func(ctx context.Context, s *Step) dErr {
+		ran = true
+		return nil
+	}}
+	s2.If = "${steps.s1.ok}"
+	if err := w.populateStep(context.Background(), s2); err != nil {
+		t.Fatalf("error populating step: %v", err)
+	}
+	if s2.skipped {
+		t.Fatal("condition referencing outputs should not be evaluated in populate")
+	}
+
+	w.setOutput("s1", "ok", "false")
+	if err := w.runStepWithEvents(context.Background(), s2); err != nil {
+		t.Fatalf("error running step: %v", err)
+	}
+	if ran {
+		t.Error("step should have been skipped")
+	}
+}
+
+func TestWaitForSerialOutputOutputs(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("wait")
+	ws := &WaitForInstancesSignal{{
+		Name:         "i",
+		SerialOutput: &SerialOutput{Port: 1, SuccessMatch: "success", Outputs: map[string]string{"all": "fail", "sub": "fail(\\w+)"}},
+	}}
+	if err := ws.populate(context.Background(), s); err != nil {
+		t.Fatalf("error populating step: %v", err)
+	}
+	if err := waitForSerialOutput(context.Background(), s, "i", testProject, testZone, "i", (*ws)[0].SerialOutput, time.Microsecond); err != nil {
+		t.Fatalf("error waiting for serial output: %v", err)
+	}
+	for o, want := range map[string]string{"all": "fail", "sub": "success"} {
+		if got, _ := w.output("wait", "i."+o); got != want {
+			t.Errorf("output %q: got %q, want %q", o, got, want)
+		}
+	}
+
+	(*ws)[0].SerialOutput.Outputs = map[string]string{"bad": "("}
+	if err := ws.populate(context.Background(), s); err == nil {
+		t.Error("bad regexp: should have returned an error")
+	}
+}
+
+func TestRunFakeComputeOutputs(t *testing.T) {
+	wf := `{
+  "Name": "fake",
+  "Steps": {
+    "create-instance": {"CreateInstances": [{"Name": "inst", "Disks": [{"InitializeParams": {"SourceImage": "projects/fake-src/global/images/fake-image"}}]}]},
+    "wait": {"WaitForInstancesSignal": [{"Name": "inst", "Interval": "1ms", "SerialOutput": {"Port": 1, "SuccessMatch": "DaisySuccess", "Outputs": {"version": "version: (\\S+)"}}}]},
+    "record": {"CreateDisks": [{"Name": "record", "SizeGb": "10", "NoCleanup": true, "Description": "${steps.wait.inst.version} ${steps.create-instance.inst.NetworkIP}"}]}
+  },
+  "Dependencies": {
+    "wait": ["create-instance"],
+    "record": ["wait"]
+  }
+}`
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	tf := filepath.Join(td, "fake.wf.json")
+	if err := ioutil.WriteFile(tf, []byte(wf), 0600); err != nil {
+		t.Fatalf("error writing workflow: %v", err)
+	}
+
+	w, err := NewFromFile(tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := daisyCompute.NewFakeClient()
+	c.Permissive = true
+	c.AddProject("fake-project", "fake-zone")
+	c.AddImage("fake-src", &compute.Image{Name: "fake-image"})
+	c.AddInstanceScript(&daisyCompute.InstanceScript{Instance: "^inst-", Output: []string{"version: 1.2.3\n", "DaisySuccess\n"}})
+	w.Project = "fake-project"
+	w.Zone = "fake-zone"
+	w.GCSPath = testGCSPath
+	w.ComputeClient = c
+	w.StorageClient, _ = newTestGCSClient()
+	w.logger = log.New(ioutil.Discard, "", 0)
+
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatalf("error running workflow: %v", err)
+	}
+
+	ds, err := c.ListDisks("fake-project", "fake-zone")
+	if err != nil || len(ds) != 1 || !strings.HasPrefix(ds[0].Name, "record-") {
+		t.Fatalf("want one disk named record-*, got %v, err: %v", ds, err)
+	}
+	if want := "1.2.3 10.128.0.1"; ds[0].Description != want {
+		t.Errorf("disk description: got %q, want %q", ds[0].Description, want)
+	}
+}
+
+func TestRunFakeComputeRuntimeResources(t *testing.T) {
+	tests := []struct {
+		desc, image, wantErr string
+	}{
+		{"image URL", "projects/fake-src/global/images/fake-image", ""},
+		{"image partial URL", "global/images/built", ""},
+		{"image self link", "https://www.googleapis.com/compute/v1/projects/fake-src/global/images/fake-image", ""},
+		{"missing image", "projects/fake-src/global/images/dne", "images/dne does not exist"},
+	}
+	for _, tt := range tests {
+		w := fakeTestWorkflow(t, `{
+  "Name": "runtime",
+  "Steps": {
+    "create-instance": {"CreateInstances": [{"Name": "inst", "Disks": [{"InitializeParams": {"SourceImage": "projects/fake-src/global/images/fake-image"}}]}]},
+    "wait": {"WaitForInstancesSignal": [{"Name": "inst", "Interval": "1ms", "SerialOutput": {"Port": 1, "SuccessMatch": "DaisySuccess", "Outputs": {"image": "image: (\\S+)"}}}]},
+    "create-disk": {"CreateDisks": [{"Name": "disk", "SourceImage": "${steps.wait.inst.image}"}]},
+    "create-inst2": {"CreateInstances": [{"Name": "inst2", "Disks": [{"InitializeParams": {"SourceImage": "${steps.wait.inst.image}"}}]}]},
+    "create-image": {"CreateImages": [{"Name": "image", "SourceDisk": "${steps.create-disk.disk.SelfLink}"}]}
+  },
+  "Dependencies": {
+    "wait": ["create-instance"],
+    "create-disk": ["wait"],
+    "create-inst2": ["wait"],
+    "create-image": ["create-disk"]
+  }
+}`, nil)
+		defer os.RemoveAll(w.workflowDir)
+		c := w.ComputeClient.(*daisyCompute.FakeClient)
+		c.Permissive = false
+		c.AddImage("fake-src", &compute.Image{Name: "fake-image"})
+		c.AddImage("fake-project", &compute.Image{Name: "built"})
+		c.AddInstanceScript(&daisyCompute.InstanceScript{Instance: "^inst-", Output: []string{"image: " + tt.image + "\n", "DaisySuccess\n"}})
+
+		err := w.Run(context.Background())
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got error %v, want error containing %q", tt.desc, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: error running workflow: %v", tt.desc, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+)
+
+// workflowHashLabel is set on images created with Provenance to the first
+// characters of the SHA-256 of the top level workflow file, the whole hash
+// doesn't fit in a label value.
+const workflowHashLabel = "daisy-workflow-sha256"
+
+// Provenance is the record of how an image created with Provenance set was
+// built, written to OUTSPATH as IMAGE.provenance.json.
+type Provenance struct {
+	Image string
+	// Workflow and RunID are the name and run ID of the top level workflow.
+	Workflow string
+	RunID    string
+	// SHA-256 of the top level workflow file, empty if the workflow was not
+	// read from a file.
+	WorkflowSHA256 string `json:",omitempty"`
+	// Values of the vars of the image's workflow, secret vars excluded.
+	Vars map[string]string `json:",omitempty"`
+	// SHA-256 of the uploaded Sources of the image's workflow, by name. Files
+	// of directory sources are listed as NAME/PATH.
+	Sources map[string]string `json:",omitempty"`
+	// Links of the images the source disk of the image was created from,
+	// nearest first: the source image of the disk, then, if the run created
+	// that image, the source image of its source disk, and so on.
+	SourceImages []string `json:",omitempty"`
+}
+
+// workflowSHA256 returns the SHA-256 of the file of the top level workflow of
+// w.
+func (w *Workflow) workflowSHA256() string {
+	return w.rootWorkflow().fileSHA256
+}
+
+// partialLink returns link without the API URL it may start with.
+func partialLink(link string) string {
+	if i := strings.Index(link, "projects/"); i > 0 {
+		return link[i:]
+	}
+	return link
+}
+
+// recordDiskImage records that the run created disk from image.
+func (w *Workflow) recordDiskImage(disk, image string) {
+	root := w.rootWorkflow()
+	root.lineageMx.Lock()
+	defer root.lineageMx.Unlock()
+	if root.diskImages == nil {
+		root.diskImages = map[string]string{}
+	}
+	root.diskImages[partialLink(disk)] = partialLink(image)
+}
+
+// recordImageDisk records that the run created image from disk.
+func (w *Workflow) recordImageDisk(image, disk string) {
+	root := w.rootWorkflow()
+	root.lineageMx.Lock()
+	defer root.lineageMx.Unlock()
+	if root.imageDisks == nil {
+		root.imageDisks = map[string]string{}
+	}
+	root.imageDisks[partialLink(image)] = partialLink(disk)
+}
+
+// sourceImages returns the images disk was created from, following the
+// images created by the run back to their source disks.
+func (w *Workflow) sourceImages(disk string) []string {
+	root := w.rootWorkflow()
+	root.lineageMx.Lock()
+	defer root.lineageMx.Unlock()
+	var imgs []string
+	for disk = partialLink(disk); disk != ""; {
+		img, ok := root.diskImages[disk]
+		if !ok || strIn(img, imgs) {
+			break
+		}
+		imgs = append(imgs, img)
+		disk = root.imageDisks[img]
+	}
+	return imgs
+}
+
+// wantsProvenance reports whether a CreateImages step of w, or of a workflow
+// it includes, records the provenance of its images.
+func (w *Workflow) wantsProvenance() bool {
+	steps := []map[string]*Step{w.Steps}
+	_, groups := w.stepGroups()
+	for _, g := range groups {
+		steps = append(steps, g.Steps)
+	}
+	for _, ss := range steps {
+		for _, s := range ss {
+			if s.IncludeWorkflow != nil && s.IncludeWorkflow.Workflow != nil && s.IncludeWorkflow.Workflow.wantsProvenance() {
+				return true
+			}
+			if s.CreateImages == nil {
+				continue
+			}
+			for _, ci := range *s.CreateImages {
+				if ci.Provenance {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// uploadedSources returns the SHA-256 of the Sources of w, recorded as they
+// were uploaded.
+func (w *Workflow) uploadedSources() map[string]string {
+	// Included workflows' Sources are uploaded by the workflow including them.
+	up := w
+	for up.parent != nil && up.parent.sourcesPath == up.sourcesPath {
+		up = up.parent
+	}
+	var hashes map[string]string
+	for name, sum := range up.sourceHashes {
+		if _, ok := w.Sources[strings.SplitN(name, "/", 2)[0]]; !ok {
+			continue
+		}
+		if hashes == nil {
+			hashes = map[string]string{}
+		}
+		hashes[name] = sum
+	}
+	return hashes
+}
+
+// provenance returns the provenance of image ci, created by a step of w.
+func (w *Workflow) provenance(ci *CreateImage) *Provenance {
+	root := w.rootWorkflow()
+	p := &Provenance{Image: ci.SelfLink, Workflow: root.Name, RunID: root.id, WorkflowSHA256: w.workflowSHA256()}
+	for k, v := range w.Vars {
+		if v.Secret {
+			continue
+		}
+		if p.Vars == nil {
+			p.Vars = map[string]string{}
+		}
+		// Values of other vars may be derived from secret ones.
+		p.Vars[k] = w.redact(v.Value)
+	}
+	p.Sources = w.uploadedSources()
+	if ci.SourceDisk != "" {
+		p.SourceImages = w.sourceImages(ci.SourceDisk)
+	}
+	return p
+}
+
+// writeProvenance writes the provenance of image ci to OUTSPATH.
+func (w *Workflow) writeProvenance(ctx context.Context, ci *CreateImage) dErr {
+	b, jErr := json.MarshalIndent(w.provenance(ci), "", "  ")
+	if jErr != nil {
+		return newErr(jErr)
+	}
+	obj := path.Join(w.outsPath, ci.Name+".provenance.json")
+	wc := w.StorageClient.NewWriter(ctx, w.bucket, obj, "application/json")
+	if _, err := wc.Write(b); err != nil {
+		return typedErr(apiError, err)
+	}
+	if err := wc.Close(); err != nil {
+		return typedErr(apiError, err)
+	}
+	w.logger.Printf("CreateImages: wrote provenance of image %q to gs://%s/%s", ci.Name, w.bucket, obj)
+	return nil
+}
@@ -0,0 +1,169 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/storage"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestProvenance(t *testing.T) {
+	wf := `{
+  "Name": "prov",
+  "Vars": {"size": "10", "password": {"Value": "hunter2", "Secret": true}, "login": "admin:${password}"},
+  "Sources": {"startup.sh": "./startup.sh"},
+  "Steps": {
+    "disk": {"CreateDisks": [
+      {"Name": "disk", "SourceImage": "projects/other/global/images/family/base", "SizeGb": "${size}"},
+      {"Name": "other", "SourceImage": "projects/other/global/images/family/unrelated", "Description": "${login}"}
+    ]},
+    "base": {"CreateImages": [{"Name": "base", "SourceDisk": "disk"}]},
+    "disk-2": {"CreateDisks": [{"Name": "disk-2", "SourceImage": "base"}]},
+    "image": {"CreateImages": [{"Name": "image", "SourceDisk": "disk-2", "NoCleanup": true, "Provenance": true}]}
+  },
+  "Dependencies": {"base": ["disk"], "disk-2": ["base"], "image": ["disk-2"]}
+}`
+	tf := writeTestWorkflow(t, "prov.wf.json", wf)
+	td := filepath.Dir(tf)
+	defer os.RemoveAll(td)
+	if err := ioutil.WriteFile(filepath.Join(td, "startup.sh"), []byte("echo hi\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewFromFile(tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := daisyCompute.NewFakeClient()
+	c.Permissive = true
+	c.AddProject("fake-project", "fake-zone")
+	sc, err := storage.NewLocalClient(filepath.Join(td, "gcs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(td, "gcs", "test-bucket"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	w.Project = "fake-project"
+	w.Zone = "fake-zone"
+	w.GCSPath = testGCSPath
+	w.ComputeClient = c
+	w.StorageClient = sc
+	w.logger = log.New(ioutil.Discard, "", 0)
+
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatalf("error running workflow: %v", err)
+	}
+
+	sum := func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+	image := "image-prov-" + w.ID()
+	want := Provenance{
+		Image:          "https://www.googleapis.com/compute/v1/projects/fake-project/global/images/" + image,
+		Workflow:       "prov",
+		RunID:          w.ID(),
+		WorkflowSHA256: sum(wf),
+		Vars:           map[string]string{"size": "10", "login": "admin:*****"},
+		Sources:        map[string]string{"startup.sh": sum("echo hi\n")},
+		SourceImages:   []string{"projects/fake-project/global/images/base-prov-" + w.ID(), "projects/other/global/images/family/base"},
+	}
+	b, err := ioutil.ReadFile(filepath.Join(td, "gcs", "test-bucket", w.outsPath, image+".provenance.json"))
+	if err != nil {
+		t.Fatalf("error reading provenance: %v", err)
+	}
+	var got Provenance
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("error parsing provenance: %v", err)
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("provenance does not match expectation: (-got +want)\n%s", diff)
+	}
+	img, err := c.GetImage("fake-project", image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.Labels[workflowHashLabel]; got != sum(wf)[:63] {
+		t.Errorf("got %s label %q, want %q", workflowHashLabel, got, sum(wf)[:63])
+	}
+}
+
+func TestProvenanceIncludedWorkflow(t *testing.T) {
+	wf := `{
+  "Name": "prov",
+  "Vars": {"size": "10", "parent": "only"},
+  "Sources": {"startup.sh": "./startup.sh"},
+  "Steps": {
+    "inc": {"IncludeWorkflow": {"Path": "./inc.wf.json", "Vars": {"size": "20"}}}
+  }
+}`
+	inc := `{
+  "Vars": {"size": {"Required": true}, "token": {"Value": "s3cret", "Secret": true}},
+  "Sources": {"inc.sh": "./inc.sh"},
+  "Steps": {
+    "disk": {"CreateDisks": [{"Name": "disk", "SourceImage": "projects/other/global/images/family/base", "SizeGb": "${size}"}]},
+    "image": {"CreateImages": [{"Name": "image", "SourceDisk": "disk", "Provenance": true}]}
+  },
+  "Dependencies": {"image": ["disk"]}
+}`
+	w := fakeTestWorkflow(t, wf, map[string]string{"inc.wf.json": inc, "startup.sh": "echo hi\n", "inc.sh": "echo inc\n"})
+	defer os.RemoveAll(w.workflowDir)
+	sc, err := storage.NewLocalClient(filepath.Join(w.workflowDir, "gcs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(w.workflowDir, "gcs", "test-bucket"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	w.StorageClient = sc
+
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatalf("error running workflow: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte("echo inc\n"))
+	want := map[string]interface{}{
+		"Vars":    map[string]interface{}{"size": "20"},
+		"Sources": map[string]interface{}{"inc.sh": hex.EncodeToString(sum[:])},
+	}
+	files, err := filepath.Glob(filepath.Join(w.workflowDir, "gcs", "test-bucket", w.outsPath, "*.provenance.json"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("want 1 provenance file, got %v, %v", files, err)
+	}
+	b, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("error reading provenance: %v", err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("error parsing provenance: %v", err)
+	}
+	for k, v := range want {
+		if diff := pretty.Compare(got[k], v); diff != "" {
+			t.Errorf("provenance %s does not match expectation: (-got +want)\n%s", k, diff)
+		}
+	}
+}
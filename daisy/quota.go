@@ -0,0 +1,255 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Region quota metrics checked before a workflow runs.
+const (
+	cpusQuota      = "CPUS"
+	instancesQuota = "INSTANCES"
+	diskGbQuota    = "DISKS_TOTAL_GB"
+	ssdGbQuota     = "SSD_TOTAL_GB"
+)
+
+// defaultImageSizeGb is the size counted for disks sized by an image whose
+// size isn't known before the workflow runs, such as an image it creates.
+const defaultImageSizeGb = 10
+
+// quotaNeed is an amount of a region quota used by a resource from the step
+// that creates it to the step that deletes it, or to the end of the run.
+type quotaNeed struct {
+	creator, deleter        *Step
+	project, region, metric string
+	amount                  float64
+}
+
+// zoneRegion returns the region of zone.
+func zoneRegion(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
+
+// diskQuota returns the quota metric of disks of type diskType.
+func diskQuota(diskType string) string {
+	if namedSubexp(diskTypeURLRgx, diskType)["disktype"] == "pd-ssd" {
+		return ssdGbQuota
+	}
+	return diskGbQuota
+}
+
+// quotaSteps returns the steps of w, its handlers and nested workflows that
+// are not skipped.
+func (w *Workflow) quotaSteps() []*Step {
+	var steps []*Step
+	groups := []map[string]*Step{w.Steps}
+	_, gs := w.stepGroups()
+	for _, g := range gs {
+		groups = append(groups, g.Steps)
+	}
+	for _, g := range groups {
+		for _, s := range g {
+			if s == nil || s.skipped {
+				continue
+			}
+			steps = append(steps, s)
+			if s.IncludeWorkflow != nil && s.IncludeWorkflow.Workflow != nil {
+				steps = append(steps, s.IncludeWorkflow.Workflow.quotaSteps()...)
+			}
+			if s.SubWorkflow != nil && s.SubWorkflow.Workflow != nil {
+				steps = append(steps, s.SubWorkflow.Workflow.quotaSteps()...)
+			}
+		}
+	}
+	return steps
+}
+
+// imageSizeGb returns the size of disks created from image, as used by a step
+// of w.
+func (w *Workflow) imageSizeGb(image string) float64 {
+	if r, ok := images[w].get(image); ok && r.creator != nil {
+		return defaultImageSizeGb
+	}
+	m := namedSubexp(imageURLRgx, image)
+	if m["project"] == "" {
+		return defaultImageSizeGb
+	}
+	var size int64
+	if m["family"] != "" {
+		if img, err := w.ComputeClient.GetImageFromFamily(m["project"], m["family"]); err == nil {
+			size = img.DiskSizeGb
+		}
+	} else if img, err := w.ComputeClient.GetImage(m["project"], m["image"]); err == nil {
+		size = img.DiskSizeGb
+	}
+	if size == 0 {
+		return defaultImageSizeGb
+	}
+	return float64(size)
+}
+
+// quotaNeeds returns the quota used by the resources w creates. Steps done
+// in a resumed run are left out, their resources are counted in the quota
+// usage. The CPUs of instances whose machine type can't be read are not
+// counted.
+func (w *Workflow) quotaNeeds() []quotaNeed {
+	var needs []quotaNeed
+	cpus := map[string]int64{}
+	deleter := func(r *resource, ok bool) *Step {
+		if !ok {
+			return nil
+		}
+		return r.deleter
+	}
+	for _, s := range w.quotaSteps() {
+		if s.w.stepDone(s.name) {
+			continue
+		}
+		if s.CreateDisks != nil {
+			for _, cd := range *s.CreateDisks {
+				size := float64(cd.Disk.SizeGb)
+				if size == 0 {
+					size = s.w.imageSizeGb(cd.SourceImage)
+				}
+				needs = append(needs, quotaNeed{s, deleter(disks[s.w].get(cd.daisyName)), cd.Project, zoneRegion(cd.Zone), diskQuota(cd.Type), size})
+			}
+		}
+		if s.CreateInstances == nil {
+			continue
+		}
+		for _, ci := range *s.CreateInstances {
+			region := zoneRegion(ci.Zone)
+			del := deleter(instances[s.w].get(ci.daisyName))
+			n, ok := cpus[ci.MachineType]
+			if !ok {
+				m := namedSubexp(machineTypeURLRegex, ci.MachineType)
+				n = -1
+				if mt, err := s.w.ComputeClient.GetMachineType(m["project"], m["zone"], m["machinetype"]); err != nil {
+					w.logger.Printf("Not checking the CPUS quota of machine type %q: %v", ci.MachineType, err)
+				} else {
+					n = mt.GuestCpus
+				}
+				cpus[ci.MachineType] = n
+			}
+			needs = append(needs, quotaNeed{s, del, ci.Project, region, instancesQuota, 1})
+			if n >= 0 {
+				needs = append(needs, quotaNeed{s, del, ci.Project, region, cpusQuota, float64(n)})
+			}
+			for _, ad := range ci.Disks {
+				p := ad.InitializeParams
+				if p == nil {
+					continue
+				}
+				size := float64(p.DiskSizeGb)
+				if size == 0 {
+					size = s.w.imageSizeGb(p.SourceImage)
+				}
+				dDel := deleter(disks[s.w].get(p.DiskName))
+				if dDel == nil && ad.AutoDelete {
+					dDel = del
+				}
+				needs = append(needs, quotaNeed{s, dDel, ci.Project, region, diskQuota(p.DiskType), size})
+			}
+		}
+	}
+	return needs
+}
+
+// peakQuota returns the most of each quota, by project and region, that
+// needs use at the same time. Steps that may run concurrently are assumed to
+// do so.
+func peakQuota(needs []quotaNeed) map[[2]string]map[string]float64 {
+	peak := map[[2]string]map[string]float64{}
+	seen := map[*Step]bool{}
+	for _, n := range needs {
+		// Use only goes up when a step creates resources.
+		at := n.creator
+		if seen[at] {
+			continue
+		}
+		seen[at] = true
+		use := map[[2]string]map[string]float64{}
+		for _, o := range needs {
+			if o.creator.nestedDepends(at) || (o.deleter != nil && at.nestedDepends(o.deleter)) {
+				continue
+			}
+			key := [2]string{o.project, o.region}
+			if use[key] == nil {
+				use[key] = map[string]float64{}
+			}
+			use[key][o.metric] += o.amount
+		}
+		for key, m := range use {
+			if peak[key] == nil {
+				peak[key] = map[string]float64{}
+			}
+			for metric, v := range m {
+				if v > peak[key][metric] {
+					peak[key][metric] = v
+				}
+			}
+		}
+	}
+	return peak
+}
+
+// validateQuotas checks that the region quotas of the projects w creates
+// resources in leave room for the most resources it uses at once. Regions
+// whose quotas can't be read are not checked.
+func (w *Workflow) validateQuotas() dErr {
+	if w.SkipQuotaCheck {
+		return nil
+	}
+	peak := peakQuota(w.quotaNeeds())
+	var keys [][2]string
+	for key := range peak {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || (keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1])
+	})
+
+	var short []string
+	for _, key := range keys {
+		r, err := w.ComputeClient.GetRegion(key[0], key[1])
+		if err != nil {
+			w.logger.Printf("Not checking quotas of region %q in project %q: %v", key[1], key[0], err)
+			continue
+		}
+		var metrics []string
+		for metric := range peak[key] {
+			metrics = append(metrics, metric)
+		}
+		sort.Strings(metrics)
+		for _, metric := range metrics {
+			for _, q := range r.Quotas {
+				if q.Metric != metric || peak[key][metric] <= q.Limit-q.Usage {
+					continue
+				}
+				short = append(short, fmt.Sprintf("* project %q region %q %s: workflow uses up to %g, %g available (limit %g, usage %g)", key[0], key[1], metric, peak[key][metric], q.Limit-q.Usage, q.Limit, q.Usage))
+			}
+		}
+	}
+	if short != nil {
+		return errf("not enough quota to run the workflow:\n%s", strings.Join(short, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestValidateQuotas(t *testing.T) {
+	steps := `
+    "disks": {"CreateDisks": [{"Name": "a", "SourceImage": "projects/p/global/images/family/f", "SizeGb": "100", "Type": "pd-ssd"}, {"Name": "b", "SizeGb": "20"}]},
+    "inst-a": {"CreateInstances": [{"Name": "a", "Disks": [{"Source": "a"}], "MachineType": "n1-standard-4"}]},
+    "delete-a": {"DeleteResources": {"Instances": ["a"]}},
+    "inst-b": {"CreateInstances": [{"Name": "b", "Disks": [{"Source": "b"}], "MachineType": "n1-standard-4"}]}`
+	tests := []struct {
+		desc, deps string
+		quotas     map[string][2]float64
+		want       string
+	}{
+		{
+			"enough quota",
+			`"inst-a": ["disks"], "delete-a": ["inst-a"], "inst-b": ["disks"]`,
+			map[string][2]float64{"CPUS": {24, 0}, "INSTANCES": {10, 0}, "SSD_TOTAL_GB": {500, 0}, "DISKS_TOTAL_GB": {500, 0}},
+			"",
+		},
+		{
+			"instances at the same time",
+			`"inst-a": ["disks"], "delete-a": ["inst-a"], "inst-b": ["disks"]`,
+			map[string][2]float64{"CPUS": {8, 2}, "INSTANCES": {10, 9}, "SSD_TOTAL_GB": {500, 450}},
+			"not enough quota to run the workflow:\n" +
+				`* project "fake-project" region "fake-region" CPUS: workflow uses up to 8, 6 available (limit 8, usage 2)` + "\n" +
+				`* project "fake-project" region "fake-region" INSTANCES: workflow uses up to 2, 1 available (limit 10, usage 9)` + "\n" +
+				`* project "fake-project" region "fake-region" SSD_TOTAL_GB: workflow uses up to 100, 50 available (limit 500, usage 450)`,
+		},
+		{
+			"instance deleted before the next",
+			`"inst-a": ["disks"], "delete-a": ["inst-a"], "inst-b": ["delete-a"]`,
+			map[string][2]float64{"CPUS": {8, 2}, "INSTANCES": {10, 9}},
+			"",
+		},
+	}
+	for _, tt := range tests {
+		w := fakeTestWorkflow(t, `{"Name": "quota", "Steps": {`+steps+`}, "Dependencies": {`+tt.deps+`}}`, nil)
+		defer os.RemoveAll(w.workflowDir)
+		w.Zone = "fake-region-a"
+		c := w.ComputeClient.(*daisyCompute.FakeClient)
+		c.AddProject("fake-project", "fake-region-a")
+		for metric, q := range tt.quotas {
+			c.SetQuota("fake-project", "fake-region", metric, q[0], q[1])
+		}
+
+		err := w.Validate(context.Background())
+		if tt.want == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		} else if tt.want != "" && (err == nil || !strings.HasSuffix(err.Error(), tt.want)) {
+			t.Errorf("%s: got error %v, want error ending with %q", tt.desc, err, tt.want)
+		}
+	}
+}
+
+// failingMachineTypes is a Client whose machine types can't be read.
+type failingMachineTypes struct {
+	daisyCompute.Client
+}
+
+func (c failingMachineTypes) GetMachineType(project, zone, machineType string) (*compute.MachineType, error) {
+	return nil, errors.New("machine type lookup failed")
+}
+
+func TestValidateQuotasSkipped(t *testing.T) {
+	w := fakeTestWorkflow(t, `{
+  "Name": "quota",
+  "Steps": {"inst": {"CreateInstances": [{"Name": "a", "Disks": [{"InitializeParams": {"SourceImage": "projects/p/global/images/family/f", "DiskSizeGb": "10"}}], "MachineType": "n1-standard-4"}]}}
+}`, nil)
+	defer os.RemoveAll(w.workflowDir)
+	w.Zone = "fake-region-a"
+	c := w.ComputeClient.(*daisyCompute.FakeClient)
+	c.AddProject("fake-project", "fake-region-a")
+	c.SetQuota("fake-project", "fake-region", "CPUS", 2, 0)
+
+	w.SkipQuotaCheck = true
+	if err := w.Validate(context.Background()); err != nil {
+		t.Fatalf("unexpected error with SkipQuotaCheck: %v", err)
+	}
+
+	// Instances whose machine type can't be read count no CPUs.
+	w.ComputeClient = failingMachineTypes{c}
+	var metrics []string
+	for _, n := range w.quotaNeeds() {
+		metrics = append(metrics, n.metric)
+	}
+	if diff := pretty.Compare(metrics, []string{instancesQuota, diskGbQuota}); diff != "" {
+		t.Errorf("quota metrics do not match expectation: (-got +want)\n%s", diff)
+	}
+}
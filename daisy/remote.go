@@ -0,0 +1,248 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	// remoteHTTPClient fetches the https:// workflow files and Sources.
+	remoteHTTPClient = &http.Client{Timeout: 10 * time.Minute}
+	// maxRemoteSize is the size, in bytes, of the largest remote workflow
+	// file or https:// Source read.
+	maxRemoteSize int64 = 256 << 20
+)
+
+// isRemote reports whether p is the gs:// or https:// URL of a file.
+func isRemote(p string) bool {
+	return strings.HasPrefix(p, "gs://") || strings.HasPrefix(p, "https://")
+}
+
+// joinPath returns p resolved against dir, a local directory or the URL of a
+// remote one. URLs, and absolute paths in a local directory, are returned as
+// is. A remote directory can't refer to local files: p must not be an
+// absolute path, nor go above the bucket or host of dir.
+func joinPath(dir, p string) (string, error) {
+	if isRemote(p) {
+		return p, nil
+	}
+	i := strings.Index(dir, "://")
+	if i < 0 || !isRemote(dir) {
+		if filepath.IsAbs(p) {
+			return p, nil
+		}
+		return filepath.Join(dir, p), nil
+	}
+	if filepath.IsAbs(p) || path.IsAbs(p) {
+		return "", fmt.Errorf("%q can't be used in %q: remote workflows can't use local files", p, dir)
+	}
+	root := strings.SplitN(dir[i+3:], "/", 2)[0]
+	joined := path.Join(dir[i+3:], p)
+	if joined != root && !strings.HasPrefix(joined, root+"/") {
+		return "", fmt.Errorf("%q can't be used in %q: it is outside of %s", p, dir, dir[:i+3]+root)
+	}
+	return dir[:i+3] + joined, nil
+}
+
+// cacheDir returns the directory remote workflow files are cached in.
+func (w *Workflow) cacheDir() string {
+	root := w.rootWorkflow()
+	if root.CacheDir != "" {
+		return root.CacheDir
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "daisy")
+	}
+	return filepath.Join(os.TempDir(), "daisy-cache")
+}
+
+// cachePath returns the path of the cached copy of the file at url. The file
+// keeps its name, so that its format is still known from its extension.
+func (w *Workflow) cachePath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(w.cacheDir(), hex.EncodeToString(sum[:]), path.Base(url))
+}
+
+func checkSHA256(url string, data []byte, want string) error {
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); !strings.EqualFold(got, want) {
+		return fmt.Errorf("SHA-256 of %q is %s, want %s", url, got, want)
+	}
+	return nil
+}
+
+// answerError is returned by fetch when the server of url was reached and
+// refused the file, e.g. because it was not found or access was denied.
+type answerError struct {
+	url string
+	err error
+}
+
+func (e *answerError) Error() string {
+	return fmt.Sprintf("error reading %q: %v", e.url, e.err)
+}
+
+// isAnswer reports whether err, returned by GCS, is the answer of the server
+// rather than a failure to reach it.
+func isAnswer(err error) bool {
+	if err == storage.ErrObjectNotExist || err == storage.ErrBucketNotExist {
+		return true
+	}
+	gErr, ok := err.(*googleapi.Error)
+	return ok && gErr.Code >= 400 && gErr.Code < 500
+}
+
+// fetch returns the content of the file at url. If the server refuses the
+// file, the error is an *answerError.
+func (w *Workflow) fetch(ctx context.Context, url string) ([]byte, error) {
+	if bkt, obj, err := splitGCSPath(url); err == nil {
+		r, err := w.StorageClient.NewReader(ctx, bkt, obj)
+		if err != nil {
+			if isAnswer(err) {
+				return nil, &answerError{url, err}
+			}
+			return nil, fmt.Errorf("error reading %q: %v", url, err)
+		}
+		defer r.Close()
+		return readRemoteBody(url, r)
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := remoteHTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return nil, &answerError{url, errors.New(resp.Status)}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching %q: %s", url, resp.Status)
+	}
+	return readRemoteBody(url, resp.Body)
+}
+
+// readRemoteBody reads r, the content of the file at url, which must not be
+// larger than maxRemoteSize.
+func readRemoteBody(url string, r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxRemoteSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading %q: %v", url, err)
+	}
+	if int64(len(data)) > maxRemoteSize {
+		return nil, fmt.Errorf("error reading %q: larger than %d bytes", url, maxRemoteSize)
+	}
+	return data, nil
+}
+
+// readRemote returns the content of the workflow file at url, which must have
+// SHA-256 sum if it is set. A pinned file is read from the cache once cached;
+// others are fetched, falling back to the cached copy if the server can't be
+// reached. A file the server refuses is never read from the cache.
+func (w *Workflow) readRemote(ctx context.Context, url, sum string) ([]byte, error) {
+	cached := w.cachePath(url)
+	if sum != "" {
+		if data, err := ioutil.ReadFile(cached); err == nil && checkSHA256(url, data, sum) == nil {
+			return data, nil
+		}
+	}
+	data, err := w.fetch(ctx, url)
+	if err != nil {
+		if _, ok := err.(*answerError); ok || sum != "" {
+			return nil, err
+		}
+		data, cErr := ioutil.ReadFile(cached)
+		if cErr != nil {
+			return nil, err
+		}
+		w.logger.Printf("Using the cached copy of %q, fetching it failed: %v", url, err)
+		return data, nil
+	}
+	if sum != "" {
+		if err := checkSHA256(url, data, sum); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeCache(cached, data); err != nil {
+		w.logger.Printf("Not caching %q: %v", url, err)
+	}
+	return data, nil
+}
+
+// writeCache writes data to file, replacing it at once so that concurrent
+// runs never read a partial file.
+func writeCache(file string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(file), ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), file)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// readChildWorkflow reads into cw the workflow file of an IncludeWorkflow or
+// SubWorkflow step of w. file is a local path or a gs:// or https:// URL,
+// relative paths are resolved against the location of w's file. If sum is
+// set, the file must have that SHA-256.
+func (w *Workflow) readChildWorkflow(ctx context.Context, cw *Workflow, file, sum string) error {
+	file, err := joinPath(w.workflowDir, file)
+	if err != nil {
+		return err
+	}
+	if !isRemote(file) {
+		if err := readWorkflow(file, cw); err != nil {
+			return err
+		}
+		if sum != "" && !strings.EqualFold(cw.fileSHA256, sum) {
+			return fmt.Errorf("SHA-256 of %q is %s, want %s", file, cw.fileSHA256, sum)
+		}
+		return nil
+	}
+	data, err := w.readRemote(ctx, file, sum)
+	if err != nil {
+		return err
+	}
+	return unmarshalWorkflow(file, file[:strings.LastIndex(file, "/")], data, cw)
+}
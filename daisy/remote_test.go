@@ -0,0 +1,226 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/storage"
+)
+
+func TestJoinPath(t *testing.T) {
+	tests := []struct {
+		dir, p, want string
+		shouldErr    bool
+	}{
+		{"/wfs", "inc.wf.json", "/wfs/inc.wf.json", false},
+		{"/wfs", "/other/inc.wf.json", "/other/inc.wf.json", false},
+		{"/wfs", "../other/inc.wf.json", "/other/inc.wf.json", false},
+		{"/wfs", "gs://bkt/inc.wf.json", "gs://bkt/inc.wf.json", false},
+		{"gs://bkt/wfs", "inc.wf.json", "gs://bkt/wfs/inc.wf.json", false},
+		{"gs://bkt/wfs", "../common/inc.wf.json", "gs://bkt/common/inc.wf.json", false},
+		{"https://example.com/wfs", "./inc.wf.json", "https://example.com/wfs/inc.wf.json", false},
+		{"gs://bkt/wfs", "https://example.com/inc.sh", "https://example.com/inc.sh", false},
+		{"gs://bkt/wfs", "/etc/passwd", "", true},
+		{"gs://bkt/wfs", "../../other/inc.sh", "", true},
+		{"https://example.com/wfs", "../../../etc/passwd", "", true},
+	}
+	for _, tt := range tests {
+		got, err := joinPath(tt.dir, tt.p)
+		if tt.shouldErr {
+			if err == nil {
+				t.Errorf("joinPath(%q, %q) = %q, should have errored", tt.dir, tt.p, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("joinPath(%q, %q) = %q, %v, want %q", tt.dir, tt.p, got, err, tt.want)
+		}
+	}
+}
+
+func TestRemoteWorkflows(t *testing.T) {
+	td, err := ioutil.TempDir("", "daisy-remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	sc, err := storage.NewLocalClient(filepath.Join(td, "gcs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bkt := range []string{"scratch", "wfs"} {
+		if err := os.MkdirAll(filepath.Join(td, "gcs", bkt), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(obj, data string) {
+		wc := sc.NewWriter(context.Background(), "wfs", obj, "")
+		wc.Write([]byte(data))
+		if err := wc.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("translate/inc.wf.json", `{"Sources": {"inc.sh": "./inc.sh"}, "Steps": {"inc": {"TestRecord": {"Message": "inc"}}}}`)
+	write("translate/inc.sh", "echo inc\n")
+
+	sub := `{"Sources": {"sub.sh": "scripts/sub.sh"}, "Steps": {"sub": {"TestRecord": {"Message": "sub"}}}}`
+	sum := sha256.Sum256([]byte(sub))
+	subSHA256 := hex.EncodeToString(sum[:])
+	files := map[string]string{"/wfs/sub.wf.json": sub, "/wfs/scripts/sub.sh": "echo sub\n"}
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(rw, r)
+			return
+		}
+		rw.Write([]byte(data))
+	}))
+	defer ts.Close()
+	defer func(c *http.Client) { remoteHTTPClient = c }(remoteHTTPClient)
+	remoteHTTPClient = ts.Client()
+
+	newWorkflow := func(subSHA256 string) *Workflow {
+		w := fakeTestWorkflow(t, `{
+  "Name": "remote",
+  "Steps": {
+    "inc": {"IncludeWorkflow": {"Path": "gs://wfs/translate/inc.wf.json"}},
+    "sub": {"SubWorkflow": {"Path": "`+ts.URL+`/wfs/sub.wf.json", "SHA256": "`+subSHA256+`"}}
+  }
+}`, nil)
+		w.GCSPath = "gs://scratch"
+		w.StorageClient = sc
+		w.CacheDir = filepath.Join(td, "cache")
+		return w
+	}
+
+	w := newWorkflow(subSHA256)
+	defer os.RemoveAll(w.workflowDir)
+	if err := w.populate(context.Background()); err != nil {
+		t.Fatalf("error populating workflow: %v", err)
+	}
+	if got, want := w.Sources["inc.sh"], "gs://wfs/translate/inc.sh"; got != want {
+		t.Errorf("included workflow source: got %q, want %q", got, want)
+	}
+	sw := w.Steps["sub"].SubWorkflow.Workflow
+	if err := w.uploadSources(context.Background()); err != nil {
+		t.Fatalf("error uploading sources: %v", err)
+	}
+	for _, obj := range []string{path.Join(w.sourcesPath, "inc.sh"), path.Join(sw.sourcesPath, "sub.sh")} {
+		r, err := sc.NewReader(context.Background(), "scratch", obj)
+		if err != nil {
+			t.Errorf("source %q not uploaded: %v", obj, err)
+			continue
+		}
+		r.Close()
+	}
+
+	w = newWorkflow(strings.Repeat("0", 64))
+	defer os.RemoveAll(w.workflowDir)
+	if err := w.populate(context.Background()); err == nil || !strings.Contains(err.Error(), "SHA-256 of") {
+		t.Errorf("got error %v, want SHA-256 mismatch", err)
+	}
+
+	// A file the server doesn't have is not read from the cache.
+	delete(files, "/wfs/sub.wf.json")
+	w = newWorkflow("")
+	defer os.RemoveAll(w.workflowDir)
+	if err := w.populate(context.Background()); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("got error %v, want not found", err)
+	}
+
+	// Later runs read the workflow files from the cache when the server
+	// can't be reached.
+	ts.Close()
+	w = newWorkflow("")
+	defer os.RemoveAll(w.workflowDir)
+	if err := w.populate(context.Background()); err != nil {
+		t.Errorf("error populating workflow from the cache: %v", err)
+	}
+
+	// Nor is an object missing from GCS.
+	if err := sc.DeleteObject(context.Background(), "wfs", "translate/inc.wf.json"); err != nil {
+		t.Fatal(err)
+	}
+	w = newWorkflow("")
+	defer os.RemoveAll(w.workflowDir)
+	if err := w.populate(context.Background()); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("got error %v, want not found", err)
+	}
+}
+
+func TestRemoteWorkflowLocalSources(t *testing.T) {
+	td, err := ioutil.TempDir("", "daisy-remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	sc, err := storage.NewLocalClient(filepath.Join(td, "gcs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(td, "gcs", "wfs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct{ desc, source string }{
+		{"absolute path", "/etc/passwd"},
+		{"escaping path", "../../etc/passwd"},
+	}
+	for _, tt := range tests {
+		wc := sc.NewWriter(context.Background(), "wfs", "inc.wf.json", "")
+		wc.Write([]byte(`{"Sources": {"s": "` + tt.source + `"}, "Steps": {"inc": {"TestRecord": {"Message": "inc"}}}}`))
+		if err := wc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		w := fakeTestWorkflow(t, `{"Name": "remote", "Steps": {"inc": {"IncludeWorkflow": {"Path": "gs://wfs/inc.wf.json"}}}}`, nil)
+		defer os.RemoveAll(w.workflowDir)
+		w.StorageClient = sc
+		w.CacheDir = filepath.Join(td, "cache")
+		if err := w.populate(context.Background()); err == nil || !strings.Contains(err.Error(), "can't be used in") {
+			t.Errorf("%s: got error %v, want the source rejected", tt.desc, err)
+		}
+	}
+}
+
+func TestFetchMaxSize(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("0123456789"))
+	}))
+	defer ts.Close()
+	defer func(c *http.Client) { remoteHTTPClient = c }(remoteHTTPClient)
+	remoteHTTPClient = ts.Client()
+	defer func(n int64) { maxRemoteSize = n }(maxRemoteSize)
+
+	w := testWorkflow()
+	maxRemoteSize = 10
+	if data, err := w.fetch(context.Background(), ts.URL+"/f"); err != nil || string(data) != "0123456789" {
+		t.Errorf("file of the maximum size: got %q, err: %v", data, err)
+	}
+	maxRemoteSize = 9
+	if _, err := w.fetch(context.Background(), ts.URL+"/f"); err == nil || !strings.Contains(err.Error(), "larger than 9 bytes") {
+		t.Errorf("got error %v, want the file rejected as too large", err)
+	}
+}
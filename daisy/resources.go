@@ -49,7 +49,7 @@ func (r *baseResourceRegistry) init() {
 func (r *baseResourceRegistry) cleanup() {
 	var wg sync.WaitGroup
 	for name, res := range r.m {
-		if res.noCleanup || res.deleted {
+		if res.noCleanup || res.deleted || r.w.keptForResume(res) {
 			continue
 		}
 		wg.Add(1)
@@ -77,6 +77,7 @@ func (r *baseResourceRegistry) delete(name string) dErr {
 		return err
 	}
 	res.deleted = true
+	r.w.emitResource(ResourceDeleted, r.typeName, res.real)
 	return nil
 }
 
@@ -117,10 +118,15 @@ func (r *baseResourceRegistry) registerCreation(name string, res *resource, s *S
 	r.mx.Lock()
 	defer r.mx.Unlock()
 	if res, ok := r.m[name]; ok {
+		if err := forEachCollision(r.typeName, name, res.creator, s); err != nil {
+			return err
+		}
 		return errf("cannot create %s %q; already created by step %q", r.typeName, name, res.creator.name)
 	}
 
-	if !overWrite {
+	// Resources recorded in the checkpoint being resumed from may still exist,
+	// they are reconciled when the run resumes.
+	if !overWrite && !r.checkpointed(name) {
 		if exists, err := resourceExists(r.w.ComputeClient, res.link); err != nil {
 			return errf("cannot create %s %q; resource lookup error: %v", r.typeName, name, err)
 		} else if exists {
@@ -163,10 +169,29 @@ func (r *baseResourceRegistry) registerDeletion(name string, s *Step) dErr {
 			return errf("deleting %s %q MUST transitively depend on step %q which references %q", r.typeName, name, u.name, name)
 		}
 	}
+	if err := r.checkCreatorRuns(name, res, s); err != nil {
+		return err
+	}
 	res.deleter = s
 	return nil
 }
 
+// checkCreatorRuns checks that the creator of res runs whenever s, a step
+// using or deleting it, runs: a creator whose condition references outputs
+// may be skipped, leaving nothing for s to use.
+func (r *baseResourceRegistry) checkCreatorRuns(name string, res *resource, s *Step) dErr {
+	if res.creator == nil {
+		return nil
+	}
+	if st := conditionalCreator(res.creator, s); st != nil {
+		if st.skipped {
+			return errf("cannot reference %s %q: step %q creating it was skipped", r.typeName, name, st.name)
+		}
+		return errf("cannot reference %s %q: step %q creating it may be skipped, its If %q is evaluated when it runs", r.typeName, name, st.name, st.If)
+	}
+	return nil
+}
+
 func (r *baseResourceRegistry) registerExisting(url string) (*resource, dErr) {
 	if !strings.HasPrefix(url, "projects/") {
 		return nil, errf("partial GCE resource URL %q needs leading \"projects/PROJECT/\"", url)
@@ -210,11 +235,40 @@ func (r *baseResourceRegistry) registerUsage(name string, s *Step) (*resource, d
 	if res.deleter != nil {
 		return nil, errf("using %s %q; step %q deletes %q and MUST transitively depend on this step", r.typeName, name, res.deleter.name, name)
 	}
+	if err := r.checkCreatorRuns(name, res, s); err != nil {
+		return nil, err
+	}
 
 	r.m[name].users = append(r.m[name].users, s)
 	return res, nil
 }
 
+// registerRuntimeUsage makes s a user of the resource name, the value of a
+// runtime resource field once its output references are resolved. URLs, like
+// the SelfLink outputs of steps, are made partial and extended with project,
+// the URLs of resources the workflow creates are replaced by their names.
+func (r *baseResourceRegistry) registerRuntimeUsage(name, project string, s *Step) (*resource, dErr) {
+	if name == "" {
+		return nil, errf("output references resolved to an empty %s", r.typeName)
+	}
+	if i := strings.Index(name, "/projects/"); strings.HasPrefix(name, "https://") && i >= 0 {
+		name = name[i+1:]
+	}
+	if r.urlRgx != nil && r.urlRgx.MatchString(name) {
+		name = extendPartialURL(name, project)
+	}
+	// Resources created by the workflow are used by name.
+	r.mx.Lock()
+	for n, res := range r.m {
+		if res.creator != nil && res.link == name {
+			name = n
+			break
+		}
+	}
+	r.mx.Unlock()
+	return r.registerUsage(name, s)
+}
+
 func initWorkflowResources(w *Workflow) {
 	initDiskRegistry(w)
 	initImageRegistry(w)
@@ -240,6 +294,9 @@ func shareWorkflowResources(giver, taker *Workflow) {
 
 func resourceCleanupHook(w *Workflow) func() dErr {
 	return func() dErr {
+		if w.keepingResources() {
+			return nil
+		}
 		images[w].cleanup()
 		instances[w].cleanup()
 		disks[w].cleanup()
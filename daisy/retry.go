@@ -0,0 +1,150 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"time"
+)
+
+const defaultRetryBackoff = "10s"
+
+// retryableErrors are the error types a Retry can retry on.
+var retryableErrors = []string{apiError, timeoutError, failureMatchError}
+
+// Retry is a step's retry policy.
+type Retry struct {
+	// Number of times to run the step, including the first attempt.
+	Attempts int
+	// Time to wait before the first retry, doubled for each following retry
+	// (default 10s). Must be parsable by https://golang.org/pkg/time/#ParseDuration.
+	Backoff string `json:",omitempty"`
+	backoff time.Duration
+	// Error types to retry on: APIError, Timeout and FailureMatch. All of
+	// them if empty.
+	On []string `json:",omitempty"`
+}
+
+func (r *Retry) populate() dErr {
+	if r.Backoff == "" {
+		r.Backoff = defaultRetryBackoff
+	}
+	var err error
+	if r.backoff, err = time.ParseDuration(r.Backoff); err != nil {
+		return newErr(err)
+	}
+	return nil
+}
+
+func (r *Retry) validate() dErr {
+	if r.Attempts < 1 {
+		return errf("Retry.Attempts must be at least 1, got %d", r.Attempts)
+	}
+	if r.backoff < 0 {
+		return errf("Retry.Backoff must not be negative, got %q", r.Backoff)
+	}
+	for _, on := range r.On {
+		if !strIn(on, retryableErrors) {
+			return errf("cannot retry on %q, Retry.On must be one of %v", on, retryableErrors)
+		}
+	}
+	return nil
+}
+
+// retries reports whether a step should run again after its attempt-th
+// attempt failed with an error of type errType.
+func (r *Retry) retries(errType string, attempt int) bool {
+	if r == nil || attempt >= r.Attempts {
+		return false
+	}
+	if len(r.On) == 0 {
+		return strIn(errType, retryableErrors)
+	}
+	return strIn(errType, r.On)
+}
+
+// wait returns the time to wait after the attempt-th attempt failed.
+func (r *Retry) wait(attempt int) time.Duration {
+	return r.backoff << uint(attempt-1)
+}
+
+// retryable reports whether s, or a step containing s, may run again after
+// failing.
+func (s *Step) retryable() bool {
+	for _, st := range s.getChain() {
+		if st.Retry != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// resetStep undoes a failed attempt of s so it can run again. Resources
+// created by s, or by steps nested in s, are deleted and the steps nested in
+// s are marked as not done.
+func resetStep(s *Step) dErr {
+	ws := []*Workflow{s.w}
+	var nested *Workflow
+	if s.IncludeWorkflow != nil {
+		nested = s.IncludeWorkflow.Workflow
+	} else if s.SubWorkflow != nil {
+		nested = s.SubWorkflow.Workflow
+	}
+	if nested != nil {
+		for _, wf := range nested.allWorkflows() {
+			wf.stepsDoneMx.Lock()
+			wf.stepsDone = nil
+			wf.stepsDoneMx.Unlock()
+			ws = append(ws, wf)
+		}
+	}
+
+	seen := map[*baseResourceRegistry]bool{}
+	for _, wf := range ws {
+		rs := wf.registries()
+		// Instances are deleted before the disks attached to them.
+		for i := len(rs) - 1; i >= 0; i-- {
+			r := rs[i]
+			if seen[r] {
+				continue
+			}
+			seen[r] = true
+
+			var created []*resource
+			r.mx.Lock()
+			for _, res := range r.m {
+				if res.creator != nil && (res.creator == s || stepContains(s, res.creator)) {
+					created = append(created, res)
+				}
+			}
+			r.mx.Unlock()
+
+			for _, res := range created {
+				res.mx.Lock()
+				if !res.deleted {
+					s.w.logger.Printf("Step %q: deleting %s %q before retrying.", s.name, r.typeName, res.real)
+					if err := r.deleteFn(res); err == nil {
+						r.w.emitResource(ResourceDeleted, r.typeName, res.real)
+					} else if err.Type() != resourceDNEError {
+						res.mx.Unlock()
+						return err
+					}
+				}
+				res.deleted = false
+				res.mx.Unlock()
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+)
+
+func TestRetryPopulateValidate(t *testing.T) {
+	tests := []struct {
+		desc        string
+		r           *Retry
+		wantBackoff time.Duration
+		shouldErr   bool
+	}{
+		{"defaults case", &Retry{Attempts: 2}, 10 * time.Second, false},
+		{"backoff case", &Retry{Attempts: 2, Backoff: "1m", On: []string{"Timeout", "APIError"}}, time.Minute, false},
+		{"bad backoff case", &Retry{Attempts: 2, Backoff: "1 minute"}, 0, true},
+		{"negative backoff case", &Retry{Attempts: 2, Backoff: "-1s"}, 0, true},
+		{"no attempts case", &Retry{}, 10 * time.Second, true},
+		{"bad error type case", &Retry{Attempts: 2, On: []string{"MultiError"}}, 10 * time.Second, true},
+	}
+
+	for _, tt := range tests {
+		err := tt.r.populate()
+		if err == nil {
+			err = tt.r.validate()
+		}
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if err == nil && tt.r.backoff != tt.wantBackoff {
+			t.Errorf("%s: backoff: got %s, want %s", tt.desc, tt.r.backoff, tt.wantBackoff)
+		}
+	}
+}
+
+func TestRetryRetries(t *testing.T) {
+	tests := []struct {
+		desc    string
+		r       *Retry
+		errType string
+		attempt int
+		want    bool
+	}{
+		{"no policy case", nil, apiError, 1, false},
+		{"default types case", &Retry{Attempts: 3}, failureMatchError, 2, true},
+		{"untyped case", &Retry{Attempts: 3}, untypedError, 1, false},
+		{"filtered case", &Retry{Attempts: 3, On: []string{timeoutError}}, apiError, 1, false},
+		{"matching case", &Retry{Attempts: 3, On: []string{timeoutError}}, timeoutError, 1, true},
+		{"out of attempts case", &Retry{Attempts: 3}, apiError, 3, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.r.retries(tt.errType, tt.attempt); got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestRunStepRetry(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+	s.timeout = time.Minute
+	s.Retry = &Retry{Attempts: 3, backoff: time.Nanosecond}
+
+	// Fails twice, then succeeds.
+	var runs int
+	s.testType = &mockStep{runImpl: func(ctx context.Context, s *Step) dErr {
+		runs++
+		if runs < 3 {
+			return typedErrf(apiError, "failure %d", runs)
+		}
+		return nil
+	}}
+	if err := w.runStep(ctx, s); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if runs != 3 {
+		t.Errorf("step ran %d times, want 3", runs)
+	}
+
+	// Out of attempts.
+	runs = 0
+	fail := typedErrf(apiError, "failure")
+	s.testType = &mockStep{runImpl: func(ctx context.Context, s *Step) dErr {
+		runs++
+		return fail
+	}}
+	want := s.wrapRunError(fail)
+	if err := w.runStep(ctx, s); err == nil || err.Error() != want.Error() {
+		t.Errorf("unexpected error: got %v, want %v", err, want)
+	}
+	if runs != 3 {
+		t.Errorf("step ran %d times, want 3", runs)
+	}
+
+	// Errors of other types aren't retried.
+	runs = 0
+	s.Retry.On = []string{failureMatchError}
+	if err := w.runStep(ctx, s); err == nil {
+		t.Error("should have returned an error")
+	}
+	if runs != 1 {
+		t.Errorf("step ran %d times, want 1", runs)
+	}
+
+	// Timeouts cancel the attempt, which stops before the step runs again.
+	runs = 0
+	var stopped bool
+	s.timeout = 10 * time.Millisecond
+	s.Retry.On = []string{timeoutError}
+	s.testType = &mockStep{runImpl: func(ctx context.Context, s *Step) dErr {
+		runs++
+		if runs == 1 {
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+			stopped = true
+		} else if !stopped {
+			t.Error("step ran again before the timed out attempt stopped")
+		}
+		return nil
+	}}
+	if err := w.runStep(ctx, s); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if runs != 2 {
+		t.Errorf("step ran %d times, want 2", runs)
+	}
+}
+
+func TestRunStepRetryReleasesSlot(t *testing.T) {
+	w := testWorkflow()
+	w.stepSlots = make(chan struct{}, 1)
+	s, _ := w.NewStep("s")
+	s.timeout = time.Minute
+	s.Retry = &Retry{Attempts: 2, backoff: time.Minute}
+	failed := make(chan struct{})
+	s.testType = &mockStep{runImpl: func(ctx context.Context, s *Step) dErr {
+		if len(w.stepSlots) != 1 {
+			t.Error("attempt ran without holding a slot")
+		}
+		close(failed)
+		return typedErrf(apiError, "failure")
+	}}
+	e := make(chan dErr, 1)
+	go func() { e <- w.runStep(context.Background(), s) }()
+
+	// Another step can take the slot while s waits to retry.
+	<-failed
+	select {
+	case w.stepSlots <- struct{}{}:
+		<-w.stepSlots
+	case <-time.After(5 * time.Second):
+		t.Error("step held its slot while waiting to retry")
+	}
+	close(w.Cancel)
+	if err := <-e; err == nil {
+		t.Error("should have returned an error")
+	}
+}
+
+func TestResetStep(t *testing.T) {
+	w := testWorkflow()
+	s1, _ := w.NewStep("s1")
+	s2, _ := w.NewStep("s2")
+
+	var deleted []string
+	c := w.ComputeClient.(*daisyCompute.TestClient)
+	c.DeleteDiskFn = func(_, _, name string) error {
+		deleted = append(deleted, name)
+		return nil
+	}
+	c.DeleteInstanceFn = func(_, _, name string) error {
+		deleted = append(deleted, name)
+		return nil
+	}
+	d1 := &resource{real: "d1", link: "projects/p/zones/z/disks/d1", creator: s1}
+	d2 := &resource{real: "d2", link: "projects/p/zones/z/disks/d2", creator: s1, deleted: true}
+	d3 := &resource{real: "d3", link: "projects/p/zones/z/disks/d3", creator: s2}
+	i1 := &resource{real: "i1", link: "projects/p/zones/z/instances/i1", creator: s1}
+	disks[w].m = map[string]*resource{"d1": d1, "d2": d2, "d3": d3}
+	instances[w].m = map[string]*resource{"i1": i1}
+
+	if err := resetStep(s1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The instance goes first, d2 was already deleted and d3 isn't s1's.
+	if len(deleted) != 2 || deleted[0] != "i1" || deleted[1] != "d1" {
+		t.Errorf("unexpected deletions: %v", deleted)
+	}
+	if d2.deleted {
+		t.Error("d2 should be marked as not deleted")
+	}
+}
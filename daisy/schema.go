@@ -0,0 +1,410 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonField is a field of a struct as encoding/json sees it.
+type jsonField struct {
+	name string
+	typ  reflect.Type
+	// The field is an integer encoded as a JSON string, `json:",string"`.
+	quoted bool
+}
+
+// jsonFields returns the fields of struct type t, including the fields of
+// embedded structs. Like encoding/json, fields of embedded structs are
+// shadowed by fields of the same name in the embedding struct.
+func jsonFields(t reflect.Type) []jsonField {
+	var fs []jsonField
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		if f.Anonymous && tag[0] == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded = append(embedded, ft)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		jf := jsonField{name: tag[0], typ: f.Type}
+		if jf.name == "" {
+			jf.name = f.Name
+		}
+		jf.quoted = strIn("string", tag[1:])
+		fs = append(fs, jf)
+	}
+
+	for _, et := range embedded {
+	Fields:
+		for _, ef := range jsonFields(et) {
+			for _, f := range fs {
+				if strings.EqualFold(f.name, ef.name) {
+					continue Fields
+				}
+			}
+			fs = append(fs, ef)
+		}
+	}
+	return fs
+}
+
+// jsonFieldType returns the type of the value JSON key k unmarshals into in
+// a value of type t. Like encoding/json, keys match field names case
+// insensitively.
+func jsonFieldType(t reflect.Type, k string) (reflect.Type, bool) {
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Elem(), true
+	case reflect.Struct:
+		for _, f := range jsonFields(t) {
+			if strings.EqualFold(f.name, k) {
+				return f.typ, true
+			}
+		}
+		if t == stepTyp {
+			if _, factory, ok := lookupStepType(k); ok {
+				return reflect.TypeOf(factory()), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// schemaViolation is a value of a decoded JSON document that its schema does
+// not allow.
+type schemaViolation struct {
+	path []string
+	// The value is a key that is not a property of its object.
+	unknown bool
+	msg     string
+}
+
+// jsonType returns the JSON Schema type of v, a decoded JSON value.
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	}
+	return "null"
+}
+
+// schemaProperty returns the schema of property k of props. With fold set,
+// names match case insensitively, as encoding/json matches field names.
+func schemaProperty(props map[string]interface{}, k string, fold bool) (map[string]interface{}, bool) {
+	if ps, ok := props[k]; ok {
+		return ps.(map[string]interface{}), true
+	}
+	if fold {
+		for n, ps := range props {
+			if strings.EqualFold(n, k) {
+				return ps.(map[string]interface{}), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// resolveSchema returns schema s, or the definition in defs it references.
+func resolveSchema(s, defs map[string]interface{}) map[string]interface{} {
+	if ref, ok := s["$ref"].(string); ok {
+		return defs[strings.TrimPrefix(ref, "#/definitions/")].(map[string]interface{})
+	}
+	return s
+}
+
+// validateSchema returns the violations of schema s, whose definitions are
+// defs, by v, a decoded JSON value at path. It supports the schemas Schema
+// generates. With fold set, property names match case insensitively.
+func validateSchema(v interface{}, s, defs map[string]interface{}, fold bool, path []string) []schemaViolation {
+	s = resolveSchema(s, defs)
+	vt := jsonType(v)
+	if vt == "null" {
+		// encoding/json leaves fields set to null unchanged.
+		return nil
+	}
+	if alts, ok := s["oneOf"].([]interface{}); ok {
+		var types []string
+		for _, a := range alts {
+			as := resolveSchema(a.(map[string]interface{}), defs)
+			at, _ := as["type"].(string)
+			if at == vt || (at == "number" && vt == "integer") {
+				return validateSchema(v, as, defs, fold, path)
+			}
+			types = append(types, at)
+		}
+		return []schemaViolation{{path: path, msg: fmt.Sprintf("got %s, want %s", vt, strings.Join(types, " or "))}}
+	}
+	st, ok := s["type"].(string)
+	if !ok {
+		return nil
+	}
+	if st != vt && !(st == "number" && vt == "integer") {
+		return []schemaViolation{{path: path, msg: fmt.Sprintf("got %s, want %s", vt, st)}}
+	}
+
+	var vs []schemaViolation
+	switch v := v.(type) {
+	case map[string]interface{}:
+		props, _ := s["properties"].(map[string]interface{})
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := append(append([]string{}, path...), k)
+			ps, ok := schemaProperty(props, k, fold)
+			if !ok {
+				switch ap := s["additionalProperties"].(type) {
+				case bool:
+					if !ap {
+						vs = append(vs, schemaViolation{path: p, unknown: true, msg: "unknown field"})
+					}
+					continue
+				case map[string]interface{}:
+					ps = ap
+				default:
+					continue
+				}
+			}
+			vs = append(vs, validateSchema(v[k], ps, defs, fold, p)...)
+		}
+	case []interface{}:
+		items, ok := s["items"].(map[string]interface{})
+		if !ok {
+			break
+		}
+		for i, e := range v {
+			p := append(append([]string{}, path...), strconv.Itoa(i))
+			vs = append(vs, validateSchema(e, items, defs, fold, p)...)
+		}
+	}
+	return vs
+}
+
+// checkFields returns an error listing the fields of the JSON workflow data
+// that do not exist, with their positions in file. src is the content of
+// file, which differs from data for YAML workflows.
+func checkFields(file string, src, data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	// Other violations of the schema are reported by encoding/json.
+	root, defs := workflowSchema()
+	var unknown [][]string
+	for _, sv := range validateSchema(v, root, defs, true, nil) {
+		if sv.unknown {
+			unknown = append(unknown, sv.path)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	type field struct {
+		path      []string
+		line, col int
+	}
+	fs := make([]field, len(unknown))
+	for i, p := range unknown {
+		fs[i].path = p
+		if isYAML(file) {
+			fs[i].line, fs[i].col = yamlKeyPosition(src, p)
+		} else {
+			fs[i].line, fs[i].col = jsonKeyPosition(src, p)
+		}
+	}
+	sort.Slice(fs, func(i, j int) bool {
+		return fs[i].line < fs[j].line || (fs[i].line == fs[j].line && fs[i].col < fs[j].col)
+	})
+
+	var msgs []string
+	for _, f := range fs {
+		name, parent := f.path[len(f.path)-1], strings.Join(f.path[:len(f.path)-1], ".")
+		msg := fmt.Sprintf("%s:%d:%d: unknown field %q", file, f.line, f.col, name)
+		if parent != "" {
+			msg += " in " + parent
+		}
+		msgs = append(msgs, msg)
+	}
+	return fmt.Errorf("%s", strings.Join(msgs, "\n"))
+}
+
+// jsonKeyPosition returns the line and column of the key at path in data, or
+// 0, 0 if there is no such key.
+func jsonKeyPosition(data []byte, path []string) (int, int) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	off, ok := findJSONKey(d, path)
+	if !ok {
+		return 0, 0
+	}
+	start := bytes.LastIndex(data[:off], []byte("\n")) + 1
+	return bytes.Count(data[:start], []byte("\n")) + 1, int(off) - start + 1
+}
+
+// findJSONKey reads the next value from d, returning the offset of the key
+// at path in it.
+func findJSONKey(d *json.Decoder, path []string) (int64, bool) {
+	tok, err := d.Token()
+	if err != nil {
+		return 0, false
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok || (delim != '{' && delim != '[') {
+		return 0, false
+	}
+	for i := 0; d.More(); i++ {
+		k := strconv.Itoa(i)
+		if delim == '{' {
+			if tok, err = d.Token(); err != nil {
+				return 0, false
+			}
+			k = tok.(string)
+			if len(path) == 1 && k == path[0] {
+				// The decoder is past the key's closing quote.
+				quoted, _ := json.Marshal(k)
+				return d.InputOffset() - int64(len(quoted)), true
+			}
+		}
+		if len(path) > 1 && k == path[0] {
+			return findJSONKey(d, path[1:])
+		}
+		var skip json.RawMessage
+		if err := d.Decode(&skip); err != nil {
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// workflowSchema returns the schema of workflow files and the definitions it
+// references. NewFromFile checks workflows against it.
+func workflowSchema() (map[string]interface{}, map[string]interface{}) {
+	defs := map[string]interface{}{}
+	return schemaOf(reflect.TypeOf(Workflow{}), defs), defs
+}
+
+// Schema returns a JSON Schema of workflow files. Fields are listed by the
+// names encoding/json gives them and, where it differs, in upper camel case,
+// the case used in the documentation; daisy itself matches them case
+// insensitively.
+func Schema() ([]byte, error) {
+	root, defs := workflowSchema()
+	s := map[string]interface{}{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"title":       "Daisy workflow",
+		"definitions": defs,
+	}
+	for k, v := range root {
+		s[k] = v
+	}
+	return json.MarshalIndent(s, "", "  ")
+}
+
+var wVarType = reflect.TypeOf(wVar{})
+
+// schemaOf returns the schema of type t, adding the schemas of the structs
+// it references to defs.
+func schemaOf(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json encodes []byte as a base64 string.
+			return map[string]interface{}{"type": "string"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), defs)}
+	case reflect.Struct:
+	default:
+		return map[string]interface{}{}
+	}
+
+	// Types of other packages are qualified by their package name.
+	name := t.Name()
+	if t.PkgPath() != reflect.TypeOf(Workflow{}).PkgPath() {
+		name = t.String()
+	}
+	ref := map[string]interface{}{"$ref": "#/definitions/" + name}
+	if t == wVarType {
+		// A wVar is a string or a struct.
+		ref = map[string]interface{}{"oneOf": []interface{}{map[string]interface{}{"type": "string"}, ref}}
+	}
+	if _, ok := defs[name]; ok {
+		return ref
+	}
+
+	props := map[string]interface{}{}
+	def := map[string]interface{}{"type": "object", "properties": props, "additionalProperties": false}
+	defs[name] = def
+	for _, f := range jsonFields(t) {
+		fs := schemaOf(f.typ, defs)
+		if f.quoted {
+			fs = map[string]interface{}{"type": "string"}
+		}
+		props[f.name] = fs
+		props[strings.ToUpper(f.name[:1])+f.name[1:]] = fs
+	}
+	if t == stepTyp {
+		for _, n := range registeredStepTypes() {
+			_, factory, _ := lookupStepType(n)
+			props[n] = schemaOf(reflect.TypeOf(factory()), defs)
+		}
+	}
+	return ref
+}
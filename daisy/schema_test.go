@@ -0,0 +1,161 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestSchema(t *testing.T) {
+	b, err := Schema()
+	if err != nil {
+		t.Fatalf("error generating schema: %v", err)
+	}
+	var s struct {
+		Ref         string `json:"$ref"`
+		Definitions map[string]struct {
+			Properties map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatalf("error unmarshalling schema: %v", err)
+	}
+
+	if s.Ref != "#/definitions/Workflow" {
+		t.Errorf("unexpected $ref: %q", s.Ref)
+	}
+	tests := []struct {
+		def, prop string
+		want      interface{}
+	}{
+		{"Workflow", "Name", map[string]interface{}{"type": "string"}},
+		{"Workflow", "Vars", map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{
+			"oneOf": []interface{}{map[string]interface{}{"type": "string"}, map[string]interface{}{"$ref": "#/definitions/wVar"}},
+		}}},
+		{"Step", "CreateDisks", map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/definitions/CreateDisk"}}},
+		// Fields of the embedded compute.Disk, SizeGb is shadowed by CreateDisk's.
+		{"CreateDisk", "SourceImage", map[string]interface{}{"type": "string"}},
+		// And by their API names.
+		{"CreateDisk", "sourceImage", map[string]interface{}{"type": "string"}},
+		{"CreateInstance", "machineType", map[string]interface{}{"type": "string"}},
+		{"CreateDisk", "SizeGb", map[string]interface{}{"type": "string"}},
+		{"SerialOutput", "SuccessMatch", map[string]interface{}{"type": "string"}},
+	}
+	for _, tt := range tests {
+		got := s.Definitions[tt.def].Properties[tt.prop]
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("%s.%s does not match expectation: (-got +want)\n%s", tt.def, tt.prop, diff)
+		}
+	}
+	if _, ok := s.Definitions["Step"].Properties["Name"]; ok {
+		t.Error("unexported fields should not be in the schema")
+	}
+}
+
+func TestSchemaWorkflows(t *testing.T) {
+	b, err := Schema()
+	if err != nil {
+		t.Fatalf("error generating schema: %v", err)
+	}
+	var s struct {
+		Definitions map[string]interface{}
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatalf("error unmarshalling schema: %v", err)
+	}
+	var root map[string]interface{}
+	if err := json.Unmarshal(b, &root); err != nil {
+		t.Fatalf("error unmarshalling schema: %v", err)
+	}
+
+	// Editors match property names exactly.
+	var n int
+	if err := filepath.Walk("../daisy_workflows", func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(p) != ".json" {
+			return err
+		}
+		n++
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			t.Errorf("%s: %v", p, err)
+			return nil
+		}
+		for _, sv := range validateSchema(v, root, s.Definitions, false, nil) {
+			t.Errorf("%s: %s: %s", p, strings.Join(sv.path, "."), sv.msg)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Error("no workflows found in daisy_workflows")
+	}
+}
+
+func TestNewFromFileUnknownFields(t *testing.T) {
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	tests := []struct{ file, data, error string }{
+		{
+			"test.wf.json",
+			`{
+  "Name": "foo",
+  "Vars": {"a": "b", "c": {"Value": "d", "Requried": true}},
+  "Steps": {
+    "wait": {
+      "WaitForInstancesSignal": [{"Name": "i", "SerialOutput": {"Port": 1, "SuccesMatch": "done"}}]
+    }
+  }
+}`,
+			"test.wf.json:3:42: unknown field \"Requried\" in Vars.c\n" +
+				"test.wf.json:6:76: unknown field \"SuccesMatch\" in Steps.wait.WaitForInstancesSignal.0.SerialOutput",
+		},
+		{
+			"test.wf.yaml",
+			"Name: foo\nProjct: bar\nSteps:\n  disks:\n    CreateDisks:\n      - Name: d\n        SizeGB: 10\n        SourceImg: i\n",
+			"test.wf.yaml:2:1: unknown field \"Projct\"\n" +
+				"test.wf.yaml:8:9: unknown field \"SourceImg\" in Steps.disks.CreateDisks.0",
+		},
+	}
+
+	for _, tt := range tests {
+		tf := filepath.Join(td, tt.file)
+		if err := ioutil.WriteFile(tf, []byte(tt.data), 0600); err != nil {
+			t.Fatalf("error writing workflow: %v", err)
+		}
+		want := strings.Replace(tt.error, tt.file, tf, -1)
+
+		if _, err := NewFromFile(tf); err == nil {
+			t.Errorf("%s: expected error, got nil", tt.file)
+		} else if err.Error() != want {
+			t.Errorf("%s: did not get expected error from NewFromFile():\ngot: %q\nwant: %q", tt.file, err.Error(), want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+// secretMask replaces the values of secret vars in logs, printed workflows
+// and errors.
+const secretMask = "*****"
+
+// AddSecretVar adds a secret variable to the Workflow. Its value is
+// substituted like any other var, but masked in the workflow's logs.
+func (w *Workflow) AddSecretVar(k, v string) {
+	w.AddVar(k, v)
+	wv := w.Vars[k]
+	wv.Secret = true
+	w.Vars[k] = wv
+}
+
+// addSecret masks v in the logs of the top level workflow and of all the
+// workflows it runs.
+func (w *Workflow) addSecret(v string) {
+	if v == "" {
+		return
+	}
+	root := w.rootWorkflow()
+	root.secretsMx.Lock()
+	defer root.secretsMx.Unlock()
+	if strIn(v, root.secrets) {
+		return
+	}
+	root.secrets = append(root.secrets, v)
+	// Longer secrets first, so a secret containing another is masked whole.
+	sort.Slice(root.secrets, func(i, j int) bool { return len(root.secrets[i]) > len(root.secrets[j]) })
+	var oldnew []string
+	for _, s := range root.secrets {
+		oldnew = append(oldnew, s, secretMask)
+		// Secrets are also masked in JSON, as printed by Print.
+		if b, _ := json.Marshal(s); string(b[1:len(b)-1]) != s {
+			oldnew = append(oldnew, string(b[1:len(b)-1]), secretMask)
+		}
+	}
+	root.redactor = strings.NewReplacer(oldnew...)
+}
+
+// addSecretVars masks the values of the workflow's secret vars.
+func (w *Workflow) addSecretVars() {
+	for _, v := range w.Vars {
+		if v.Secret {
+			w.addSecret(v.Value)
+		}
+	}
+}
+
+// redact returns s with the values of secret vars masked.
+func (w *Workflow) redact(s string) string {
+	root := w.rootWorkflow()
+	root.secretsMx.Lock()
+	r := root.redactor
+	root.secretsMx.Unlock()
+	if r == nil {
+		return s
+	}
+	return r.Replace(s)
+}
+
+// redactErr returns err with the values of secret vars masked, keeping its
+// type.
+func (w *Workflow) redactErr(err error) error {
+	if err == nil || w.redact(err.Error()) == err.Error() {
+		return err
+	}
+	dE, ok := err.(*dErrImpl)
+	if !ok {
+		return errors.New(w.redact(err.Error()))
+	}
+	r := &dErrImpl{errType: dE.errType}
+	for _, e := range dE.errs {
+		r.errs = append(r.errs, errors.New(w.redact(e.Error())))
+	}
+	return r
+}
+
+// redactWriter masks the values of secret vars in what is written to it.
+type redactWriter struct {
+	w   *Workflow
+	out io.Writer
+}
+
+func (r *redactWriter) Write(b []byte) (int, error) {
+	if _, err := io.WriteString(r.out, r.w.redact(string(b))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,127 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestSecretVars(t *testing.T) {
+	w := testWorkflow()
+	var buf bytes.Buffer
+	w.logger = nil
+	w.gcsLogWriter = &syncedWriter{buf: bufio.NewWriter(&buf)}
+	w.Vars = map[string]wVar{"license": {Value: "declared", Secret: true}}
+	w.AddVar("license", "s3cr3t")
+	w.AddSecretVar("password", `pa"ss`)
+	w.AddVar("plain", "visible")
+	s, _ := w.NewStep("s")
+	s.CreateDisks = &CreateDisks{{Disk: compute.Disk{Name: "d", Description: "${license} ${plain}"}}}
+
+	if err := w.populate(context.Background()); err != nil {
+		t.Fatalf("error populating workflow: %v", err)
+	}
+	if got, want := (*s.CreateDisks)[0].Description, "s3cr3t visible"; got != want {
+		t.Errorf("secret var was not substituted, got %q, want %q", got, want)
+	}
+
+	w.logger.Printf("license %s, password %s", w.Vars["license"].Value, w.Vars["password"].Value)
+	w.gcsLogWriter.Flush()
+	b, _ := json.Marshal(w.Vars)
+
+	for desc, got := range map[string]string{
+		"log":    buf.String(),
+		"String": w.String(),
+		"JSON":   w.redact(string(b)),
+		"error":  w.redactErr(errf("bad license s3cr3t")).Error(),
+	} {
+		if strings.Contains(got, "s3cr3t") || strings.Contains(got, `pa"ss`) || strings.Contains(got, `pa\"ss`) {
+			t.Errorf("%s: secret not masked: %q", desc, got)
+		}
+	}
+	if got := w.String(); !strings.Contains(got, "visible") {
+		t.Errorf("plain var should not be masked: %q", got)
+	}
+	if got, want := buf.String(), "license *****, password *****\n"; !strings.HasSuffix(got, want) {
+		t.Errorf("log does not match expectation, got %q, want suffix %q", got, want)
+	}
+}
+
+func TestRedactErr(t *testing.T) {
+	w := testWorkflow()
+	w.addSecret("s3cr3t")
+	w.addSecret("s3cr3t-long")
+
+	tests := []struct {
+		err, want error
+	}{
+		{nil, nil},
+		{errors.New("no secret"), errors.New("no secret")},
+		{errors.New("key s3cr3t-long"), errors.New("key *****")},
+		{typedErrf(apiError, "key s3cr3t"), typedErrf(apiError, "key *****")},
+	}
+	for _, tt := range tests {
+		got := w.redactErr(tt.err)
+		if (got == nil) != (tt.want == nil) || (got != nil && got.Error() != tt.want.Error()) {
+			t.Errorf("redactErr(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+		if dE, ok := got.(dErr); ok && dE.Type() != apiError {
+			t.Errorf("redactErr(%v) lost the error type: %q", tt.err, dE.Type())
+		}
+	}
+}
+
+func TestSecretVarsSubWorkflow(t *testing.T) {
+	w := testWorkflow()
+	sw := w.NewSubWorkflow()
+	sw.Vars = map[string]wVar{"key": {Secret: true}}
+	sw.addSecretVars()
+	sw.AddVar("key", "s3cr3t")
+	sw.addSecretVars()
+
+	if got := w.redact("key s3cr3t"); got != "key *****" {
+		t.Errorf("secret of a sub workflow should be masked by the parent, got %q", got)
+	}
+}
+
+func TestSecretVarsDerived(t *testing.T) {
+	os.Setenv("DAISY_TEST_TOKEN", "t0ken")
+	defer os.Unsetenv("DAISY_TEST_TOKEN")
+	w := testWorkflow()
+	w.AllowEnv = true
+	w.AddSecretVar("password", "xs3cr3t")
+	w.AddSecretVar("token", "${env.DAISY_TEST_TOKEN}")
+	w.AddVar("hash", "${sha(password)}")
+	s, _ := w.NewStep("s")
+	s.CreateDisks = &CreateDisks{{Disk: compute.Disk{Name: "d", Description: `${upper(password)} ${trimprefix(password, "x")} ${sha(hash)} ${upper(token)} ${lower(plain)}`}}}
+	w.AddVar("plain", "VISIBLE")
+
+	if err := w.populate(context.Background()); err != nil {
+		t.Fatalf("error populating workflow: %v", err)
+	}
+	desc := (*s.CreateDisks)[0].Description
+	if got, want := w.redact(desc+" "+w.Vars["hash"].Value), "***** ***** ***** ***** visible *****"; got != want {
+		t.Errorf("derived secrets not masked: got %q, want %q", got, want)
+	}
+}
@@ -16,6 +16,8 @@ package daisy
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
 	"net/http"
 	"os"
@@ -23,30 +25,58 @@ import (
 	"path/filepath"
 	"strings"
 
-	"cloud.google.com/go/storage"
 	"google.golang.org/api/googleapi"
-	"google.golang.org/api/iterator"
 )
 
 func (w *Workflow) recursiveGCS(ctx context.Context, bkt, prefix, dst string) dErr {
-	it := w.StorageClient.Bucket(bkt).Objects(ctx, &storage.Query{Prefix: prefix})
-	for objAttr, err := it.Next(); err != iterator.Done; objAttr, err = it.Next() {
-		if err != nil {
-			return typedErr(apiError, err)
-		}
+	objs, err := w.StorageClient.ListObjects(ctx, bkt, prefix)
+	if err != nil {
+		return typedErr(apiError, err)
+	}
+	for _, objAttr := range objs {
 		if objAttr.Size == 0 {
 			continue
 		}
-		srcPath := w.StorageClient.Bucket(bkt).Object(objAttr.Name)
-		o := path.Join(w.sourcesPath, dst, strings.TrimPrefix(objAttr.Name, prefix))
-		dstPath := w.StorageClient.Bucket(w.bucket).Object(o)
-		if _, err := dstPath.CopierFrom(srcPath).Run(ctx); err != nil {
+		obj := path.Join(dst, strings.TrimPrefix(objAttr.Name, prefix))
+		if err := w.StorageClient.CopyObject(ctx, bkt, objAttr.Name, w.bucket, path.Join(w.sourcesPath, obj)); err != nil {
 			return typedErr(apiError, err)
 		}
+		if err := w.hashCopiedSource(ctx, obj); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// recordSourceHash records sum, the SHA-256 of obj, a Source uploaded to
+// sourcesPath, for Provenance.
+func (w *Workflow) recordSourceHash(obj string, sum []byte) {
+	if w.sourceHashes == nil {
+		w.sourceHashes = map[string]string{}
+	}
+	w.sourceHashes[obj] = hex.EncodeToString(sum)
+}
+
+// hashCopiedSource records the SHA-256 of obj, a Source copied to sourcesPath
+// from GCS, if w records the provenance of images: unlike the other Sources,
+// copied ones are not read as they are uploaded.
+func (w *Workflow) hashCopiedSource(ctx context.Context, obj string) dErr {
+	if !w.wantsProvenance() {
+		return nil
+	}
+	r, err := w.StorageClient.NewReader(ctx, w.bucket, path.Join(w.sourcesPath, obj))
+	if err != nil {
+		return typedErr(apiError, err)
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return typedErr(apiError, err)
+	}
+	w.recordSourceHash(obj, h.Sum(nil))
+	return nil
+}
+
 func (w *Workflow) sourceExists(s string) bool {
 	_, ok := w.Sources[s]
 	return ok
@@ -54,16 +84,40 @@ func (w *Workflow) sourceExists(s string) bool {
 
 func (w *Workflow) uploadFile(ctx context.Context, src, obj string) dErr {
 	obj = filepath.ToSlash(obj)
-	dstPath := w.StorageClient.Bucket(w.bucket).Object(path.Join(w.sourcesPath, obj))
-	gcs := dstPath.NewWriter(ctx)
+	gcs := w.StorageClient.NewWriter(ctx, w.bucket, path.Join(w.sourcesPath, obj), "")
 	f, err := os.Open(src)
 	if err != nil {
 		return newErr(err)
 	}
-	if _, err := io.Copy(gcs, f); err != nil {
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(gcs, h), f); err != nil {
+		return newErr(err)
+	}
+	if err := gcs.Close(); err != nil {
 		return newErr(err)
 	}
-	return newErr(gcs.Close())
+	w.recordSourceHash(obj, h.Sum(nil))
+	return nil
+}
+
+func (w *Workflow) uploadURL(ctx context.Context, url, obj string) dErr {
+	if strings.HasSuffix(url, "/") {
+		return errf("can't copy %s: directories can't be copied over HTTPS", url)
+	}
+	data, err := w.fetch(ctx, url)
+	if err != nil {
+		return newErr(err)
+	}
+	gcs := w.StorageClient.NewWriter(ctx, w.bucket, path.Join(w.sourcesPath, obj), "")
+	if _, err := gcs.Write(data); err != nil {
+		return newErr(err)
+	}
+	if err := gcs.Close(); err != nil {
+		return newErr(err)
+	}
+	sum := sha256.Sum256(data)
+	w.recordSourceHash(obj, sum[:])
+	return nil
 }
 
 func (w *Workflow) uploadSources(ctx context.Context) dErr {
@@ -71,6 +125,15 @@ func (w *Workflow) uploadSources(ctx context.Context) dErr {
 		if origPath == "" {
 			continue
 		}
+		// Sources of remote workflows are relative to their URL.
+		if _, _, err := splitGCSPath(origPath); err != nil {
+			p, err := joinPath(w.workflowDir, origPath)
+			if err != nil {
+				return errf("source %q: %v", dst, err)
+			}
+			origPath = p
+		}
+
 		// GCS to GCS.
 		if bkt, objPath, err := splitGCSPath(origPath); err == nil {
 			if objPath == "" || strings.HasSuffix(objPath, "/") {
@@ -79,22 +142,27 @@ func (w *Workflow) uploadSources(ctx context.Context) dErr {
 				}
 				continue
 			}
-			src := w.StorageClient.Bucket(bkt).Object(objPath)
-			dstPath := w.StorageClient.Bucket(w.bucket).Object(path.Join(w.sourcesPath, dst))
-			if _, err := dstPath.CopierFrom(src).Run(ctx); err != nil {
+			if err := w.StorageClient.CopyObject(ctx, bkt, objPath, w.bucket, path.Join(w.sourcesPath, dst)); err != nil {
 				if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
 					return typedErrf(resourceDNEError, "error copying from file %s: %v", origPath, err)
 				}
 				return errf("error copying from file %s: %v", origPath, err)
 			}
+			if err := w.hashCopiedSource(ctx, dst); err != nil {
+				return err
+			}
+			continue
+		}
 
+		// HTTPS to GCS.
+		if strings.HasPrefix(origPath, "https://") {
+			if err := w.uploadURL(ctx, origPath, dst); err != nil {
+				return err
+			}
 			continue
 		}
 
 		// Local to GCS.
-		if !filepath.IsAbs(origPath) {
-			origPath = filepath.Join(w.workflowDir, origPath)
-		}
 		fi, err := os.Stat(origPath)
 		if err != nil {
 			return typedErr(fileIOError, err)
@@ -126,7 +194,7 @@ func (w *Workflow) uploadSources(ctx context.Context) dErr {
 		}
 	}
 	for _, step := range w.Steps {
-		if step.SubWorkflow != nil {
+		if step.SubWorkflow != nil && !step.skipped {
 			if err := step.SubWorkflow.Workflow.uploadSources(ctx); err != nil {
 				return err
 			}
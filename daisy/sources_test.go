@@ -16,12 +16,17 @@ package daisy
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"io/ioutil"
 	"log"
+	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/storage"
 )
 
 func TestUploadSources(t *testing.T) {
@@ -113,3 +118,72 @@ func TestUploadSources(t *testing.T) {
 		}
 	}
 }
+
+func TestUploadSourcesLocalStorage(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	os.MkdirAll(filepath.Join(src, "folder"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "file"), []byte("file"), 0600)
+	ioutil.WriteFile(filepath.Join(src, "folder", "object"), []byte("object"), 0600)
+
+	sc, err := storage.NewLocalClient(filepath.Join(dir, "gcs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.CreateBucket(ctx, "", "bucket")
+	sc.CreateBucket(ctx, "", "gcs")
+	for _, obj := range []string{"folder/object", "file"} {
+		wc := sc.NewWriter(ctx, "gcs", obj, "")
+		wc.Write([]byte(obj))
+		wc.Close()
+	}
+
+	w := testWorkflow()
+	w.GCSPath = "gs://bucket"
+	w.StorageClient = sc
+	if err := w.populate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	w.Sources = map[string]string{
+		"local":     filepath.Join(src, "file"),
+		"localdir":  filepath.Join(src, "folder"),
+		"gcs":       "gs://gcs/file",
+		"gcsfolder": "gs://gcs/folder/",
+	}
+	// Sources are hashed as they are uploaded when an image records its
+	// provenance.
+	w.Steps = map[string]*Step{"image": {CreateImages: &CreateImages{{Provenance: true}}}}
+	if err := w.uploadSources(ctx); err != nil {
+		t.Fatalf("error uploading sources: %v", err)
+	}
+
+	want := map[string]string{
+		"local":            "file",
+		"localdir/object":  "object",
+		"gcs":              "file",
+		"gcsfolder/object": "folder/object",
+	}
+	for obj, content := range want {
+		b, err := ioutil.ReadFile(filepath.Join(dir, "gcs", "bucket", filepath.FromSlash(w.sourcesPath), filepath.FromSlash(obj)))
+		if err != nil {
+			t.Errorf("source %q not uploaded: %v", obj, err)
+		} else if string(b) != content {
+			t.Errorf("source %q: got %q, want %q", obj, b, content)
+		}
+		h := sha256.Sum256([]byte(content))
+		if got, want := w.sourceHashes[obj], hex.EncodeToString(h[:]); got != want {
+			t.Errorf("source %q: got SHA-256 %q, want %q", obj, got, want)
+		}
+	}
+
+	w.Sources = map[string]string{"gcs": "gs://gcs/dne"}
+	if err := w.uploadSources(ctx); err == nil || err.Type() != resourceDNEError {
+		t.Errorf("want resourceDNEError for missing GCS source, got: %v", err)
+	}
+}
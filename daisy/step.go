@@ -36,11 +36,23 @@ type stepImpl interface {
 type Step struct {
 	name string
 	w    *Workflow
+	// The handler step group of the step, nil for steps of Steps.
+	group *StepGroup
+	// The ForEach step the step is an expansion of, if any.
+	forEachOf string
 
 	// Time to wait for this step to complete (default 10m).
 	// Must be parsable by https://golang.org/pkg/time/#ParseDuration.
 	Timeout string
 	timeout time.Duration
+	// Condition to run this step on, evaluated after var substitution, or
+	// when the step runs if it references step outputs. Steps whose condition
+	// is false are skipped, steps depending on them still run.
+	If      string `json:",omitempty"`
+	cond    []condToken
+	skipped bool
+	// Policy to run this step again if it fails.
+	Retry *Retry `json:",omitempty"`
 	// Only one of the below fields should exist for each instance of Step.
 	CreateDisks            *CreateDisks            `json:",omitempty"`
 	CreateImages           *CreateImages           `json:",omitempty"`
@@ -50,6 +62,8 @@ type Step struct {
 	IncludeWorkflow        *IncludeWorkflow        `json:",omitempty"`
 	SubWorkflow            *SubWorkflow            `json:",omitempty"`
 	WaitForInstancesSignal *WaitForInstancesSignal `json:",omitempty"`
+	// Step types registered with RegisterStepType, by name.
+	Custom map[string]StepType `json:"-"`
 	// Used for unit tests.
 	testType stepImpl
 }
@@ -89,6 +103,10 @@ func (s *Step) stepImpl() (stepImpl, dErr) {
 		matchCount++
 		result = s.WaitForInstancesSignal
 	}
+	for _, st := range s.Custom {
+		matchCount++
+		result = customStep{st}
+	}
 	if s.testType != nil {
 		matchCount++
 		result = s.testType
@@ -107,8 +125,16 @@ func (s *Step) depends(other *Step) bool {
 	if s == nil || other == nil || s.w == nil || s.w != other.w {
 		return false
 	}
+	// Handler steps run after the steps of the groups before theirs.
+	if s.group != other.group {
+		return s.w.groupOrder(s.group) > s.w.groupOrder(other.group)
+	}
 	deps := s.w.Dependencies
 	steps := s.w.Steps
+	if s.group != nil {
+		deps = s.group.Dependencies
+		steps = s.group.Steps
+	}
 	q := deps[s.name]
 	seen := map[string]bool{}
 
@@ -166,12 +192,19 @@ func (s *Step) getChain() []*Step {
 	if s.w.parent == nil {
 		return []*Step{s}
 	}
-	for _, st := range s.w.parent.Steps {
-		if st.IncludeWorkflow != nil && st.IncludeWorkflow.Workflow == s.w {
-			return append(st.getChain(), s)
-		}
-		if st.SubWorkflow != nil && st.SubWorkflow.Workflow == s.w {
-			return append(st.getChain(), s)
+	parentSteps := []map[string]*Step{s.w.parent.Steps}
+	_, groups := s.w.parent.stepGroups()
+	for _, g := range groups {
+		parentSteps = append(parentSteps, g.Steps)
+	}
+	for _, steps := range parentSteps {
+		for _, st := range steps {
+			if st.IncludeWorkflow != nil && st.IncludeWorkflow.Workflow == s.w {
+				return append(st.getChain(), s)
+			}
+			if st.SubWorkflow != nil && st.SubWorkflow.Workflow == s.w {
+				return append(st.getChain(), s)
+			}
 		}
 	}
 	// We shouldn't get here.
@@ -179,9 +212,16 @@ func (s *Step) getChain() []*Step {
 }
 
 func (s *Step) run(ctx context.Context) dErr {
+	if s.skipped {
+		s.w.logger.Printf("Skipping step %q, condition %q is false.", s.name, s.If)
+		return nil
+	}
+	if err := s.resolveOutputs(); err != nil {
+		return err
+	}
 	impl, err := s.stepImpl()
 	if err != nil {
-		return s.wrapRunError(err)
+		return err
 	}
 	var st string
 	if t := reflect.TypeOf(impl); t.Kind() == reflect.Ptr {
@@ -190,8 +230,9 @@ func (s *Step) run(ctx context.Context) dErr {
 		st = t.Name()
 	}
 	s.w.logger.Printf("Running step %q (%s)", s.name, st)
+	// Errors are wrapped by the caller, which checks their type first.
 	if err = impl.run(ctx, s); err != nil {
-		return s.wrapRunError(err)
+		return err
 	}
 	select {
 	case <-s.w.Cancel:
@@ -206,6 +247,19 @@ func (s *Step) validate(ctx context.Context) dErr {
 	if !rfc1035Rgx.MatchString(strings.ToLower(s.name)) {
 		return s.wrapValidateError(errf("step name must start with a letter and only contain letters, numbers, and hyphens"))
 	}
+	if s.Retry != nil {
+		if err := s.Retry.validate(); err != nil {
+			return s.wrapValidateError(err)
+		}
+	}
+	// Skipped steps don't register the resources they would create, use or
+	// delete, steps referencing these resources fail validation.
+	if s.skipped {
+		return nil
+	}
+	if err := s.validateOutputRefs(); err != nil {
+		return s.wrapValidateError(err)
+	}
 	impl, err := s.stepImpl()
 	if err != nil {
 		return s.wrapValidateError(err)
@@ -22,7 +22,6 @@ import (
 	"sync"
 
 	"cloud.google.com/go/storage"
-	"google.golang.org/api/iterator"
 )
 
 // CopyGCSObjects is a Daisy CopyGCSObject workflow step.
@@ -57,7 +56,7 @@ func (c *CopyGCSObjects) validate(ctx context.Context, s *Step) dErr {
 		// Check if source bucket exists and is readable.
 		readableBkts.mx.Lock()
 		if !strIn(sBkt, readableBkts.bkts) {
-			if _, err := s.w.StorageClient.Bucket(sBkt).Attrs(ctx); err != nil {
+			if _, err := s.w.StorageClient.GetBucket(ctx, sBkt); err != nil {
 				return errf("error reading bucket %q: %v", sBkt, err)
 			}
 			readableBkts.bkts = append(readableBkts.bkts, sBkt)
@@ -67,21 +66,21 @@ func (c *CopyGCSObjects) validate(ctx context.Context, s *Step) dErr {
 		// Check if destination bucket exists and is readable.
 		writableBkts.mx.Lock()
 		if !strIn(dBkt, writableBkts.bkts) {
-			if _, err := s.w.StorageClient.Bucket(dBkt).Attrs(ctx); err != nil {
+			if _, err := s.w.StorageClient.GetBucket(ctx, dBkt); err != nil {
 				return errf("error reading bucket %q: %v", dBkt, err)
 			}
 
 			// Check if destination bucket is writable.
-			tObj := s.w.StorageClient.Bucket(dBkt).Object(fmt.Sprintf("daisy-validate-%s-%s", s.name, s.w.id))
-			w := tObj.NewWriter(ctx)
+			tObj := fmt.Sprintf("daisy-validate-%s-%s", s.name, s.w.id)
+			w := s.w.StorageClient.NewWriter(ctx, dBkt, tObj, "")
 			if _, err := w.Write(nil); err != nil {
 				return newErr(err)
 			}
 			if err := w.Close(); err != nil {
 				return errf("error writing to bucket %q: %v", dBkt, err)
 			}
-			if err := tObj.Delete(ctx); err != nil {
-				return errf("error deleting file %+v after write validation: %v", tObj, err)
+			if err := s.w.StorageClient.DeleteObject(ctx, dBkt, tObj); err != nil {
+				return errf("error deleting file %q after write validation: %v", tObj, err)
 			}
 			writableBkts.bkts = append(writableBkts.bkts, dBkt)
 		}
@@ -98,17 +97,17 @@ func (c *CopyGCSObjects) validate(ctx context.Context, s *Step) dErr {
 			}
 
 			// Test ACLRule.Entity.
-			tObj := s.w.StorageClient.Bucket(dBkt).Object(fmt.Sprintf("daisy-validate-%s-%s", s.name, s.w.id))
-			w := tObj.NewWriter(ctx)
+			tObj := fmt.Sprintf("daisy-validate-%s-%s", s.name, s.w.id)
+			w := s.w.StorageClient.NewWriter(ctx, dBkt, tObj, "")
 			if _, err := w.Write(nil); err != nil {
 				return newErr(err)
 			}
 			if err := w.Close(); err != nil {
 				return newErr(err)
 			}
-			defer tObj.Delete(ctx)
+			defer s.w.StorageClient.DeleteObject(ctx, dBkt, tObj)
 
-			if err := tObj.ACL().Set(ctx, acl.Entity, acl.Role); err != nil {
+			if err := s.w.StorageClient.SetObjectACL(ctx, dBkt, tObj, acl.Entity, acl.Role); err != nil {
 				return errf("error validating ACLRule %+v: %v", acl, err)
 			}
 		}
@@ -118,23 +117,21 @@ func (c *CopyGCSObjects) validate(ctx context.Context, s *Step) dErr {
 }
 
 func recursiveGCS(ctx context.Context, w *Workflow, sBkt, sPrefix, dBkt, dPrefix string, acls []*storage.ACLRule) dErr {
-	it := w.StorageClient.Bucket(sBkt).Objects(ctx, &storage.Query{Prefix: sPrefix})
-	for objAttr, err := it.Next(); err != iterator.Done; objAttr, err = it.Next() {
-		if err != nil {
-			return typedErr(apiError, err)
-		}
+	objs, err := w.StorageClient.ListObjects(ctx, sBkt, sPrefix)
+	if err != nil {
+		return typedErr(apiError, err)
+	}
+	for _, objAttr := range objs {
 		if objAttr.Size == 0 {
 			continue
 		}
-		srcPath := w.StorageClient.Bucket(sBkt).Object(objAttr.Name)
 		o := path.Join(dPrefix, strings.TrimPrefix(objAttr.Name, sPrefix))
-		dstPath := w.StorageClient.Bucket(dBkt).Object(o)
-		if _, err := dstPath.CopierFrom(srcPath).Run(ctx); err != nil {
+		if err := w.StorageClient.CopyObject(ctx, sBkt, objAttr.Name, dBkt, o); err != nil {
 			return typedErr(apiError, err)
 		}
 
 		for _, acl := range acls {
-			if err := dstPath.ACL().Set(ctx, acl.Entity, acl.Role); err != nil {
+			if err := w.StorageClient.SetObjectACL(ctx, dBkt, o, acl.Entity, acl.Role); err != nil {
 				return typedErr(apiError, err)
 			}
 		}
@@ -169,14 +166,12 @@ func (c *CopyGCSObjects) run(ctx context.Context, s *Step) dErr {
 				return
 			}
 
-			src := s.w.StorageClient.Bucket(sBkt).Object(sObj)
-			dstPath := s.w.StorageClient.Bucket(dBkt).Object(dObj)
-			if _, err := dstPath.CopierFrom(src).Run(ctx); err != nil {
+			if err := s.w.StorageClient.CopyObject(ctx, sBkt, sObj, dBkt, dObj); err != nil {
 				e <- errf("error copying from %s to %s: %v", co.Source, co.Destination, err)
 				return
 			}
 			for _, acl := range co.ACLRules {
-				if err := dstPath.ACL().Set(ctx, acl.Entity, acl.Role); err != nil {
+				if err := s.w.StorageClient.SetObjectACL(ctx, dBkt, dObj, acl.Entity, acl.Role); err != nil {
 					e <- errf("error setting ACLRule on %s: %v", co.Destination, err)
 					return
 				}
@@ -22,6 +22,7 @@ import (
 	"testing"
 
 	"cloud.google.com/go/storage"
+	daisyStorage "github.com/GoogleCloudPlatform/compute-image-tools/daisy/storage"
 	"github.com/kylelemons/godebug/pretty"
 	"google.golang.org/api/option"
 )
@@ -69,7 +70,7 @@ func TestCopyGCSObjectsValidate(t *testing.T) {
 			fmt.Fprintf(w, "testGCSClient unknown request: %+v\n", r)
 		}
 	}))
-	sc, err := storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+	sc, err := daisyStorage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -44,6 +44,8 @@ type CreateDisk struct {
 
 	// The name of the disk as known to the Daisy user.
 	daisyName string
+	// SourceImage references step outputs, it is checked when the step runs.
+	sourceImageAtRun bool
 	// Deprecated: Use RealName instead.
 	ExactName bool
 }
@@ -68,6 +70,7 @@ func (c *CreateDisks) populate(ctx context.Context, s *Step) dErr {
 		cd.Project = strOr(cd.Project, s.w.Project)
 		cd.Zone = strOr(cd.Zone, s.w.Zone)
 		cd.Description = strOr(cd.Description, fmt.Sprintf("Disk created by Daisy in workflow %q on behalf of %s.", s.w.Name, s.w.username))
+		cd.Labels = s.w.resourceLabels(cd.Labels, cd.NoCleanup)
 		if cd.SizeGb != "" {
 			size, err := strconv.ParseInt(cd.SizeGb, 10, 64)
 			if err != nil {
@@ -110,7 +113,9 @@ func (c *CreateDisks) validate(ctx context.Context, s *Step) dErr {
 			return errf("cannot create disk: bad disk type: %q", cd.Type)
 		}
 
-		if cd.SourceImage != "" {
+		if hasOutputRef(cd.SourceImage) {
+			cd.sourceImageAtRun = true
+		} else if cd.SourceImage != "" {
 			if _, err := images[s.w].registerUsage(cd.SourceImage, s); err != nil {
 				return errf("cannot create disk: can't use image %q: %v", cd.SourceImage, err)
 			}
@@ -138,16 +143,28 @@ func (c *CreateDisks) run(ctx context.Context, s *Step) dErr {
 			defer wg.Done()
 
 			// Get the source image link if using a source image.
-			if cd.SourceImage != "" {
+			if cd.sourceImageAtRun {
+				image, err := images[w].registerRuntimeUsage(cd.SourceImage, cd.Project, s)
+				if err != nil {
+					e <- errf("cannot create disk: can't use image %q: %v", cd.SourceImage, err)
+					return
+				}
+				cd.SourceImage = image.link
+			} else if cd.SourceImage != "" {
 				image, _ := images[w].get(cd.SourceImage)
 				cd.SourceImage = image.link
 			}
 
 			w.logger.Printf("CreateDisks: creating disk %q.", cd.Name)
 			if err := w.ComputeClient.CreateDisk(cd.Project, cd.Zone, &cd.Disk); err != nil {
-				e <- newErr(err)
+				e <- typedErr(apiError, err)
 				return
 			}
+			w.emitResource(ResourceCreated, "disk", cd.Name)
+			if cd.SourceImage != "" {
+				w.recordDiskImage(fmt.Sprintf("projects/%s/zones/%s/disks/%s", cd.Project, cd.Zone, cd.Name), cd.SourceImage)
+			}
+			s.setOutput(cd.daisyName+".SelfLink", cd.SelfLink)
 		}(cd)
 	}
 
@@ -33,6 +33,7 @@ func TestCreateDisksPopulate(t *testing.T) {
 
 	genFoo := w.genName("foo")
 	defType := fmt.Sprintf("projects/%s/zones/%s/diskTypes/pd-standard", w.Project, w.Zone)
+	defLabels := map[string]string{workflowLabel: testWf, runIDLabel: "abcdef", userLabel: "", cleanupLabel: cleanupDelete}
 	tests := []struct {
 		desc        string
 		input, want *CreateDisk
@@ -41,43 +42,43 @@ func TestCreateDisksPopulate(t *testing.T) {
 		{
 			"defaults case",
 			&CreateDisk{Disk: compute.Disk{Name: "foo"}},
-			&CreateDisk{Disk: compute.Disk{Name: genFoo, Type: defType}, daisyName: "foo", Project: w.Project, Zone: w.Zone},
+			&CreateDisk{Disk: compute.Disk{Name: genFoo, Labels: defLabels, Type: defType}, daisyName: "foo", Project: w.Project, Zone: w.Zone},
 			false,
 		},
 		{
 			"nondefaults case",
 			&CreateDisk{Disk: compute.Disk{Name: "foo", Type: "pd-ssd"}, SizeGb: "10", Project: "pfoo", Zone: "zfoo"},
-			&CreateDisk{Disk: compute.Disk{Name: genFoo, Type: "projects/pfoo/zones/zfoo/diskTypes/pd-ssd", SizeGb: 10}, daisyName: "foo", SizeGb: "10", Project: "pfoo", Zone: "zfoo"},
+			&CreateDisk{Disk: compute.Disk{Name: genFoo, Labels: defLabels, Type: "projects/pfoo/zones/zfoo/diskTypes/pd-ssd", SizeGb: 10}, daisyName: "foo", SizeGb: "10", Project: "pfoo", Zone: "zfoo"},
 			false,
 		},
 		{
 			"ExactName case",
 			&CreateDisk{Disk: compute.Disk{Name: "foo"}, ExactName: true},
-			&CreateDisk{Disk: compute.Disk{Name: "foo", Type: defType}, daisyName: "foo", Project: w.Project, Zone: w.Zone, ExactName: true, RealName: "foo"},
+			&CreateDisk{Disk: compute.Disk{Name: "foo", Labels: defLabels, Type: defType}, daisyName: "foo", Project: w.Project, Zone: w.Zone, ExactName: true, RealName: "foo"},
 			false,
 		},
 		{
 			"RealName case",
 			&CreateDisk{Disk: compute.Disk{Name: "foo"}, RealName: "foo-foo"},
-			&CreateDisk{Disk: compute.Disk{Name: "foo-foo", Type: defType}, daisyName: "foo", Project: w.Project, Zone: w.Zone, RealName: "foo-foo"},
+			&CreateDisk{Disk: compute.Disk{Name: "foo-foo", Labels: defLabels, Type: defType}, daisyName: "foo", Project: w.Project, Zone: w.Zone, RealName: "foo-foo"},
 			false,
 		},
 		{
 			"extend Type URL case",
 			&CreateDisk{Disk: compute.Disk{Name: "foo", Type: "zones/zfoo/diskTypes/pd-ssd"}, Project: "pfoo"},
-			&CreateDisk{Disk: compute.Disk{Name: genFoo, Type: "projects/pfoo/zones/zfoo/diskTypes/pd-ssd"}, daisyName: "foo", Project: "pfoo", Zone: w.Zone},
+			&CreateDisk{Disk: compute.Disk{Name: genFoo, Labels: defLabels, Type: "projects/pfoo/zones/zfoo/diskTypes/pd-ssd"}, daisyName: "foo", Project: "pfoo", Zone: w.Zone},
 			false,
 		},
 		{
 			"extend SourceImage URL case",
 			&CreateDisk{Disk: compute.Disk{Name: "foo"}},
-			&CreateDisk{Disk: compute.Disk{Name: genFoo, Type: defType}, daisyName: "foo", Project: w.Project, Zone: w.Zone},
+			&CreateDisk{Disk: compute.Disk{Name: genFoo, Labels: defLabels, Type: defType}, daisyName: "foo", Project: w.Project, Zone: w.Zone},
 			false,
 		},
 		{
 			"SourceImage daisy name case",
 			&CreateDisk{Disk: compute.Disk{Name: "foo", SourceImage: "ifoo"}},
-			&CreateDisk{Disk: compute.Disk{Name: genFoo, SourceImage: "ifoo", Type: defType}, daisyName: "foo", Project: w.Project, Zone: w.Zone},
+			&CreateDisk{Disk: compute.Disk{Name: genFoo, Labels: defLabels, SourceImage: "ifoo", Type: defType}, daisyName: "foo", Project: w.Project, Zone: w.Zone},
 			false,
 		},
 		{
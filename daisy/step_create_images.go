@@ -42,9 +42,14 @@ type CreateImage struct {
 	// Should an existing image of the same name be deleted, defaults to false
 	// which will fail validation.
 	OverWrite bool
+	// Label the image with the hash of the workflow file and write the
+	// Provenance of the image to OUTSPATH.
+	Provenance bool `json:",omitempty"`
 
 	// The name of the disk as known to the Daisy user.
 	daisyName string
+	// SourceDisk references step outputs, it is checked when the step runs.
+	sourceDiskAtRun bool
 	// Deprecated: Use RealName instead.
 	ExactName bool
 }
@@ -72,6 +77,7 @@ func (c *CreateImages) populate(ctx context.Context, s *Step) dErr {
 		}
 		ci.Project = strOr(ci.Project, s.w.Project)
 		ci.Description = strOr(ci.Description, fmt.Sprintf("Image created by Daisy in workflow %q on behalf of %s.", s.w.Name, s.w.username))
+		ci.Labels = s.w.resourceLabels(ci.Labels, ci.NoCleanup)
 
 		if diskURLRgx.MatchString(ci.SourceDisk) {
 			ci.SourceDisk = extendPartialURL(ci.SourceDisk, ci.Project)
@@ -112,7 +118,9 @@ func (c *CreateImages) validate(ctx context.Context, s *Step) dErr {
 			if ci.RawDisk != nil {
 				return errf("must provide either SourceDisk or RawDisk, exclusively")
 			}
-			if _, err := disks[s.w].registerUsage(ci.SourceDisk, s); err != nil {
+			if hasOutputRef(ci.SourceDisk) {
+				ci.sourceDiskAtRun = true
+			} else if _, err := disks[s.w].registerUsage(ci.SourceDisk, s); err != nil {
 				return newErr(err)
 			}
 		}
@@ -147,7 +155,14 @@ func (c *CreateImages) run(ctx context.Context, s *Step) dErr {
 		go func(ci *CreateImage) {
 			defer wg.Done()
 			// Get source disk link if SourceDisk is a daisy reference to a disk.
-			if d, ok := disks[w].get(ci.SourceDisk); ok {
+			if ci.sourceDiskAtRun {
+				d, err := disks[w].registerRuntimeUsage(ci.SourceDisk, ci.Project, s)
+				if err != nil {
+					e <- errf("cannot create image: can't use disk %q: %v", ci.SourceDisk, err)
+					return
+				}
+				ci.SourceDisk = d.link
+			} else if d, ok := disks[w].get(ci.SourceDisk); ok {
 				ci.SourceDisk = d.link
 			}
 
@@ -162,11 +177,26 @@ func (c *CreateImages) run(ctx context.Context, s *Step) dErr {
 				}
 			}
 
+			if ci.Provenance && w.workflowSHA256() != "" {
+				ci.Labels[workflowHashLabel] = labelValue(w.workflowSHA256())
+			}
+
 			w.logger.Printf("CreateImages: creating image %q.", ci.Name)
 			if err := w.ComputeClient.CreateImage(ci.Project, &ci.Image); err != nil {
-				e <- newErr(err)
+				e <- typedErr(apiError, err)
 				return
 			}
+			w.emitResource(ResourceCreated, "image", ci.Name)
+			if ci.SourceDisk != "" {
+				w.recordImageDisk(fmt.Sprintf("projects/%s/global/images/%s", ci.Project, ci.Name), ci.SourceDisk)
+			}
+			if ci.Provenance {
+				if err := w.writeProvenance(ctx, ci); err != nil {
+					e <- errf("error writing provenance of image %q: %v", ci.Name, err)
+					return
+				}
+			}
+			s.setOutput(ci.daisyName+".SelfLink", ci.SelfLink)
 		}(ci)
 	}
 
@@ -33,6 +33,7 @@ func TestCreateImagePopulate(t *testing.T) {
 
 	genFoo := w.genName("foo")
 	gcsAPIPath, _ := getGCSAPIPath("gs://bucket/d")
+	defLabels := map[string]string{workflowLabel: testWf, runIDLabel: "abcdef", userLabel: "", cleanupLabel: cleanupDelete}
 	tests := []struct {
 		desc        string
 		input, want *CreateImage
@@ -41,55 +42,55 @@ func TestCreateImagePopulate(t *testing.T) {
 		{
 			"defaults case",
 			&CreateImage{Image: compute.Image{Name: "foo"}},
-			&CreateImage{Image: compute.Image{Name: genFoo}, daisyName: "foo", Project: w.Project},
+			&CreateImage{Image: compute.Image{Name: genFoo, Labels: defLabels}, daisyName: "foo", Project: w.Project},
 			false,
 		},
 		{
 			"nondefaults case",
 			&CreateImage{Image: compute.Image{Name: "foo"}, Project: "pfoo"},
-			&CreateImage{Image: compute.Image{Name: genFoo}, daisyName: "foo", Project: "pfoo"},
+			&CreateImage{Image: compute.Image{Name: genFoo, Labels: defLabels}, daisyName: "foo", Project: "pfoo"},
 			false,
 		},
 		{
 			"ExactName case",
 			&CreateImage{Image: compute.Image{Name: "foo"}, ExactName: true},
-			&CreateImage{Image: compute.Image{Name: "foo"}, daisyName: "foo", Project: w.Project, ExactName: true, RealName: "foo"},
+			&CreateImage{Image: compute.Image{Name: "foo", Labels: defLabels}, daisyName: "foo", Project: w.Project, ExactName: true, RealName: "foo"},
 			false,
 		},
 		{
 			"RealName case",
 			&CreateImage{Image: compute.Image{Name: "foo"}, RealName: "foo-foo"},
-			&CreateImage{Image: compute.Image{Name: "foo-foo"}, daisyName: "foo", Project: w.Project, RealName: "foo-foo"},
+			&CreateImage{Image: compute.Image{Name: "foo-foo", Labels: defLabels}, daisyName: "foo", Project: w.Project, RealName: "foo-foo"},
 			false,
 		},
 		{
 			"SourceDisk case",
 			&CreateImage{Image: compute.Image{Name: "foo", SourceDisk: "d"}},
-			&CreateImage{Image: compute.Image{Name: genFoo, SourceDisk: "d"}, daisyName: "foo", Project: w.Project},
+			&CreateImage{Image: compute.Image{Name: genFoo, Labels: defLabels, SourceDisk: "d"}, daisyName: "foo", Project: w.Project},
 			false,
 		},
 		{
 			"SourceDisk URL case",
 			&CreateImage{Image: compute.Image{Name: "foo", SourceDisk: "projects/p/zones/z/disks/d"}},
-			&CreateImage{Image: compute.Image{Name: genFoo, SourceDisk: "projects/p/zones/z/disks/d"}, daisyName: "foo", Project: w.Project},
+			&CreateImage{Image: compute.Image{Name: genFoo, Labels: defLabels, SourceDisk: "projects/p/zones/z/disks/d"}, daisyName: "foo", Project: w.Project},
 			false,
 		},
 		{
 			"extend SourceDisk URL case",
 			&CreateImage{Image: compute.Image{Name: "foo", SourceDisk: "zones/z/disks/d"}, Project: "p"},
-			&CreateImage{Image: compute.Image{Name: genFoo, SourceDisk: "projects/p/zones/z/disks/d"}, daisyName: "foo", Project: "p"},
+			&CreateImage{Image: compute.Image{Name: genFoo, Labels: defLabels, SourceDisk: "projects/p/zones/z/disks/d"}, daisyName: "foo", Project: "p"},
 			false,
 		},
 		{
 			"RawDisk.Source from Sources case",
 			&CreateImage{Image: compute.Image{Name: "foo", RawDisk: &compute.ImageRawDisk{Source: "d"}}},
-			&CreateImage{Image: compute.Image{Name: genFoo, RawDisk: &compute.ImageRawDisk{Source: w.getSourceGCSAPIPath("d")}}, daisyName: "foo", Project: w.Project},
+			&CreateImage{Image: compute.Image{Name: genFoo, Labels: defLabels, RawDisk: &compute.ImageRawDisk{Source: w.getSourceGCSAPIPath("d")}}, daisyName: "foo", Project: w.Project},
 			false,
 		},
 		{
 			"RawDisk.Source GCS URL case",
 			&CreateImage{Image: compute.Image{Name: "foo", RawDisk: &compute.ImageRawDisk{Source: "gs://bucket/d"}}},
-			&CreateImage{Image: compute.Image{Name: genFoo, RawDisk: &compute.ImageRawDisk{Source: gcsAPIPath}}, daisyName: "foo", Project: w.Project},
+			&CreateImage{Image: compute.Image{Name: genFoo, Labels: defLabels, RawDisk: &compute.ImageRawDisk{Source: gcsAPIPath}}, daisyName: "foo", Project: w.Project},
 			false,
 		},
 		{
@@ -19,6 +19,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"path"
 	"sync"
 	"time"
@@ -48,6 +49,9 @@ type CreateInstance struct {
 	Project string `json:",omitempty"`
 	// Zone to create the instance in, overrides workflow Zone.
 	Zone string `json:",omitempty"`
+	// Zones to create the instance in, in order, if Zone is out of resources,
+	// overrides workflow FallbackZones.
+	FallbackZones []string `json:",omitempty"`
 	// Should this resource be cleaned up after the workflow?
 	NoCleanup bool
 	// If set Daisy will use this as the resource name instead generating a name.
@@ -55,6 +59,9 @@ type CreateInstance struct {
 
 	// The name of the disk as known to the Daisy user.
 	daisyName string
+	// InitializeParams whose SourceImage references step outputs, checked
+	// when the step runs.
+	imagesAtRun []*compute.AttachedDiskInitializeParams
 	// Deprecated: Use RealName instead.
 	ExactName bool
 }
@@ -65,7 +72,7 @@ func (c *CreateInstance) MarshalJSON() ([]byte, error) {
 	return json.Marshal(*c)
 }
 
-func logSerialOutput(ctx context.Context, w *Workflow, name string, port int64, interval time.Duration) {
+func logSerialOutput(ctx context.Context, w *Workflow, project, zone, name string, port int64, interval time.Duration) {
 	logsObj := path.Join(w.logsPath, fmt.Sprintf("%s-serial-port%d.log", name, port))
 	w.logger.Printf("CreateInstances: streaming instance %q serial port %d output to gs://%s/%s", name, port, w.bucket, logsObj)
 	var start int64
@@ -77,14 +84,14 @@ func logSerialOutput(ctx context.Context, w *Workflow, name string, port int64,
 		case <-ctx.Done():
 			return
 		case <-tick:
-			resp, err := w.ComputeClient.GetSerialPortOutput(w.Project, w.Zone, name, port, start)
+			resp, err := w.ComputeClient.GetSerialPortOutput(project, zone, name, port, start)
 			if err != nil {
 				// Instance was deleted by this workflow.
 				if _, ok := instances[w].get(name); !ok {
 					return
 				}
 				// Instance is stopped.
-				stopped, sErr := w.ComputeClient.InstanceStopped(w.Project, w.Zone, name)
+				stopped, sErr := w.ComputeClient.InstanceStopped(project, zone, name)
 				if stopped && sErr == nil {
 					return
 				}
@@ -93,9 +100,8 @@ func logSerialOutput(ctx context.Context, w *Workflow, name string, port int64,
 			}
 			start = resp.Next
 			buf.WriteString(resp.Contents)
-			wc := w.StorageClient.Bucket(w.bucket).Object(logsObj).NewWriter(ctx)
-			wc.ContentType = "text/plain"
-			if _, err := wc.Write(buf.Bytes()); err != nil {
+			wc := w.StorageClient.NewWriter(ctx, w.bucket, logsObj, "text/plain")
+			if _, err := io.WriteString(wc, w.redact(buf.String())); err != nil {
 				w.logger.Printf("CreateInstances: instance %q: error writing log to GCS: %v", name, err)
 				return
 			}
@@ -112,6 +118,21 @@ func logSerialOutput(ctx context.Context, w *Workflow, name string, port int64,
 	}
 }
 
+// populateFallbackZones defaults FallbackZones to the workflow's, without Zone
+// and duplicates.
+func (c *CreateInstance) populateFallbackZones(w *Workflow) {
+	zones := c.FallbackZones
+	if zones == nil {
+		zones = w.FallbackZones
+	}
+	c.FallbackZones = nil
+	for _, z := range zones {
+		if z != c.Zone && !strIn(z, c.FallbackZones) {
+			c.FallbackZones = append(c.FallbackZones, z)
+		}
+	}
+}
+
 func (c *CreateInstance) populateDisks(w *Workflow) dErr {
 	autonameIdx := 1
 	for i, d := range c.Disks {
@@ -143,6 +164,7 @@ func (c *CreateInstance) populateDisks(w *Workflow) dErr {
 			} else {
 				p.DiskType = fmt.Sprintf("projects/%s/zones/%s/diskTypes/%s", c.Project, c.Zone, p.DiskType)
 			}
+			p.Labels = w.resourceLabels(p.Labels, c.NoCleanup)
 		}
 	}
 	return nil
@@ -233,7 +255,9 @@ func (c *CreateInstances) populate(ctx context.Context, s *Step) dErr {
 		}
 		ci.Project = strOr(ci.Project, s.w.Project)
 		ci.Zone = strOr(ci.Zone, s.w.Zone)
+		ci.populateFallbackZones(s.w)
 		ci.Description = strOr(ci.Description, fmt.Sprintf("Instance created by Daisy in workflow %q on behalf of %s.", s.w.Name, s.w.username))
+		ci.Labels = s.w.resourceLabels(ci.Labels, ci.NoCleanup)
 
 		errs = addErrs(errs, ci.populateDisks(s.w))
 		errs = addErrs(errs, ci.populateMachineType())
@@ -289,7 +313,9 @@ func (c *CreateInstance) validateDiskInitializeParams(d *compute.AttachedDisk, s
 	if !rfc1035Rgx.MatchString(p.DiskName) {
 		errs = addErrs(errs, errf("cannot create instance: bad InitializeParams.DiskName: %q", p.DiskName))
 	}
-	if _, err := images[s.w].registerUsage(p.SourceImage, s); err != nil {
+	if hasOutputRef(p.SourceImage) {
+		c.imagesAtRun = append(c.imagesAtRun, p)
+	} else if _, err := images[s.w].registerUsage(p.SourceImage, s); err != nil {
 		errs = addErrs(errs, errf("cannot create instance: can't use InitializeParams.SourceImage %q: %v", p.SourceImage, err))
 	}
 	parts := namedSubexp(diskTypeURLRgx, p.DiskType)
@@ -329,6 +355,28 @@ func (c *CreateInstance) validateMachineType(client daisyCompute.Client) (errs d
 	return
 }
 
+func (c *CreateInstance) validateFallbackZones(client daisyCompute.Client) (errs dErr) {
+	mt := namedSubexp(machineTypeURLRegex, c.MachineType)["machinetype"]
+	for _, z := range c.FallbackZones {
+		if exists, err := zoneExists(client, c.Project, z); err != nil {
+			errs = addErrs(errs, errf("cannot create instance: bad fallback zone lookup: %q, error: %v", z, err))
+			continue
+		} else if !exists {
+			errs = addErrs(errs, errf("cannot create instance: fallback zone does not exist: %q", z))
+			continue
+		}
+		if mt == "" {
+			continue
+		}
+		if exists, err := machineTypeExists(client, c.Project, z, mt); err != nil {
+			errs = addErrs(errs, errf("cannot create instance, bad machineType lookup in fallback zone %q: %q, error: %v", z, mt, err))
+		} else if !exists {
+			errs = addErrs(errs, errf("cannot create instance, machineType does not exist in fallback zone %q: %q", z, mt))
+		}
+	}
+	return
+}
+
 func (c *CreateInstance) validateNetworks(s *Step) (errs dErr) {
 	for _, n := range c.NetworkInterfaces {
 		nr, err := networks[s.w].registerUsage(n.Network, s)
@@ -369,6 +417,7 @@ func (c *CreateInstances) validate(ctx context.Context, s *Step) dErr {
 		errs = addErrs(errs, ci.validateDisks(s))
 		errs = addErrs(errs, ci.validateMachineType(s.w.ComputeClient))
 		errs = addErrs(errs, ci.validateNetworks(s))
+		errs = addErrs(errs, ci.validateFallbackZones(s.w.ComputeClient))
 
 		// Register creation.
 		link := fmt.Sprintf("projects/%s/zones/%s/instances/%s", ci.Project, ci.Zone, ci.Name)
@@ -393,13 +442,43 @@ func (c *CreateInstances) run(ctx context.Context, s *Step) dErr {
 					d.Source = diskRes.link
 				}
 			}
+			for _, p := range ci.imagesAtRun {
+				image, err := images[w].registerRuntimeUsage(p.SourceImage, ci.Project, s)
+				if err != nil {
+					eChan <- errf("cannot create instance: can't use InitializeParams.SourceImage %q: %v", p.SourceImage, err)
+					return
+				}
+				p.SourceImage = image.link
+			}
 
 			w.logger.Printf("CreateInstances: creating instance %q.", ci.Name)
-			if err := w.ComputeClient.CreateInstance(ci.Project, ci.Zone, &ci.Instance); err != nil {
-				eChan <- newErr(err)
+			if err := ci.create(s); err != nil {
+				eChan <- typedErr(apiError, err)
 				return
 			}
-			go logSerialOutput(ctx, w, ci.Name, 1, 3*time.Second)
+			w.emitResource(ResourceCreated, "instance", ci.Name)
+			for _, d := range ci.Disks {
+				if p := d.InitializeParams; p != nil {
+					w.emitResource(ResourceCreated, "disk", p.DiskName)
+					if p.SourceImage == "" {
+						continue
+					}
+					image := p.SourceImage
+					if r, ok := images[w].get(image); ok {
+						image = r.link
+					}
+					w.recordDiskImage(fmt.Sprintf("projects/%s/zones/%s/disks/%s", ci.Project, ci.Zone, p.DiskName), image)
+				}
+			}
+			s.setOutput(ci.daisyName+".SelfLink", ci.SelfLink)
+			if len(ci.NetworkInterfaces) > 0 {
+				ni := ci.NetworkInterfaces[0]
+				s.setOutput(ci.daisyName+".NetworkIP", ni.NetworkIP)
+				if len(ni.AccessConfigs) > 0 {
+					s.setOutput(ci.daisyName+".NatIP", ni.AccessConfigs[0].NatIP)
+				}
+			}
+			go logSerialOutput(ctx, w, ci.Project, ci.Zone, ci.Name, 1, 3*time.Second)
 		}(ci)
 	}
 
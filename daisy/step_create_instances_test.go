@@ -85,7 +85,7 @@ func TestLogSerialOutput(t *testing.T) {
 
 	for _, tt := range tests {
 		buf.Reset()
-		logSerialOutput(ctx, w, tt.name, 0, 1*time.Microsecond)
+		logSerialOutput(ctx, w, w.Project, w.Zone, tt.name, 0, 1*time.Microsecond)
 		if buf.String() != tt.want {
 			t.Errorf("%s: got: %q, want: %q", tt.test, buf.String(), tt.want)
 		}
@@ -107,6 +107,7 @@ func TestCreateInstancePopulate(t *testing.T) {
 	defMD := map[string]string{"daisy-sources-path": "gs://", "daisy-logs-path": "gs://", "daisy-outs-path": "gs://"}
 	defSs := []string{"https://www.googleapis.com/auth/devstorage.read_only"}
 	defSAs := []*compute.ServiceAccount{{Email: "default", Scopes: defSs}}
+	defLabels := map[string]string{workflowLabel: testWf, runIDLabel: "abcdef", userLabel: "", cleanupLabel: cleanupDelete}
 
 	tests := []struct {
 		desc      string
@@ -116,7 +117,7 @@ func TestCreateInstancePopulate(t *testing.T) {
 		{
 			"defaults, non exact name case",
 			&CreateInstance{Instance: compute.Instance{Name: "foo", Description: desc, Disks: []*compute.AttachedDisk{{Source: "foo"}}}},
-			&CreateInstance{Instance: compute.Instance{Name: w.genName("foo"), Description: desc, Disks: defDs, MachineType: defMT, NetworkInterfaces: defNs, ServiceAccounts: defSAs}, Metadata: defMD, Scopes: defSs, Project: defP, Zone: defZ, daisyName: "foo"},
+			&CreateInstance{Instance: compute.Instance{Name: w.genName("foo"), Description: desc, Labels: defLabels, Disks: defDs, MachineType: defMT, NetworkInterfaces: defNs, ServiceAccounts: defSAs}, Metadata: defMD, Scopes: defSs, Project: defP, Zone: defZ, daisyName: "foo"},
 			false,
 		},
 		{
@@ -127,7 +128,7 @@ func TestCreateInstancePopulate(t *testing.T) {
 			},
 			&CreateInstance{
 				Instance: compute.Instance{
-					Name: "inst-pfoo", Description: desc,
+					Name: "inst-pfoo", Description: desc, Labels: defLabels,
 					Disks:             []*compute.AttachedDisk{{Boot: true, Source: "foo", Mode: defDM}},
 					MachineType:       "projects/pfoo/zones/zfoo/machineTypes/n1-standard-1",
 					NetworkInterfaces: []*compute.NetworkInterface{{Network: "projects/pfoo/global/networks/default", AccessConfigs: defAcs}},
@@ -163,6 +164,7 @@ func TestCreateInstancePopulateDisks(t *testing.T) {
 
 	iName := "foo"
 	defDT := fmt.Sprintf("projects/%s/zones/%s/diskTypes/%s", testProject, testZone, defaultDiskType)
+	defLabels := map[string]string{workflowLabel: testWf, runIDLabel: "abcdef", userLabel: "", cleanupLabel: cleanupDelete}
 	tests := []struct {
 		desc       string
 		ad, wantAd []*compute.AttachedDisk
@@ -185,27 +187,27 @@ func TestCreateInstancePopulateDisks(t *testing.T) {
 		{
 			"init params daisy image (and other defaults)",
 			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{SourceImage: "i"}}},
-			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName, SourceImage: "i", DiskType: defDT}, Mode: defaultDiskMode, Boot: true}},
+			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{Labels: defLabels, DiskName: iName, SourceImage: "i", DiskType: defDT}, Mode: defaultDiskMode, Boot: true}},
 		},
 		{
 			"init params image short url",
 			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{SourceImage: "global/images/i"}}},
-			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName, SourceImage: fmt.Sprintf("projects/%s/global/images/i", testProject), DiskType: defDT}, Mode: defaultDiskMode, Boot: true}},
+			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{Labels: defLabels, DiskName: iName, SourceImage: fmt.Sprintf("projects/%s/global/images/i", testProject), DiskType: defDT}, Mode: defaultDiskMode, Boot: true}},
 		},
 		{
 			"init params image extended url",
 			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{SourceImage: fmt.Sprintf("projects/%s/global/images/i", testProject)}}},
-			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName, SourceImage: fmt.Sprintf("projects/%s/global/images/i", testProject), DiskType: defDT}, Mode: defaultDiskMode, Boot: true}},
+			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{Labels: defLabels, DiskName: iName, SourceImage: fmt.Sprintf("projects/%s/global/images/i", testProject), DiskType: defDT}, Mode: defaultDiskMode, Boot: true}},
 		},
 		{
 			"init params disk type short url",
 			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{SourceImage: "i", DiskType: fmt.Sprintf("zones/%s/diskTypes/dt", testZone)}}},
-			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName, SourceImage: "i", DiskType: fmt.Sprintf("projects/%s/zones/%s/diskTypes/dt", testProject, testZone)}, Mode: defaultDiskMode, Boot: true}},
+			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{Labels: defLabels, DiskName: iName, SourceImage: "i", DiskType: fmt.Sprintf("projects/%s/zones/%s/diskTypes/dt", testProject, testZone)}, Mode: defaultDiskMode, Boot: true}},
 		},
 		{
 			"init params disk type extended url",
 			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{SourceImage: "i", DiskType: fmt.Sprintf("projects/%s/zones/%s/diskTypes/dt", testProject, testZone)}}},
-			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName, SourceImage: "i", DiskType: fmt.Sprintf("projects/%s/zones/%s/diskTypes/dt", testProject, testZone)}, Mode: defaultDiskMode, Boot: true}},
+			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{Labels: defLabels, DiskName: iName, SourceImage: "i", DiskType: fmt.Sprintf("projects/%s/zones/%s/diskTypes/dt", testProject, testZone)}, Mode: defaultDiskMode, Boot: true}},
 		},
 		{
 			"init params name suffixes",
@@ -216,10 +218,10 @@ func TestCreateInstancePopulateDisks(t *testing.T) {
 				{InitializeParams: &compute.AttachedDiskInitializeParams{SourceImage: "i"}},
 			},
 			[]*compute.AttachedDisk{
-				{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName, SourceImage: "i", DiskType: defDT}, Mode: defaultDiskMode, Boot: true},
+				{InitializeParams: &compute.AttachedDiskInitializeParams{Labels: defLabels, DiskName: iName, SourceImage: "i", DiskType: defDT}, Mode: defaultDiskMode, Boot: true},
 				{Source: "d", Mode: defaultDiskMode},
-				{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: "foo", SourceImage: "i", DiskType: defDT}, Mode: defaultDiskMode},
-				{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: fmt.Sprintf("%s-2", iName), SourceImage: "i", DiskType: defDT}, Mode: defaultDiskMode},
+				{InitializeParams: &compute.AttachedDiskInitializeParams{Labels: defLabels, DiskName: "foo", SourceImage: "i", DiskType: defDT}, Mode: defaultDiskMode},
+				{InitializeParams: &compute.AttachedDiskInitializeParams{Labels: defLabels, DiskName: fmt.Sprintf("%s-2", iName), SourceImage: "i", DiskType: defDT}, Mode: defaultDiskMode},
 			},
 		},
 	}
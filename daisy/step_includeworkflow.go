@@ -14,28 +14,28 @@
 
 package daisy
 
-import (
-	"context"
-	"fmt"
-	"path/filepath"
-	"reflect"
-	"strings"
-)
+import "context"
 
 // IncludeWorkflow defines a Daisy workflow injection step. This step will
 // 'include' the workflow found the path given into the parent workflow. Unlike
 // a Subworkflow the included workflow will exist in the same namespace
 // as the parent and have access to all its resources.
 type IncludeWorkflow struct {
-	Path     string
-	Vars     map[string]string `json:",omitempty"`
+	// Local path, relative to the including workflow's, or gs:// or https://
+	// URL of the workflow file.
+	Path string
+	// SHA-256 the workflow file must have, in hex.
+	SHA256 string            `json:",omitempty"`
+	Vars   map[string]string `json:",omitempty"`
+	// Expands the step into one workflow per set of vars.
+	ForEach  *ForEach `json:",omitempty"`
 	Workflow *Workflow
 }
 
 func (i *IncludeWorkflow) populate(ctx context.Context, s *Step) dErr {
 	if i.Path != "" {
-		var err error
-		if i.Workflow, err = s.w.NewIncludedWorkflowFromFile(i.Path); err != nil {
+		i.Workflow = s.w.NewIncludedWorkflow()
+		if err := s.w.readChildWorkflow(ctx, i.Workflow, i.Path, i.SHA256); err != nil {
 			return newErr(err)
 		}
 	}
@@ -45,6 +45,10 @@ func (i *IncludeWorkflow) populate(ctx context.Context, s *Step) dErr {
 	}
 
 	i.Workflow.id = s.w.id
+	i.Workflow.started = s.w.started
+	if s.w.checkpoint != nil {
+		i.Workflow.checkpoint = s.w.checkpoint.Workflows[s.name]
+	}
 	i.Workflow.username = s.w.username
 	i.Workflow.ComputeClient = s.w.ComputeClient
 	i.Workflow.StorageClient = s.w.StorageClient
@@ -52,6 +56,7 @@ func (i *IncludeWorkflow) populate(ctx context.Context, s *Step) dErr {
 	i.Workflow.Name = s.name
 	i.Workflow.Project = s.w.Project
 	i.Workflow.Zone = s.w.Zone
+	i.Workflow.FallbackZones = s.w.FallbackZones
 	i.Workflow.autovars = s.w.autovars
 	i.Workflow.bucket = s.w.bucket
 	i.Workflow.scratchPath = s.w.scratchPath
@@ -65,24 +70,30 @@ func (i *IncludeWorkflow) populate(ctx context.Context, s *Step) dErr {
 		i.Workflow.AddVar(k, v)
 	}
 
-	var replacements []string
+	// The included workflow has its own NAME and WFDIR.
+	autovars := map[string]string{}
 	for k, v := range i.Workflow.autovars {
-		if k == "NAME" {
-			v = s.name
-		}
-		if k == "WFDIR" {
-			v = i.Workflow.workflowDir
-		}
-		replacements = append(replacements, fmt.Sprintf("${%s}", k), v)
+		autovars[k] = v
+	}
+	autovars["NAME"] = s.name
+	autovars["WFDIR"] = i.Workflow.workflowDir
+	in := i.Workflow.newInterpolator(autovars, handlerAutovars...)
+	if err := i.Workflow.resolveVars(in); err != nil {
+		return err
 	}
-	substitute(reflect.ValueOf(i.Workflow).Elem(), strings.NewReplacer(replacements...))
-	for k, v := range i.Workflow.Vars {
-		replacements = append(replacements, fmt.Sprintf("${%s}", k), v.Value)
+	i.Workflow.addSecretVars()
+	if err := i.Workflow.validateVars(); err != nil {
+		return err
+	}
+	if err := i.Workflow.interpolate(in); err != nil {
+		return err
 	}
-	substitute(reflect.ValueOf(i.Workflow).Elem(), strings.NewReplacer(replacements...))
 
 	i.Workflow.populateLogger(ctx)
 
+	if err := i.Workflow.expandForEach(); err != nil {
+		return err
+	}
 	for name, st := range i.Workflow.Steps {
 		st.name = name
 		st.w = i.Workflow
@@ -90,6 +101,9 @@ func (i *IncludeWorkflow) populate(ctx context.Context, s *Step) dErr {
 			return err
 		}
 	}
+	if err := i.Workflow.populateHandlers(ctx); err != nil {
+		return err
+	}
 
 	// Copy Sources up to parent resolving relative paths as we go.
 	for k, v := range i.Workflow.Sources {
@@ -103,8 +117,12 @@ func (i *IncludeWorkflow) populate(ctx context.Context, s *Step) dErr {
 			s.w.Sources = map[string]string{}
 		}
 
-		if _, _, err := splitGCSPath(v); err != nil && !filepath.IsAbs(v) {
-			v = filepath.Join(i.Workflow.workflowDir, v)
+		if _, _, err := splitGCSPath(v); err != nil {
+			p, err := joinPath(i.Workflow.workflowDir, v)
+			if err != nil {
+				return errf("source %q: %v", k, err)
+			}
+			v = p
 		}
 		s.w.Sources[k] = v
 	}
@@ -0,0 +1,257 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// StepType is a step type registered with RegisterStepType. Like the
+// built-in step types, a StepType is populated, then validated, then run.
+// Vars in its string fields are substituted before Populate is called.
+type StepType interface {
+	// Populate sets defaults. It should not validate values.
+	Populate(ctx context.Context, s *Step) error
+	// Validate checks the step's values, without side effects.
+	Validate(ctx context.Context, s *Step) error
+	// Run runs the step. Use s.AddCleanupHook to clean up what it creates.
+	Run(ctx context.Context, s *Step) error
+}
+
+var (
+	stepTypes   = map[string]func() StepType{}
+	stepTypesMx sync.RWMutex
+	stepTyp     = reflect.TypeOf(Step{})
+	stepTypeTyp = reflect.TypeOf((*StepType)(nil)).Elem()
+)
+
+// RegisterStepType registers a step type under name, the step's key in
+// workflow files. factory returns a new value to unmarshal the step's JSON
+// into, usually a pointer to a struct. Names match case insensitively, like
+// other workflow fields.
+//
+// RegisterStepType is meant to be called from init functions. It panics if
+// name is already registered or is the name of a built-in step field.
+func RegisterStepType(name string, factory func() StepType) {
+	stepTypesMx.Lock()
+	defer stepTypesMx.Unlock()
+	for _, f := range jsonFields(stepTyp) {
+		if strings.EqualFold(f.name, name) {
+			panic(fmt.Sprintf("daisy: cannot register step type %q, it is a built-in step field", name))
+		}
+	}
+	for n := range stepTypes {
+		if strings.EqualFold(n, name) {
+			panic(fmt.Sprintf("daisy: step type %q is already registered", name))
+		}
+	}
+	stepTypes[name] = factory
+}
+
+// lookupStepType returns the registered name and the factory of step type
+// k, matching case insensitively.
+func lookupStepType(k string) (string, func() StepType, bool) {
+	stepTypesMx.RLock()
+	defer stepTypesMx.RUnlock()
+	for n, f := range stepTypes {
+		if strings.EqualFold(n, k) {
+			return n, f, true
+		}
+	}
+	return "", nil, false
+}
+
+// registeredStepTypes returns the names of the registered step types, sorted.
+func registeredStepTypes() []string {
+	stepTypesMx.RLock()
+	defer stepTypesMx.RUnlock()
+	var names []string
+	for n := range stepTypes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UnmarshalJSON unmarshals the built-in fields of a step, and the keys of
+// registered step types into Custom.
+func (s *Step) UnmarshalJSON(b []byte) error {
+	// aStep has no methods, unmarshalling into it doesn't recurse.
+	type aStep Step
+	if err := json.Unmarshal(b, (*aStep)(s)); err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	for k, v := range raw {
+		name, factory, ok := lookupStepType(k)
+		if !ok {
+			continue
+		}
+		st := factory()
+		if err := json.Unmarshal(v, st); err != nil {
+			return fmt.Errorf("error unmarshalling step type %q: %v", name, err)
+		}
+		if s.Custom == nil {
+			s.Custom = map[string]StepType{}
+		}
+		s.Custom[name] = st
+	}
+	return nil
+}
+
+// MarshalJSON marshals a step, including its registered step types.
+func (s *Step) MarshalJSON() ([]byte, error) {
+	type aStep Step
+	b, err := json.Marshal((*aStep)(s))
+	if err != nil || len(s.Custom) == 0 {
+		return b, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	for name, st := range s.Custom {
+		m[name] = st
+	}
+	return json.Marshal(m)
+}
+
+// customStep runs a registered step type as a stepImpl.
+type customStep struct {
+	StepType
+}
+
+func (c customStep) populate(ctx context.Context, s *Step) dErr {
+	return newErr(c.Populate(ctx, s))
+}
+
+func (c customStep) validate(ctx context.Context, s *Step) dErr {
+	return newErr(c.Validate(ctx, s))
+}
+
+func (c customStep) run(ctx context.Context, s *Step) dErr {
+	return newErr(c.Run(ctx, s))
+}
+
+// Name returns the name of the step.
+func (s *Step) Name() string {
+	return s.name
+}
+
+// Workflow returns the workflow the step is in.
+func (s *Step) Workflow() *Workflow {
+	return s.w
+}
+
+// Logf writes to the workflow's log, prefixed with the step's name.
+func (s *Step) Logf(format string, a ...interface{}) {
+	s.w.logger.Printf("%s: %s", s.name, fmt.Sprintf(format, a...))
+}
+
+// resourceRegistry returns the registry of the step's resources of kind,
+// "disk", "image" or "instance". Registries are added as workflows populate,
+// take the locks to read them.
+func (s *Step) resourceRegistry(kind string) (*baseResourceRegistry, error) {
+	switch kind {
+	case "disk":
+		disksMu.Lock()
+		defer disksMu.Unlock()
+		return &disks[s.w].baseResourceRegistry, nil
+	case "image":
+		imagesMu.Lock()
+		defer imagesMu.Unlock()
+		return &images[s.w].baseResourceRegistry, nil
+	case "instance":
+		instancesMu.Lock()
+		defer instancesMu.Unlock()
+		return &instances[s.w].baseResourceRegistry, nil
+	}
+	return nil, fmt.Errorf("unknown resource kind %q, must be disk, image or instance", kind)
+}
+
+// CreatesResource registers, from Validate, that the step creates the disk
+// or image name, whose URL is link. Later steps reference the resource by
+// name, and it is deleted when the workflow finishes unless noCleanup is set.
+// Instances are only created by CreateInstances steps.
+func (s *Step) CreatesResource(kind, name, link string, noCleanup bool) error {
+	if kind == "instance" {
+		return fmt.Errorf("cannot create instance %q, instances are only created by CreateInstances steps", name)
+	}
+	r, err := s.resourceRegistry(kind)
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(link, "/")
+	res := &resource{real: parts[len(parts)-1], link: link, noCleanup: noCleanup}
+	if err := r.registerCreation(name, res, s, false); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UsesResource registers, from Validate, that the step uses the disk, image
+// or instance name, created by the workflow, or the URL of an existing one.
+// The step must depend on the step creating it, and steps deleting it must
+// depend on the step.
+func (s *Step) UsesResource(kind, name string) error {
+	r, err := s.resourceRegistry(kind)
+	if err != nil {
+		return err
+	}
+	if _, err := r.registerUsage(name, s); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ResourceURL returns, from Run, the URL of the disk, image or instance name
+// the step registered with CreatesResource or UsesResource.
+func (s *Step) ResourceURL(kind, name string) (string, bool) {
+	r, err := s.resourceRegistry(kind)
+	if err != nil {
+		return "", false
+	}
+	res, ok := r.get(name)
+	if !ok {
+		return "", false
+	}
+	res.mx.Lock()
+	defer res.mx.Unlock()
+	return res.link, true
+}
+
+// AddCleanupHook adds a function to run when the workflow finishes, to
+// delete the resources the step created other than those registered with
+// CreatesResource. Errors are logged.
+func (s *Step) AddCleanupHook(f func() error) {
+	// Included workflows share their parent's resources and are cleaned up
+	// with it.
+	w := s.w
+	disksMu.Lock()
+	for w.parent != nil && disks[w] == disks[w.parent] {
+		w = w.parent
+	}
+	disksMu.Unlock()
+	w.addCleanupHook(func() dErr { return newErr(f()) })
+}
@@ -0,0 +1,348 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// testArtifact is a step type registered for the tests, as a library user
+// would register one.
+type testArtifact struct {
+	Source string
+	Dest   string `json:",omitempty"`
+
+	calls []string
+}
+
+func (a *testArtifact) Populate(ctx context.Context, s *Step) error {
+	a.calls = append(a.calls, "populate")
+	if a.Dest == "" {
+		a.Dest = "gs://default/" + a.Source
+	}
+	return nil
+}
+
+func (a *testArtifact) Validate(ctx context.Context, s *Step) error {
+	a.calls = append(a.calls, "validate")
+	if a.Source == "" {
+		return errors.New("no Source")
+	}
+	return nil
+}
+
+func (a *testArtifact) Run(ctx context.Context, s *Step) error {
+	a.calls = append(a.calls, "run")
+	s.AddCleanupHook(func() error {
+		a.calls = append(a.calls, "cleanup")
+		return nil
+	})
+	return nil
+}
+
+// testDiskCopy is a step type that creates a disk from a disk of the
+// workflow, registering both.
+type testDiskCopy struct {
+	Disk, Name string
+
+	source string
+}
+
+func (c *testDiskCopy) Populate(ctx context.Context, s *Step) error { return nil }
+
+func (c *testDiskCopy) Validate(ctx context.Context, s *Step) error {
+	if err := s.UsesResource("disk", c.Disk); err != nil {
+		return err
+	}
+	w := s.Workflow()
+	return s.CreatesResource("disk", c.Name, fmt.Sprintf("projects/%s/zones/%s/disks/%s", w.Project, w.Zone, c.Name), false)
+}
+
+func (c *testDiskCopy) Run(ctx context.Context, s *Step) error {
+	c.source, _ = s.ResourceURL("disk", c.Disk)
+	w := s.Workflow()
+	return w.ComputeClient.CreateDisk(w.Project, w.Zone, &compute.Disk{Name: c.Name, Description: "Copy of " + c.source})
+}
+
+func init() {
+	RegisterStepType("TestArtifact", func() StepType { return &testArtifact{} })
+	RegisterStepType("TestDiskCopy", func() StepType { return &testDiskCopy{} })
+}
+
+func writeTestWorkflow(t *testing.T, name, data string) string {
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	tf := filepath.Join(td, name)
+	if err := ioutil.WriteFile(tf, []byte(data), 0600); err != nil {
+		t.Fatalf("error writing workflow: %v", err)
+	}
+	return tf
+}
+
+func TestRegisterStepTypePanics(t *testing.T) {
+	for _, name := range []string{"testartifact", "CreateDisks", "timeout"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: RegisterStepType should have panicked", name)
+				}
+			}()
+			RegisterStepType(name, func() StepType { return &testArtifact{} })
+		}()
+	}
+}
+
+func TestCustomStepRun(t *testing.T) {
+	tf := writeTestWorkflow(t, "custom.wf.json", `{
+  "Name": "custom",
+  "Vars": {"src": "image.tar.gz"},
+  "Steps": {
+    "push": {"testArtifact": {"Source": "${src}"}},
+    "push-to": {"TestArtifact": {"Source": "${src}", "Dest": "gs://bucket/${NAME}"}}
+  }
+}`)
+	defer os.RemoveAll(filepath.Dir(tf))
+
+	w, err := NewFromFile(tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := daisyCompute.NewFakeClient()
+	c.Permissive = true
+	c.AddProject("fake-project", "fake-zone")
+	w.Project = "fake-project"
+	w.Zone = "fake-zone"
+	w.GCSPath = testGCSPath
+	w.ComputeClient = c
+	w.StorageClient, _ = newTestGCSClient()
+	w.logger = log.New(ioutil.Discard, "", 0)
+
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatalf("error running workflow: %v", err)
+	}
+
+	want := map[string]*testArtifact{
+		"push":    {Source: "image.tar.gz", Dest: "gs://default/image.tar.gz"},
+		"push-to": {Source: "image.tar.gz", Dest: "gs://bucket/custom"},
+	}
+	for name, wantA := range want {
+		got, ok := w.Steps[name].Custom["TestArtifact"].(*testArtifact)
+		if !ok {
+			t.Errorf("%s: TestArtifact not set: %+v", name, w.Steps[name].Custom)
+			continue
+		}
+		wantA.calls = []string{"populate", "validate", "run", "cleanup"}
+		if diff := pretty.Compare(got, wantA); diff != "" {
+			t.Errorf("%s: step does not match expectation: (-got +want)\n%s", name, diff)
+		}
+	}
+}
+
+func TestCustomStepResources(t *testing.T) {
+	tests := []struct {
+		desc, deps, wantErr string
+	}{
+		{"ordered case", `"copy": ["disk"], "delete": ["copy"]`, ""},
+		{"unordered case", `"copy": ["disk"], "delete": ["disk"]`, `MUST transitively depend on`},
+	}
+	for _, tt := range tests {
+		w := fakeTestWorkflow(t, `{
+  "Name": "custom",
+  "Steps": {
+    "disk": {"CreateDisks": [{"Name": "d", "SizeGb": "10"}]},
+    "copy": {"TestDiskCopy": {"Disk": "d", "Name": "copy"}},
+    "delete": {"DeleteResources": {"Disks": ["d"]}}
+  },
+  "Dependencies": {`+tt.deps+`}
+}`, nil)
+		defer os.RemoveAll(w.workflowDir)
+
+		err := w.Run(context.Background())
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got error %v, want error containing %q", tt.desc, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: error running workflow: %v", tt.desc, err)
+		}
+		c := w.Steps["copy"].Custom["TestDiskCopy"].(*testDiskCopy)
+		if want := "projects/fake-project/zones/fake-zone/disks/" + w.genName("d"); c.source != want {
+			t.Errorf("%s: source disk: got %q, want %q", tt.desc, c.source, want)
+		}
+		if _, err := w.ComputeClient.GetDisk("fake-project", "fake-zone", "copy"); err == nil {
+			t.Errorf("%s: created disk not deleted by cleanup", tt.desc)
+		}
+	}
+}
+
+func TestResourceRegistryConcurrentWorkflows(t *testing.T) {
+	w := testWorkflow()
+	initDiskRegistry(w)
+	initImageRegistry(w)
+	initInstanceRegistry(w)
+	s := &Step{name: "custom", w: w}
+
+	// Other workflows add and remove their registries while the step looks
+	// up its own, run with -race.
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	started := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for i := 0; ; i++ {
+			if i == 1 {
+				close(started)
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+			other := &Workflow{}
+			initDiskRegistry(other)
+			initImageRegistry(other)
+			initInstanceRegistry(other)
+			disksMu.Lock()
+			delete(disks, other)
+			disksMu.Unlock()
+			imagesMu.Lock()
+			delete(images, other)
+			imagesMu.Unlock()
+			instancesMu.Lock()
+			delete(instances, other)
+			instancesMu.Unlock()
+		}
+	}()
+	<-started
+	for i := 0; i < 1000; i++ {
+		for _, kind := range []string{"disk", "image", "instance"} {
+			if r, err := s.resourceRegistry(kind); err != nil || r.w != w {
+				t.Fatalf("got %s registry of workflow %p, error %v, want the registry of %p", kind, r.w, err, w)
+			}
+		}
+	}
+	close(done)
+	<-stopped
+}
+
+func TestCustomStepErrors(t *testing.T) {
+	tests := []struct{ desc, step, error string }{
+		{"validation error", `{"TestArtifact": {}}`, `no Source`},
+		{"multiple step types", `{"TestArtifact": {"Source": "a"}, "CreateDisks": []}`, `multiple step types defined`},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		s, _ := w.NewStep("s")
+		if err := json.Unmarshal([]byte(tt.step), s); err != nil {
+			t.Fatalf("%s: error unmarshalling step: %v", tt.desc, err)
+		}
+		err := w.populate(context.Background())
+		if err == nil {
+			err = w.validate(context.Background())
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.error) {
+			t.Errorf("%s: got error %v, want error containing %q", tt.desc, err, tt.error)
+		}
+	}
+}
+
+func TestCustomStepUnknownFields(t *testing.T) {
+	tf := writeTestWorkflow(t, "custom.wf.json", `{
+  "Name": "custom",
+  "Steps": {
+    "push": {"TestArtifact": {"Source": "a", "Dst": "b"}}
+  }
+}`)
+	defer os.RemoveAll(filepath.Dir(tf))
+
+	want := tf + `:4:46: unknown field "Dst" in Steps.push.TestArtifact`
+	if _, err := NewFromFile(tf); err == nil || err.Error() != want {
+		t.Errorf("did not get expected error from NewFromFile():\ngot: %v\nwant: %q", err, want)
+	}
+}
+
+func TestCustomStepMarshalJSON(t *testing.T) {
+	s := &Step{Timeout: "1m", Custom: map[string]StepType{"TestArtifact": &testArtifact{Source: "a"}}}
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("error marshalling step: %v", err)
+	}
+	want := `{"TestArtifact":{"Source":"a"},"Timeout":"1m"}`
+	if string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+}
+
+func TestAddCleanupHookIncludedWorkflow(t *testing.T) {
+	w := testWorkflow()
+	iw := w.NewIncludedWorkflow()
+	sw := w.NewSubWorkflow()
+	for _, cw := range []*Workflow{w, iw, sw} {
+		initWorkflowResources(cw)
+	}
+	shareWorkflowResources(w, iw)
+	wHooks, iwHooks, swHooks := len(w.cleanupHooks), len(iw.cleanupHooks), len(sw.cleanupHooks)
+
+	is := &Step{w: iw}
+	is.AddCleanupHook(func() error { return nil })
+	ss := &Step{w: sw}
+	ss.AddCleanupHook(func() error { return nil })
+
+	if len(w.cleanupHooks) != wHooks+1 || len(iw.cleanupHooks) != iwHooks {
+		t.Error("hook of an included workflow step should be added to the parent")
+	}
+	if len(sw.cleanupHooks) != swHooks+1 {
+		t.Error("hook of a sub workflow step should be added to the sub workflow")
+	}
+}
+
+func TestSchemaCustomStep(t *testing.T) {
+	b, err := Schema()
+	if err != nil {
+		t.Fatalf("error generating schema: %v", err)
+	}
+	var s struct {
+		Definitions map[string]struct {
+			Properties map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatalf("error unmarshalling schema: %v", err)
+	}
+	want := map[string]interface{}{"$ref": "#/definitions/testArtifact"}
+	if diff := pretty.Compare(s.Definitions["Step"].Properties["TestArtifact"], want); diff != "" {
+		t.Errorf("TestArtifact does not match expectation: (-got +want)\n%s", diff)
+	}
+	if _, ok := s.Definitions["testArtifact"].Properties["Dest"]; !ok {
+		t.Error("testArtifact definition should have Dest")
+	}
+}
@@ -21,15 +21,21 @@ import (
 
 // SubWorkflow defines a Daisy sub workflow.
 type SubWorkflow struct {
-	Path     string
-	Vars     map[string]string `json:",omitempty"`
+	// Local path, relative to the parent workflow's, or gs:// or https://
+	// URL of the workflow file.
+	Path string
+	// SHA-256 the workflow file must have, in hex.
+	SHA256 string            `json:",omitempty"`
+	Vars   map[string]string `json:",omitempty"`
+	// Expands the step into one workflow per set of vars.
+	ForEach  *ForEach `json:",omitempty"`
 	Workflow *Workflow
 }
 
 func (s *SubWorkflow) populate(ctx context.Context, st *Step) dErr {
 	if s.Path != "" {
-		var err error
-		if s.Workflow, err = st.w.NewSubWorkflowFromFile(s.Path); err != nil {
+		s.Workflow = st.w.NewSubWorkflow()
+		if err := st.w.readChildWorkflow(ctx, s.Workflow, s.Path, s.SHA256); err != nil {
 			return newErr(err)
 		}
 	}
@@ -39,16 +45,20 @@ func (s *SubWorkflow) populate(ctx context.Context, st *Step) dErr {
 	}
 
 	s.Workflow.parent = st.w
+	if st.w.checkpoint != nil {
+		s.Workflow.checkpoint = st.w.checkpoint.Workflows[st.name]
+	}
 	s.Workflow.GCSPath = fmt.Sprintf("gs://%s/%s", s.Workflow.parent.bucket, s.Workflow.parent.scratchPath)
 	s.Workflow.Name = st.name
 	s.Workflow.Project = s.Workflow.parent.Project
 	s.Workflow.Zone = s.Workflow.parent.Zone
+	s.Workflow.FallbackZones = s.Workflow.parent.FallbackZones
 	s.Workflow.OAuthPath = s.Workflow.parent.OAuthPath
 	s.Workflow.ComputeClient = s.Workflow.parent.ComputeClient
 	s.Workflow.StorageClient = s.Workflow.parent.StorageClient
 	s.Workflow.gcsLogWriter = s.Workflow.parent.gcsLogWriter
 	for k, v := range s.Vars {
-		s.Workflow.Vars[k] = wVar{Value: v}
+		s.Workflow.AddVar(k, v)
 	}
 	return s.Workflow.populate(ctx)
 }
@@ -57,9 +67,15 @@ func (s *SubWorkflow) validate(ctx context.Context, st *Step) dErr {
 	return s.Workflow.validate(ctx)
 }
 
-func (s *SubWorkflow) run(ctx context.Context, st *Step) dErr {
+func (s *SubWorkflow) run(ctx context.Context, st *Step) (err dErr) {
 	// Prerun work has already been done. Just run(), not Run().
-	defer s.Workflow.cleanup()
+	defer func() {
+		// The resources of a failed subworkflow may be kept for resume or
+		// KeepOnFailure, the workflow cleans them up if not.
+		if err == nil || !(st.w.keepsOnFailure() || st.w.keepsForResume()) {
+			s.Workflow.cleanup()
+		}
+	}()
 	// If the workflow fails before the subworkflow completes, the previous
 	// "defer" cleanup won't happen. Add a failsafe here, have the workflow
 	// also call this subworkflow's cleanup.
@@ -71,7 +87,11 @@ func (s *SubWorkflow) run(ctx context.Context, st *Step) dErr {
 	st.w.logger.Printf("Running subworkflow %q", s.Workflow.Name)
 	if err := s.Workflow.run(ctx); err != nil {
 		s.Workflow.logger.Printf("Error running subworkflow %q: %v", s.Workflow.Name, err)
-		close(st.w.Cancel)
+		// A step that may run again, or a workflow with handler steps to run,
+		// leaves canceling to the workflow.
+		if !st.retryable() && !st.w.hasHandlers() {
+			close(st.w.Cancel)
+		}
 		return err
 	}
 	return nil
@@ -15,7 +15,10 @@
 package daisy
 
 import (
+	"context"
 	"reflect"
+	"sort"
+	"sync"
 	"testing"
 )
 
@@ -221,3 +224,56 @@ func TestStepImpl(t *testing.T) {
 		t.Fatal("malformed step should have thrown an error")
 	}
 }
+
+func TestStepIf(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	var ran []string
+	var mx sync.Mutex
+	impl := func() *mockStep {
+		return &mockStep{
+			validateImpl: func(_ context.Context, s *Step) dErr {
+				if s.skipped {
+					t.Errorf("skipped step %q was validated", s.name)
+				}
+				return nil
+			},
+			runImpl: func(_ context.Context, s *Step) dErr {
+				mx.Lock()
+				defer mx.Unlock()
+				ran = append(ran, s.name)
+				return nil
+			},
+		}
+	}
+	w.Vars = map[string]wVar{"license": {Value: "byol"}}
+	w.Steps = map[string]*Step{
+		"byol":     {If: `"${license}" == byol`, testType: impl()},
+		"licensed": {If: `"${license}" != byol`, testType: impl()},
+		"after":    {testType: impl()},
+	}
+	w.Dependencies = map[string][]string{"after": {"byol", "licensed"}}
+
+	if err := w.populate(ctx); err != nil {
+		t.Fatalf("error populating workflow: %v", err)
+	}
+	if w.Steps["byol"].skipped || !w.Steps["licensed"].skipped {
+		t.Errorf("unexpected skipped steps: byol: %t, licensed: %t", w.Steps["byol"].skipped, w.Steps["licensed"].skipped)
+	}
+	if err := w.validate(ctx); err != nil {
+		t.Fatalf("error validating workflow: %v", err)
+	}
+	if err := w.run(ctx); err != nil {
+		t.Fatalf("error running workflow: %v", err)
+	}
+	sort.Strings(ran)
+	if want := []string{"after", "byol"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran steps: got %v, want %v", ran, want)
+	}
+
+	w = testWorkflow()
+	w.Steps = map[string]*Step{"bad": {If: "maybe", testType: impl()}}
+	if err := w.populate(ctx); err == nil {
+		t.Error("expected error for bad If condition")
+	}
+}
@@ -17,6 +17,7 @@ package daisy
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -40,6 +41,11 @@ type SerialOutput struct {
 	SuccessMatch string
 	FailureMatch string
 	StatusMatch  string
+	// Step outputs to set from the serial output, output name to regexp.
+	// The output is set to the first submatch of the first matching line,
+	// or to the whole match if the regexp has no submatch.
+	Outputs map[string]string `json:",omitempty"`
+	outputs map[string]*regexp.Regexp
 }
 
 // InstanceSignal waits for a signal from an instance.
@@ -56,13 +62,15 @@ type InstanceSignal struct {
 	SerialOutput *SerialOutput
 }
 
-func waitForInstanceStopped(w *Workflow, project, zone, name string, interval time.Duration) dErr {
+func waitForInstanceStopped(ctx context.Context, w *Workflow, project, zone, name string, interval time.Duration) dErr {
 	w.logger.Printf("WaitForInstancesSignal: waiting for instance %q to stop.", name)
 	tick := time.Tick(interval)
 	for {
 		select {
 		case <-w.Cancel:
 			return nil
+		case <-ctx.Done():
+			return newErr(ctx.Err())
 		case <-tick:
 			stopped, err := w.ComputeClient.InstanceStopped(project, zone, name)
 			if err != nil {
@@ -76,7 +84,8 @@ func waitForInstanceStopped(w *Workflow, project, zone, name string, interval ti
 	}
 }
 
-func waitForSerialOutput(w *Workflow, project, zone, name string, so *SerialOutput, interval time.Duration) dErr {
+func waitForSerialOutput(ctx context.Context, s *Step, daisyName, project, zone, name string, so *SerialOutput, interval time.Duration) dErr {
+	w := s.w
 	msg := fmt.Sprintf("WaitForInstancesSignal: watching serial port %d", so.Port)
 	if so.SuccessMatch != "" {
 		msg += fmt.Sprintf(", SuccessMatch: %q", so.SuccessMatch)
@@ -90,11 +99,14 @@ func waitForSerialOutput(w *Workflow, project, zone, name string, so *SerialOutp
 	w.logger.Print(msg + ".")
 	var start int64
 	var errs int
+	found := map[string]string{}
 	tick := time.Tick(interval)
 	for {
 		select {
 		case <-w.Cancel:
 			return nil
+		case <-ctx.Done():
+			return newErr(ctx.Err())
 		case <-tick:
 			resp, err := w.ComputeClient.GetSerialPortOutput(project, zone, name, so.Port, start)
 			if err != nil {
@@ -119,23 +131,39 @@ func waitForSerialOutput(w *Workflow, project, zone, name string, so *SerialOutp
 					continue
 				}
 
-				return errf("WaitForInstancesSignal: instance %q: error getting serial port: %v", name, err)
+				return typedErrf(apiError, "WaitForInstancesSignal: instance %q: error getting serial port: %v", name, err)
 			}
 			start = resp.Next
 			for _, ln := range strings.Split(resp.Contents, "\n") {
 				if so.StatusMatch != "" {
 					if i := strings.Index(ln, so.StatusMatch); i != -1 {
 						w.logger.Printf("WaitForInstancesSignal: StatusMatch found for %q: %q", name, strings.TrimSpace(ln[i:]))
+						w.emit(&Event{Type: SerialOutputMatch, Step: s.name, Resource: name, MatchType: "StatusMatch", Match: strings.TrimSpace(ln[i:])})
+					}
+				}
+				for o, re := range so.outputs {
+					if _, ok := found[o]; ok {
+						continue
+					}
+					if m := re.FindStringSubmatch(ln); m != nil {
+						found[o] = m[0]
+						if len(m) > 1 {
+							found[o] = m[1]
+						}
+						w.logger.Printf("WaitForInstancesSignal: output %q of %q: %q", o, name, found[o])
+						s.setOutput(daisyName+"."+o, found[o])
 					}
 				}
 				if so.FailureMatch != "" {
 					if i := strings.Index(ln, so.FailureMatch); i != -1 {
-						return errf("WaitForInstancesSignal: FailureMatch found for %q: %q", name, strings.TrimSpace(ln[i:]))
+						w.emit(&Event{Type: SerialOutputMatch, Step: s.name, Resource: name, MatchType: "FailureMatch", Match: strings.TrimSpace(ln[i:])})
+						return typedErrf(failureMatchError, "WaitForInstancesSignal: FailureMatch found for %q: %q", name, strings.TrimSpace(ln[i:]))
 					}
 				}
 				if so.SuccessMatch != "" {
 					if i := strings.Index(ln, so.SuccessMatch); i != -1 {
 						w.logger.Printf("WaitForInstancesSignal: SuccessMatch found for %q: %q", name, strings.TrimSpace(ln[i:]))
+						w.emit(&Event{Type: SerialOutputMatch, Step: s.name, Resource: name, MatchType: "SuccessMatch", Match: strings.TrimSpace(ln[i:])})
 						return nil
 					}
 				}
@@ -155,6 +183,16 @@ func (w *WaitForInstancesSignal) populate(ctx context.Context, s *Step) dErr {
 		if err != nil {
 			return newErr(err)
 		}
+		if ws.SerialOutput != nil && len(ws.SerialOutput.Outputs) > 0 {
+			ws.SerialOutput.outputs = map[string]*regexp.Regexp{}
+			for o, expr := range ws.SerialOutput.Outputs {
+				re, err := regexp.Compile(expr)
+				if err != nil {
+					return errf("bad regexp for output %q: %v", o, err)
+				}
+				ws.SerialOutput.outputs[o] = re
+			}
+		}
 	}
 	return nil
 }
@@ -177,7 +215,7 @@ func (w *WaitForInstancesSignal) run(ctx context.Context, s *Step) dErr {
 			stoppedSig := make(chan struct{})
 			if is.Stopped {
 				go func() {
-					if err := waitForInstanceStopped(s.w, m["project"], m["zone"], m["instance"], is.interval); err != nil {
+					if err := waitForInstanceStopped(ctx, s.w, m["project"], m["zone"], m["instance"], is.interval); err != nil {
 						e <- err
 					}
 					close(stoppedSig)
@@ -185,7 +223,7 @@ func (w *WaitForInstancesSignal) run(ctx context.Context, s *Step) dErr {
 			}
 			if is.SerialOutput != nil {
 				go func() {
-					if err := waitForSerialOutput(s.w, m["project"], m["zone"], m["instance"], is.SerialOutput, is.interval); err != nil {
+					if err := waitForSerialOutput(ctx, s, is.Name, m["project"], m["zone"], m["instance"], is.SerialOutput, is.interval); err != nil {
 						e <- err
 					}
 					close(serialSig)
@@ -45,7 +45,7 @@ func TestWaitForInstanceStopped(t *testing.T) {
 	defer svr.Close()
 
 	w.ComputeClient = c
-	if err := waitForInstanceStopped(w, testProject, testZone, "foo", 1*time.Microsecond); err != nil {
+	if err := waitForInstanceStopped(context.Background(), w, testProject, testZone, "foo", 1*time.Microsecond); err != nil {
 		t.Fatalf("error running waitForInstanceStopped: %v", err)
 	}
 }
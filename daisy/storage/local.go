@@ -0,0 +1,222 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// LocalClient is a Client backed by a local directory: bucket directories
+// are in the root directory, gs://bucket/object maps to ROOT/bucket/object.
+// Buckets are not tied to projects and ACLs are ignored.
+type LocalClient struct {
+	root string
+}
+
+// NewLocalClient returns a LocalClient rooted at dir, dir is created if it
+// does not exist.
+func NewLocalClient(dir string) (*LocalClient, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalClient{root: dir}, nil
+}
+
+func notFound(format string, a ...interface{}) error {
+	return &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf(format, a...)}
+}
+
+func (c *LocalClient) bucketPath(bucket string) (string, error) {
+	if bucket == "" || strings.ContainsAny(bucket, `/\`) || bucket == "." || bucket == ".." {
+		return "", &googleapi.Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("invalid bucket name %q", bucket)}
+	}
+	p := filepath.Join(c.root, bucket)
+	if fi, err := os.Stat(p); err != nil || !fi.IsDir() {
+		return "", notFound("bucket %q not found", bucket)
+	}
+	return p, nil
+}
+
+func (c *LocalClient) objectPath(bucket, object string) (string, error) {
+	bp, err := c.bucketPath(bucket)
+	if err != nil {
+		return "", err
+	}
+	clean := path.Clean("/" + object)
+	if object == "" || clean == "/" {
+		return "", &googleapi.Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("invalid object name %q", object)}
+	}
+	return filepath.Join(bp, filepath.FromSlash(clean)), nil
+}
+
+// ListBuckets lists all buckets.
+func (c *LocalClient) ListBuckets(ctx context.Context, project string) ([]*storage.BucketAttrs, error) {
+	fis, err := ioutil.ReadDir(c.root)
+	if err != nil {
+		return nil, err
+	}
+	var bs []*storage.BucketAttrs
+	for _, fi := range fis {
+		if fi.IsDir() {
+			bs = append(bs, &storage.BucketAttrs{Name: fi.Name(), Created: fi.ModTime()})
+		}
+	}
+	return bs, nil
+}
+
+// GetBucket gets the attributes of a bucket.
+func (c *LocalClient) GetBucket(ctx context.Context, bucket string) (*storage.BucketAttrs, error) {
+	bp, err := c.bucketPath(bucket)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(bp)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.BucketAttrs{Name: bucket, Created: fi.ModTime()}, nil
+}
+
+// CreateBucket creates a bucket.
+func (c *LocalClient) CreateBucket(ctx context.Context, project, bucket string) error {
+	if _, err := c.bucketPath(bucket); err == nil {
+		return &googleapi.Error{Code: http.StatusConflict, Message: fmt.Sprintf("bucket %q already exists", bucket)}
+	} else if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != http.StatusNotFound {
+		return err
+	}
+	return os.Mkdir(filepath.Join(c.root, bucket), 0755)
+}
+
+// ListObjects lists the objects in a bucket starting with prefix.
+func (c *LocalClient) ListObjects(ctx context.Context, bucket, prefix string) ([]*storage.ObjectAttrs, error) {
+	bp, err := c.bucketPath(bucket)
+	if err != nil {
+		return nil, err
+	}
+	var objs []*storage.ObjectAttrs
+	err = filepath.Walk(bp, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(bp, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if strings.HasPrefix(name, prefix) {
+			objs = append(objs, &storage.ObjectAttrs{Bucket: bucket, Name: name, Size: fi.Size(), Updated: fi.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Name < objs[j].Name })
+	return objs, nil
+}
+
+// NewReader returns a reader for an object.
+func (c *LocalClient) NewReader(ctx context.Context, bucket, object string) (io.ReadCloser, error) {
+	op, err := c.objectPath(bucket, object)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(op)
+	if os.IsNotExist(err) {
+		return nil, notFound("object %q not found in bucket %q", object, bucket)
+	}
+	return f, err
+}
+
+type localWriter struct {
+	c              *LocalClient
+	bucket, object string
+	buf            bytes.Buffer
+}
+
+func (w *localWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+func (w *localWriter) Close() error {
+	op, err := w.c.objectPath(w.bucket, w.object)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(op), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(op, w.buf.Bytes(), 0644)
+}
+
+// NewWriter returns a writer for an object, contentType is ignored.
+func (c *LocalClient) NewWriter(ctx context.Context, bucket, object, contentType string) io.WriteCloser {
+	return &localWriter{c: c, bucket: bucket, object: object}
+}
+
+// CopyObject copies an object.
+func (c *LocalClient) CopyObject(ctx context.Context, srcBucket, srcObject, dstBucket, dstObject string) error {
+	r, err := c.NewReader(ctx, srcBucket, srcObject)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w := c.NewWriter(ctx, dstBucket, dstObject, "")
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// DeleteObject deletes an object.
+func (c *LocalClient) DeleteObject(ctx context.Context, bucket, object string) error {
+	op, err := c.objectPath(bucket, object)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(op)
+	if os.IsNotExist(err) {
+		return notFound("object %q not found in bucket %q", object, bucket)
+	}
+	return err
+}
+
+// SetObjectACL checks that the object exists, ACLs are not kept.
+func (c *LocalClient) SetObjectACL(ctx context.Context, bucket, object string, entity storage.ACLEntity, role storage.ACLRole) error {
+	op, err := c.objectPath(bucket, object)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(op); os.IsNotExist(err) {
+		return notFound("object %q not found in bucket %q", object, bucket)
+	}
+	return nil
+}
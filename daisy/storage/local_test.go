@@ -0,0 +1,148 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+var _ Client = &LocalClient{}
+
+func errCode(err error) int {
+	if apiErr, ok := err.(*googleapi.Error); ok {
+		return apiErr.Code
+	}
+	return 0
+}
+
+func newTestLocalClient(t *testing.T) (*LocalClient, string) {
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	c, err := NewLocalClient(td)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	return c, td
+}
+
+func write(t *testing.T, c Client, bkt, obj, content string) {
+	w := c.NewWriter(context.Background(), bkt, obj, "text/plain")
+	if _, err := w.Write([]byte(content)); err != nil {
+		t.Fatalf("error writing %s/%s: %v", bkt, obj, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error closing %s/%s: %v", bkt, obj, err)
+	}
+}
+
+func read(t *testing.T, c Client, bkt, obj string) string {
+	r, err := c.NewReader(context.Background(), bkt, obj)
+	if err != nil {
+		t.Fatalf("error reading %s/%s: %v", bkt, obj, err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("error reading %s/%s: %v", bkt, obj, err)
+	}
+	return string(b)
+}
+
+func TestLocalClientBuckets(t *testing.T) {
+	ctx := context.Background()
+	c, td := newTestLocalClient(t)
+	defer os.RemoveAll(td)
+
+	if _, err := c.GetBucket(ctx, "bkt"); errCode(err) != http.StatusNotFound {
+		t.Errorf("missing bucket: got error %v, want 404", err)
+	}
+	if err := c.CreateBucket(ctx, "project", "bkt"); err != nil {
+		t.Fatalf("error creating bucket: %v", err)
+	}
+	if err := c.CreateBucket(ctx, "project", "bkt"); errCode(err) != http.StatusConflict {
+		t.Errorf("duplicate bucket: got error %v, want 409", err)
+	}
+	if err := c.CreateBucket(ctx, "project", "../bkt"); errCode(err) != http.StatusBadRequest {
+		t.Errorf("bad bucket name: got error %v, want 400", err)
+	}
+	if _, err := c.GetBucket(ctx, "bkt"); err != nil {
+		t.Errorf("error getting bucket: %v", err)
+	}
+	bs, err := c.ListBuckets(ctx, "project")
+	if err != nil || len(bs) != 1 || bs[0].Name != "bkt" {
+		t.Errorf("want bucket bkt, got %v, error: %v", bs, err)
+	}
+}
+
+func TestLocalClientObjects(t *testing.T) {
+	ctx := context.Background()
+	c, td := newTestLocalClient(t)
+	defer os.RemoveAll(td)
+	c.CreateBucket(ctx, "", "bkt")
+	c.CreateBucket(ctx, "", "bkt2")
+
+	write(t, c, "bkt", "dir/a", "a")
+	write(t, c, "bkt", "dir/sub/b", "bb")
+	write(t, c, "bkt", "other", "c")
+	if _, err := os.Stat(filepath.Join(td, "bkt", "dir", "sub", "b")); err != nil {
+		t.Errorf("object not written to the bucket directory: %v", err)
+	}
+	// Objects can't escape their bucket.
+	write(t, c, "bkt", "../escape", "d")
+	if _, err := os.Stat(filepath.Join(td, "escape")); !os.IsNotExist(err) {
+		t.Errorf("object written outside of its bucket")
+	}
+
+	objs, err := c.ListObjects(ctx, "bkt", "dir/")
+	if err != nil {
+		t.Fatalf("error listing objects: %v", err)
+	}
+	var names []string
+	for _, o := range objs {
+		names = append(names, o.Name)
+	}
+	if len(names) != 2 || names[0] != "dir/a" || names[1] != "dir/sub/b" || objs[1].Size != 2 {
+		t.Errorf("unexpected objects listed: %v", names)
+	}
+
+	if err := c.CopyObject(ctx, "bkt", "dir/a", "bkt2", "copy/a"); err != nil {
+		t.Fatalf("error copying object: %v", err)
+	}
+	if got := read(t, c, "bkt2", "copy/a"); got != "a" {
+		t.Errorf("copied object: got %q, want %q", got, "a")
+	}
+	if err := c.CopyObject(ctx, "bkt", "dne", "bkt2", "dne"); errCode(err) != http.StatusNotFound {
+		t.Errorf("copying missing object: got error %v, want 404", err)
+	}
+	if err := c.SetObjectACL(ctx, "bkt2", "copy/a", "allUsers", "READER"); err != nil {
+		t.Errorf("error setting ACL: %v", err)
+	}
+
+	if err := c.DeleteObject(ctx, "bkt2", "copy/a"); err != nil {
+		t.Errorf("error deleting object: %v", err)
+	}
+	if _, err := c.NewReader(ctx, "bkt2", "copy/a"); errCode(err) != http.StatusNotFound {
+		t.Errorf("deleted object: got error %v, want 404", err)
+	}
+}
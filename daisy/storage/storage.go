@@ -0,0 +1,125 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package storage provides access to Google Cloud Storage, or to a local
+// directory standing in for it.
+package storage
+
+import (
+	"context"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// Client is a client for interacting with Google Cloud Storage.
+type Client interface {
+	ListBuckets(ctx context.Context, project string) ([]*storage.BucketAttrs, error)
+	GetBucket(ctx context.Context, bucket string) (*storage.BucketAttrs, error)
+	CreateBucket(ctx context.Context, project, bucket string) error
+	ListObjects(ctx context.Context, bucket, prefix string) ([]*storage.ObjectAttrs, error)
+	NewReader(ctx context.Context, bucket, object string) (io.ReadCloser, error)
+	// NewWriter returns a writer for an object, the object is written on
+	// Close. An empty contentType is left to the implementation to detect.
+	NewWriter(ctx context.Context, bucket, object, contentType string) io.WriteCloser
+	CopyObject(ctx context.Context, srcBucket, srcObject, dstBucket, dstObject string) error
+	DeleteObject(ctx context.Context, bucket, object string) error
+	SetObjectACL(ctx context.Context, bucket, object string, entity storage.ACLEntity, role storage.ACLRole) error
+}
+
+type client struct {
+	raw *storage.Client
+}
+
+// NewClient creates a new Google Cloud Storage client.
+func NewClient(ctx context.Context, opts ...option.ClientOption) (Client, error) {
+	c, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &client{raw: c}, nil
+}
+
+// NewClientFromGCS creates a Client using an existing Google Cloud Storage
+// client.
+func NewClientFromGCS(c *storage.Client) Client {
+	return &client{raw: c}
+}
+
+// ListBuckets lists the buckets in a project.
+func (c *client) ListBuckets(ctx context.Context, project string) ([]*storage.BucketAttrs, error) {
+	var bs []*storage.BucketAttrs
+	it := c.raw.Buckets(ctx, project)
+	for attrs, err := it.Next(); err != iterator.Done; attrs, err = it.Next() {
+		if err != nil {
+			return nil, err
+		}
+		bs = append(bs, attrs)
+	}
+	return bs, nil
+}
+
+// GetBucket gets the attributes of a bucket.
+func (c *client) GetBucket(ctx context.Context, bucket string) (*storage.BucketAttrs, error) {
+	return c.raw.Bucket(bucket).Attrs(ctx)
+}
+
+// CreateBucket creates a bucket.
+func (c *client) CreateBucket(ctx context.Context, project, bucket string) error {
+	return c.raw.Bucket(bucket).Create(ctx, project, nil)
+}
+
+// ListObjects lists the objects in a bucket starting with prefix.
+func (c *client) ListObjects(ctx context.Context, bucket, prefix string) ([]*storage.ObjectAttrs, error) {
+	var objs []*storage.ObjectAttrs
+	it := c.raw.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	for attrs, err := it.Next(); err != iterator.Done; attrs, err = it.Next() {
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, attrs)
+	}
+	return objs, nil
+}
+
+// NewReader returns a reader for an object.
+func (c *client) NewReader(ctx context.Context, bucket, object string) (io.ReadCloser, error) {
+	return c.raw.Bucket(bucket).Object(object).NewReader(ctx)
+}
+
+// NewWriter returns a writer for an object.
+func (c *client) NewWriter(ctx context.Context, bucket, object, contentType string) io.WriteCloser {
+	wc := c.raw.Bucket(bucket).Object(object).NewWriter(ctx)
+	wc.ContentType = contentType
+	return wc
+}
+
+// CopyObject copies an object.
+func (c *client) CopyObject(ctx context.Context, srcBucket, srcObject, dstBucket, dstObject string) error {
+	src := c.raw.Bucket(srcBucket).Object(srcObject)
+	_, err := c.raw.Bucket(dstBucket).Object(dstObject).CopierFrom(src).Run(ctx)
+	return err
+}
+
+// DeleteObject deletes an object.
+func (c *client) DeleteObject(ctx context.Context, bucket, object string) error {
+	return c.raw.Bucket(bucket).Object(object).Delete(ctx)
+}
+
+// SetObjectACL sets the role of entity on an object.
+func (c *client) SetObjectACL(ctx context.Context, bucket, object string, entity storage.ACLEntity, role storage.ACLRole) error {
+	return c.raw.Bucket(bucket).Object(object).ACL().Set(ctx, entity, role)
+}
@@ -0,0 +1,127 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+)
+
+// LeakedResource is a disk, image or instance created by a workflow run to be
+// deleted when the run ended, that still exists.
+type LeakedResource struct {
+	// Type is disk, image or instance.
+	Type string
+	Link string
+	// Workflow, RunID and User are the labels daisy set on the resource.
+	Workflow, RunID, User string
+	Created               time.Time
+}
+
+// leaked returns the resource of type typeName at link as a LeakedResource if
+// daisy created it for deletion before now minus olderThan.
+func leaked(typeName, link, created string, labels map[string]string, olderThan time.Duration) (LeakedResource, bool) {
+	if labels[cleanupLabel] != cleanupDelete {
+		return LeakedResource{}, false
+	}
+	t, err := time.Parse(time.RFC3339, created)
+	if err != nil || time.Since(t) < olderThan {
+		return LeakedResource{}, false
+	}
+	return LeakedResource{
+		Type:     typeName,
+		Link:     link,
+		Workflow: labels[workflowLabel],
+		RunID:    labels[runIDLabel],
+		User:     labels[userLabel],
+		Created:  t,
+	}, true
+}
+
+// FindLeaked returns the disks, images and instances of project, created by
+// workflow runs more than olderThan ago, that the runs should have deleted.
+// Resources created with NoCleanup and the resources kept by failed runs are
+// not leaked.
+func FindLeaked(client compute.Client, project string, olderThan time.Duration) ([]LeakedResource, error) {
+	var res []LeakedResource
+	add := func(typeName, link, created string, labels map[string]string) {
+		if r, ok := leaked(typeName, link, created, labels, olderThan); ok {
+			res = append(res, r)
+		}
+	}
+
+	zs, err := client.ListZones(project)
+	if err != nil {
+		return nil, typedErr(apiError, err)
+	}
+	for _, z := range zs {
+		is, err := client.ListInstances(project, z.Name)
+		if err != nil {
+			return nil, typedErr(apiError, err)
+		}
+		for _, i := range is {
+			add("instance", fmt.Sprintf("projects/%s/zones/%s/instances/%s", project, z.Name, i.Name), i.CreationTimestamp, i.Labels)
+		}
+		ds, err := client.ListDisks(project, z.Name)
+		if err != nil {
+			return nil, typedErr(apiError, err)
+		}
+		for _, d := range ds {
+			add("disk", fmt.Sprintf("projects/%s/zones/%s/disks/%s", project, z.Name, d.Name), d.CreationTimestamp, d.Labels)
+		}
+	}
+	is, err := client.ListImages(project)
+	if err != nil {
+		return nil, typedErr(apiError, err)
+	}
+	for _, i := range is {
+		add("image", fmt.Sprintf("projects/%s/global/images/%s", project, i.Name), i.CreationTimestamp, i.Labels)
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Type != res[j].Type {
+			return deletionOrder[res[i].Type] < deletionOrder[res[j].Type]
+		}
+		return res[i].Link < res[j].Link
+	})
+	return res, nil
+}
+
+// DeleteLeaked deletes resources, as returned by FindLeaked. Resources that no
+// longer exist are skipped. It returns the resources it deleted.
+func DeleteLeaked(client compute.Client, resources []LeakedResource) ([]LeakedResource, error) {
+	resources = append([]LeakedResource(nil), resources...)
+	sort.SliceStable(resources, func(i, j int) bool {
+		return deletionOrder[resources[i].Type] < deletionOrder[resources[j].Type]
+	})
+	var deleted []LeakedResource
+	var errs dErr
+	for _, r := range resources {
+		if err := deleteResource(client, r.Type, r.Link); err != nil {
+			if err.Type() != resourceDNEError {
+				errs = addErrs(errs, errf("error deleting %s %s: %v", r.Type, r.Link, err))
+			}
+			continue
+		}
+		deleted = append(deleted, r)
+	}
+	if errs != nil {
+		return deleted, errs
+	}
+	return deleted, nil
+}
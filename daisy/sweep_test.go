@@ -0,0 +1,85 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"testing"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestSweep(t *testing.T) {
+	c := daisyCompute.NewFakeClient()
+	c.Permissive = true
+	c.AddProject("p", "z")
+
+	old := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	labels := func(cleanup string) map[string]string {
+		return map[string]string{workflowLabel: "wf", runIDLabel: "abcde", userLabel: "me", cleanupLabel: cleanup}
+	}
+	disks := []*compute.Disk{
+		{Name: "leaked", Labels: labels(cleanupDelete), CreationTimestamp: old.Format(time.RFC3339)},
+		{Name: "kept", Labels: labels(cleanupKeep), CreationTimestamp: old.Format(time.RFC3339)},
+		{Name: "recent", Labels: labels(cleanupDelete)},
+		{Name: "unlabeled", CreationTimestamp: old.Format(time.RFC3339)},
+	}
+	for _, d := range disks {
+		if err := c.CreateDisk("p", "z", d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.CreateImage("p", &compute.Image{Name: "leaked", SourceDisk: "zones/z/disks/kept", Labels: labels(cleanupDelete), CreationTimestamp: old.Format(time.RFC3339)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateInstance("p", "z", &compute.Instance{Name: "leaked", Disks: []*compute.AttachedDisk{{Source: "zones/z/disks/leaked"}}, Labels: labels(cleanupDelete), CreationTimestamp: old.Format(time.RFC3339)}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := FindLeaked(c, "p", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LeakedResource{
+		{Type: "instance", Link: "projects/p/zones/z/instances/leaked", Workflow: "wf", RunID: "abcde", User: "me", Created: old},
+		{Type: "image", Link: "projects/p/global/images/leaked", Workflow: "wf", RunID: "abcde", User: "me", Created: old},
+		{Type: "disk", Link: "projects/p/zones/z/disks/leaked", Workflow: "wf", RunID: "abcde", User: "me", Created: old},
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Fatalf("leaked resources do not match expectation: (-got +want)\n%s", diff)
+	}
+
+	// The instance is deleted out of band, it is skipped.
+	if err := c.DeleteInstance("p", "z", "leaked"); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := DeleteLeaked(c, got)
+	if err != nil {
+		t.Fatalf("error deleting leaked resources: %v", err)
+	}
+	if diff := pretty.Compare(deleted, want[1:]); diff != "" {
+		t.Errorf("deleted resources do not match expectation: (-got +want)\n%s", diff)
+	}
+	if _, err := c.GetDisk("p", "z", "leaked"); err == nil {
+		t.Error("leaked disk should have been deleted")
+	}
+	for _, name := range []string{"kept", "recent", "unlabeled"} {
+		if _, err := c.GetDisk("p", "z", name); err != nil {
+			t.Errorf("disk %q should not have been deleted: %v", name, err)
+		}
+	}
+}
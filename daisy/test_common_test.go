@@ -23,12 +23,15 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
+	"testing"
 
 	"cloud.google.com/go/storage"
 	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	daisyStorage "github.com/GoogleCloudPlatform/compute-image-tools/daisy/storage"
 	compute "google.golang.org/api/compute/v1"
 	"google.golang.org/api/option"
 )
@@ -75,6 +78,40 @@ var (
 	testGCSObjsMx   = sync.Mutex{}
 )
 
+// fakeTestWorkflow writes the workflow wf, and files by name next to
+// it, and reads it with fake clients. Callers remove w.workflowDir.
+// Each call starts a new fake, so it also drops the zones and images cached
+// for the fake project by earlier tests.
+func fakeTestWorkflow(t *testing.T, wf string, files map[string]string) *Workflow {
+	zonesCache.mu.Lock()
+	delete(zonesCache.exists, "fake-project")
+	zonesCache.mu.Unlock()
+	imagesCache.mu.Lock()
+	delete(imagesCache.exists, "fake-project")
+	imagesCache.mu.Unlock()
+
+	tf := writeTestWorkflow(t, "test.wf.json", wf)
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(filepath.Dir(tf), name), []byte(data), 0600); err != nil {
+			t.Fatalf("error writing %s: %v", name, err)
+		}
+	}
+	w, err := NewFromFile(tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := daisyCompute.NewFakeClient()
+	c.Permissive = true
+	c.AddProject("fake-project", "fake-zone")
+	w.Project = "fake-project"
+	w.Zone = "fake-zone"
+	w.GCSPath = testGCSPath
+	w.ComputeClient = c
+	w.StorageClient, _ = newTestGCSClient()
+	w.logger = log.New(ioutil.Discard, "", 0)
+	return w
+}
+
 func testWorkflow() *Workflow {
 	w := New()
 	w.id = "abcdef"
@@ -179,7 +216,7 @@ func newTestGCEClient() (*daisyCompute.TestClient, error) {
 	return c, err
 }
 
-func newTestGCSClient() (*storage.Client, error) {
+func newTestGCSClient() (daisyStorage.Client, error) {
 	nameRgx := regexp.MustCompile(`"name":"([^"].*)"`)
 	rewriteRgx := regexp.MustCompile(`/b/([^/]+)/o/([^/]+)/rewriteTo/b/([^/]+)/o/([^?]+)`)
 	uploadRgx := regexp.MustCompile(`/b/([^/]+)/o?.*uploadType=multipart.*`)
@@ -248,5 +285,5 @@ func newTestGCSClient() (*storage.Client, error) {
 		}
 	}))
 
-	return storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+	return daisyStorage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
 }
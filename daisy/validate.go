@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -55,6 +57,12 @@ func (w *Workflow) validateRequiredFields() dErr {
 	} else if !exists {
 		return errf("zone does not exist: %q", w.Zone)
 	}
+	if w.MaxConcurrentSteps < 0 {
+		return errf("workflow field 'MaxConcurrentSteps' must not be negative, got %d", w.MaxConcurrentSteps)
+	}
+	if w.MaxConcurrentOperations < 0 {
+		return errf("workflow field 'MaxConcurrentOperations' must not be negative, got %d", w.MaxConcurrentOperations)
+	}
 	if len(w.Steps) == 0 {
 		return errf("must provide at least one step in workflow field 'Steps'")
 	}
@@ -72,23 +80,38 @@ func (w *Workflow) validate(ctx context.Context) dErr {
 		return err
 	}
 
-	return w.validateDAG(ctx)
+	if err := w.validateDAG(ctx); err != nil {
+		return err
+	}
+	if err := w.validateHandlers(ctx); err != nil {
+		return err
+	}
+	if w.parent != nil {
+		return nil
+	}
+	return w.validateQuotas()
 }
 
 // Step through the step DAG, calling each step's validate().
 func (w *Workflow) validateDAG(ctx context.Context) dErr {
+	return w.validateSteps(ctx, w.Steps, w.Dependencies)
+}
+
+// validateSteps checks deps, the dependencies of steps, then calls each
+// step's validate() in dependency order.
+func (w *Workflow) validateSteps(ctx context.Context, steps map[string]*Step, deps map[string][]string) dErr {
 	// Sanitation.
-	for s, deps := range w.Dependencies {
+	for s, sDeps := range deps {
 		// Check for missing steps.
-		if _, ok := w.Steps[s]; !ok {
-			return errf("Dependencies reference non existent step %q: %q:%q", s, s, deps)
+		if _, ok := steps[s]; !ok {
+			return errf("Dependencies reference non existent step %q: %q:%q", s, s, sDeps)
 		}
 		seen := map[string]bool{}
 		var clean []string
-		for _, dep := range deps {
+		for _, dep := range sDeps {
 			// Check for missing dependencies.
-			if _, ok := w.Steps[dep]; !ok {
-				return errf("Dependencies reference non existent step %q: %q:%q", dep, s, deps)
+			if _, ok := steps[dep]; !ok {
+				return errf("Dependencies reference non existent step %q: %q:%q", dep, s, sDeps)
 			}
 			// Remove duplicate dependencies.
 			if !seen[dep] {
@@ -96,27 +119,92 @@ func (w *Workflow) validateDAG(ctx context.Context) dErr {
 				clean = append(clean, dep)
 			}
 		}
-		w.Dependencies[s] = clean
+		deps[s] = clean
 	}
 
 	// Check for cycles.
-	for _, s := range w.Steps {
+	for _, s := range steps {
 		if s.depends(s) {
 			return errf("cyclic dependency on step %v", s)
 		}
 	}
-	return w.traverseDAG(func(s *Step) dErr { return s.validate(ctx) })
+	return w.traverseSteps(steps, deps, func(s *Step) dErr { return s.validate(ctx) })
 }
 
+var unsubbedVarRgx = regexp.MustCompile(`\$\{([^}]*)}`)
+
+// validateVarsSubbed returns an error for each expression left unresolved
+// after populate, with the path of the field it is in and why.
 func (w *Workflow) validateVarsSubbed() dErr {
-	unsubbedVarRgx := regexp.MustCompile(`\$\{([^}]+)}`)
-	return traverseData(reflect.ValueOf(w).Elem(), func(v reflect.Value) dErr {
-		switch v.Interface().(type) {
-		case string:
-			if match := unsubbedVarRgx.FindStringSubmatch(v.String()); match != nil {
-				return errf("Unresolved var %q found in %q", match[0], v.String())
+	// The steps of a workflow included by a handler step are handler steps.
+	var inHandler bool
+	for _, s := range w.Steps {
+		inHandler = s.inHandler()
+		break
+	}
+	var errs dErr
+	walkStrings(reflect.ValueOf(w).Elem(), nil, func(path []string, s string) {
+		if !strings.Contains(s, "${") {
+			return
+		}
+		handler := inHandler || path[0] == "OnFailure" || path[0] == "Finally"
+		at := strings.Join(path, ".")
+		t, _ := parseTemplate(s, 0, false)
+		for _, p := range t {
+			if p.expr != nil {
+				// Step output references have no reason, they are resolved
+				// when the referencing step runs.
+				if r := unresolvedReason(p.expr, handler); r != "" {
+					errs = addErrs(errs, errf("Unresolved var %q found in %q at %s: %s", p.expr.src(), s, at, r))
+				}
+			} else if m := unsubbedVarRgx.FindString(p.text); m != "" {
+				errs = addErrs(errs, errf("Unresolved var %q found in %q at %s: bad expression", m, s, at))
 			}
 		}
-		return nil
 	})
+	return errs
+}
+
+// walkStrings runs f on the strings in v that traverseData would traverse,
+// with their paths as in workflow files. Map keys are visited in order.
+func walkStrings(v reflect.Value, path []string, f func([]string, string)) {
+	sub := func(k string) []string {
+		return append(append([]string{}, path...), k)
+	}
+	switch v.Kind() {
+	case reflect.String:
+		f(path, v.String())
+	case reflect.Interface:
+		if !v.IsNil() && v.Type() == stepTypeTyp {
+			walkStrings(v.Elem(), path, f)
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			walkStrings(v.Elem(), path, f)
+		}
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			walkStrings(v.Index(i), sub(strconv.Itoa(i)), f)
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, k := range keys {
+			walkStrings(v.MapIndex(k), sub(fmt.Sprint(k)), f)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			sf := v.Type().Field(i)
+			switch {
+			case sf.PkgPath != "":
+				// Don't run on private fields.
+			case sf.Anonymous, v.Type() == stepTyp && sf.Name == "Custom":
+				// Fields of embedded structs and custom step types are
+				// written in the same object as the embedding struct's.
+				walkStrings(v.Field(i), path, f)
+			default:
+				walkStrings(v.Field(i), sub(sf.Name), f)
+			}
+		}
+	}
 }
@@ -54,7 +54,7 @@ func TestValidateVarsSubbed(t *testing.T) {
 	}
 
 	w.Name = "workflow-${unsubbed}"
-	want := `Unresolved var "${unsubbed}" found in "workflow-${unsubbed}"`
+	want := `Unresolved var "${unsubbed}" found in "workflow-${unsubbed}" at Name: var "unsubbed" is not defined`
 	if err := w.validateVarsSubbed(); err.Error() != want {
 		t.Errorf("workflow with unsubbed var bad error, want: %q got: %q", want, err.Error())
 	}
@@ -0,0 +1,130 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+var envKeyRgx = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// ReadVarFile reads workflow vars from file, by its extension:
+//   - .json: an object of var names to strings, numbers or booleans.
+//   - .yaml, .yml: the same as a YAML mapping.
+//   - .env: KEY=VALUE lines. Blank lines, lines starting with '#' and an
+//     "export " prefix are ignored. Values can be in single quotes, taken
+//     as is, or in double quotes, where \n, \t, \", \$ and \\ are unescaped.
+func ReadVarFile(file string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ToLower(filepath.Ext(file)) == ".env" {
+		return parseEnvFile(file, data)
+	}
+	if isYAML(file) {
+		var y interface{}
+		if err := yaml.Unmarshal(data, &y); err != nil {
+			return nil, yamlSyntaxError(file, data, err)
+		}
+		if data, err = json.Marshal(y); err != nil {
+			return nil, err
+		}
+	} else if strings.ToLower(filepath.Ext(file)) != ".json" {
+		return nil, fmt.Errorf("%s: unknown var file type, must be .json, .yaml, .yml or .env", file)
+	}
+
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var raw map[string]interface{}
+	if err := d.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("%s: var files must be an object of var names to values: %v", file, err)
+	}
+	vars := map[string]string{}
+	for k, v := range stringifyScalars(raw, reflect.TypeOf(vars)).(map[string]interface{}) {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: value of var %q is not a string, number or boolean", file, k)
+		}
+		vars[k] = s
+	}
+	return vars, nil
+}
+
+func parseEnvFile(file string, data []byte) (map[string]string, error) {
+	vars := map[string]string{}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		ln := strings.TrimSpace(s.Text())
+		if ln == "" || strings.HasPrefix(ln, "#") {
+			continue
+		}
+		ln = strings.TrimPrefix(ln, "export ")
+		i := strings.Index(ln, "=")
+		if i == -1 {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", file, n)
+		}
+		k, v := strings.TrimSpace(ln[:i]), strings.TrimSpace(ln[i+1:])
+		if !envKeyRgx.MatchString(k) {
+			return nil, fmt.Errorf("%s:%d: bad var name %q", file, n, k)
+		}
+		if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+			v = v[1 : len(v)-1]
+		} else if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+			var err error
+			if v, err = unquoteEnv(v[1 : len(v)-1]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", file, n, err)
+			}
+		}
+		vars[k] = v
+	}
+	return vars, s.Err()
+}
+
+// unquoteEnv unescapes the content of a double quoted .env value.
+func unquoteEnv(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i++; i == len(s) {
+			return "", fmt.Errorf("unterminated escape in %s", strconv.Quote(s))
+		}
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case '"', '\\', '$':
+			b.WriteByte(s[i])
+		default:
+			return "", fmt.Errorf("unknown escape \\%c in %s", s[i], strconv.Quote(s))
+		}
+	}
+	return b.String(), nil
+}
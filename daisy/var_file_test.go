@@ -0,0 +1,72 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestReadVarFile(t *testing.T) {
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	tests := []struct {
+		desc, file, data string
+		want             map[string]string
+		err              string
+	}{
+		{"JSON case", "vars.json", `{"a": "b", "size": 10, "debug": true}`, map[string]string{"a": "b", "size": "10", "debug": "true"}, ""},
+		{"YAML case", "vars.yaml", "a: b\nsize: 10\nlist: a,b\n", map[string]string{"a": "b", "size": "10", "list": "a,b"}, ""},
+		{
+			"env case", "vars.env",
+			"# comment\n\nexport a=b\nc = d e\nsingle='x\\ny'\ndouble=\"x\\ny \\\"z\\\" \\${v}\"\nempty=\n",
+			map[string]string{"a": "b", "c": "d e", "single": `x\ny`, "double": "x\ny \"z\" ${v}", "empty": ""},
+			"",
+		},
+		{"nested value case", "vars.json", `{"a": {"b": "c"}}`, nil, `vars.json: value of var "a" is not a string, number or boolean`},
+		{"not an object case", "vars.json", `["a"]`, nil, "vars.json: var files must be an object of var names to values: json: cannot unmarshal array into Go value of type map[string]interface {}"},
+		{"bad env line case", "vars.env", "a=b\nc\n", nil, "vars.env:2: expected KEY=VALUE"},
+		{"bad env name case", "vars.env", "a b=c\n", nil, `vars.env:1: bad var name "a b"`},
+		{"bad env escape case", "vars.env", `a="\q"`, nil, `vars.env:1: unknown escape \q in "\\q"`},
+		{"unknown type case", "vars.txt", "a=b", nil, "vars.txt: unknown var file type, must be .json, .yaml, .yml or .env"},
+	}
+
+	for _, tt := range tests {
+		tf := filepath.Join(td, tt.file)
+		if err := ioutil.WriteFile(tf, []byte(tt.data), 0600); err != nil {
+			t.Fatalf("error writing var file: %v", err)
+		}
+		got, err := ReadVarFile(tf)
+		if tt.err != "" {
+			if want := filepath.Join(td, tt.err); err == nil || err.Error() != want {
+				t.Errorf("%s: got error %v, want %q", tt.desc, err, want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		} else if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("%s: vars do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
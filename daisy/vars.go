@@ -0,0 +1,165 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Types of workflow vars, wVar.Type. Vars without a Type are strings.
+const (
+	varTypeString   = "string"
+	varTypeInt      = "int"
+	varTypeBool     = "bool"
+	varTypeEnum     = "enum"
+	varTypeList     = "list"
+	varTypeGCSPath  = "gcs-path"
+	varTypeImageURL = "image-url"
+)
+
+var computeAPIPrefixRgx = regexp.MustCompile(`^https://www\.googleapis\.com/compute/[^/]+/`)
+
+// listValues returns the elements of a list var value, separated by commas.
+func listValues(s string) []string {
+	var vs []string
+	for _, v := range strings.Split(s, ",") {
+		vs = append(vs, strings.TrimSpace(v))
+	}
+	return vs
+}
+
+// checkVarType returns an error if s is not a value of var type t.
+func checkVarType(t, s string) error {
+	switch t {
+	case varTypeInt:
+		if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+			return fmt.Errorf("%q is not an int", s)
+		}
+	case varTypeBool:
+		if _, err := strconv.ParseBool(s); err != nil {
+			return fmt.Errorf("%q is not a bool", s)
+		}
+	case varTypeGCSPath:
+		if _, _, err := splitGCSPath(s); err != nil {
+			return fmt.Errorf("%q is not a GCS path", s)
+		}
+	case varTypeImageURL:
+		// Images can also be referenced by name.
+		if !rfc1035Rgx.MatchString(s) && !imageURLRgx.MatchString(computeAPIPrefixRgx.ReplaceAllString(s, "")) {
+			return fmt.Errorf("%q is not an image URL", s)
+		}
+	}
+	return nil
+}
+
+// validate checks the declaration of var name, and its value if it is set.
+func (v wVar) validate(name string) dErr {
+	var pattern *regexp.Regexp
+	if v.Pattern != "" {
+		var err error
+		if pattern, err = regexp.Compile("^(?:" + v.Pattern + ")$"); err != nil {
+			return errf("var %q: bad Pattern: %v", name, err)
+		}
+	}
+	switch v.Type {
+	case "", varTypeString, varTypeInt, varTypeBool, varTypeList, varTypeGCSPath, varTypeImageURL:
+	case varTypeEnum:
+		if len(v.Allowed) == 0 {
+			return errf("var %q: enum vars must have Allowed values", name)
+		}
+	default:
+		return errf("var %q: unknown Type %q", name, v.Type)
+	}
+
+	// Optional vars can be left empty. Values referencing autovars that are
+	// set later are not checked.
+	if v.Value == "" || strings.Contains(v.Value, "${") {
+		return nil
+	}
+	values := []string{v.Value}
+	if v.Type == varTypeList {
+		values = listValues(v.Value)
+	}
+	for _, s := range values {
+		if err := checkVarType(v.Type, s); err != nil {
+			return errf("var %q: %v", name, err)
+		}
+		if len(v.Allowed) > 0 && !strIn(s, v.Allowed) {
+			return errf("var %q: %q is not one of %q", name, s, v.Allowed)
+		}
+		if pattern != nil && !pattern.MatchString(s) {
+			return errf("var %q: %q does not match Pattern %q", name, s, v.Pattern)
+		}
+	}
+	return nil
+}
+
+// validateVars checks the types of the workflow's vars. It runs before vars
+// are substituted, so errors point at the var rather than at a step field.
+func (w *Workflow) validateVars() dErr {
+	var names []string
+	for k := range w.Vars {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var errs dErr
+	for _, k := range names {
+		errs = addErrs(errs, w.Vars[k].validate(k))
+	}
+	return errs
+}
+
+// DescribeVars writes a table of the workflow's vars to out, with their
+// types, defaults and descriptions. The defaults of secret vars are masked.
+func (w *Workflow) DescribeVars(out io.Writer) error {
+	var names []string
+	for k := range w.Vars {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTYPE\tREQUIRED\tDEFAULT\tVALUES\tDESCRIPTION")
+	for _, k := range names {
+		v := w.Vars[k]
+		typ := v.Type
+		if typ == "" {
+			typ = varTypeString
+		}
+		required := "no"
+		if v.Required {
+			required = "yes"
+		}
+		def := v.Value
+		if v.Secret && def != "" {
+			def = secretMask
+		}
+		var values []string
+		if len(v.Allowed) > 0 {
+			values = append(values, strings.Join(v.Allowed, "|"))
+		}
+		if v.Pattern != "" {
+			values = append(values, "/"+v.Pattern+"/")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", k, typ, required, def, strings.Join(values, " "), v.Description)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,136 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestWVarValidate(t *testing.T) {
+	tests := []struct {
+		desc string
+		v    wVar
+		err  string
+	}{
+		{"untyped case", wVar{Value: "foo"}, ""},
+		{"unset optional case", wVar{Type: "int"}, ""},
+		{"int case", wVar{Value: "10", Type: "int"}, ""},
+		{"bad int case", wVar{Value: "ten", Type: "int"}, `var "v": "ten" is not an int`},
+		{"bool case", wVar{Value: "true", Type: "bool"}, ""},
+		{"bad bool case", wVar{Value: "yes", Type: "bool"}, `var "v": "yes" is not a bool`},
+		{"enum case", wVar{Value: "b", Type: "enum", Allowed: []string{"a", "b"}}, ""},
+		{"bad enum case", wVar{Value: "c", Type: "enum", Allowed: []string{"a", "b"}}, `var "v": "c" is not one of ["a" "b"]`},
+		{"enum without Allowed case", wVar{Type: "enum"}, `var "v": enum vars must have Allowed values`},
+		{"list case", wVar{Value: "a, b", Type: "list", Allowed: []string{"a", "b"}}, ""},
+		{"bad list case", wVar{Value: "a,c", Type: "list", Allowed: []string{"a", "b"}}, `var "v": "c" is not one of ["a" "b"]`},
+		{"gcs-path case", wVar{Value: "gs://bucket/object", Type: "gcs-path"}, ""},
+		{"bad gcs-path case", wVar{Value: "/local/file", Type: "gcs-path"}, `var "v": "/local/file" is not a GCS path`},
+		{"image-url case", wVar{Value: "projects/debian-cloud/global/images/family/debian-9", Type: "image-url"}, ""},
+		{"full image-url case", wVar{Value: "https://www.googleapis.com/compute/v1/projects/p/global/images/i", Type: "image-url"}, ""},
+		{"image name case", wVar{Value: "my-image", Type: "image-url"}, ""},
+		{"bad image-url case", wVar{Value: "projects/p/zones/z/disks/d", Type: "image-url"}, `var "v": "projects/p/zones/z/disks/d" is not an image URL`},
+		{"unknown Type case", wVar{Value: "1", Type: "float"}, `var "v": unknown Type "float"`},
+		{"Pattern case", wVar{Value: "n1-standard-4", Pattern: "n1-standard-[0-9]+"}, ""},
+		{"bad Pattern match case", wVar{Value: "xn1-standard-4", Pattern: "n1-standard-[0-9]+"}, `var "v": "xn1-standard-4" does not match Pattern "n1-standard-[0-9]+"`},
+		{"bad Pattern case", wVar{Pattern: "("}, "var \"v\": bad Pattern: error parsing regexp: missing closing ): `^(?:()$`"},
+	}
+
+	for _, tt := range tests {
+		err := tt.v.validate("v")
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		} else if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.desc, err, tt.err)
+		}
+	}
+}
+
+func TestPopulateTypedVars(t *testing.T) {
+	w := testWorkflow()
+	w.Vars = map[string]wVar{
+		"disk_size": {Value: "10", Type: "int"},
+		"mode":      {Value: "fast", Type: "enum", Allowed: []string{"slow"}},
+	}
+	w.AddVar("disk_size", "big")
+
+	want := "Multiple errors:\n" +
+		`* var "disk_size": "big" is not an int` + "\n" +
+		`* var "mode": "fast" is not one of ["slow"]`
+	if err := w.populate(context.Background()); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestDescribeVars(t *testing.T) {
+	w := New()
+	w.Vars = map[string]wVar{
+		"size":  {Value: "10", Type: "int", Description: "disk size"},
+		"key":   {Value: "secret", Required: true, Secret: true},
+		"mode":  {Type: "enum", Allowed: []string{"a", "b"}},
+		"image": {Pattern: "img-.*"},
+	}
+	var buf bytes.Buffer
+	if err := w.DescribeVars(&buf); err != nil {
+		t.Fatalf("error describing vars: %v", err)
+	}
+	want := `NAME   TYPE    REQUIRED  DEFAULT  VALUES    DESCRIPTION
+image  string  no                 /img-.*/  
+key    string  yes       *****              
+mode   enum    no                 a|b       
+size   int     no        10                 disk size
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPopulateEnvRefs(t *testing.T) {
+	os.Setenv("DAISY_TEST_ZONE", "env-zone")
+	os.Setenv("DAISY_TEST_SIZE", "20")
+	defer os.Unsetenv("DAISY_TEST_ZONE")
+	defer os.Unsetenv("DAISY_TEST_SIZE")
+
+	w := testWorkflow()
+	w.AllowEnv = true
+	w.Vars = map[string]wVar{"size": {Value: "${env.DAISY_TEST_SIZE}", Type: "int"}}
+	s, _ := w.NewStep("s")
+	s.CreateDisks = &CreateDisks{{Disk: compute.Disk{Name: "d", Description: "${env.DAISY_TEST_ZONE} ${size}"}}}
+	if err := w.populate(context.Background()); err != nil {
+		t.Fatalf("error populating workflow: %v", err)
+	}
+	if got, want := (*s.CreateDisks)[0].Description, "env-zone 20"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	w = testWorkflow()
+	w.AllowEnv = true
+	w.Zone = "${env.DAISY_TEST_DNE}"
+	want := `environment variable "DAISY_TEST_DNE" is not set`
+	if err := w.populate(context.Background()); err == nil || err.Error() != want {
+		t.Errorf("unset env var: got error %v, want %q", err, want)
+	}
+
+	// References are left as is unless allowed.
+	w = testWorkflow()
+	w.Zone = "${env.DAISY_TEST_ZONE}"
+	if err := w.populate(context.Background()); err != nil || w.Zone != "${env.DAISY_TEST_ZONE}" {
+		t.Errorf("env not allowed: got Zone %q, error %v", w.Zone, err)
+	}
+}
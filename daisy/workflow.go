@@ -19,6 +19,8 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -33,16 +35,15 @@ import (
 	"sync"
 	"time"
 
-	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
-	"google.golang.org/api/iterator"
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/storage"
 	"google.golang.org/api/option"
 )
 
 const defaultTimeout = "10m"
 
 type gcsLogger struct {
-	client         *storage.Client
+	client         storage.Client
 	bucket, object string
 	buf            *bytes.Buffer
 	ctx            context.Context
@@ -53,8 +54,7 @@ func (l *gcsLogger) Write(b []byte) (int, error) {
 		l.buf = new(bytes.Buffer)
 	}
 	l.buf.Write(b)
-	wc := l.client.Bucket(l.bucket).Object(l.object).NewWriter(l.ctx)
-	wc.ContentType = "text/plain"
+	wc := l.client.NewWriter(l.ctx, l.bucket, l.object, "text/plain")
 	n, err := wc.Write(l.buf.Bytes())
 	if err != nil {
 		return 0, err
@@ -82,19 +82,19 @@ func (l *syncedWriter) Flush() error {
 	return l.buf.Flush()
 }
 
-func daisyBkt(ctx context.Context, client *storage.Client, project string) (string, dErr) {
+func daisyBkt(ctx context.Context, client storage.Client, project string) (string, dErr) {
 	dBkt := strings.Replace(project, ":", "-", -1) + "-daisy-bkt"
-	it := client.Buckets(ctx, project)
-	for bucketAttrs, err := it.Next(); err != iterator.Done; bucketAttrs, err = it.Next() {
-		if err != nil {
-			return "", typedErr(apiError, err)
-		}
+	bkts, err := client.ListBuckets(ctx, project)
+	if err != nil {
+		return "", typedErr(apiError, err)
+	}
+	for _, bucketAttrs := range bkts {
 		if bucketAttrs.Name == dBkt {
 			return dBkt, nil
 		}
 	}
 
-	if err := client.Bucket(dBkt).Create(ctx, project, nil); err != nil {
+	if err := client.CreateBucket(ctx, project, dBkt); err != nil {
 		return "", typedErr(apiError, err)
 	}
 	return dBkt, nil
@@ -103,10 +103,20 @@ func daisyBkt(ctx context.Context, client *storage.Client, project string) (stri
 // wVar is a type with a flexible JSON representation. A wVar can be represented
 // by either a string, or by this struct definition. A wVar that is represented
 // by a string will unmarshal into the struct: {Value: <string>, Required: false, Description: ""}.
+// The values of Secret vars are masked in logs and printed workflows.
+// Values are checked against Type, Allowed and Pattern before substitution.
 type wVar struct {
 	Value       string
 	Required    bool
 	Description string
+	Secret      bool `json:",omitempty"`
+	// One of string (the default), int, bool, enum, list, gcs-path or
+	// image-url. list values are comma separated.
+	Type string `json:",omitempty"`
+	// Values the var can take, required for enum vars.
+	Allowed []string `json:",omitempty"`
+	// Regular expression values must match in full.
+	Pattern string `json:",omitempty"`
 }
 
 func (v *wVar) UnmarshalJSON(b []byte) error {
@@ -133,6 +143,8 @@ type Workflow struct {
 	Project string
 	// Zone to run in.
 	Zone string
+	// Zones to create instances in, in order, when Zone is out of resources.
+	FallbackZones []string `json:",omitempty"`
 	// GCS Path to use for scratch data and write logs/results to.
 	GCSPath string
 	// Path to OAuth credentials file.
@@ -144,6 +156,39 @@ type Workflow struct {
 	Steps map[string]*Step
 	// Map of steps to their dependencies.
 	Dependencies map[string][]string
+	// Maximum number of steps to run at the same time, unlimited if 0.
+	// IncludeWorkflow and SubWorkflow steps do not count towards the limit,
+	// their steps do. Only set on the top level workflow.
+	MaxConcurrentSteps int `json:",omitempty"`
+	// Maximum number of create, delete and set labels operations in flight at
+	// the same time, unlimited if 0. Only set on the top level workflow.
+	MaxConcurrentOperations int `json:",omitempty"`
+	// Steps to run after Steps if a step fails, and steps to run after Steps
+	// and OnFailure whether a step fails or not. Neither runs if the workflow
+	// is canceled. Only set on the top level workflow.
+	OnFailure *StepGroup `json:",omitempty"`
+	Finally   *StepGroup `json:",omitempty"`
+	// Allow ${env.NAME} references to environment variables. Only set on
+	// the top level workflow, by the program running it.
+	AllowEnv bool `json:"-"`
+	// Keep the resources of the workflow if it fails, to debug it, instead of
+	// deleting them. See RetainedResources and CleanupRun. Only set on the
+	// top level workflow.
+	KeepOnFailure bool `json:"-"`
+	// Keep the resources created by the completed steps of the workflow if
+	// it fails or is canceled, for a run resuming it to use. Runs resuming a
+	// run keep them too. Only set on the top level workflow.
+	KeepForResume bool `json:"-"`
+	// Directory to cache the gs:// and https:// workflow files of
+	// IncludeWorkflow and SubWorkflow steps in. Defaults to daisy in the
+	// user's cache directory. Only set on the top level workflow.
+	CacheDir string `json:"-"`
+	// Writer the log of the workflow is written to, besides its GCS log.
+	// Defaults to os.Stdout. Only set on the top level workflow.
+	LogOutput io.Writer `json:"-"`
+	// Don't check that the region quotas leave room for the resources of
+	// the workflow when validating it. Only set on the top level workflow.
+	SkipQuotaCheck bool `json:"-"`
 
 	// Working fields.
 	autovars       map[string]string
@@ -157,12 +202,43 @@ type Workflow struct {
 	username       string
 	gcsLogging     bool
 	gcsLogWriter   *syncedWriter
-	ComputeClient  compute.Client  `json:"-"`
-	StorageClient  *storage.Client `json:"-"`
+	ComputeClient  compute.Client `json:"-"`
+	StorageClient  storage.Client `json:"-"`
 	id             string
 	logger         *log.Logger
 	cleanupHooks   []func() dErr
 	cleanupHooksMx sync.Mutex
+	started        time.Time
+	resumeFrom     string
+	checkpoint     *checkpoint
+	checkpointMx   sync.Mutex
+	// checkpointed is set while the last checkpoint write succeeded,
+	// keepCompleted once the run stops with steps left to resume.
+	checkpointed  bool
+	keepCompleted bool
+	stepsDone     map[string]bool
+	stepsDoneMx   sync.Mutex
+	outputs       map[string]map[string]string
+	outputsMx     sync.Mutex
+	observers     []Observer
+	stepSlots     chan struct{}
+	secrets       []string
+	secretsMx     sync.Mutex
+	redactor      *strings.Replacer
+	failedStep    string
+	failure       string
+	failureMx     sync.Mutex
+	keepResources bool
+	retained      []RetainedResource
+	fileSHA256    string
+	// SHA-256 of the Sources uploaded to sourcesPath, by object name under
+	// it, for Provenance.
+	sourceHashes map[string]string
+	// Source images of the disks and source disks of the images created by
+	// the run, by link, for Provenance.
+	diskImages map[string]string
+	imageDisks map[string]string
+	lineageMx  sync.Mutex
 }
 
 // AddVar adds a variable set to the Workflow.
@@ -170,7 +246,10 @@ func (w *Workflow) AddVar(k, v string) {
 	if w.Vars == nil {
 		w.Vars = map[string]wVar{}
 	}
-	w.Vars[k] = wVar{Value: v}
+	// Vars declared in the workflow stay secret.
+	wv := w.Vars[k]
+	wv.Value = v
+	w.Vars[k] = wv
 }
 
 func (w *Workflow) addCleanupHook(hook func() dErr) {
@@ -180,9 +259,10 @@ func (w *Workflow) addCleanupHook(hook func() dErr) {
 }
 
 // Validate runs validation on the workflow.
-func (w *Workflow) Validate(ctx context.Context) error {
+func (w *Workflow) Validate(ctx context.Context) (err error) {
+	defer func() { err = w.redactErr(err) }()
+
 	// API clients instantiation.
-	var err error
 	if w.ComputeClient == nil {
 		w.ComputeClient, err = compute.NewClient(ctx, option.WithCredentialsFile(w.OAuthPath))
 		if err != nil {
@@ -197,6 +277,15 @@ func (w *Workflow) Validate(ctx context.Context) error {
 		}
 	}
 
+	if w.resumeFrom != "" {
+		c, err := readCheckpoint(ctx, w.StorageClient, w.resumeFrom)
+		if err != nil {
+			close(w.Cancel)
+			return errf("error reading checkpoint: %v", err)
+		}
+		w.checkpoint = c
+	}
+
 	if err := w.validateRequiredFields(); err != nil {
 		close(w.Cancel)
 		return errf("error validating workflow: %v", err)
@@ -218,7 +307,18 @@ func (w *Workflow) Validate(ctx context.Context) error {
 }
 
 // Run runs a workflow.
-func (w *Workflow) Run(ctx context.Context) error {
+func (w *Workflow) Run(ctx context.Context) (err error) {
+	start := time.Now()
+	w.emit(&Event{Type: WorkflowStarted})
+	defer func() {
+		err = w.redactErr(err)
+		e := &Event{Type: WorkflowFinished, Duration: time.Since(start)}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		w.emit(e)
+	}()
+
 	w.gcsLogging = true
 	if err := w.Validate(ctx); err != nil {
 		return err
@@ -226,6 +326,20 @@ func (w *Workflow) Run(ctx context.Context) error {
 	defer w.cleanup()
 	w.logger.Println("Using the GCS path", "gs://"+path.Join(w.bucket, w.scratchPath))
 
+	if w.checkpoint != nil {
+		w.logger.Printf("Resuming run %q", w.id)
+		if err := w.resume(); err != nil {
+			w.logger.Printf("Error resuming workflow: %v", err)
+			close(w.Cancel)
+			return err
+		}
+	}
+	if err := w.writeCheckpoint(ctx); err != nil {
+		w.logger.Printf("Error writing checkpoint: %v", err)
+	} else {
+		w.logger.Println("Checkpointing to", w.checkpointPath())
+	}
+
 	w.logger.Print("Uploading sources")
 	if err := w.uploadSources(ctx); err != nil {
 		w.logger.Printf("Error uploading sources: %v", err)
@@ -233,21 +347,56 @@ func (w *Workflow) Run(ctx context.Context) error {
 		return err
 	}
 	w.logger.Print("Running workflow")
-	if err := w.run(ctx); err != nil {
-		w.logger.Printf("Error running workflow: %v", err)
-		select {
-		case <-w.Cancel:
-		default:
+	runErr := w.run(ctx)
+	if runErr != nil {
+		w.logger.Printf("Error running workflow: %v", runErr)
+	}
+	hErr := w.runHandlers(ctx, runErr)
+	canceled := false
+	select {
+	case <-w.Cancel:
+		canceled = true
+	default:
+	}
+	if hErr != nil && w.KeepOnFailure {
+		if kErr := w.retainResources(ctx); kErr != nil {
+			w.logger.Printf("Error recording kept resources: %v", kErr)
+		}
+	} else if (hErr != nil || canceled) && w.keepsForResume() && w.keepForResume() {
+		if kErr := w.recordRetained(ctx, "Resources kept for resume:", createdByCompletedStep); kErr != nil {
+			w.logger.Printf("Error recording kept resources: %v", kErr)
+		}
+	}
+	if hErr != nil {
+		if !canceled {
 			close(w.Cancel)
 		}
-		return err
+		return hErr
 	}
 	return nil
 }
 
+// Resume runs a workflow, continuing the run recorded in the checkpoint at
+// checkpointPath. checkpointPath can be a GCS path or a local file.
+func (w *Workflow) Resume(ctx context.Context, checkpointPath string) error {
+	w.resumeFrom = checkpointPath
+	return w.Run(ctx)
+}
+
+// ID returns the ID of the workflow run, set when the workflow is validated.
+func (w *Workflow) ID() string {
+	return w.id
+}
+
+// ScratchPath returns the GCS path of the scratch directory of the workflow
+// run, set when the workflow is validated.
+func (w *Workflow) ScratchPath() string {
+	return fmt.Sprintf("gs://%s", path.Join(w.bucket, w.scratchPath))
+}
+
 func (w *Workflow) String() string {
 	f := "{Name:%q Project:%q Zone:%q Bucket:%q OAuthPath:%q Sources:%s Vars:%s Steps:%s Dependencies:%s id:%q}"
-	return fmt.Sprintf(f, w.Name, w.Project, w.Zone, w.bucket, w.OAuthPath, w.Sources, w.Vars, w.Steps, w.Dependencies, w.id)
+	return w.redact(fmt.Sprintf(f, w.Name, w.Project, w.Zone, w.bucket, w.OAuthPath, w.Sources, w.Vars, w.Steps, w.Dependencies, w.id))
 }
 
 func (w *Workflow) cleanup() {
@@ -262,6 +411,15 @@ func (w *Workflow) cleanup() {
 	}
 }
 
+// rootWorkflow returns the top level workflow w is nested in, or w.
+func (w *Workflow) rootWorkflow() *Workflow {
+	root := w
+	for root.parent != nil {
+		root = root.parent
+	}
+	return root
+}
+
 func (w *Workflow) genName(n string) string {
 	name := w.Name
 	for parent := w.parent; parent != nil; parent = parent.parent {
@@ -292,6 +450,25 @@ func (w *Workflow) populateStep(ctx context.Context, s *Step) dErr {
 	}
 	s.timeout = timeout
 
+	if s.Retry != nil {
+		if err := s.Retry.populate(); err != nil {
+			return errf("error populating Retry: %v", err)
+		}
+	}
+
+	// Conditions referencing step outputs, and conditions of handler steps,
+	// are evaluated when the step runs.
+	if s.If != "" && !s.runtimeIf() {
+		run, err := s.evalIf()
+		if err != nil {
+			return errf("error evaluating If: %v", err)
+		}
+		s.skipped = !run
+	}
+	if s.skipped {
+		return nil
+	}
+
 	var derr dErr
 	var step stepImpl
 	if step, derr = s.stepImpl(); derr != nil {
@@ -309,6 +486,15 @@ func (w *Workflow) populateStep(ctx context.Context, s *Step) dErr {
 // - sets up logger.
 // - runs populate on each step.
 func (w *Workflow) populate(ctx context.Context) dErr {
+	// Vars of a resumed run are the ones the run started with.
+	if w.checkpoint != nil {
+		for k, v := range w.checkpoint.Vars {
+			if wv, ok := w.Vars[k]; ok {
+				wv.Value = v
+				w.Vars[k] = wv
+			}
+		}
+	}
 	for k, v := range w.Vars {
 		if v.Required && v.Value == "" {
 			return errf("cannot populate workflow, required var %q is unset", k)
@@ -319,6 +505,11 @@ func (w *Workflow) populate(ctx context.Context) dErr {
 	w.id = randString(5)
 	cwd, _ := os.Getwd()
 	now := time.Now().UTC()
+	if w.checkpoint != nil {
+		w.id = w.checkpoint.ID
+		now = w.checkpoint.Time.UTC()
+	}
+	w.started = now
 	w.username = getUser()
 
 	w.autovars = map[string]string{
@@ -331,14 +522,18 @@ func (w *Workflow) populate(ctx context.Context) dErr {
 		"CWD":       cwd,
 	}
 
-	var replacements []string
-	for k, v := range w.autovars {
-		replacements = append(replacements, fmt.Sprintf("${%s}", k), v)
+	// Var values are resolved and checked before they are substituted.
+	in := w.newInterpolator(w.autovars, append(lateAutovars, handlerAutovars...)...)
+	if err := w.resolveVars(in); err != nil {
+		return err
 	}
-	for k, v := range w.Vars {
-		replacements = append(replacements, fmt.Sprintf("${%s}", k), v.Value)
+	w.addSecretVars()
+	if err := w.validateVars(); err != nil {
+		return err
+	}
+	if err := w.interpolate(in); err != nil {
+		return err
 	}
-	substitute(reflect.ValueOf(w).Elem(), strings.NewReplacer(replacements...))
 
 	// Set up GCS paths.
 	if w.GCSPath == "" {
@@ -368,14 +563,27 @@ func (w *Workflow) populate(ctx context.Context) dErr {
 	w.autovars["LOGSPATH"] = fmt.Sprintf("gs://%s/%s", w.bucket, w.logsPath)
 	w.autovars["OUTSPATH"] = fmt.Sprintf("gs://%s/%s", w.bucket, w.outsPath)
 
-	replacements = []string{}
-	for k, v := range w.autovars {
-		replacements = append(replacements, fmt.Sprintf("${%s}", k), v)
+	if err := w.interpolate(w.newInterpolator(w.autovars, handlerAutovars...)); err != nil {
+		return err
 	}
-	substitute(reflect.ValueOf(w).Elem(), strings.NewReplacer(replacements...))
 
 	w.populateLogger(ctx)
 
+	// IncludeWorkflow and SubWorkflow workflows use the limits of the top
+	// level workflow, they share its compute client and step slots.
+	if w.parent == nil {
+		if w.MaxConcurrentSteps > 0 {
+			w.stepSlots = make(chan struct{}, w.MaxConcurrentSteps)
+		}
+		if w.MaxConcurrentOperations > 0 {
+			w.ComputeClient = compute.NewLimitedClient(w.ComputeClient, w.MaxConcurrentOperations)
+		}
+	}
+
+	if err := w.expandForEach(); err != nil {
+		return err
+	}
+
 	// Run populate on each step.
 	for name, s := range w.Steps {
 		s.name = name
@@ -384,7 +592,7 @@ func (w *Workflow) populate(ctx context.Context) dErr {
 			return errf("error populating step %q: %v", name, err)
 		}
 	}
-	return nil
+	return w.populateHandlers(ctx)
 }
 
 func (w *Workflow) populateLogger(ctx context.Context) {
@@ -409,7 +617,11 @@ func (w *Workflow) populateLogger(ctx context.Context) {
 			}
 		}()
 	}
-	w.logger = log.New(io.MultiWriter(os.Stdout, w.gcsLogWriter), prefix, flags)
+	out := w.rootWorkflow().LogOutput
+	if out == nil {
+		out = os.Stdout
+	}
+	w.logger = log.New(&redactWriter{w: w, out: io.MultiWriter(out, w.gcsLogWriter)}, prefix, flags)
 }
 
 // AddDependency creates a dependency of dependent on each dependency. Returns an
@@ -491,62 +703,176 @@ func (w *Workflow) NewSubWorkflowFromFile(file string) (*Workflow, error) {
 func (w *Workflow) Print(ctx context.Context) {
 	w.gcsLogging = false
 	if err := w.populate(ctx); err != nil {
-		fmt.Println("Error running populate:", err)
+		fmt.Println("Error running populate:", w.redactErr(err))
 	}
 
 	b, err := json.MarshalIndent(w, "", "  ")
 	if err != nil {
 		fmt.Println("Error marshalling workflow for printing:", err)
 	}
-	fmt.Println(string(b))
+	fmt.Println(w.redact(string(b)))
 }
 
 func (w *Workflow) run(ctx context.Context) dErr {
+	// Running steps are canceled once a step fails.
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	return w.traverseDAG(func(s *Step) dErr {
-		return w.runStep(ctx, s)
+		if w.stepDone(s.name) {
+			w.logger.Printf("Step %q already completed, skipping.", s.name)
+			w.emit(&Event{Type: StepSkipped, Step: s.name, Reason: "already completed"})
+			return nil
+		}
+		// The step containing w timed out.
+		if err := runCtx.Err(); err != nil {
+			return newErr(err)
+		}
+		if err := w.runStepWithEvents(runCtx, s); err != nil {
+			cancel()
+			return err
+		}
+		select {
+		case <-w.Cancel:
+		default:
+			w.completeStep(ctx, s)
+		}
+		return nil
 	})
 }
 
+// runStepWithEvents runs s, emitting events as it starts and finishes.
+func (w *Workflow) runStepWithEvents(ctx context.Context, s *Step) dErr {
+	start := time.Now()
+	err := s.checkRuntimeIf()
+	if err != nil {
+		err = s.wrapRunError(err)
+	} else {
+		if !s.skipped {
+			w.emit(&Event{Type: StepStarted, Step: s.name})
+		}
+		err = w.runStep(ctx, s)
+	}
+	if err != nil {
+		w.recordFailure(s, err)
+		w.emit(&Event{Type: StepFailed, Step: s.name, Duration: time.Since(start), Error: err.Error()})
+		return err
+	}
+	if s.skipped {
+		w.emit(&Event{Type: StepSkipped, Step: s.name, Reason: fmt.Sprintf("condition %q is false", s.If)})
+	} else {
+		w.emit(&Event{Type: StepFinished, Step: s.name, Duration: time.Since(start)})
+	}
+	return nil
+}
+
 func (w *Workflow) runStep(ctx context.Context, s *Step) dErr {
-	timeout := make(chan struct{})
-	go func() {
-		time.Sleep(s.timeout)
-		close(timeout)
-	}()
+	for attempt := 1; ; attempt++ {
+		timedOut, err := w.runStepAttempt(ctx, s, s.Retry.retries(timeoutError, attempt))
+		if err == nil {
+			return nil
+		}
+		errType := err.Type()
+		if timedOut {
+			errType = timeoutError
+		} else {
+			err = s.wrapRunError(err)
+		}
+		if !s.Retry.retries(errType, attempt) {
+			return err
+		}
+		select {
+		case <-w.Cancel:
+			return err
+		case <-ctx.Done():
+			return err
+		default:
+		}
+
+		wait := s.Retry.wait(attempt)
+		w.logger.Printf("Step %q failed (attempt %d of %d), retrying in %s: %v", s.name, attempt, s.Retry.Attempts, wait, err)
+		if rErr := resetStep(s); rErr != nil {
+			return errf("step %q: error cleaning up before retrying: %v, step error: %v", s.name, rErr, err)
+		}
+		select {
+		case <-w.Cancel:
+			return err
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
 
-	e := make(chan dErr)
+func (w *Workflow) runStepAttempt(ctx context.Context, s *Step, retry bool) (bool, dErr) {
+	defer w.stepSlot(s)()
+	timeout := time.NewTimer(s.timeout)
+	defer timeout.Stop()
+
+	// The attempt is canceled when it times out.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	e := make(chan dErr, 1)
 	go func() {
 		e <- s.run(ctx)
 	}()
 
 	select {
 	case err := <-e:
-		return err
-	case <-timeout:
-		return errf("step %q did not stop in specified timeout of %s", s.name, s.timeout)
+		return false, err
+	case <-timeout.C:
+	}
+	err := errf("step %q did not stop in specified timeout of %s", s.name, s.timeout)
+	if retry {
+		// The attempt must not create resources once the step is reset.
+		cancel()
+		w.logger.Printf("Step %q timed out, waiting for it to stop before retrying.", s.name)
+		<-e
+	}
+	return true, err
+}
+
+// stepSlot waits until fewer than MaxConcurrentSteps steps are running an
+// attempt, and returns the function releasing the slot s takes. Validation,
+// waiting for dependencies and retry backoffs don't hold a slot.
+// IncludeWorkflow and SubWorkflow steps don't take one, holding a slot while
+// waiting on their own steps could deadlock the workflow.
+func (w *Workflow) stepSlot(s *Step) func() {
+	root := w.rootWorkflow()
+	if root.stepSlots == nil || s.IncludeWorkflow != nil || s.SubWorkflow != nil {
+		return func() {}
 	}
+	root.stepSlots <- struct{}{}
+	return func() { <-root.stepSlots }
 }
 
 // Concurrently traverse the DAG, running func f on each step.
 // Return an error if f returns an error on any step.
 func (w *Workflow) traverseDAG(f func(*Step) dErr) dErr {
+	return w.traverseSteps(w.Steps, w.Dependencies, f)
+}
+
+// traverseSteps concurrently runs f on each of steps, after the steps it
+// depends on, by deps. Returns the first error f returns, once the steps
+// running then have finished.
+func (w *Workflow) traverseSteps(steps map[string]*Step, deps map[string][]string, f func(*Step) dErr) dErr {
 	// waiting = steps and the dependencies they are waiting for.
 	// running = the currently running steps.
 	// start = map of steps' start channels/semaphores.
 	// done = map of steps' done channels for signaling step completion.
 	waiting := map[string][]string{}
 	var running []string
+	var firstErr dErr
 	start := map[string]chan dErr{}
 	done := map[string]chan dErr{}
 
 	// Setup: channels, copy dependencies.
-	for name := range w.Steps {
-		waiting[name] = w.Dependencies[name]
+	for name := range steps {
+		waiting[name] = deps[name]
 		start[name] = make(chan dErr)
 		done[name] = make(chan dErr)
 	}
 	// Setup: goroutine for each step. Each waits to be notified to start.
-	for name, s := range w.Steps {
+	for name, s := range steps {
 		go func(name string, s *Step) {
 			// Wait for signal, then run the function. Return any errs.
 			if err := <-start[name]; err != nil {
@@ -583,10 +909,11 @@ func (w *Workflow) traverseDAG(f func(*Step) dErr) dErr {
 			continue
 		}
 
-		// Get next finished step. Return the step error if it erred.
+		// Get next finished step. If it erred, start no more steps.
 		finished, err := stepsListen(running, done)
-		if err != nil {
-			return err
+		if err != nil && firstErr == nil {
+			firstErr = err
+			waiting = map[string][]string{}
 		}
 
 		// Remove finished step from other steps' waiting lists.
@@ -597,7 +924,7 @@ func (w *Workflow) traverseDAG(f func(*Step) dErr) dErr {
 		// Remove finished from currently running list.
 		running = filter(running, finished)
 	}
-	return nil
+	return firstErr
 }
 
 // New instantiates a new workflow.
@@ -629,11 +956,25 @@ func readWorkflow(file string, w *Workflow) error {
 	if err != nil {
 		return err
 	}
-
-	w.workflowDir, err = filepath.Abs(filepath.Dir(file))
+	dir, err := filepath.Abs(filepath.Dir(file))
 	if err != nil {
 		return err
 	}
+	return unmarshalWorkflow(file, dir, data, w)
+}
+
+// unmarshalWorkflow unmarshals data, the content of the workflow file in
+// directory dir, into w.
+func unmarshalWorkflow(file, dir string, data []byte, w *Workflow) (err error) {
+	w.workflowDir = dir
+	src := data
+	sum := sha256.Sum256(src)
+	w.fileSHA256 = hex.EncodeToString(sum[:])
+	if isYAML(file) {
+		if data, err = yamlToJSON(file, data); err != nil {
+			return err
+		}
+	}
 
 	if err := json.Unmarshal(data, &w); err != nil {
 		// If this is a syntax error return a useful error.
@@ -661,6 +1002,11 @@ func readWorkflow(file string, w *Workflow) error {
 		return fmt.Errorf("%s: JSON syntax error in line %d: %s \n%s\n%s^", file, line, err, data[start:end], strings.Repeat(" ", pos))
 	}
 
+	// Misspelled fields would otherwise be ignored.
+	if err := checkFields(file, src, data); err != nil {
+		return err
+	}
+
 	if w.OAuthPath != "" && !filepath.IsAbs(w.OAuthPath) {
 		w.OAuthPath = filepath.Join(w.workflowDir, w.OAuthPath)
 	}
@@ -17,6 +17,8 @@ package daisy
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -32,7 +34,8 @@ import (
 	"testing"
 	"time"
 
-	"cloud.google.com/go/storage"
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	daisyStorage "github.com/GoogleCloudPlatform/compute-image-tools/daisy/storage"
 	"github.com/kylelemons/godebug/diff"
 	"github.com/kylelemons/godebug/pretty"
 	compute "google.golang.org/api/compute/v1"
@@ -206,10 +209,16 @@ func TestNewFromFile(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	data, err := ioutil.ReadFile("./test_data/test.wf.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
 
 	want := &Workflow{
 		id:          got.id,
 		workflowDir: filepath.Join(wd, "test_data"),
+		fileSHA256:  hex.EncodeToString(sum[:]),
 		Name:        "some-name",
 		Project:     "some-project",
 		Zone:        "us-central1-a",
@@ -407,6 +416,7 @@ func TestPopulate(t *testing.T) {
 		Project:    "bar-project",
 		OAuthPath:  tf,
 		id:         got.id,
+		started:    got.started,
 		gcsLogging: true,
 		Cancel:     got.Cancel,
 		Vars: map[string]wVar{
@@ -574,6 +584,65 @@ func TestTraverseDAG(t *testing.T) {
 	}
 }
 
+func TestMaxConcurrentSteps(t *testing.T) {
+	var mx sync.Mutex
+	var running, maxRunning int
+	mockRun := func(ctx context.Context, s *Step) dErr {
+		mx.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mx.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mx.Lock()
+		running--
+		mx.Unlock()
+		return nil
+	}
+
+	w := testWorkflow()
+	w.MaxConcurrentSteps = 2
+	w.stepSlots = make(chan struct{}, w.MaxConcurrentSteps)
+	for i := 0; i < 4; i++ {
+		s, _ := w.NewStep(fmt.Sprintf("s%d", i))
+		s.timeout = time.Minute
+		s.testType = &mockStep{runImpl: mockRun}
+	}
+	// The included workflow's steps share the limit, the IncludeWorkflow step
+	// itself does not hold a slot.
+	iw := w.NewIncludedWorkflow()
+	iw.logger = w.logger
+	for i := 0; i < 4; i++ {
+		s, _ := iw.NewStep(fmt.Sprintf("s%d", i))
+		s.timeout = time.Minute
+		s.testType = &mockStep{runImpl: mockRun}
+	}
+	inc, _ := w.NewStep("include")
+	inc.timeout = time.Minute
+	inc.IncludeWorkflow = &IncludeWorkflow{Workflow: iw}
+
+	if err := w.run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if maxRunning > 2 {
+		t.Errorf("want at most 2 concurrent steps, got %d", maxRunning)
+	}
+
+	// A limit of 1 must not deadlock on the IncludeWorkflow step.
+	for _, wf := range []*Workflow{w, iw} {
+		wf.stepsDone = nil
+	}
+	w.stepSlots = make(chan struct{}, 1)
+	maxRunning = 0
+	if err := w.run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if maxRunning != 1 {
+		t.Errorf("want 1 concurrent step, got %d", maxRunning)
+	}
+}
+
 func TestPrint(t *testing.T) {
 	data := []byte(`{
 "Name": "some-name",
@@ -721,7 +790,7 @@ func TestWrite(t *testing.T) {
 
 	}))
 
-	gcsClient, err := storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+	gcsClient, err := daisyStorage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -769,3 +838,64 @@ func TestRunStepTimeout(t *testing.T) {
 		t.Errorf("did not get expected error, got: %q, want: %q", err.Error(), want)
 	}
 }
+
+func TestRunFakeCompute(t *testing.T) {
+	wf := `{
+  "Name": "fake",
+  "Steps": {
+    "create-disk": {"CreateDisks": [{"Name": "disk", "SourceImage": "projects/fake-src/global/images/family/fake-family"}]},
+    "create-instance": {"CreateInstances": [{"Name": "inst", "Disks": [{"Source": "disk"}]}]},
+    "wait": {"WaitForInstancesSignal": [{"Name": "inst", "Interval": "1ms", "SerialOutput": {"Port": 1, "SuccessMatch": "DaisySuccess"}}]},
+    "delete-instance": {"DeleteResources": {"Instances": ["inst"]}},
+    "create-image": {"CreateImages": [{"Name": "image", "SourceDisk": "disk", "NoCleanup": true}]}
+  },
+  "Dependencies": {
+    "create-instance": ["create-disk"],
+    "wait": ["create-instance"],
+    "delete-instance": ["wait"],
+    "create-image": ["delete-instance"]
+  }
+}`
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	tf := filepath.Join(td, "fake.wf.json")
+	if err := ioutil.WriteFile(tf, []byte(wf), 0600); err != nil {
+		t.Fatalf("error writing workflow: %v", err)
+	}
+
+	w, err := NewFromFile(tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := daisyCompute.NewFakeClient()
+	c.AddProject("fake-project", "fake-zone")
+	c.AddMachineType("fake-project", "fake-zone", "n1-standard-1")
+	c.AddImage("fake-src", &compute.Image{Name: "fake-image", Family: "fake-family"})
+	c.AddInstanceScript(&daisyCompute.InstanceScript{Instance: "^inst-", Output: []string{"booting\n", "DaisySuccess\n"}})
+	w.Project = "fake-project"
+	w.Zone = "fake-zone"
+	w.GCSPath = testGCSPath
+	w.ComputeClient = c
+	w.StorageClient, _ = newTestGCSClient()
+	w.logger = log.New(ioutil.Discard, "", 0)
+
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatalf("error running workflow: %v", err)
+	}
+
+	imgs, _ := c.ListImages("fake-project")
+	if len(imgs) != 1 || !strings.HasPrefix(imgs[0].Name, "image-fake-") {
+		t.Errorf("want one image named image-fake-*, got %v", imgs)
+	}
+	for _, f := range []func() (int, error){
+		func() (int, error) { ds, err := c.ListDisks("fake-project", "fake-zone"); return len(ds), err },
+		func() (int, error) { is, err := c.ListInstances("fake-project", "fake-zone"); return len(is), err },
+	} {
+		if n, err := f(); err != nil || n != 0 {
+			t.Errorf("workflow resources not cleaned up: %d left, err: %v", n, err)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+)
+
+// isYAML reports whether file is a YAML workflow, by its extension.
+func isYAML(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// yamlToJSON converts a YAML workflow to JSON. Unquoted YAML numbers and
+// booleans are converted to JSON strings where the workflow expects strings,
+// so `SizeGb: 10` and `Vars: {count: 3}` read the same as in JSON workflows.
+func yamlToJSON(file string, data []byte) ([]byte, error) {
+	var y interface{}
+	if err := yaml.Unmarshal(data, &y); err != nil {
+		return nil, yamlSyntaxError(file, data, err)
+	}
+	j, err := json.Marshal(y)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(j))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(stringifyScalars(v, reflect.TypeOf(Workflow{})))
+}
+
+// yamlSyntaxError formats a YAML parser error like the syntax errors of
+// JSON workflows, with the line and column of the token the parser stopped
+// at.
+func yamlSyntaxError(file string, data []byte, err error) error {
+	var yErr yaml.Error
+	if !errors.As(err, &yErr) || yErr.GetToken() == nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	pos := yErr.GetToken().Position
+	lines := bytes.Split(data, []byte("\n"))
+	if pos.Line < 1 || pos.Line > len(lines) || pos.Column < 1 {
+		return fmt.Errorf("%s: YAML syntax error in line %d: %s", file, pos.Line, yErr.GetMessage())
+	}
+	return fmt.Errorf("%s: YAML syntax error in line %d, column %d: %s \n%s\n%s^", file, pos.Line, pos.Column, yErr.GetMessage(), lines[pos.Line-1], strings.Repeat(" ", pos.Column-1))
+}
+
+// stringifyScalars converts the numbers and booleans in v to strings where
+// the corresponding field of type t is not a number or boolean.
+func stringifyScalars(v interface{}, t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if ft, ok := jsonFieldType(t, k); ok {
+				v[k] = stringifyScalars(e, ft)
+			}
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for i, e := range v {
+				v[i] = stringifyScalars(e, t.Elem())
+			}
+		}
+	case json.Number:
+		if t.Kind() == reflect.String || t.Kind() == reflect.Struct {
+			return v.String()
+		}
+	case bool:
+		if t.Kind() == reflect.String || t.Kind() == reflect.Struct {
+			return strconv.FormatBool(v)
+		}
+	}
+	return v
+}
+
+// ConvertWorkflowFile converts the workflow file src from JSON to YAML, or
+// from YAML to JSON, and writes it to dst. Formats are chosen by the files'
+// extensions. Vars are not substituted and dst must not exist.
+func ConvertWorkflowFile(src, dst string) error {
+	if isYAML(src) == isYAML(dst) {
+		return fmt.Errorf("cannot convert %q to %q, one must be a JSON and the other a YAML file", src, dst)
+	}
+	// Reading the workflow reports errors the same way a run would.
+	if err := readWorkflow(src, New()); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+	if isYAML(dst) {
+		if out, err = yaml.JSONToYAML(data); err != nil {
+			return err
+		}
+	} else {
+		if data, err = yamlToJSON(src, data); err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, data, "", "  "); err != nil {
+			return err
+		}
+		buf.WriteString("\n")
+		out = buf.Bytes()
+	}
+
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(out); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// yamlKeyPosition returns the line and column of the key at path in the YAML
+// document data, or of the closest key found if there is no such key, or
+// 0, 0 if none is found. Aliases are followed to the node they refer to, and
+// keys merged with "<<" are looked for in the merged mappings.
+func yamlKeyPosition(data []byte, path []string) (int, int) {
+	f, err := parser.ParseBytes(data, 0)
+	if err != nil || len(f.Docs) == 0 {
+		return 0, 0
+	}
+	anchors := map[string]ast.Node{}
+	ast.Walk(yamlAnchors(anchors), f.Docs[0])
+
+	n := f.Docs[0].Body
+	line, col := 0, 0
+	for _, p := range path {
+		var next ast.Node
+		switch v := yamlResolve(n, anchors).(type) {
+		case *ast.MappingNode, *ast.MappingValueNode:
+			var k ast.MapKeyNode
+			if k, next = yamlMappingValue(v, p, anchors); k == nil {
+				return line, col
+			}
+			pos := k.GetToken().Position
+			line, col = pos.Line, pos.Column
+		case *ast.SequenceNode:
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(v.Values) {
+				return line, col
+			}
+			next = v.Values[i]
+		default:
+			return line, col
+		}
+		n = next
+	}
+	return line, col
+}
+
+// yamlAnchors records the nodes of the anchors it visits by name.
+type yamlAnchors map[string]ast.Node
+
+func (a yamlAnchors) Visit(n ast.Node) ast.Visitor {
+	if an, ok := n.(*ast.AnchorNode); ok {
+		a[an.Name.GetToken().Value] = an.Value
+	}
+	return a
+}
+
+// yamlResolve returns the node n refers to, without its anchor or tag.
+func yamlResolve(n ast.Node, anchors map[string]ast.Node) ast.Node {
+	for i := 0; i < len(anchors)+1; i++ {
+		switch v := n.(type) {
+		case *ast.AnchorNode:
+			n = v.Value
+		case *ast.TagNode:
+			n = v.Value
+		case *ast.MappingKeyNode:
+			n = v.Value
+		case *ast.AliasNode:
+			n = anchors[v.Value.GetToken().Value]
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// yamlMappingValue returns the key named name of the mapping m and its
+// value, or nil if there is no such key.
+func yamlMappingValue(m ast.Node, name string, anchors map[string]ast.Node) (ast.MapKeyNode, ast.Node) {
+	var mvs []*ast.MappingValueNode
+	switch v := m.(type) {
+	case *ast.MappingNode:
+		mvs = v.Values
+	case *ast.MappingValueNode:
+		mvs = []*ast.MappingValueNode{v}
+	}
+	var merged []ast.Node
+	for _, mv := range mvs {
+		if mv.Key.IsMergeKey() {
+			merged = append(merged, mv.Value)
+			continue
+		}
+		if yamlKeyName(mv.Key, anchors) == name {
+			return mv.Key, mv.Value
+		}
+	}
+	for _, n := range merged {
+		n = yamlResolve(n, anchors)
+		ms := []ast.Node{n}
+		if seq, ok := n.(*ast.SequenceNode); ok {
+			ms = seq.Values
+		}
+		for _, m := range ms {
+			if k, v := yamlMappingValue(yamlResolve(m, anchors), name, anchors); k != nil {
+				return k, v
+			}
+		}
+	}
+	return nil, nil
+}
+
+// yamlKeyName returns the name of the mapping key k, unquoted.
+func yamlKeyName(k ast.Node, anchors map[string]ast.Node) string {
+	switch v := yamlResolve(k, anchors).(type) {
+	case nil:
+		return ""
+	case *ast.StringNode:
+		return v.Value
+	default:
+		return v.GetToken().Value
+	}
+}
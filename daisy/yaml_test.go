@@ -0,0 +1,242 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+)
+
+const testYAMLWorkflow = `# A YAML workflow.
+Name: some-name
+Project: some-project
+Zone: us-central1-a
+Vars:
+  count: 3
+  machine_type:
+    Value: n1-standard-1
+    Required: true
+Steps:
+  create-disks:
+    CreateDisks:
+      - Name: disk
+        SourceImage: projects/debian-cloud/global/images/family/debian-9
+        SizeGb: 10
+  create-instance:
+    Timeout: 1h
+    CreateInstances:
+      - Name: inst
+        Disks: [{Source: disk}]
+        Metadata:
+          startup-script: |
+            echo starting
+            echo BuildSuccess
+Dependencies:
+  create-instance: [create-disks]
+`
+
+func TestNewFromFileYAML(t *testing.T) {
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	tf := filepath.Join(td, "test.wf.yaml")
+	if err := ioutil.WriteFile(tf, []byte(testYAMLWorkflow), 0600); err != nil {
+		t.Fatalf("error writing workflow: %v", err)
+	}
+
+	got, err := NewFromFile(tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantVars := map[string]wVar{"count": {Value: "3"}, "machine_type": {Value: "n1-standard-1", Required: true}}
+	if diff := pretty.Compare(got.Vars, wantVars); diff != "" {
+		t.Errorf("Vars do not match expectation: (-got +want)\n%s", diff)
+	}
+	wantDisks := &CreateDisks{{Disk: compute.Disk{Name: "disk", SourceImage: "projects/debian-cloud/global/images/family/debian-9"}, SizeGb: "10"}}
+	if diff := pretty.Compare(got.Steps["create-disks"].CreateDisks, wantDisks); diff != "" {
+		t.Errorf("CreateDisks does not match expectation: (-got +want)\n%s", diff)
+	}
+	s := got.Steps["create-instance"]
+	if s.Timeout != "1h" {
+		t.Errorf("Timeout: got %q, want %q", s.Timeout, "1h")
+	}
+	if script, want := (*s.CreateInstances)[0].Metadata["startup-script"], "echo starting\necho BuildSuccess\n"; script != want {
+		t.Errorf("startup-script: got %q, want %q", script, want)
+	}
+	if diff := pretty.Compare(got.Dependencies, map[string][]string{"create-instance": {"create-disks"}}); diff != "" {
+		t.Errorf("Dependencies do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestNewFromFileYAMLError(t *testing.T) {
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	tf := filepath.Join(td, "test.wf.yaml")
+
+	tests := []struct{ data, error string }{
+		{
+			"Name: foo\nSteps:\n  step: [1, 2\n",
+			tf + ": YAML syntax error in line 3, column 9: sequence end token ']' not found \n  step: [1, 2\n        ^",
+		},
+		{
+			"Name: foo\nProject: 'bar\n",
+			tf + ": YAML syntax error in line 2, column 10: could not find end character of single-quoted text \nProject: 'bar\n         ^",
+		},
+		{
+			"Name: foo\nName: bar\n",
+			tf + ": YAML syntax error in line 2, column 1: mapping key \"Name\" already defined at [1:1] \nName: bar\n^",
+		},
+	}
+
+	for i, tt := range tests {
+		if err := ioutil.WriteFile(tf, []byte(tt.data), 0600); err != nil {
+			t.Fatalf("error creating yaml file: %v", err)
+		}
+
+		if _, err := NewFromFile(tf); err == nil {
+			t.Errorf("expected error, got nil for test %d", i+1)
+		} else if err.Error() != tt.error {
+			t.Errorf("did not get expected error from NewFromFile():\ngot: %q\nwant: %q", err.Error(), tt.error)
+		}
+	}
+}
+
+func TestConvertWorkflowFile(t *testing.T) {
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	src := filepath.Join(td, "test.wf.yaml")
+	if err := ioutil.WriteFile(src, []byte(testYAMLWorkflow), 0600); err != nil {
+		t.Fatalf("error writing workflow: %v", err)
+	}
+
+	// YAML to JSON and back.
+	j := filepath.Join(td, "test.wf.json")
+	y := filepath.Join(td, "test2.wf.yaml")
+	if err := ConvertWorkflowFile(src, j); err != nil {
+		t.Fatalf("error converting to JSON: %v", err)
+	}
+	if err := ConvertWorkflowFile(j, y); err != nil {
+		t.Fatalf("error converting to YAML: %v", err)
+	}
+	if err := ConvertWorkflowFile(j, y); err == nil {
+		t.Error("existing destination: should have returned an error")
+	}
+	if err := ConvertWorkflowFile(j, filepath.Join(td, "test3.wf.json")); err == nil {
+		t.Error("JSON to JSON: should have returned an error")
+	}
+
+	want, err := NewFromFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantJSON, _ := json.Marshal(want)
+	for _, f := range []string{j, y} {
+		got, err := NewFromFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotJSON, _ := json.Marshal(got)
+		if string(gotJSON) != string(wantJSON) {
+			t.Errorf("%s: workflow does not match expectation:\ngot:  %s\nwant: %s", filepath.Base(f), gotJSON, wantJSON)
+		}
+	}
+}
+
+func TestYAMLKeyPosition(t *testing.T) {
+	data := []byte(`Name: foo
+# Steps:
+Steps:
+  disks:
+    CreateDisks:
+    - Name: d
+      SizeGb: 10
+    - Name: e
+      SourceImg: i
+  wait:
+    WaitForInstancesSignal: [{Name: i, SerialOutput: {SuccesMatch: x}}]
+Vars:
+  - Name: e
+`)
+	tests := []struct {
+		path      []string
+		line, col int
+	}{
+		{[]string{"Name"}, 1, 1},
+		{[]string{"Steps", "disks", "CreateDisks", "0", "SizeGb"}, 7, 7},
+		{[]string{"Steps", "disks", "CreateDisks", "1", "SourceImg"}, 9, 7},
+		{[]string{"Steps", "wait", "WaitForInstancesSignal", "0", "SerialOutput", "SuccesMatch"}, 11, 55},
+		{[]string{"Steps", "disks", "CreateDisks", "2", "Name"}, 5, 5},
+		{[]string{"Steps", "Name"}, 3, 1},
+		{[]string{"Project"}, 0, 0},
+	}
+	for _, tt := range tests {
+		if line, col := yamlKeyPosition(data, tt.path); line != tt.line || col != tt.col {
+			t.Errorf("%v: got %d:%d, want %d:%d", tt.path, line, col, tt.line, tt.col)
+		}
+	}
+}
+
+func TestYAMLKeyPositionNodes(t *testing.T) {
+	data := []byte(`Defaults: &defaults
+  Timeout: 1h
+  Retri: 3
+Steps:
+  script:
+    Description: >
+      Project: not a key
+      SizeGb: 10
+    "Quoted Key": x
+    'single': {
+      Name: i,
+      Flow: y
+    }
+  merged:
+    <<: *defaults
+    Name: m
+  alias: *defaults
+`)
+	tests := []struct {
+		desc      string
+		path      []string
+		line, col int
+	}{
+		{"multi-line scalar", []string{"Steps", "script", "Project"}, 5, 3},
+		{"double-quoted key", []string{"Steps", "script", "Quoted Key"}, 9, 5},
+		{"multi-line flow mapping", []string{"Steps", "script", "single", "Flow"}, 12, 7},
+		{"merged key", []string{"Steps", "merged", "Retri"}, 3, 3},
+		{"key next to merge", []string{"Steps", "merged", "Name"}, 16, 5},
+		{"alias", []string{"Steps", "alias", "Retri"}, 3, 3},
+	}
+	for _, tt := range tests {
+		if line, col := yamlKeyPosition(data, tt.path); line != tt.line || col != tt.col {
+			t.Errorf("%s: got %d:%d, want %d:%d", tt.desc, line, col, tt.line, tt.col)
+		}
+	}
+}